@@ -74,6 +74,10 @@ var MemoryCacheEnabled bool
 
 var LogConsumeEnabled = true
 
+// MetricsEnabled 是否暴露 Prometheus /metrics 端点，MetricsToken 非空时要求 Bearer 鉴权
+var MetricsEnabled bool
+var MetricsToken string
+
 var TLSInsecureSkipVerify bool
 var InsecureTLSConfig = &tls.Config{InsecureSkipVerify: true}
 
@@ -206,6 +210,7 @@ const (
 	ChannelStatusEnabled          = 1 // don't use 0, 0 is the default value!
 	ChannelStatusManuallyDisabled = 2 // also don't use 0
 	ChannelStatusAutoDisabled     = 3
+	ChannelStatusBudgetExhausted  = 4 // 预算耗尽暂停，周期重置后自动恢复
 )
 
 const (
@@ -61,6 +61,9 @@ func InitEnv() {
 	} else {
 		CryptoSecret = SessionSecret
 	}
+	if err := InitSecretEncryption(); err != nil {
+		log.Fatal(err)
+	}
 	if os.Getenv("SQLITE_PATH") != "" {
 		SQLitePath = os.Getenv("SQLITE_PATH")
 	}
@@ -82,6 +85,8 @@ func InitEnv() {
 	DebugEnabled = os.Getenv("DEBUG") == "true"
 	MemoryCacheEnabled = os.Getenv("MEMORY_CACHE_ENABLED") == "true"
 	IsMasterNode = os.Getenv("NODE_TYPE") != "slave"
+	MetricsEnabled = GetEnvOrDefaultBool("METRICS_ENABLED", false)
+	MetricsToken = GetEnvOrDefaultString("METRICS_TOKEN", "")
 	TLSInsecureSkipVerify = GetEnvOrDefaultBool("TLS_INSECURE_SKIP_VERIFY", false)
 	if TLSInsecureSkipVerify {
 		if tr, ok := http.DefaultTransport.(*http.Transport); ok && tr != nil {
@@ -147,6 +152,14 @@ func initConstantEnv() {
 	constant.TaskQueryLimit = GetEnvOrDefault("TASK_QUERY_LIMIT", 1000)
 	// 异步任务超时时间（分钟），超过此时间未完成的任务将被标记为失败并退款。0 表示禁用。
 	constant.TaskTimeoutMinutes = GetEnvOrDefault("TASK_TIMEOUT_MINUTES", 1440)
+	// /v1/files 存储方式：disk（本地目录）或 db（数据库）
+	constant.FileStorageType = resolveFileStorageType(os.Getenv("NODE_TYPE"), os.Getenv("FILE_STORAGE_TYPE"))
+	constant.FileStorageDir = GetEnvOrDefaultString("FILE_STORAGE_DIR", "./files")
+	constant.FileMaxSizeMB = GetEnvOrDefault("FILE_MAX_SIZE_MB", 100)
+	// 单个批处理最多请求数、单批并发数、同时执行的批处理数
+	constant.BatchMaxRequests = GetEnvOrDefault("BATCH_MAX_REQUESTS", 50000)
+	constant.BatchConcurrency = GetEnvOrDefault("BATCH_CONCURRENCY", 8)
+	constant.BatchWorkerCount = GetEnvOrDefault("BATCH_WORKER_COUNT", 2)
 
 	soraPatchStr := GetEnvOrDefaultString("TASK_PRICE_PATCH", "")
 	if soraPatchStr != "" {
@@ -174,3 +187,26 @@ func initConstantEnv() {
 	}
 	constant.TrustedRedirectDomains = trustedDomains
 }
+
+// resolveFileStorageType 决定 /v1/files 的存储方式。多节点部署（设置了 NODE_TYPE）时节点之间无法读取彼此的本地文件，
+// 而批处理只在主节点执行：未配置时默认使用共享的数据库存储；从节点上传的文件必须能被主节点读取，因此从节点强制使用数据库；
+// 主节点显式配置为本地存储时保留配置，只输出警告
+func resolveFileStorageType(nodeType string, configured string) string {
+	if configured == "" {
+		if nodeType != "" {
+			return "db"
+		}
+		return "disk"
+	}
+	if configured == "db" {
+		return configured
+	}
+	if nodeType == "slave" {
+		SysLog(fmt.Sprintf("FILE_STORAGE_TYPE=%s is not readable by the master node, using db on this slave node", configured))
+		return "db"
+	}
+	if nodeType != "" {
+		SysLog(fmt.Sprintf("Warning: FILE_STORAGE_TYPE=%s is not shared between nodes, files stored on this node cannot be read by other nodes", configured))
+	}
+	return configured
+}
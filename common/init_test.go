@@ -0,0 +1,20 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveFileStorageType(t *testing.T) {
+	// 单节点保持默认 / 显式配置
+	require.Equal(t, "disk", resolveFileStorageType("", ""))
+	require.Equal(t, "disk", resolveFileStorageType("", "disk"))
+	require.Equal(t, "db", resolveFileStorageType("", "db"))
+	// 多节点未配置时默认使用共享的数据库存储
+	require.Equal(t, "db", resolveFileStorageType("master", ""))
+	require.Equal(t, "db", resolveFileStorageType("slave", ""))
+	// 主节点的显式配置不被覆盖，从节点必须使用数据库
+	require.Equal(t, "disk", resolveFileStorageType("master", "disk"))
+	require.Equal(t, "db", resolveFileStorageType("slave", "disk"))
+}
@@ -0,0 +1,30 @@
+-- 并发限制：有序集合记录在途请求，超过 TTL 的成员视为泄漏并清理
+-- KEYS[1]: 限流器唯一标识
+-- ARGV[1]: 操作 acquire / release
+-- ARGV[2]: 请求唯一标识
+-- ARGV[3]: 并发上限
+-- ARGV[4]: 成员 TTL（毫秒）
+-- 返回 {是否允许, 当前在途请求数}
+
+local key = KEYS[1]
+local op = ARGV[1]
+local member = ARGV[2]
+
+if op == 'release' then
+    redis.call('ZREM', key, member)
+    return {1, redis.call('ZCARD', key)}
+end
+
+local limit = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+local now = redis.call('TIME')
+local nowMs = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', nowMs - ttl)
+local count = redis.call('ZCARD', key)
+if count >= limit then
+    return {0, count}
+end
+redis.call('ZADD', key, nowMs, member)
+redis.call('PEXPIRE', key, ttl)
+return {1, count + 1}
@@ -0,0 +1,46 @@
+-- TPM 令牌桶：容量为每分钟额度，按毫秒匀速恢复，允许通过 adjust 透支（余额为负）
+-- KEYS[1]: 限流器唯一标识
+-- ARGV[1]: 操作 acquire / adjust
+-- ARGV[2]: acquire 时为请求的令牌数；adjust 时为需要额外扣除的令牌数（负数表示返还）
+-- ARGV[3]: 每分钟额度（桶容量）
+-- 返回 {是否允许, 当前余额, 恢复满额所需毫秒}
+
+local key = KEYS[1]
+local op = ARGV[1]
+local amount = tonumber(ARGV[2])
+local capacity = tonumber(ARGV[3])
+local rate = capacity / 60000
+
+local now = redis.call('TIME')
+local nowMs = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+
+local bucket = redis.call('HMGET', key, 'tokens', 'last_ms')
+local tokens = tonumber(bucket[1])
+local lastMs = tonumber(bucket[2])
+if not tokens or not lastMs then
+    tokens = capacity
+else
+    tokens = math.min(capacity, tokens + (nowMs - lastMs) * rate)
+end
+
+local allowed = 1
+if op == 'acquire' then
+    -- 超过单次容量的请求在桶满时放行，避免永远无法通过
+    if tokens <= 0 or (tokens < amount and tokens < capacity) then
+        allowed = 0
+    else
+        tokens = tokens - amount
+    end
+else
+    tokens = math.min(capacity, tokens - amount)
+end
+
+local resetMs = 0
+if tokens < capacity then
+    resetMs = math.ceil((capacity - tokens) / rate)
+end
+
+-- 恢复满额后即可丢弃，过期时间至少保留一分钟
+redis.call('HMSET', key, 'tokens', tokens, 'last_ms', nowMs)
+redis.call('PEXPIRE', key, math.max(60000, resetMs + 1000))
+return {allowed, math.floor(tokens), resetMs}
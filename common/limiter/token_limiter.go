@@ -0,0 +1,199 @@
+package limiter
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/go-redis/redis/v8"
+)
+
+//go:embed lua/token_window.lua
+var tokenWindowScriptSrc string
+
+//go:embed lua/concurrency.lua
+var concurrencyScriptSrc string
+
+var (
+	tokenWindowScript = redis.NewScript(tokenWindowScriptSrc)
+	concurrencyScript = redis.NewScript(concurrencyScriptSrc)
+)
+
+// ConcurrencyTTL 在途请求的最长记录时间，超过后视为泄漏自动清理
+const ConcurrencyTTL = 30 * time.Minute
+
+// TokenWindowResult TPM 令牌桶的检查结果
+type TokenWindowResult struct {
+	Allowed    bool
+	Remaining  int64
+	ResetAfter time.Duration
+}
+
+// TokenWindowAcquire 从每分钟额度为 limit 的令牌桶中预占 amount 个 token。
+// amount 为 0 时只检查额度是否已耗尽（用于输出 token，事后再通过 TokenWindowAdjust 扣除）
+func TokenWindowAcquire(ctx context.Context, key string, amount int64, limit int64) (TokenWindowResult, error) {
+	if common.RedisEnabled {
+		return redisTokenWindow(ctx, key, "acquire", amount, limit)
+	}
+	return memoryLimiter.tokenWindow(key, true, amount, limit), nil
+}
+
+// TokenWindowAdjust 按实际用量修正令牌桶，delta 为正表示补扣，为负表示返还；允许透支
+func TokenWindowAdjust(ctx context.Context, key string, delta int64, limit int64) (TokenWindowResult, error) {
+	if common.RedisEnabled {
+		return redisTokenWindow(ctx, key, "adjust", delta, limit)
+	}
+	return memoryLimiter.tokenWindow(key, false, delta, limit), nil
+}
+
+// ConcurrencyAcquire 占用一个并发名额，返回是否成功以及当前在途请求数
+func ConcurrencyAcquire(ctx context.Context, key string, member string, limit int64) (bool, int64, error) {
+	if common.RedisEnabled {
+		result, err := concurrencyScript.Run(ctx, common.RDB, []string{key}, "acquire", member, limit, ConcurrencyTTL.Milliseconds()).Int64Slice()
+		if err != nil {
+			return false, 0, fmt.Errorf("concurrency limit failed: %w", err)
+		}
+		return result[0] == 1, result[1], nil
+	}
+	allowed, count := memoryLimiter.concurrencyAcquire(key, member, limit)
+	return allowed, count, nil
+}
+
+func ConcurrencyRelease(ctx context.Context, key string, member string) error {
+	if common.RedisEnabled {
+		return concurrencyScript.Run(ctx, common.RDB, []string{key}, "release", member).Err()
+	}
+	memoryLimiter.concurrencyRelease(key, member)
+	return nil
+}
+
+func redisTokenWindow(ctx context.Context, key string, op string, amount int64, limit int64) (TokenWindowResult, error) {
+	result, err := tokenWindowScript.Run(ctx, common.RDB, []string{key}, op, amount, limit).Int64Slice()
+	if err != nil {
+		return TokenWindowResult{}, fmt.Errorf("token rate limit failed: %w", err)
+	}
+	return TokenWindowResult{
+		Allowed:    result[0] == 1,
+		Remaining:  result[1],
+		ResetAfter: time.Duration(result[2]) * time.Millisecond,
+	}, nil
+}
+
+// 内存实现，与 Lua 脚本语义一致，用于未启用 Redis 的单机部署
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// fullAt 恢复满额的时间，之后可以丢弃
+	fullAt time.Time
+}
+
+type inMemoryTokenLimiter struct {
+	mu          sync.Mutex
+	buckets     map[string]*tokenBucket
+	inflight    map[string]map[string]time.Time
+	lastCleanup time.Time
+	now         func() time.Time
+}
+
+var memoryLimiter = &inMemoryTokenLimiter{
+	buckets:  make(map[string]*tokenBucket),
+	inflight: make(map[string]map[string]time.Time),
+	now:      time.Now,
+}
+
+func (l *inMemoryTokenLimiter) cleanupLocked(now time.Time) {
+	if now.Sub(l.lastCleanup) < time.Minute {
+		return
+	}
+	l.lastCleanup = now
+	for key, bucket := range l.buckets {
+		if now.After(bucket.fullAt) {
+			delete(l.buckets, key)
+		}
+	}
+	for key, members := range l.inflight {
+		for member, at := range members {
+			if now.Sub(at) > ConcurrencyTTL {
+				delete(members, member)
+			}
+		}
+		if len(members) == 0 {
+			delete(l.inflight, key)
+		}
+	}
+}
+
+func (l *inMemoryTokenLimiter) tokenWindow(key string, acquire bool, amount int64, limit int64) TokenWindowResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.cleanupLocked(now)
+
+	capacity := float64(limit)
+	rate := capacity / float64(time.Minute.Milliseconds())
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, last: now}
+		l.buckets[key] = bucket
+	} else {
+		bucket.tokens = math.Min(capacity, bucket.tokens+float64(now.Sub(bucket.last).Milliseconds())*rate)
+		bucket.last = now
+	}
+
+	allowed := true
+	if acquire {
+		if bucket.tokens <= 0 || (bucket.tokens < float64(amount) && bucket.tokens < capacity) {
+			allowed = false
+		} else {
+			bucket.tokens -= float64(amount)
+		}
+	} else {
+		bucket.tokens = math.Min(capacity, bucket.tokens-float64(amount))
+	}
+
+	result := TokenWindowResult{Allowed: allowed, Remaining: int64(math.Floor(bucket.tokens))}
+	if bucket.tokens < capacity && rate > 0 {
+		result.ResetAfter = time.Duration(math.Ceil((capacity-bucket.tokens)/rate)) * time.Millisecond
+	}
+	bucket.fullAt = now.Add(result.ResetAfter)
+	return result
+}
+
+func (l *inMemoryTokenLimiter) concurrencyAcquire(key string, member string, limit int64) (bool, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.cleanupLocked(now)
+
+	members, ok := l.inflight[key]
+	if !ok {
+		members = make(map[string]time.Time)
+		l.inflight[key] = members
+	}
+	for m, at := range members {
+		if now.Sub(at) > ConcurrencyTTL {
+			delete(members, m)
+		}
+	}
+	count := int64(len(members))
+	if count >= limit {
+		return false, count
+	}
+	members[member] = now
+	return true, count + 1
+}
+
+func (l *inMemoryTokenLimiter) concurrencyRelease(key string, member string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if members, ok := l.inflight[key]; ok {
+		delete(members, member)
+		if len(members) == 0 {
+			delete(l.inflight, key)
+		}
+	}
+}
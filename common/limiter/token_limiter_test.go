@@ -0,0 +1,100 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/require"
+)
+
+func setupMemoryLimiterTest(t *testing.T) *time.Time {
+	t.Helper()
+	savedRedis := common.RedisEnabled
+	common.RedisEnabled = false
+	current := time.Unix(1700000000, 0)
+	memoryLimiter = &inMemoryTokenLimiter{
+		buckets:  make(map[string]*tokenBucket),
+		inflight: make(map[string]map[string]time.Time),
+		now:      func() time.Time { return current },
+	}
+	t.Cleanup(func() {
+		common.RedisEnabled = savedRedis
+	})
+	return &current
+}
+
+func TestTokenWindowAcquireAndRefill(t *testing.T) {
+	current := setupMemoryLimiterTest(t)
+	ctx := context.Background()
+
+	result, err := TokenWindowAcquire(ctx, "k", 600, 1000)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+	require.Equal(t, int64(400), result.Remaining)
+
+	result, _ = TokenWindowAcquire(ctx, "k", 600, 1000)
+	require.False(t, result.Allowed)
+	require.Equal(t, int64(400), result.Remaining)
+	require.Equal(t, 36*time.Second, result.ResetAfter)
+
+	// 每分钟恢复 limit 个 token
+	*current = current.Add(12 * time.Second)
+	result, _ = TokenWindowAcquire(ctx, "k", 600, 1000)
+	require.True(t, result.Allowed)
+	require.Equal(t, int64(0), result.Remaining)
+}
+
+func TestTokenWindowOversizedRequest(t *testing.T) {
+	setupMemoryLimiterTest(t)
+	ctx := context.Background()
+
+	// 单个请求超过整分钟额度时，额度满的情况下仍放行，避免永远无法请求
+	result, _ := TokenWindowAcquire(ctx, "k", 1500, 1000)
+	require.True(t, result.Allowed)
+	require.Equal(t, int64(-500), result.Remaining)
+
+	result, _ = TokenWindowAcquire(ctx, "k", 0, 1000)
+	require.False(t, result.Allowed)
+}
+
+func TestTokenWindowAdjust(t *testing.T) {
+	setupMemoryLimiterTest(t)
+	ctx := context.Background()
+
+	_, _ = TokenWindowAcquire(ctx, "k", 800, 1000)
+	result, _ := TokenWindowAdjust(ctx, "k", -500, 1000)
+	require.Equal(t, int64(700), result.Remaining)
+
+	result, _ = TokenWindowAdjust(ctx, "k", -5000, 1000)
+	require.Equal(t, int64(1000), result.Remaining)
+
+	result, _ = TokenWindowAdjust(ctx, "k", 1200, 1000)
+	require.Equal(t, int64(-200), result.Remaining)
+}
+
+func TestConcurrencyAcquireRelease(t *testing.T) {
+	current := setupMemoryLimiterTest(t)
+	ctx := context.Background()
+
+	allowed, count, _ := ConcurrencyAcquire(ctx, "c", "a", 2)
+	require.True(t, allowed)
+	require.Equal(t, int64(1), count)
+	allowed, count, _ = ConcurrencyAcquire(ctx, "c", "b", 2)
+	require.True(t, allowed)
+	require.Equal(t, int64(2), count)
+	allowed, count, _ = ConcurrencyAcquire(ctx, "c", "c", 2)
+	require.False(t, allowed)
+	require.Equal(t, int64(2), count)
+
+	require.NoError(t, ConcurrencyRelease(ctx, "c", "a"))
+	allowed, _, _ = ConcurrencyAcquire(ctx, "c", "c", 2)
+	require.True(t, allowed)
+
+	// 超时未释放的请求自动清理
+	*current = current.Add(ConcurrencyTTL + time.Second)
+	allowed, count, _ = ConcurrencyAcquire(ctx, "c", "d", 2)
+	require.True(t, allowed)
+	require.Equal(t, int64(1), count)
+}
@@ -68,3 +68,25 @@ func (l *InMemoryRateLimiter) Request(key string, maxRequestNum int, duration in
 	}
 	return true
 }
+
+// Window 返回窗口内的请求数，以及最早一条请求移出窗口的剩余秒数，不记录请求
+func (l *InMemoryRateLimiter) Window(key string, duration int64) (int, int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	queue, ok := l.store[key]
+	if !ok {
+		return 0, 0
+	}
+	now := time.Now().Unix()
+	used := 0
+	var resetAfter int64
+	for _, t := range *queue {
+		if now-t < duration {
+			if used == 0 {
+				resetAfter = duration - (now - t)
+			}
+			used++
+		}
+	}
+	return used, resetAfter
+}
@@ -0,0 +1,212 @@
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 敏感字段（渠道密钥、OAuth 凭据、支付密钥等）的信封加密：
+// 每个值使用随机生成的数据密钥做 AES-256-GCM 加密，数据密钥再由主密钥加密后与密文一起保存，
+// 存储格式为 enc:v1:<主密钥 id>:<加密后的数据密钥>:<密文>。
+// 主密钥来自 SECRET_ENCRYPTION_KEY 或 SECRET_ENCRYPTION_KEY_FILE，未配置时不加密；
+// 轮换主密钥时把旧主密钥放入 SECRET_ENCRYPTION_OLD_KEYS（逗号分隔），再触发重新加密。
+
+const secretPrefix = "enc:v1:"
+
+type secretMasterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+var (
+	secretCurrentKey *secretMasterKey
+	secretKeys       = make(map[string]*secretMasterKey)
+)
+
+var errSecretMalformed = errors.New("malformed encrypted secret")
+
+// InitSecretEncryption 从环境变量加载主密钥，未配置时保持明文存储
+func InitSecretEncryption() error {
+	raw := strings.TrimSpace(os.Getenv("SECRET_ENCRYPTION_KEY"))
+	if raw == "" {
+		if path := os.Getenv("SECRET_ENCRYPTION_KEY_FILE"); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("read SECRET_ENCRYPTION_KEY_FILE failed: %w", err)
+			}
+			raw = strings.TrimSpace(string(data))
+		}
+	}
+	var oldKeys []string
+	for _, key := range strings.Split(os.Getenv("SECRET_ENCRYPTION_OLD_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			oldKeys = append(oldKeys, key)
+		}
+	}
+	return SetSecretEncryptionKeys(raw, oldKeys...)
+}
+
+// SetSecretEncryptionKeys 设置当前主密钥与仅用于解密的旧主密钥，current 为空表示不加密
+func SetSecretEncryptionKeys(current string, old ...string) error {
+	secretCurrentKey = nil
+	secretKeys = make(map[string]*secretMasterKey)
+	for _, raw := range old {
+		key, err := newSecretMasterKey(raw)
+		if err != nil {
+			return err
+		}
+		secretKeys[key.id] = key
+	}
+	if current == "" {
+		if len(old) > 0 {
+			return errors.New("SECRET_ENCRYPTION_OLD_KEYS requires SECRET_ENCRYPTION_KEY")
+		}
+		return nil
+	}
+	key, err := newSecretMasterKey(current)
+	if err != nil {
+		return err
+	}
+	secretKeys[key.id] = key
+	secretCurrentKey = key
+	return nil
+}
+
+// newSecretMasterKey 主密钥可以是 base64 编码的 32 字节，其他字符串通过 sha256 派生
+func newSecretMasterKey(raw string) (*secretMasterKey, error) {
+	keyBytes, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil || len(keyBytes) != 32 {
+		sum := sha256.Sum256([]byte(raw))
+		keyBytes = sum[:]
+	}
+	aead, err := newSecretAEAD(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(keyBytes)
+	return &secretMasterKey{id: hex.EncodeToString(sum[:])[:8], aead: aead}, nil
+}
+
+func newSecretAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func secretSeal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func secretOpen(aead cipher.AEAD, data []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errSecretMalformed
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+}
+
+func SecretEncryptionEnabled() bool {
+	return secretCurrentKey != nil
+}
+
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, secretPrefix)
+}
+
+// EncryptSecret 使用当前主密钥加密，未启用加密、空值或已由当前主密钥加密时原样返回
+func EncryptSecret(value string) (string, error) {
+	key := secretCurrentKey
+	if key == nil || value == "" {
+		return value, nil
+	}
+	if IsEncryptedSecret(value) {
+		if !SecretNeedsReencrypt(value) {
+			return value, nil
+		}
+		plaintext, err := DecryptSecret(value)
+		if err != nil {
+			return "", err
+		}
+		value = plaintext
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	dataAEAD, err := newSecretAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := secretSeal(dataAEAD, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	wrappedKey, err := secretSeal(key.aead, dataKey)
+	if err != nil {
+		return "", err
+	}
+	return secretPrefix + key.id + ":" +
+		base64.RawStdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// DecryptSecret 解密 EncryptSecret 的结果，明文值原样返回
+func DecryptSecret(value string) (string, error) {
+	if !IsEncryptedSecret(value) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, secretPrefix), ":")
+	if len(parts) != 3 {
+		return "", errSecretMalformed
+	}
+	key, ok := secretKeys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("secret encrypted with unknown master key %s, check SECRET_ENCRYPTION_KEY and SECRET_ENCRYPTION_OLD_KEYS", parts[0])
+	}
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errSecretMalformed
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errSecretMalformed
+	}
+	dataKey, err := secretOpen(key.aead, wrappedKey)
+	if err != nil {
+		return "", fmt.Errorf("unwrap secret data key failed: %w", err)
+	}
+	dataAEAD, err := newSecretAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := secretOpen(dataAEAD, ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("decrypt secret failed: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// SecretNeedsReencrypt 启用加密时，明文值或由旧主密钥加密的值需要重新加密
+func SecretNeedsReencrypt(value string) bool {
+	key := secretCurrentKey
+	if key == nil || value == "" {
+		return false
+	}
+	if !IsEncryptedSecret(value) {
+		return true
+	}
+	return !strings.HasPrefix(value, secretPrefix+key.id+":")
+}
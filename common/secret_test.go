@@ -0,0 +1,69 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretEncryptionRoundTrip(t *testing.T) {
+	defer SetSecretEncryptionKeys("")
+
+	require.NoError(t, SetSecretEncryptionKeys(""))
+	value, err := EncryptSecret("sk-test")
+	require.NoError(t, err)
+	require.Equal(t, "sk-test", value, "encryption disabled keeps plaintext")
+
+	require.NoError(t, SetSecretEncryptionKeys("master-key"))
+	encrypted, err := EncryptSecret("sk-test")
+	require.NoError(t, err)
+	require.True(t, IsEncryptedSecret(encrypted))
+	require.False(t, SecretNeedsReencrypt(encrypted))
+	require.True(t, SecretNeedsReencrypt("sk-test"))
+
+	again, err := EncryptSecret(encrypted)
+	require.NoError(t, err)
+	require.Equal(t, encrypted, again, "already encrypted with current key")
+
+	other, err := EncryptSecret("sk-test")
+	require.NoError(t, err)
+	require.NotEqual(t, encrypted, other, "each value uses a fresh data key")
+
+	plaintext, err := DecryptSecret(encrypted)
+	require.NoError(t, err)
+	require.Equal(t, "sk-test", plaintext)
+
+	plaintext, err = DecryptSecret("legacy-plaintext")
+	require.NoError(t, err)
+	require.Equal(t, "legacy-plaintext", plaintext)
+
+	empty, err := EncryptSecret("")
+	require.NoError(t, err)
+	require.Empty(t, empty)
+}
+
+func TestSecretEncryptionRotation(t *testing.T) {
+	defer SetSecretEncryptionKeys("")
+
+	require.NoError(t, SetSecretEncryptionKeys("old-key"))
+	encrypted, err := EncryptSecret(`{"type":"service_account"}`)
+	require.NoError(t, err)
+
+	require.NoError(t, SetSecretEncryptionKeys("new-key"))
+	_, err = DecryptSecret(encrypted)
+	require.Error(t, err, "old key no longer configured")
+
+	require.NoError(t, SetSecretEncryptionKeys("new-key", "old-key"))
+	require.True(t, SecretNeedsReencrypt(encrypted))
+	rotated, err := EncryptSecret(encrypted)
+	require.NoError(t, err)
+	require.NotEqual(t, encrypted, rotated)
+	require.False(t, SecretNeedsReencrypt(rotated))
+
+	require.NoError(t, SetSecretEncryptionKeys("new-key"))
+	plaintext, err := DecryptSecret(rotated)
+	require.NoError(t, err)
+	require.Equal(t, `{"type":"service_account"}`, plaintext)
+
+	require.Error(t, SetSecretEncryptionKeys("", "old-key"))
+}
@@ -19,6 +19,13 @@ const (
 	ContextKeyTokenModelLimitEnabled ContextKey = "token_model_limit_enabled"
 	ContextKeyTokenModelLimit        ContextKey = "token_model_limit"
 	ContextKeyTokenCrossGroupRetry   ContextKey = "token_cross_group_retry"
+	ContextKeyTokenTpmLimit          ContextKey = "token_tpm_limit"
+	ContextKeyTokenOutputTpmLimit    ContextKey = "token_output_tpm_limit"
+	ContextKeyTokenConcurrencyLimit  ContextKey = "token_concurrency_limit"
+	ContextKeyTokenResponseCache     ContextKey = "token_response_cache"
+	ContextKeyTokenModelFallback     ContextKey = "token_model_fallback"
+	ContextKeyTokenBudget            ContextKey = "token_budget"
+	ContextKeyTokenScopes            ContextKey = "token_scopes"
 
 	/* channel related keys */
 	ContextKeyChannelId                ContextKey = "channel_id"
@@ -52,6 +59,10 @@ const (
 	ContextKeyUsingGroup  ContextKey = "group"
 	ContextKeyUserName    ContextKey = "username"
 
+	ContextKeyUserTpmLimit         ContextKey = "user_tpm_limit"
+	ContextKeyUserOutputTpmLimit   ContextKey = "user_output_tpm_limit"
+	ContextKeyUserConcurrencyLimit ContextKey = "user_concurrency_limit"
+
 	ContextKeyLocalCountTokens ContextKey = "local_count_tokens"
 
 	ContextKeySystemPromptOverride ContextKey = "system_prompt_override"
@@ -65,4 +76,30 @@ const (
 
 	// ContextKeyLanguage stores the user's language preference for i18n
 	ContextKeyLanguage ContextKey = "language"
+
+	// ContextKeyTraceSpan stores the currently active tracing span of the request
+	ContextKeyTraceSpan ContextKey = "trace_span"
+
+	// ContextKeyRateLimitSession stores the TPM / concurrency reservations held by the request
+	ContextKeyRateLimitSession ContextKey = "rate_limit_session"
+
+	// ContextKeyRequestRateLimitState stores the request-count window state computed by ModelRequestRateLimit
+	ContextKeyRequestRateLimitState ContextKey = "request_rate_limit_state"
+
+	// ContextKeyResponseCacheSession stores the response cache state reused across retries
+	ContextKeyResponseCacheSession ContextKey = "response_cache_session"
+	// ContextKeyResponseCacheHit marks requests served from the response cache
+	ContextKeyResponseCacheHit ContextKey = "response_cache_hit"
+
+	// ContextKeyModelFallbackFrom stores the originally requested model when a fallback model served the request
+	ContextKeyModelFallbackFrom ContextKey = "model_fallback_from"
+
+	// ContextKeyVirtualModel stores the virtual model name requested by the client before routing to a real model
+	ContextKeyVirtualModel ContextKey = "virtual_model"
+
+	// ContextKeyHedgeCancelledChannels stores the channel ids of hedged attempts that lost the race and were cancelled
+	ContextKeyHedgeCancelledChannels ContextKey = "hedge_cancelled_channels"
+
+	// ContextKeyChildToken stores the claims (*model.ChildTokenClaims) of the child token used by the request
+	ContextKeyChildToken ContextKey = "child_token"
 )
@@ -18,6 +18,14 @@ var ErrorLogEnabled bool
 var TaskQueryLimit int
 var TaskTimeoutMinutes int
 
+// Batch API / Files API
+var FileStorageType string
+var FileStorageDir string
+var FileMaxSizeMB int
+var BatchMaxRequests int
+var BatchConcurrency int
+var BatchWorkerCount int
+
 // temporary variable for sora patch, will be removed in future
 var TaskPricePatches []string
 
@@ -5,4 +5,6 @@ type MultiKeyMode string
 const (
 	MultiKeyModeRandom  MultiKeyMode = "random"  // 随机
 	MultiKeyModePolling MultiKeyMode = "polling" // 轮询
+	// MultiKeyModeRateLimit 按各密钥在途请求、最近一分钟用量与 429 情况挑选余量最大的密钥
+	MultiKeyModeRateLimit MultiKeyMode = "rate_limit"
 )
@@ -0,0 +1,32 @@
+package constant
+
+// 令牌作用域，限制令牌可以调用的接口类型；令牌未配置作用域时不限制
+const (
+	TokenScopeChat        = "chat"        // chat/completions、completions、responses、Claude messages、Gemini generateContent
+	TokenScopeEmbeddings  = "embeddings"  // embeddings、Gemini embedContent
+	TokenScopeImages      = "images"      // images/generations、images/edits、edits
+	TokenScopeAudio       = "audio"       // audio/speech、audio/transcriptions、audio/translations
+	TokenScopeRealtime    = "realtime"    // realtime
+	TokenScopeRerank      = "rerank"      // rerank
+	TokenScopeModerations = "moderations" // moderations
+	TokenScopeModels      = "models"      // 只读的模型列表 /v1/models
+	TokenScopeBatch       = "batch"       // files、batches
+	TokenScopeMidjourney  = "midjourney"  // Midjourney 任务
+	TokenScopeSuno        = "suno"        // Suno 任务
+	TokenScopeVideo       = "video"       // 视频任务（含 Kling、即梦）
+)
+
+var TokenScopes = []string{
+	TokenScopeChat,
+	TokenScopeEmbeddings,
+	TokenScopeImages,
+	TokenScopeAudio,
+	TokenScopeRealtime,
+	TokenScopeRerank,
+	TokenScopeModerations,
+	TokenScopeModels,
+	TokenScopeBatch,
+	TokenScopeMidjourney,
+	TokenScopeSuno,
+	TokenScopeVideo,
+}
@@ -0,0 +1,206 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const batchCompletionWindow = "24h"
+
+// batchEndpoints 支持批处理的端点，与内部 relay engine 注册的路由保持一致
+var batchEndpoints = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+	"/v1/responses":        true,
+}
+
+func optionalTimestamp(ts int64) *int64 {
+	if ts == 0 {
+		return nil
+	}
+	return &ts
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func toOpenAIBatch(batch *model.Batch) dto.OpenAIBatch {
+	result := dto.OpenAIBatch{
+		Id:               batch.BatchId,
+		Object:           "batch",
+		Endpoint:         batch.Endpoint,
+		InputFileId:      batch.InputFileId,
+		CompletionWindow: batch.CompletionWindow,
+		Status:           batch.Status,
+		OutputFileId:     optionalString(batch.OutputFileId),
+		ErrorFileId:      optionalString(batch.ErrorFileId),
+		CreatedAt:        batch.CreatedAt,
+		InProgressAt:     optionalTimestamp(batch.InProgressAt),
+		ExpiresAt:        optionalTimestamp(batch.ExpiresAt),
+		FinalizingAt:     optionalTimestamp(batch.FinalizingAt),
+		CompletedAt:      optionalTimestamp(batch.CompletedAt),
+		FailedAt:         optionalTimestamp(batch.FailedAt),
+		ExpiredAt:        optionalTimestamp(batch.ExpiredAt),
+		CancellingAt:     optionalTimestamp(batch.CancellingAt),
+		CancelledAt:      optionalTimestamp(batch.CancelledAt),
+		RequestCounts: dto.BatchRequestCounts{
+			Total:     batch.RequestTotal,
+			Completed: batch.RequestCompleted,
+			Failed:    batch.RequestFailed,
+		},
+	}
+	if batch.Errors != "" {
+		var batchErrors dto.BatchErrors
+		if err := common.UnmarshalJsonStr(batch.Errors, &batchErrors); err == nil {
+			result.Errors = &batchErrors
+		}
+	}
+	if batch.Metadata != "" {
+		_ = common.UnmarshalJsonStr(batch.Metadata, &result.Metadata)
+	}
+	return result
+}
+
+func getBatchOrAbort(c *gin.Context) *model.Batch {
+	batch, err := model.GetBatchByBatchId(c.Param("id"), c.GetInt("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondOpenAIError(c, http.StatusNotFound, "batch_not_found", fmt.Sprintf("No such Batch object: %s", c.Param("id")))
+		} else {
+			respondOpenAIError(c, http.StatusInternalServerError, "get_batch_failed", err.Error())
+		}
+		return nil
+	}
+	return batch
+}
+
+func CreateBatch(c *gin.Context) {
+	var req dto.BatchCreateRequest
+	if err := common.DecodeJson(c.Request.Body, &req); err != nil {
+		respondOpenAIError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if !batchEndpoints[req.Endpoint] {
+		respondOpenAIError(c, http.StatusBadRequest, "invalid_endpoint", fmt.Sprintf("unsupported endpoint: %s", req.Endpoint))
+		return
+	}
+	scopes := common.GetContextKeyString(c, constant.ContextKeyTokenScopes)
+	if !model.HasTokenScope(scopes, service.RequestTokenScope(http.MethodPost, req.Endpoint)) {
+		respondOpenAIError(c, http.StatusForbidden, "permission_denied", fmt.Sprintf("token scopes %s do not allow endpoint %s", scopes, req.Endpoint))
+		return
+	}
+	if req.CompletionWindow != batchCompletionWindow {
+		respondOpenAIError(c, http.StatusBadRequest, "invalid_completion_window", "completion_window must be 24h")
+		return
+	}
+	userId := c.GetInt("id")
+	inputFile, err := model.GetRelayFileByFileId(req.InputFileId, userId)
+	if err != nil {
+		respondOpenAIError(c, http.StatusBadRequest, "invalid_input_file", fmt.Sprintf("No such File object: %s", req.InputFileId))
+		return
+	}
+	if inputFile.Purpose != model.RelayFilePurposeBatch {
+		respondOpenAIError(c, http.StatusBadRequest, "invalid_input_file", "input file purpose must be batch")
+		return
+	}
+
+	now := common.GetTimestamp()
+	batch := &model.Batch{
+		BatchId:          model.NewBatchId(),
+		UserId:           userId,
+		TokenId:          c.GetInt("token_id"),
+		Endpoint:         req.Endpoint,
+		InputFileId:      inputFile.FileId,
+		CompletionWindow: req.CompletionWindow,
+		Status:           model.BatchStatusValidating,
+		CreatedAt:        now,
+		ExpiresAt:        now + 24*60*60,
+	}
+	if len(req.Metadata) > 0 {
+		metadata, err := common.Marshal(req.Metadata)
+		if err != nil {
+			respondOpenAIError(c, http.StatusBadRequest, "invalid_metadata", err.Error())
+			return
+		}
+		batch.Metadata = string(metadata)
+	}
+	if err := batch.Insert(); err != nil {
+		respondOpenAIError(c, http.StatusInternalServerError, "create_batch_failed", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIBatch(batch))
+}
+
+func GetBatch(c *gin.Context) {
+	batch := getBatchOrAbort(c)
+	if batch == nil {
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIBatch(batch))
+}
+
+func ListBatches(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	// 多取一条用于判断 has_more
+	batches, err := model.GetUserBatches(c.GetInt("id"), c.Query("after"), limit+1)
+	if err != nil {
+		respondOpenAIError(c, http.StatusInternalServerError, "list_batches_failed", err.Error())
+		return
+	}
+	hasMore := len(batches) > limit
+	if hasMore {
+		batches = batches[:limit]
+	}
+	data := make([]dto.OpenAIBatch, 0, len(batches))
+	for _, batch := range batches {
+		data = append(data, toOpenAIBatch(batch))
+	}
+	resp := gin.H{
+		"object":   "list",
+		"data":     data,
+		"has_more": hasMore,
+		"first_id": nil,
+		"last_id":  nil,
+	}
+	if len(data) > 0 {
+		resp["first_id"] = data[0].Id
+		resp["last_id"] = data[len(data)-1].Id
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func CancelBatch(c *gin.Context) {
+	batch := getBatchOrAbort(c)
+	if batch == nil {
+		return
+	}
+	ok, err := model.MarkBatchCancelling(batch)
+	if err != nil {
+		respondOpenAIError(c, http.StatusInternalServerError, "cancel_batch_failed", err.Error())
+		return
+	}
+	if !ok {
+		respondOpenAIError(c, http.StatusConflict, "batch_not_cancellable", fmt.Sprintf("Cannot cancel a batch with status '%s'", batch.Status))
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIBatch(batch))
+}
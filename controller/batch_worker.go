@@ -0,0 +1,513 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	batchPollInterval  = 5 * time.Second
+	batchWatchInterval = 3 * time.Second
+	batchClaimLimit    = 20
+)
+
+// 被限流（429）的请求行按指数退避重试，而不是直接作为失败写入错误文件；测试中可调小
+var (
+	batchThrottleRetries   = 8
+	batchThrottleBaseDelay = time.Second
+	batchThrottleMaxDelay  = time.Minute
+)
+
+var (
+	batchWorkerOnce         sync.Once
+	internalRelayEngineOnce sync.Once
+	internalRelayEngine     *gin.Engine
+	// runningBatches 本进程正在执行的批处理，key 为 Batch.Id
+	runningBatches sync.Map
+)
+
+// StartBatchWorker 启动批处理执行器，仅在主节点运行
+func StartBatchWorker() {
+	batchWorkerOnce.Do(func() {
+		if !common.IsMasterNode {
+			return
+		}
+		workerCount := constant.BatchWorkerCount
+		if workerCount < 1 {
+			workerCount = 1
+		}
+		recoverInterruptedBatches()
+		go func() {
+			common.SysLog(fmt.Sprintf("batch worker started: workers=%d, concurrency=%d", workerCount, constant.BatchConcurrency))
+			slots := make(chan struct{}, workerCount)
+			ticker := time.NewTicker(batchPollInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				runBatchWorkerOnce(slots)
+			}
+		}()
+	})
+}
+
+// recoverInterruptedBatches 进程重启后，上次未执行完的批处理无法续跑，直接标记为失败 / 已取消
+func recoverInterruptedBatches() {
+	now := common.GetTimestamp()
+	for _, status := range []string{model.BatchStatusInProgress, model.BatchStatusFinalizing} {
+		batches, err := model.GetBatchesByStatus(status, 1000)
+		if err != nil {
+			common.SysError("load interrupted batches failed: " + err.Error())
+			return
+		}
+		for _, batch := range batches {
+			batch.Status = model.BatchStatusFailed
+			batch.FailedAt = now
+			batch.Errors = marshalBatchErrors([]dto.BatchLineError{{
+				Code:    "interrupted",
+				Message: "batch was interrupted by a server restart",
+			}})
+			if _, err := batch.UpdateWithStatus(status); err != nil {
+				common.SysError(fmt.Sprintf("mark batch %s failed error: %s", batch.BatchId, err.Error()))
+			}
+		}
+	}
+	finalizeCancellingBatches()
+}
+
+func runBatchWorkerOnce(slots chan struct{}) {
+	finalizeCancellingBatches()
+
+	batches, err := model.GetBatchesByStatus(model.BatchStatusValidating, batchClaimLimit)
+	if err != nil {
+		common.SysError("load validating batches failed: " + err.Error())
+		return
+	}
+	for _, batch := range batches {
+		if _, running := runningBatches.Load(batch.Id); running {
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			return
+		}
+		runningBatches.Store(batch.Id, struct{}{})
+		b := batch
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					common.SysError(fmt.Sprintf("batch %s panic: %v", b.BatchId, r))
+				}
+				runningBatches.Delete(b.Id)
+				<-slots
+			}()
+			processBatch(b)
+		}()
+	}
+}
+
+// finalizeCancellingBatches 收尾不在本进程执行中的 cancelling 批处理（例如还未开始执行就被取消）
+func finalizeCancellingBatches() {
+	batches, err := model.GetBatchesByStatus(model.BatchStatusCancelling, batchClaimLimit)
+	if err != nil {
+		common.SysError("load cancelling batches failed: " + err.Error())
+		return
+	}
+	for _, batch := range batches {
+		if _, running := runningBatches.Load(batch.Id); running {
+			continue
+		}
+		batch.Status = model.BatchStatusCancelled
+		batch.CancelledAt = common.GetTimestamp()
+		if _, err := batch.UpdateWithStatus(model.BatchStatusCancelling); err != nil {
+			common.SysError(fmt.Sprintf("cancel batch %s failed: %s", batch.BatchId, err.Error()))
+		}
+	}
+}
+
+func marshalBatchErrors(errs []dto.BatchLineError) string {
+	data, err := common.Marshal(dto.BatchErrors{Object: "list", Data: errs})
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func failBatch(batch *model.Batch, errs []dto.BatchLineError) {
+	batch.Status = model.BatchStatusFailed
+	batch.FailedAt = common.GetTimestamp()
+	batch.Errors = marshalBatchErrors(errs)
+	if _, err := batch.UpdateWithStatus(model.BatchStatusValidating); err != nil {
+		common.SysError(fmt.Sprintf("mark batch %s failed error: %s", batch.BatchId, err.Error()))
+	}
+}
+
+// parseBatchInput 校验输入 JSONL，返回有效行或逐行的错误信息
+func parseBatchInput(content []byte, endpoint string) ([]*dto.BatchRequestLine, []dto.BatchLineError) {
+	var lines []*dto.BatchRequestLine
+	var errs []dto.BatchLineError
+	seen := make(map[string]bool)
+	lineNo := 0
+	for _, raw := range bytes.Split(content, []byte("\n")) {
+		lineNo++
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 {
+			continue
+		}
+		n := lineNo
+		addErr := func(code, message string) {
+			errs = append(errs, dto.BatchLineError{Code: code, Message: message, Line: &n})
+		}
+		var line dto.BatchRequestLine
+		if err := common.Unmarshal(raw, &line); err != nil {
+			addErr("invalid_json_line", "line is not valid JSON: "+err.Error())
+			continue
+		}
+		switch {
+		case line.CustomId == "":
+			addErr("missing_custom_id", "custom_id is required")
+		case seen[line.CustomId]:
+			addErr("duplicate_custom_id", fmt.Sprintf("custom_id %s is duplicated", line.CustomId))
+		case line.Method != http.MethodPost:
+			addErr("invalid_method", "method must be POST")
+		case line.Url != endpoint:
+			addErr("mismatched_url", fmt.Sprintf("url must be %s", endpoint))
+		case len(line.Body) == 0 || !gjson.ValidBytes(line.Body) || !gjson.ParseBytes(line.Body).IsObject():
+			addErr("invalid_body", "body must be a JSON object")
+		case gjson.GetBytes(line.Body, "stream").Bool():
+			addErr("invalid_body", "streaming is not supported in batch requests")
+		default:
+			seen[line.CustomId] = true
+			lines = append(lines, &line)
+		}
+		if len(lines) > constant.BatchMaxRequests {
+			return nil, []dto.BatchLineError{{
+				Code:    "too_many_requests",
+				Message: fmt.Sprintf("batch input exceeds the maximum of %d requests", constant.BatchMaxRequests),
+			}}
+		}
+	}
+	if len(errs) == 0 && len(lines) == 0 {
+		errs = append(errs, dto.BatchLineError{Code: "empty_file", Message: "input file contains no requests"})
+	}
+	return lines, errs
+}
+
+func processBatch(batch *model.Batch) {
+	inputFile, err := model.GetRelayFileByFileId(batch.InputFileId, batch.UserId)
+	if err != nil {
+		failBatch(batch, []dto.BatchLineError{{Code: "input_file_not_found", Message: "input file not found"}})
+		return
+	}
+	content, err := service.ReadRelayFileContent(inputFile)
+	if err != nil {
+		failBatch(batch, []dto.BatchLineError{{Code: "input_file_unreadable", Message: err.Error()}})
+		return
+	}
+	lines, errs := parseBatchInput(content, batch.Endpoint)
+	if len(errs) > 0 {
+		failBatch(batch, errs)
+		return
+	}
+
+	batch.Status = model.BatchStatusInProgress
+	batch.InProgressAt = common.GetTimestamp()
+	batch.RequestTotal = len(lines)
+	ok, err := batch.UpdateWithStatus(model.BatchStatusValidating)
+	if err != nil {
+		common.SysError(fmt.Sprintf("start batch %s failed: %s", batch.BatchId, err.Error()))
+		return
+	}
+	if !ok {
+		// 校验期间被取消，交由 finalizeCancellingBatches 收尾
+		return
+	}
+
+	results, stopStatus := executeBatchLines(batch, lines)
+	finishBatch(batch, lines, results, stopStatus)
+}
+
+// executeBatchLines 并发执行所有请求行，返回与输入同序的结果；
+// 被取消或过期时停止派发剩余请求（已发出的请求会正常完成并计费），stopStatus 为对应的终态
+func executeBatchLines(batch *model.Batch, lines []*dto.BatchRequestLine) ([]*dto.BatchResponseLine, string) {
+	results := make([]*dto.BatchResponseLine, len(lines))
+	var completed, failed atomic.Int64
+	var stopStatus atomic.Value
+	stopStatus.Store("")
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		ticker := time.NewTicker(batchWatchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := model.UpdateBatchProgress(batch.Id, int(completed.Load()), int(failed.Load())); err != nil {
+				common.SysError(fmt.Sprintf("update batch %s progress failed: %s", batch.BatchId, err.Error()))
+			}
+			if status, err := model.GetBatchStatus(batch.Id); err == nil && status == model.BatchStatusCancelling {
+				stopStatus.Store(model.BatchStatusCancelled)
+				stop()
+				return
+			}
+			if common.GetTimestamp() > batch.ExpiresAt {
+				stopStatus.Store(model.BatchStatusExpired)
+				stop()
+				return
+			}
+		}
+	}()
+
+	concurrency := constant.BatchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				result := executeBatchLineWithBackoff(ctx, batch, lines[idx])
+				if result == nil {
+					// 退避等待期间被取消或过期，按未派发处理
+					continue
+				}
+				results[idx] = result
+				if result.Error == nil && result.Response != nil && result.Response.StatusCode < http.StatusBadRequest {
+					completed.Add(1)
+				} else {
+					failed.Add(1)
+				}
+			}
+		}()
+	}
+dispatch:
+	for idx := range lines {
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case jobs <- idx:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	stop()
+	<-watcherDone
+
+	batch.RequestCompleted = int(completed.Load())
+	batch.RequestFailed = int(failed.Load())
+	return results, stopStatus.Load().(string)
+}
+
+// getInternalRelayEngine 不对外监听的 relay engine，供批处理、语义缓存等进程内调用使用
+func getInternalRelayEngine() *gin.Engine {
+	internalRelayEngineOnce.Do(func() {
+		engine := gin.New()
+		engine.Use(middleware.RelayPanicRecover())
+		engine.Use(middleware.RequestId())
+		engine.Use(middleware.BodyStorageCleanup())
+		engine.Use(middleware.RouteTag("relay"))
+		engine.Use(middleware.InternalTokenAuth())
+		// 每个子请求与普通请求一样计入用户的模型请求限流，被限流的行由 executeBatchLineWithBackoff 退避重试
+		engine.Use(middleware.ModelRequestRateLimit())
+		engine.Use(middleware.Distribute())
+		engine.POST("/v1/chat/completions", func(c *gin.Context) {
+			Relay(c, types.RelayFormatOpenAI)
+		})
+		engine.POST("/v1/completions", func(c *gin.Context) {
+			Relay(c, types.RelayFormatOpenAI)
+		})
+		engine.POST("/v1/embeddings", func(c *gin.Context) {
+			Relay(c, types.RelayFormatEmbedding)
+		})
+		engine.POST("/v1/responses", func(c *gin.Context) {
+			Relay(c, types.RelayFormatOpenAIResponses)
+		})
+		internalRelayEngine = engine
+	})
+	return internalRelayEngine
+}
+
+// executeBatchLineWithBackoff 执行单行请求，被限流时优先按 Retry-After、否则按指数退避重试；
+// 等待期间批处理被取消或过期时返回 nil
+func executeBatchLineWithBackoff(ctx context.Context, batch *model.Batch, line *dto.BatchRequestLine) *dto.BatchResponseLine {
+	delay := batchThrottleBaseDelay
+	for attempt := 0; ; attempt++ {
+		result, retryAfter := executeBatchLine(batch, line)
+		if attempt >= batchThrottleRetries || result.Response == nil || result.Response.StatusCode != http.StatusTooManyRequests {
+			return result
+		}
+		wait := delay
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		timer := time.NewTimer(min(wait, batchThrottleMaxDelay))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		delay = min(delay*2, batchThrottleMaxDelay)
+	}
+}
+
+// executeBatchLine 通过进程内 relay engine 执行单行请求，复用完整的选路、重试与计费逻辑；
+// 第二个返回值为响应中的 Retry-After
+func executeBatchLine(batch *model.Batch, line *dto.BatchRequestLine) (*dto.BatchResponseLine, time.Duration) {
+	result := &dto.BatchResponseLine{
+		Id:       "batch_req_" + common.GetRandomString(24),
+		CustomId: line.CustomId,
+	}
+	ctx := middleware.WithInternalTokenId(context.Background(), batch.TokenId)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, line.Url, bytes.NewReader(line.Body))
+	if err != nil {
+		result.Error = &dto.BatchLineError{Code: "invalid_request", Message: err.Error()}
+		return result, 0
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "127.0.0.1:0"
+
+	recorder := httptest.NewRecorder()
+	getInternalRelayEngine().ServeHTTP(recorder, req)
+
+	body := recorder.Body.Bytes()
+	if !json.Valid(body) {
+		body, _ = common.Marshal(string(body))
+	}
+	result.Response = &dto.BatchLineResponse{
+		StatusCode: recorder.Code,
+		RequestId:  recorder.Header().Get(common.RequestIdKey),
+		Body:       body,
+	}
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(recorder.Header().Get("Retry-After")); err == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	return result, retryAfter
+}
+
+func finishBatch(batch *model.Batch, lines []*dto.BatchRequestLine, results []*dto.BatchResponseLine, stopStatus string) {
+	var output, errorOutput bytes.Buffer
+	for idx, result := range results {
+		if result == nil {
+			// 取消 / 过期后未派发的请求写入错误文件
+			code, message := "batch_cancelled", "This request was not executed because the batch was cancelled."
+			if stopStatus == model.BatchStatusExpired {
+				code, message = "batch_expired", "This request could not be executed before the completion window expired."
+			}
+			result = &dto.BatchResponseLine{
+				Id:       "batch_req_" + common.GetRandomString(24),
+				CustomId: lines[idx].CustomId,
+				Error:    &dto.BatchLineError{Code: code, Message: message},
+			}
+		}
+		data, err := common.Marshal(result)
+		if err != nil {
+			continue
+		}
+		if result.Error == nil && result.Response != nil && result.Response.StatusCode < http.StatusBadRequest {
+			output.Write(data)
+			output.WriteByte('\n')
+		} else {
+			errorOutput.Write(data)
+			errorOutput.WriteByte('\n')
+		}
+	}
+
+	fromStatus := model.BatchStatusInProgress
+	if stopStatus == model.BatchStatusCancelled {
+		fromStatus = model.BatchStatusCancelling
+	}
+	if stopStatus == "" {
+		// 先进入 finalizing，避免写文件期间被取消
+		latest := reloadBatch(batch)
+		latest.Status = model.BatchStatusFinalizing
+		latest.FinalizingAt = common.GetTimestamp()
+		ok, err := latest.UpdateWithStatus(model.BatchStatusInProgress)
+		if err != nil {
+			common.SysError(fmt.Sprintf("finalize batch %s failed: %s", batch.BatchId, err.Error()))
+			return
+		}
+		if ok {
+			fromStatus = model.BatchStatusFinalizing
+		} else {
+			// 执行结束的瞬间被取消
+			stopStatus = model.BatchStatusCancelled
+			fromStatus = model.BatchStatusCancelling
+		}
+	}
+
+	latest := reloadBatch(batch)
+	if output.Len() > 0 {
+		file, err := service.SaveRelayFile(batch.UserId, model.RelayFilePurposeBatchOutput, batch.BatchId+"_output.jsonl", output.Bytes())
+		if err != nil {
+			common.SysError(fmt.Sprintf("save batch %s output failed: %s", batch.BatchId, err.Error()))
+		} else {
+			latest.OutputFileId = file.FileId
+		}
+	}
+	if errorOutput.Len() > 0 {
+		file, err := service.SaveRelayFile(batch.UserId, model.RelayFilePurposeBatchOutput, batch.BatchId+"_error.jsonl", errorOutput.Bytes())
+		if err != nil {
+			common.SysError(fmt.Sprintf("save batch %s error file failed: %s", batch.BatchId, err.Error()))
+		} else {
+			latest.ErrorFileId = file.FileId
+		}
+	}
+
+	now := common.GetTimestamp()
+	switch stopStatus {
+	case model.BatchStatusCancelled:
+		latest.Status = model.BatchStatusCancelled
+		latest.CancelledAt = now
+	case model.BatchStatusExpired:
+		latest.Status = model.BatchStatusExpired
+		latest.ExpiredAt = now
+	default:
+		latest.Status = model.BatchStatusCompleted
+		latest.CompletedAt = now
+	}
+	if _, err := latest.UpdateWithStatus(fromStatus); err != nil {
+		common.SysError(fmt.Sprintf("complete batch %s failed: %s", batch.BatchId, err.Error()))
+	}
+}
+
+// reloadBatch 读取数据库中的最新记录（取消时间等字段可能被 API 并发写入），并带上本地的执行计数
+func reloadBatch(batch *model.Batch) *model.Batch {
+	latest, err := model.GetBatchByBatchId(batch.BatchId, batch.UserId)
+	if err != nil {
+		return batch
+	}
+	latest.RequestTotal = batch.RequestTotal
+	latest.RequestCompleted = batch.RequestCompleted
+	latest.RequestFailed = batch.RequestFailed
+	return latest
+}
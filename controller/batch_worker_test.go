@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBatchInput(t *testing.T) {
+	constant.BatchMaxRequests = 10
+	content := []byte(`{"custom_id":"a","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o"}}
+
+{"custom_id":"b","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o"}}
+`)
+	lines, errs := parseBatchInput(content, "/v1/chat/completions")
+	require.Empty(t, errs)
+	require.Len(t, lines, 2)
+	require.Equal(t, "a", lines[0].CustomId)
+	require.Equal(t, "b", lines[1].CustomId)
+}
+
+func TestParseBatchInputErrors(t *testing.T) {
+	constant.BatchMaxRequests = 10
+	content := []byte(`{"custom_id":"a","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o"}}
+{"custom_id":"a","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o"}}
+{"custom_id":"c","method":"GET","url":"/v1/chat/completions","body":{"model":"gpt-4o"}}
+{"custom_id":"d","method":"POST","url":"/v1/embeddings","body":{"model":"gpt-4o"}}
+{"custom_id":"e","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o","stream":true}}
+not json`)
+	_, errs := parseBatchInput(content, "/v1/chat/completions")
+	require.Len(t, errs, 5)
+	codes := make([]string, 0, len(errs))
+	for _, e := range errs {
+		codes = append(codes, e.Code)
+	}
+	require.Equal(t, []string{"duplicate_custom_id", "invalid_method", "mismatched_url", "invalid_body", "invalid_json_line"}, codes)
+	require.Equal(t, 2, *errs[0].Line)
+	require.Equal(t, 6, *errs[4].Line)
+}
+
+func TestParseBatchInputTooMany(t *testing.T) {
+	constant.BatchMaxRequests = 1
+	content := []byte(`{"custom_id":"a","method":"POST","url":"/v1/embeddings","body":{"input":"x"}}
+{"custom_id":"b","method":"POST","url":"/v1/embeddings","body":{"input":"y"}}`)
+	lines, errs := parseBatchInput(content, "/v1/embeddings")
+	require.Nil(t, lines)
+	require.Len(t, errs, 1)
+	require.Equal(t, "too_many_requests", errs[0].Code)
+}
+
+func readBatchOutputLines(t *testing.T, fileId string, userId int) map[string]*dto.BatchResponseLine {
+	t.Helper()
+	require.NotEmpty(t, fileId)
+	file, err := model.GetRelayFileByFileId(fileId, userId)
+	require.NoError(t, err)
+	content, err := service.ReadRelayFileContent(file)
+	require.NoError(t, err)
+	lines := make(map[string]*dto.BatchResponseLine)
+	for _, raw := range bytes.Split(bytes.TrimSpace(content), []byte("\n")) {
+		var line dto.BatchResponseLine
+		require.NoError(t, common.Unmarshal(raw, &line))
+		lines[line.CustomId] = &line
+	}
+	return lines
+}
+
+func TestProcessBatchRetriesThrottledLines(t *testing.T) {
+	db := setupRelayTestDB(t, "batch-worker")
+	require.NoError(t, db.AutoMigrate(&model.Batch{}, &model.RelayFile{}, &model.RelayFileContent{}))
+	savedStorage, savedConcurrency, savedMax, savedRetryTimes := constant.FileStorageType, constant.BatchConcurrency, constant.BatchMaxRequests, common.RetryTimes
+	savedDelay := batchThrottleBaseDelay
+	t.Cleanup(func() {
+		constant.FileStorageType, constant.BatchConcurrency, constant.BatchMaxRequests, common.RetryTimes = savedStorage, savedConcurrency, savedMax, savedRetryTimes
+		batchThrottleBaseDelay = savedDelay
+	})
+	constant.FileStorageType = model.RelayFileStorageDB
+	constant.BatchConcurrency = 2
+	constant.BatchMaxRequests = 10
+	common.RetryTimes = 0
+	batchThrottleBaseDelay = time.Millisecond
+
+	var throttledCalls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.Contains(string(body), "bad request"):
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = io.WriteString(w, `{"error":{"message":"invalid prompt","type":"invalid_request_error"}}`)
+			return
+		case strings.Contains(string(body), "throttled") && throttledCalls.Add(1) <= 2:
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = io.WriteString(w, `{"error":{"message":"rate limited","type":"rate_limit_error"}}`)
+			return
+		}
+		_, _ = io.WriteString(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o-mini",`+
+			`"choices":[{"index":0,"message":{"role":"assistant","content":"hello"},"finish_reason":"stop"}],`+
+			`"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`)
+	}))
+	t.Cleanup(upstream.Close)
+
+	user := &model.User{Id: 1, Username: "batch_user", Password: "password123", Quota: 1000000, Status: common.UserStatusEnabled, Group: "default", Role: common.RoleCommonUser}
+	require.NoError(t, db.Create(user).Error)
+	token := seedToken(t, db, user.Id, "batch-token", "batchworkertoken0001")
+	baseURL := upstream.URL
+	channel := &model.Channel{Id: 1, Type: constant.ChannelTypeOpenAI, Name: "batch-channel", Key: "sk-upstream", BaseURL: &baseURL,
+		Status: common.ChannelStatusEnabled, Models: "gpt-4o-mini", Group: "default"}
+	require.NoError(t, db.Create(channel).Error)
+	require.NoError(t, channel.AddAbilities(nil))
+
+	input := strings.Join([]string{
+		`{"custom_id":"ok","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o-mini","messages":[{"role":"user","content":"hi"}]}}`,
+		`{"custom_id":"throttled","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o-mini","messages":[{"role":"user","content":"throttled"}]}}`,
+		`{"custom_id":"bad","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o-mini","messages":[{"role":"user","content":"bad request"}]}}`,
+	}, "\n")
+	inputFile, err := service.SaveRelayFile(user.Id, model.RelayFilePurposeBatch, "input.jsonl", []byte(input))
+	require.NoError(t, err)
+	batch := &model.Batch{
+		BatchId:     "batch_worker_test",
+		UserId:      user.Id,
+		TokenId:     token.Id,
+		Endpoint:    "/v1/chat/completions",
+		InputFileId: inputFile.FileId,
+		Status:      model.BatchStatusValidating,
+		CreatedAt:   common.GetTimestamp(),
+		ExpiresAt:   common.GetTimestamp() + 3600,
+	}
+	require.NoError(t, batch.Insert())
+
+	processBatch(batch)
+
+	latest, err := model.GetBatchByBatchId(batch.BatchId, user.Id)
+	require.NoError(t, err)
+	require.Equal(t, model.BatchStatusCompleted, latest.Status)
+	require.Equal(t, 3, latest.RequestTotal)
+	require.Equal(t, 2, latest.RequestCompleted)
+	require.Equal(t, 1, latest.RequestFailed)
+	// 被限流两次后重试成功，不写入错误文件
+	require.Equal(t, int32(3), throttledCalls.Load())
+
+	output := readBatchOutputLines(t, latest.OutputFileId, user.Id)
+	require.Len(t, output, 2)
+	for _, customId := range []string{"ok", "throttled"} {
+		line := output[customId]
+		require.NotNil(t, line, customId)
+		require.Nil(t, line.Error)
+		require.Equal(t, http.StatusOK, line.Response.StatusCode)
+		require.Contains(t, string(line.Response.Body), `"chat.completion"`)
+	}
+
+	errorLines := readBatchOutputLines(t, latest.ErrorFileId, user.Id)
+	require.Len(t, errorLines, 1)
+	require.NotNil(t, errorLines["bad"])
+	require.Equal(t, http.StatusBadRequest, errorLines["bad"].Response.StatusCode)
+}
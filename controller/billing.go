@@ -14,12 +14,22 @@ func GetSubscription(c *gin.Context) {
 	var err error
 	var token *model.Token
 	var expiredTime int64
+	budgeted := false
 	if common.DisplayTokenStatEnabled {
 		tokenId := c.GetInt("token_id")
 		token, err = model.GetTokenById(tokenId)
 		expiredTime = token.ExpiredTime
 		remainQuota = token.RemainQuota
 		usedQuota = token.UsedQuota
+		// 配置了周期预算时按当前周期展示：上限为本周期可用额度，用量为本周期已用
+		if err == nil && token.HasBudget() {
+			budgeted = true
+			usedQuota = token.GetBudgetUsed(common.GetTimestamp())
+			budgetRemain := max(token.BudgetLimit-usedQuota, 0)
+			if token.UnlimitedQuota || budgetRemain < remainQuota {
+				remainQuota = budgetRemain
+			}
+		}
 	} else {
 		userId := c.GetInt("id")
 		remainQuota, err = model.GetUserQuota(userId, false)
@@ -53,7 +63,7 @@ func GetSubscription(c *gin.Context) {
 	default:
 		amount = amount / common.QuotaPerUnit
 	}
-	if token != nil && token.UnlimitedQuota {
+	if token != nil && token.UnlimitedQuota && !budgeted {
 		amount = 100000000
 	}
 	subscription := OpenAISubscriptionResponse{
@@ -64,6 +74,10 @@ func GetSubscription(c *gin.Context) {
 		SystemHardLimitUSD: amount,
 		AccessUntil:        expiredTime,
 	}
+	if budgeted {
+		subscription.BudgetPeriod = token.BudgetPeriod
+		subscription.BudgetResetAt = token.BudgetResetTime
+	}
 	c.JSON(200, subscription)
 	return
 }
@@ -76,6 +90,9 @@ func GetUsage(c *gin.Context) {
 		tokenId := c.GetInt("token_id")
 		token, err = model.GetTokenById(tokenId)
 		quota = token.UsedQuota
+		if err == nil && token.HasBudget() {
+			quota = token.GetBudgetUsed(common.GetTimestamp())
+		}
 	} else {
 		userId := c.GetInt("id")
 		quota, err = model.GetUserUsedQuota(userId)
@@ -30,6 +30,9 @@ type OpenAISubscriptionResponse struct {
 	HardLimitUSD       float64 `json:"hard_limit_usd"`
 	SystemHardLimitUSD float64 `json:"system_hard_limit_usd"`
 	AccessUntil        int64   `json:"access_until"`
+	// 令牌配置了周期预算时返回，限额与用量均为当前周期
+	BudgetPeriod  string `json:"budget_period,omitempty"`
+	BudgetResetAt int64  `json:"budget_reset_at,omitempty"`
 }
 
 type OpenAIUsageDailyCost struct {
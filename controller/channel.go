@@ -13,6 +13,10 @@ import (
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/pkg/channelhealth"
+	"github.com/QuantumNous/new-api/pkg/circuitbreaker"
+	"github.com/QuantumNous/new-api/pkg/cooldown"
+	"github.com/QuantumNous/new-api/pkg/keystats"
 	relaychannel "github.com/QuantumNous/new-api/relay/channel"
 	"github.com/QuantumNous/new-api/relay/channel/gemini"
 	"github.com/QuantumNous/new-api/relay/channel/ollama"
@@ -68,6 +72,16 @@ func clearChannelInfo(channel *model.Channel) {
 	}
 }
 
+// fillChannelRuntimeStatus 附加仅存在于内存 / Redis 中的运行时状态
+func fillChannelRuntimeStatus(channel *model.Channel) {
+	channel.CircuitBreaker = circuitbreaker.GetStatuses(channel.Id)
+	channel.Cooldown = cooldown.GetStatuses(channel.Id)
+	if channel.ChannelInfo.IsMultiKey {
+		channel.KeyStats = keystats.GetStatuses(channel.Id)
+	}
+	channel.Budget = model.GetChannelBudgetStatus(channel.Id)
+}
+
 func GetAllChannels(c *gin.Context) {
 	pageInfo := common.GetPageQuery(c)
 	channelData := make([]*model.Channel, 0)
@@ -146,6 +160,7 @@ func GetAllChannels(c *gin.Context) {
 
 	for _, datum := range channelData {
 		clearChannelInfo(datum)
+		fillChannelRuntimeStatus(datum)
 	}
 
 	countQuery := model.DB.Model(&model.Channel{})
@@ -245,6 +260,24 @@ func FixChannelsAbilities(c *gin.Context) {
 	})
 }
 
+// ReencryptSecrets 使用当前主密钥重新加密渠道密钥与敏感配置项，轮换主密钥后调用
+func ReencryptSecrets(c *gin.Context) {
+	if !common.SecretEncryptionEnabled() {
+		common.ApiErrorMsg(c, "未配置 SECRET_ENCRYPTION_KEY，无法加密")
+		return
+	}
+	result, err := model.ReencryptSecrets()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    result,
+	})
+}
+
 func SearchChannels(c *gin.Context) {
 	keyword := c.Query("keyword")
 	group := c.Query("group")
@@ -344,6 +377,7 @@ func SearchChannels(c *gin.Context) {
 
 	for _, datum := range pagedData {
 		clearChannelInfo(datum)
+		fillChannelRuntimeStatus(datum)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -371,6 +405,7 @@ func GetChannel(c *gin.Context) {
 	}
 	if channel != nil {
 		clearChannelInfo(channel)
+		fillChannelRuntimeStatus(channel)
 	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -960,8 +995,16 @@ func UpdateChannel(c *gin.Context) {
 	}
 	model.InitChannelCache()
 	service.ResetProxyClientCache()
+	// 覆盖密钥后下标不再对应，追加不影响已有密钥
+	if channel.Key != "" && (channel.KeyMode == nil || *channel.KeyMode != "append") {
+		keystats.Reset(channel.Id)
+		if err := model.DeleteChannelKeyUsages(channel.Id); err != nil {
+			common.SysError(fmt.Sprintf("failed to reset key usages of channel #%d: %s", channel.Id, err.Error()))
+		}
+	}
 	channel.Key = ""
 	clearChannelInfo(&channel.Channel)
+	fillChannelRuntimeStatus(&channel.Channel)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -1210,11 +1253,13 @@ func CopyChannel(c *gin.Context) {
 // MultiKeyManageRequest represents the request for multi-key management operations
 type MultiKeyManageRequest struct {
 	ChannelId int    `json:"channel_id"`
-	Action    string `json:"action"`              // "disable_key", "enable_key", "delete_key", "delete_disabled_keys", "get_key_status"
-	KeyIndex  *int   `json:"key_index,omitempty"` // for disable_key, enable_key, and delete_key actions
+	Action    string `json:"action"`              // "disable_key", "enable_key", "delete_key", "delete_disabled_keys", "get_key_status", "set_key_quota_limit", "reset_key_usage"
+	KeyIndex  *int   `json:"key_index,omitempty"` // for disable_key, enable_key, delete_key, set_key_quota_limit and reset_key_usage actions
 	Page      int    `json:"page,omitempty"`      // for get_key_status pagination
 	PageSize  int    `json:"page_size,omitempty"` // for get_key_status pagination
 	Status    *int   `json:"status,omitempty"`    // for get_key_status filtering: 1=enabled, 2=manual_disabled, 3=auto_disabled, nil=all
+	// QuotaLimit for set_key_quota_limit: 密钥累计额度上限，0 表示不限
+	QuotaLimit *int `json:"quota_limit,omitempty"`
 }
 
 // MultiKeyStatusResponse represents the response for key status query
@@ -1236,6 +1281,11 @@ type KeyStatus struct {
 	DisabledTime int64  `json:"disabled_time,omitempty"`
 	Reason       string `json:"reason,omitempty"`
 	KeyPreview   string `json:"key_preview"` // first 10 chars of key for identification
+	// Stats 本节点上该密钥的用量统计，没有流量时为空
+	Stats *keystats.Status `json:"stats,omitempty"`
+	// UsedQuota 累计消耗额度，QuotaLimit 为额度上限（0 表示不限）
+	UsedQuota  int64 `json:"used_quota"`
+	QuotaLimit int   `json:"quota_limit,omitempty"`
 }
 
 // ManageMultiKeys handles multi-key management operations
@@ -1285,6 +1335,16 @@ func ManageMultiKeys(c *gin.Context) {
 		// Statistics for all keys (unchanged by filtering)
 		var enabledCount, manualDisabledCount, autoDisabledCount int
 
+		keyStats := make(map[int]keystats.Status)
+		for _, stats := range keystats.GetStatuses(channel.Id) {
+			keyStats[stats.KeyIndex] = stats
+		}
+		keyUsages, err := model.GetChannelKeyUsages(channel.Id)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+
 		// Build all key status data first
 		var allKeyStatusList []KeyStatus
 		for i, key := range keys {
@@ -1323,13 +1383,19 @@ func ManageMultiKeys(c *gin.Context) {
 				keyPreview = key[:10] + "..."
 			}
 
-			allKeyStatusList = append(allKeyStatusList, KeyStatus{
+			keyStatus := KeyStatus{
 				Index:        i,
 				Status:       status,
 				DisabledTime: disabledTime,
 				Reason:       reason,
 				KeyPreview:   keyPreview,
-			})
+				UsedQuota:    keyUsages[i].UsedQuota,
+				QuotaLimit:   channel.ChannelInfo.MultiKeyQuotaLimit[i],
+			}
+			if stats, ok := keyStats[i]; ok {
+				keyStatus.Stats = &stats
+			}
+			allKeyStatusList = append(allKeyStatusList, keyStatus)
 		}
 
 		// Apply status filter if specified
@@ -1468,6 +1534,74 @@ func ManageMultiKeys(c *gin.Context) {
 		})
 		return
 
+	case "set_key_quota_limit":
+		if request.KeyIndex == nil || request.QuotaLimit == nil || *request.QuotaLimit < 0 {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "未指定密钥索引或额度上限",
+			})
+			return
+		}
+
+		keyIndex := *request.KeyIndex
+		if keyIndex < 0 || keyIndex >= channel.ChannelInfo.MultiKeySize {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "密钥索引超出范围",
+			})
+			return
+		}
+
+		if channel.ChannelInfo.MultiKeyQuotaLimit == nil {
+			channel.ChannelInfo.MultiKeyQuotaLimit = make(map[int]int)
+		}
+		if *request.QuotaLimit == 0 {
+			delete(channel.ChannelInfo.MultiKeyQuotaLimit, keyIndex)
+		} else {
+			channel.ChannelInfo.MultiKeyQuotaLimit[keyIndex] = *request.QuotaLimit
+		}
+
+		err = channel.Update()
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+
+		model.InitChannelCache()
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "密钥额度上限已更新",
+		})
+		return
+
+	case "reset_key_usage":
+		if request.KeyIndex == nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "未指定要重置的密钥索引",
+			})
+			return
+		}
+
+		keyIndex := *request.KeyIndex
+		if keyIndex < 0 || keyIndex >= channel.ChannelInfo.MultiKeySize {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "密钥索引超出范围",
+			})
+			return
+		}
+
+		if err := model.ResetChannelKeyUsage(channel.Id, keyIndex); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "密钥用量已重置",
+		})
+		return
+
 	case "enable_all_keys":
 		// 清空所有禁用状态，使所有密钥回到默认启用状态
 		var enabledCount int
@@ -1562,6 +1696,8 @@ func ManageMultiKeys(c *gin.Context) {
 		var newStatusList = make(map[int]int)
 		var newDisabledTime = make(map[int]int64)
 		var newDisabledReason = make(map[int]string)
+		var newQuotaLimit = make(map[int]int)
+		var oldToNew = make(map[int]int)
 
 		newIndex := 0
 		for i, key := range keys {
@@ -1571,6 +1707,10 @@ func ManageMultiKeys(c *gin.Context) {
 			}
 
 			remainingKeys = append(remainingKeys, key)
+			oldToNew[i] = newIndex
+			if limit, exists := channel.ChannelInfo.MultiKeyQuotaLimit[i]; exists {
+				newQuotaLimit[newIndex] = limit
+			}
 
 			// 保留其他密钥的状态信息，重新索引
 			if channel.ChannelInfo.MultiKeyStatusList != nil {
@@ -1605,13 +1745,19 @@ func ManageMultiKeys(c *gin.Context) {
 		channel.ChannelInfo.MultiKeyStatusList = newStatusList
 		channel.ChannelInfo.MultiKeyDisabledTime = newDisabledTime
 		channel.ChannelInfo.MultiKeyDisabledReason = newDisabledReason
+		channel.ChannelInfo.MultiKeyQuotaLimit = newQuotaLimit
 
 		err = channel.Update()
 		if err != nil {
 			common.ApiError(c, err)
 			return
 		}
+		if err := model.RemapChannelKeyUsages(channel.Id, oldToNew); err != nil {
+			common.SysError(fmt.Sprintf("failed to remap key usages of channel #%d: %s", channel.Id, err.Error()))
+		}
 
+		// 密钥下标已变化，之前的统计不再对应
+		keystats.Reset(channel.Id)
 		model.InitChannelCache()
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -1626,6 +1772,8 @@ func ManageMultiKeys(c *gin.Context) {
 		var newStatusList = make(map[int]int)
 		var newDisabledTime = make(map[int]int64)
 		var newDisabledReason = make(map[int]string)
+		var newQuotaLimit = make(map[int]int)
+		var oldToNew = make(map[int]int)
 
 		newIndex := 0
 		for i, key := range keys {
@@ -1641,6 +1789,10 @@ func ManageMultiKeys(c *gin.Context) {
 				deletedCount++
 			} else {
 				remainingKeys = append(remainingKeys, key)
+				oldToNew[i] = newIndex
+				if limit, exists := channel.ChannelInfo.MultiKeyQuotaLimit[i]; exists {
+					newQuotaLimit[newIndex] = limit
+				}
 				// 保留非自动禁用密钥的状态信息，重新索引
 				if status != 1 {
 					newStatusList[newIndex] = status
@@ -1673,13 +1825,18 @@ func ManageMultiKeys(c *gin.Context) {
 		channel.ChannelInfo.MultiKeyStatusList = newStatusList
 		channel.ChannelInfo.MultiKeyDisabledTime = newDisabledTime
 		channel.ChannelInfo.MultiKeyDisabledReason = newDisabledReason
+		channel.ChannelInfo.MultiKeyQuotaLimit = newQuotaLimit
 
 		err = channel.Update()
 		if err != nil {
 			common.ApiError(c, err)
 			return
 		}
+		if err := model.RemapChannelKeyUsages(channel.Id, oldToNew); err != nil {
+			common.SysError(fmt.Sprintf("failed to remap key usages of channel #%d: %s", channel.Id, err.Error()))
+		}
 
+		keystats.Reset(channel.Id)
 		model.InitChannelCache()
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -1955,3 +2112,9 @@ func OllamaVersion(c *gin.Context) {
 		},
 	})
 }
+
+// GetChannelHealth 返回自适应选路统计的渠道健康快照，channel_id 为空时返回全部
+func GetChannelHealth(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	common.ApiSuccess(c, channelhealth.GetSnapshots(channelId))
+}
@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/relay"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RelayGemini 处理 /models/{model}:{action}，countTokens 不计费单独处理，其余走通用 relay
+func RelayGemini(c *gin.Context) {
+	if relayconstant.Path2RelayMode(c.Request.URL.Path) == relayconstant.RelayModeGeminiCountTokens {
+		RelayGeminiCountTokens(c)
+		return
+	}
+	Relay(c, types.RelayFormatGemini)
+}
+
+func RelayGeminiCountTokens(c *gin.Context) {
+	var newAPIError *types.NewAPIError
+	defer func() {
+		if newAPIError != nil {
+			logger.LogError(c, fmt.Sprintf("count tokens error: %s", newAPIError.Error()))
+			writeGeminiResourceError(c, newAPIError)
+		}
+	}()
+
+	request, err := helper.GetGeminiResourceRequest(c)
+	if err != nil {
+		newAPIError = types.NewError(err, types.ErrorCodeInvalidRequest)
+		return
+	}
+
+	relayInfo, err := relaycommon.GenRelayInfo(c, types.RelayFormatGemini, request, nil)
+	if err != nil {
+		newAPIError = types.NewError(err, types.ErrorCodeGenRelayInfoFailed)
+		return
+	}
+
+	newAPIError = relay.GeminiCountTokensHelper(c, relayInfo)
+}
+
+func GetGeminiCachedContent(c *gin.Context) {
+	binding, ok := getGeminiCachedContentBinding(c)
+	if !ok {
+		return
+	}
+	body, newAPIError := doGeminiCachedContentRequest(c, binding)
+	if newAPIError != nil {
+		writeGeminiResourceError(c, newAPIError)
+		return
+	}
+	c.Data(http.StatusOK, "application/json", body)
+}
+
+func DeleteGeminiCachedContent(c *gin.Context) {
+	binding, ok := getGeminiCachedContentBinding(c)
+	if !ok {
+		return
+	}
+	body, newAPIError := doGeminiCachedContentRequest(c, binding)
+	if newAPIError != nil {
+		writeGeminiResourceError(c, newAPIError)
+		return
+	}
+	service.UnbindGeminiCachedContent(binding)
+	c.Data(http.StatusOK, "application/json", body)
+}
+
+// ListGeminiCachedContents 只列出当前用户通过本站创建的缓存，逐个到创建渠道查询，已失效的跳过
+func ListGeminiCachedContents(c *gin.Context) {
+	bindings := service.ListGeminiCachedContentBindings(c.GetInt("id"))
+	cachedContents := make([]any, 0, len(bindings))
+	for _, binding := range bindings {
+		body, newAPIError := doGeminiCachedContentRequest(c, binding)
+		if newAPIError != nil {
+			logger.LogWarn(c, fmt.Sprintf("get cached content %s failed: %s", binding.Name, newAPIError.Error()))
+			continue
+		}
+		var item map[string]any
+		if err := common.Unmarshal(body, &item); err != nil {
+			continue
+		}
+		cachedContents = append(cachedContents, item)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"cachedContents": cachedContents,
+	})
+}
+
+func getGeminiCachedContentBinding(c *gin.Context) (service.GeminiCachedContentBinding, bool) {
+	name := "cachedContents/" + c.Param("id")
+	binding, found := service.GetGeminiCachedContentBinding(c.GetInt("id"), name)
+	if !found {
+		writeGeminiResourceError(c, types.NewErrorWithStatusCode(
+			fmt.Errorf("cached content %s not found", name),
+			types.ErrorCodeInvalidRequest, http.StatusNotFound, types.ErrOptionWithSkipRetry()))
+		return binding, false
+	}
+	return binding, true
+}
+
+// doGeminiCachedContentRequest 上下文缓存只存在于创建它的渠道，因此固定转发到绑定渠道
+func doGeminiCachedContentRequest(c *gin.Context, binding service.GeminiCachedContentBinding) ([]byte, *types.NewAPIError) {
+	channel, err := model.CacheGetChannel(binding.ChannelId)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
+	}
+	if channel.Status != common.ChannelStatusEnabled {
+		return nil, types.NewError(errors.New("the channel of this cached content is disabled"), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
+	}
+	if newAPIError := middleware.SetupContextForSelectedChannel(c, channel, binding.Model); newAPIError != nil {
+		return nil, newAPIError
+	}
+	relayInfo, err := relaycommon.GenRelayInfo(c, types.RelayFormatGemini, &dto.GeminiChatRequest{}, nil)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeGenRelayInfoFailed)
+	}
+	relayInfo.OriginModelName = binding.Model
+	relayInfo.UpstreamModelName = binding.Model
+	return relay.DoGeminiCachedContentRequest(c, relayInfo, binding.Name)
+}
+
+func writeGeminiResourceError(c *gin.Context, newAPIError *types.NewAPIError) {
+	newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), c.GetString(common.RequestIdKey)))
+	c.JSON(newAPIError.StatusCode, gin.H{
+		"error": newAPIError.ToOpenAIError(),
+	})
+}
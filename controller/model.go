@@ -3,6 +3,8 @@ package controller
 import (
 	"fmt"
 	"net/http"
+	"slices"
+	"sort"
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
@@ -202,6 +204,8 @@ func ListModels(c *gin.Context, modelType int) {
 		}
 	}
 
+	userOpenAiModels = appendVirtualModels(c, userOpenAiModels)
+
 	switch modelType {
 	case constant.ChannelTypeAnthropic:
 		useranthropicModels := make([]dto.AnthropicModel, len(userOpenAiModels))
@@ -240,6 +244,35 @@ func ListModels(c *gin.Context, modelType int) {
 	}
 }
 
+// appendVirtualModels 虚拟模型没有渠道和倍率，单独追加；令牌限制了模型时只展示允许的虚拟模型
+func appendVirtualModels(c *gin.Context, models []dto.OpenAIModels) []dto.OpenAIModels {
+	virtualModels := service.GetVirtualModelNames()
+	if len(virtualModels) == 0 {
+		return models
+	}
+	sort.Strings(virtualModels)
+	var tokenModelLimit map[string]bool
+	modelLimitEnable := common.GetContextKeyBool(c, constant.ContextKeyTokenModelLimitEnabled)
+	if modelLimitEnable {
+		tokenModelLimit, _ = common.GetContextKeyType[map[string]bool](c, constant.ContextKeyTokenModelLimit)
+	}
+	for _, virtualModel := range virtualModels {
+		if modelLimitEnable && !tokenModelLimit[virtualModel] {
+			continue
+		}
+		if slices.ContainsFunc(models, func(m dto.OpenAIModels) bool { return m.Id == virtualModel }) {
+			continue
+		}
+		models = append(models, dto.OpenAIModels{
+			Id:      virtualModel,
+			Object:  "model",
+			Created: 1626777600,
+			OwnedBy: "virtual",
+		})
+	}
+	return models
+}
+
 func ChannelListModels(c *gin.Context) {
 	c.JSON(200, gin.H{
 		"success": true,
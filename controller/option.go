@@ -66,11 +66,7 @@ func GetOptions(c *gin.Context) {
 	common.OptionMapRWMutex.Lock()
 	for k, v := range common.OptionMap {
 		value := common.Interface2String(v)
-		if strings.HasSuffix(k, "Token") ||
-			strings.HasSuffix(k, "Secret") ||
-			strings.HasSuffix(k, "Key") ||
-			strings.HasSuffix(k, "secret") ||
-			strings.HasSuffix(k, "api_key") {
+		if model.IsSecretOptionKey(k) {
 			continue
 		}
 		options = append(options, &model.Option{
@@ -123,6 +119,13 @@ func UpdateOption(c *gin.Context) {
 		option.Value = fmt.Sprintf("%v", option.Value)
 	}
 	switch option.Key {
+	case model.TokenHashSecretOptionKey:
+		// 修改后所有已哈希的令牌都会失效
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "该配置项不允许修改",
+		})
+		return
 	case "GitHubOAuthEnabled":
 		if option.Value == "true" && common.GitHubClientId == "" {
 			c.JSON(http.StatusOK, gin.H{
@@ -15,6 +15,10 @@ import (
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/middleware"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/pkg/channelhealth"
+	"github.com/QuantumNous/new-api/pkg/circuitbreaker"
+	"github.com/QuantumNous/new-api/pkg/keystats"
+	"github.com/QuantumNous/new-api/pkg/metrics"
 	"github.com/QuantumNous/new-api/relay"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	relayconstant "github.com/QuantumNous/new-api/relay/constant"
@@ -56,7 +60,9 @@ func relayHandler(c *gin.Context, info *relaycommon.RelayInfo) *types.NewAPIErro
 
 func geminiRelayHandler(c *gin.Context, info *relaycommon.RelayInfo) *types.NewAPIError {
 	var err *types.NewAPIError
-	if strings.Contains(c.Request.URL.Path, "embed") {
+	if info.RelayMode == relayconstant.RelayModeGeminiCachedContents {
+		err = relay.GeminiCachedContentCreateHelper(c, info)
+	} else if strings.Contains(c.Request.URL.Path, "embed") {
 		err = relay.GeminiEmbeddingHandler(c, info)
 	} else {
 		err = relay.GeminiHelper(c, info)
@@ -122,11 +128,21 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		return
 	}
 
+	defer func() {
+		statusCode := c.Writer.Status()
+		if newAPIError != nil {
+			statusCode = newAPIError.StatusCode
+		}
+		metrics.ObserveRelayRequest(string(relayFormat), relayInfo.OriginModelName, relayInfo.UsingGroup, relayInfo.TokenId, statusCode, relayInfo.IsStream, time.Since(relayInfo.StartTime))
+	}()
+
 	needSensitiveCheck := setting.ShouldCheckPromptSensitive()
 	needCountToken := constant.CountToken
 	// Avoid building huge CombineText (strings.Join) when token counting and sensitive check are both disabled.
+	// 虚拟模型的路由条件依赖完整的 token 统计信息（图片、工具、长度）
+	isVirtualModel := service.IsVirtualModel(relayInfo.OriginModelName)
 	var meta *types.TokenCountMeta
-	if needSensitiveCheck || needCountToken {
+	if needSensitiveCheck || needCountToken || isVirtualModel {
 		meta = request.GetTokenCountMeta()
 	} else {
 		meta = fastTokenCountMetaForPricing(request)
@@ -149,23 +165,25 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 
 	relayInfo.SetEstimatePromptTokens(tokens)
 
-	priceData, err := helper.ModelPriceHelper(c, relayInfo, tokens, meta)
-	if err != nil {
-		newAPIError = types.NewError(err, types.ErrorCodeModelPriceError)
-		return
-	}
-
-	// common.SetContextKey(c, constant.ContextKeyTokenCountMeta, meta)
-
-	if priceData.FreeModel {
-		logger.LogInfo(c, fmt.Sprintf("模型 %s 免费，跳过预扣费", relayInfo.OriginModelName))
-	} else {
-		newAPIError = service.PreConsumeBilling(c, priceData.QuotaToPreConsume, relayInfo)
-		if newAPIError != nil {
+	if isVirtualModel {
+		targetModel, err := service.ResolveVirtualModel(c, relayInfo, meta)
+		if err != nil {
+			newAPIError = types.NewErrorWithStatusCode(err, types.ErrorCodeModelNotFound, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
 			return
 		}
+		logger.LogInfo(c, fmt.Sprintf("虚拟模型 %s 路由到 %s", relayInfo.OriginModelName, targetModel))
+		switchRelayModel(c, relayInfo, targetModel)
 	}
 
+	newAPIError = service.AcquireRateLimit(c, relayInfo, tokens)
+	service.SetRateLimitHeaders(c, relayInfo)
+	if newAPIError != nil {
+		return
+	}
+	defer func() {
+		service.ReleaseRateLimit(c, newAPIError != nil)
+	}()
+
 	defer func() {
 		// Only return quota if downstream failed and quota was actually pre-consumed
 		if newAPIError != nil {
@@ -177,6 +195,54 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 	}()
 
+	// Distribute 阶段可能已经因为无可用渠道降级过一次，降级链始终按客户端请求的模型计算
+	requestedModel := relayInfo.OriginModelName
+	if fallbackFrom := common.GetContextKeyString(c, constant.ContextKeyModelFallbackFrom); fallbackFrom != "" {
+		requestedModel = fallbackFrom
+	}
+	fallbackModels := remainingFallbackModels(service.GetModelFallbackChain(c, requestedModel, relayInfo.UsingGroup), relayInfo.OriginModelName)
+
+	for hop := 0; ; hop++ {
+		service.MarkModelFallback(c, requestedModel, relayInfo.OriginModelName)
+		newAPIError = preConsumeModelBilling(c, relayInfo, tokens, meta)
+		if newAPIError != nil {
+			return
+		}
+		newAPIError = relayWithChannelRetry(c, relayFormat, relayInfo)
+		if newAPIError == nil || hop >= len(fallbackModels) || !shouldFallbackModel(c, newAPIError) {
+			return
+		}
+
+		nextModel := fallbackModels[hop]
+		logger.LogWarn(c, fmt.Sprintf("模型 %s 的渠道全部失败，降级到 %s：%s", relayInfo.OriginModelName, nextModel, newAPIError.Error()))
+		if relayInfo.Billing != nil {
+			// 按新模型重新定价与预扣费
+			relayInfo.Billing.Refund(c)
+			relayInfo.Billing = nil
+		}
+		metrics.IncModelFallback(relayInfo.OriginModelName, nextModel, relayInfo.UsingGroup)
+		switchRelayModel(c, relayInfo, nextModel)
+	}
+}
+
+// preConsumeModelBilling 按 relayInfo.OriginModelName 定价并预扣费，模型降级后会重新调用
+func preConsumeModelBilling(c *gin.Context, relayInfo *relaycommon.RelayInfo, tokens int, meta *types.TokenCountMeta) *types.NewAPIError {
+	priceData, err := helper.ModelPriceHelper(c, relayInfo, tokens, meta)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeModelPriceError)
+	}
+
+	// common.SetContextKey(c, constant.ContextKeyTokenCountMeta, meta)
+
+	if priceData.FreeModel {
+		logger.LogInfo(c, fmt.Sprintf("模型 %s 免费，跳过预扣费", relayInfo.OriginModelName))
+		return nil
+	}
+	return service.PreConsumeBilling(c, priceData.QuotaToPreConsume, relayInfo)
+}
+
+// relayWithChannelRetry 在同一模型的渠道之间重试
+func relayWithChannelRetry(c *gin.Context, relayFormat types.RelayFormat, relayInfo *relaycommon.RelayInfo) (newAPIError *types.NewAPIError) {
 	retryParam := &service.RetryParam{
 		Ctx:        c,
 		TokenGroup: relayInfo.TokenGroup,
@@ -208,6 +274,10 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 		c.Request.Body = io.NopCloser(bodyStorage)
 
+		attemptStart := time.Now()
+		healthDone := channelhealth.Begin(channel.Id, relayInfo.OriginModelName)
+		keyDone := beginChannelKeyStats(c, channel.Id)
+
 		switch relayFormat {
 		case types.RelayFormatOpenAIRealtime:
 			newAPIError = relay.WssHelper(c, relayInfo)
@@ -219,8 +289,38 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 			newAPIError = relayHandler(c, relayInfo)
 		}
 
+		if servedChannelId := common.GetContextKeyInt(c, constant.ContextKeyChannelId); servedChannelId != channel.Id {
+			// 对冲请求胜出：主请求的结果已在对冲逻辑中记录，本次尝试按实际返回响应的渠道统计
+			if served, err := model.CacheGetChannel(servedChannelId); err == nil {
+				healthDone(channelhealth.OutcomeIgnored, 0)
+				keyDone(0)
+				channel = served
+				healthDone = channelhealth.Begin(channel.Id, relayInfo.OriginModelName)
+				keyDone = beginChannelKeyStats(c, channel.Id)
+			}
+		}
+
+		if service.IsResponseCacheHit(c) {
+			// 命中响应缓存时没有请求上游，不计入渠道统计
+			healthDone(channelhealth.OutcomeIgnored, 0)
+			recordCircuitBreaker(c, channel.Id, circuitbreaker.OutcomeIgnored)
+			keyDone(0)
+		} else {
+			metrics.ObserveUpstreamAttempt(channel.Id, channel.Type, relayInfo.OriginModelName, newAPIError == nil)
+			healthDone(service.ChannelHealthOutcome(newAPIError), attemptLatency(relayInfo, attemptStart))
+			recordCircuitBreaker(c, channel.Id, service.CircuitBreakerOutcome(newAPIError))
+			if newAPIError == nil {
+				keyDone(http.StatusOK)
+			} else {
+				keyDone(newAPIError.StatusCode)
+			}
+		}
+
 		if newAPIError == nil {
 			relayInfo.LastError = nil
+			if relayInfo.HasSendResponse() {
+				metrics.ObserveFirstToken(channel.Id, relayInfo.OriginModelName, relayInfo.IsStream, relayInfo.FirstResponseTime.Sub(relayInfo.StartTime))
+			}
 			return
 		}
 
@@ -232,6 +332,7 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		if !shouldRetry(c, newAPIError, common.RetryTimes-retryParam.GetRetry()) {
 			break
 		}
+		metrics.IncRetry(relayInfo.OriginModelName, relayInfo.UsingGroup)
 	}
 
 	useChannel := c.GetStringSlice("use_channel")
@@ -239,6 +340,73 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		retryLogStr := fmt.Sprintf("重试：%s", strings.Trim(strings.Join(strings.Fields(fmt.Sprint(useChannel)), "->"), "[]"))
 		logger.LogInfo(c, retryLogStr)
 	}
+	return newAPIError
+}
+
+// shouldFallbackModel 只有无可用渠道或可重试的渠道错误才降级，客户端错误与已开始输出的响应不降级
+func shouldFallbackModel(c *gin.Context, err *types.NewAPIError) bool {
+	if err == nil || c.Writer.Written() {
+		return false
+	}
+	if err.GetErrorCode() == types.ErrorCodeGetChannelFailed || err.GetErrorCode() == types.ErrorCodeModelNotFound {
+		return true
+	}
+	return shouldRetry(c, err, 1)
+}
+
+// remainingFallbackModels 去掉降级链中当前模型及其之前的模型
+func remainingFallbackModels(chain []string, currentModel string) []string {
+	for i, m := range chain {
+		if m == currentModel {
+			return chain[i+1:]
+		}
+	}
+	return chain
+}
+
+func switchRelayModel(c *gin.Context, relayInfo *relaycommon.RelayInfo, modelName string) {
+	relayInfo.OriginModelName = modelName
+	common.SetContextKey(c, constant.ContextKeyOriginalModel, modelName)
+	if relayInfo.Request != nil {
+		relayInfo.Request.SetModelName(modelName)
+	}
+	if relayInfo.ChannelMeta == nil {
+		// getChannel 在 ChannelMeta 为空时直接复用 Distribute 选中的渠道，降级后必须按新模型重新选择
+		relayInfo.InitChannelMeta(c)
+	}
+}
+
+// RelayClaudeCountTokens Claude /v1/messages/count_tokens，只统计输入 token，不预扣费也不计费
+func RelayClaudeCountTokens(c *gin.Context) {
+	var newAPIError *types.NewAPIError
+	defer func() {
+		if newAPIError != nil {
+			logger.LogError(c, fmt.Sprintf("count tokens error: %s", newAPIError.Error()))
+			newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), c.GetString(common.RequestIdKey)))
+			c.JSON(newAPIError.StatusCode, gin.H{
+				"type":  "error",
+				"error": newAPIError.ToClaudeError(),
+			})
+		}
+	}()
+
+	request, err := helper.GetAndValidateClaudeRequest(c)
+	if err != nil {
+		if common.IsRequestBodyTooLargeError(err) || errors.Is(err, common.ErrRequestBodyTooLarge) {
+			newAPIError = types.NewErrorWithStatusCode(err, types.ErrorCodeReadRequestBodyFailed, http.StatusRequestEntityTooLarge, types.ErrOptionWithSkipRetry())
+		} else {
+			newAPIError = types.NewError(err, types.ErrorCodeInvalidRequest)
+		}
+		return
+	}
+
+	relayInfo, err := relaycommon.GenRelayInfo(c, types.RelayFormatClaude, request, nil)
+	if err != nil {
+		newAPIError = types.NewError(err, types.ErrorCodeGenRelayInfoFailed)
+		return
+	}
+
+	newAPIError = relay.ClaudeCountTokensHelper(c, relayInfo)
 }
 
 var upgrader = websocket.Upgrader{
@@ -248,6 +416,27 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+func recordCircuitBreaker(c *gin.Context, channelId int, outcome circuitbreaker.Outcome) {
+	service.RecordCircuitBreaker(channelId, common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey),
+		common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex), outcome)
+}
+
+// beginChannelKeyStats 多密钥渠道统计当前密钥的在途请求与结果，单密钥渠道返回空操作
+func beginChannelKeyStats(c *gin.Context, channelId int) func(statusCode int) {
+	if !common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		return func(int) {}
+	}
+	return keystats.Begin(channelId, common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex))
+}
+
+// attemptLatency 本次尝试的首字延迟，未向客户端发送过响应时取整个尝试的耗时
+func attemptLatency(info *relaycommon.RelayInfo, attemptStart time.Time) time.Duration {
+	if info.HasSendResponse() && info.FirstResponseTime.After(attemptStart) {
+		return info.FirstResponseTime.Sub(attemptStart)
+	}
+	return time.Since(attemptStart)
+}
+
 func addUsedChannel(c *gin.Context, channelId int) {
 	useChannel := c.GetStringSlice("use_channel")
 	useChannel = append(useChannel, fmt.Sprintf("%d", channelId))
@@ -334,6 +523,14 @@ func shouldRetry(c *gin.Context, openaiErr *types.NewAPIError, retryTimes int) b
 	if _, ok := c.Get("specific_channel_id"); ok {
 		return false
 	}
+	switch openaiErr.GetErrorCode() {
+	case types.ErrorCodeConnectTimeout, types.ErrorCodeResponseHeaderTimeout:
+		// 连不上或迟迟不响应，换个渠道通常能成功
+		return true
+	case types.ErrorCodeTotalTimeout:
+		// 整体超时已经耗尽了时间预算，重试只会让客户端等得更久
+		return false
+	}
 	code := openaiErr.StatusCode
 	if code >= 200 && code < 300 {
 		return false
@@ -351,7 +548,9 @@ func processChannelError(c *gin.Context, channelError types.ChannelError, err *t
 	logger.LogError(c, fmt.Sprintf("channel error (channel #%d, status code: %d): %s", channelError.ChannelId, err.StatusCode, err.Error()))
 	// 不要使用context获取渠道信息，异步处理时可能会出现渠道信息不一致的情况
 	// do not use context to get channel info, there may be inconsistent channel info when processing asynchronously
-	if service.ShouldDisableChannel(channelError.ChannelType, err) && channelError.AutoBan {
+	// 上游明确给出等待时间的限流只做临时冷却，不自动禁用
+	cooled := service.ApplyUpstreamCooldown(channelError, common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex), err)
+	if !cooled && service.ShouldDisableChannel(channelError.ChannelType, err) && channelError.AutoBan {
 		gopool.Go(func() {
 			service.DisableChannel(channelError, err.ErrorWithStatusCode())
 		})
@@ -383,6 +582,7 @@ func processChannelError(c *gin.Context, channelError types.ChannelError, err *t
 			adminInfo["multi_key_index"] = common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex)
 		}
 		service.AppendChannelAffinityAdminInfo(c, adminInfo)
+		service.AppendHedgeAdminInfo(c, adminInfo)
 		other["admin_info"] = adminInfo
 		startTime := common.GetContextKeyTime(c, constant.ContextKeyRequestStartTime)
 		if startTime.IsZero() {
@@ -487,6 +687,8 @@ func RelayTask(c *gin.Context) {
 		return
 	}
 
+	service.SetRateLimitHeaders(c, relayInfo)
+
 	if taskErr := relay.ResolveOriginTask(c, relayInfo); taskErr != nil {
 		respondTaskError(c, taskErr)
 		return
@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func respondOpenAIError(c *gin.Context, statusCode int, code string, message string) {
+	c.JSON(statusCode, gin.H{
+		"error": types.OpenAIError{
+			Message: message,
+			Type:    "invalid_request_error",
+			Code:    code,
+		},
+	})
+}
+
+func toOpenAIFile(file *model.RelayFile) dto.OpenAIFile {
+	return dto.OpenAIFile{
+		Id:        file.FileId,
+		Object:    "file",
+		Bytes:     file.Bytes,
+		CreatedAt: file.CreatedAt,
+		Filename:  file.Filename,
+		Purpose:   file.Purpose,
+		Status:    "processed",
+	}
+}
+
+// getRelayFileOrAbort 读取当前用户的文件，不存在时返回 404
+func getRelayFileOrAbort(c *gin.Context) *model.RelayFile {
+	file, err := model.GetRelayFileByFileId(c.Param("id"), c.GetInt("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondOpenAIError(c, http.StatusNotFound, "file_not_found", fmt.Sprintf("No such File object: %s", c.Param("id")))
+		} else {
+			respondOpenAIError(c, http.StatusInternalServerError, "get_file_failed", err.Error())
+		}
+		return nil
+	}
+	return file
+}
+
+func UploadRelayFile(c *gin.Context) {
+	purpose := c.PostForm("purpose")
+	if purpose != model.RelayFilePurposeBatch {
+		respondOpenAIError(c, http.StatusBadRequest, "invalid_purpose", "only purpose 'batch' is supported")
+		return
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		respondOpenAIError(c, http.StatusBadRequest, "invalid_file", "file is required")
+		return
+	}
+	maxBytes := int64(constant.FileMaxSizeMB) << 20
+	if header.Size > maxBytes {
+		respondOpenAIError(c, http.StatusRequestEntityTooLarge, "file_too_large", fmt.Sprintf("file exceeds the maximum size of %d MB", constant.FileMaxSizeMB))
+		return
+	}
+	reader, err := header.Open()
+	if err != nil {
+		respondOpenAIError(c, http.StatusBadRequest, "invalid_file", err.Error())
+		return
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(io.LimitReader(reader, maxBytes+1))
+	if err != nil {
+		respondOpenAIError(c, http.StatusBadRequest, "invalid_file", err.Error())
+		return
+	}
+	if int64(len(content)) > maxBytes {
+		respondOpenAIError(c, http.StatusRequestEntityTooLarge, "file_too_large", fmt.Sprintf("file exceeds the maximum size of %d MB", constant.FileMaxSizeMB))
+		return
+	}
+	file, err := service.SaveRelayFile(c.GetInt("id"), purpose, header.Filename, content)
+	if err != nil {
+		respondOpenAIError(c, http.StatusInternalServerError, "save_file_failed", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIFile(file))
+}
+
+func ListRelayFiles(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit <= 0 || limit > 10000 {
+		limit = 100
+	}
+	files, err := model.GetUserRelayFiles(c.GetInt("id"), c.Query("purpose"), limit)
+	if err != nil {
+		respondOpenAIError(c, http.StatusInternalServerError, "list_files_failed", err.Error())
+		return
+	}
+	data := make([]dto.OpenAIFile, 0, len(files))
+	for _, file := range files {
+		data = append(data, toOpenAIFile(file))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+func GetRelayFile(c *gin.Context) {
+	file := getRelayFileOrAbort(c)
+	if file == nil {
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIFile(file))
+}
+
+func GetRelayFileContent(c *gin.Context) {
+	file := getRelayFileOrAbort(c)
+	if file == nil {
+		return
+	}
+	content, err := service.ReadRelayFileContent(file)
+	if err != nil {
+		respondOpenAIError(c, http.StatusInternalServerError, "read_file_failed", err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "application/octet-stream", content)
+}
+
+func DeleteRelayFile(c *gin.Context) {
+	file := getRelayFileOrAbort(c)
+	if file == nil {
+		return
+	}
+	if err := service.DeleteRelayFile(file); err != nil {
+		respondOpenAIError(c, http.StatusInternalServerError, "delete_file_failed", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      file.FileId,
+		"object":  "file",
+		"deleted": true,
+	})
+}
@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/service"
+)
+
+// InitResponseCacheEmbedder 语义缓存通过进程内 relay 调用 embeddings 接口计算向量，复用选路与计费
+func InitResponseCacheEmbedder() {
+	service.SetResponseCacheEmbedder(embedForResponseCache)
+}
+
+func embedForResponseCache(tokenId int, model string, input string) ([]float64, error) {
+	body, err := common.Marshal(dto.EmbeddingRequest{Model: model, Input: input})
+	if err != nil {
+		return nil, err
+	}
+	ctx := middleware.WithInternalTokenId(context.Background(), tokenId)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/v1/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "127.0.0.1:0"
+
+	recorder := httptest.NewRecorder()
+	getInternalRelayEngine().ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		return nil, fmt.Errorf("embedding request failed with status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var response dto.OpenAIEmbeddingResponse
+	if err := common.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		return nil, err
+	}
+	if len(response.Data) == 0 || len(response.Data[0].Embedding) == 0 {
+		return nil, fmt.Errorf("embedding response is empty")
+	}
+	return response.Data[0].Embedding, nil
+}
@@ -1,25 +1,67 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/i18n"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 
 	"github.com/gin-gonic/gin"
 )
 
+// rateLimitFields 令牌 / 用户上的 TPM 与并发限制，字段缺省时保持原值，避免旧版前端提交时被清零
+type rateLimitFields struct {
+	TpmLimit         *int `json:"tpm_limit"`
+	OutputTpmLimit   *int `json:"output_tpm_limit"`
+	ConcurrencyLimit *int `json:"concurrency_limit"`
+}
+
+// tokenOptionalFields 令牌上同样需要在缺省时保持原值的开关
+type tokenOptionalFields struct {
+	ResponseCache *bool   `json:"response_cache"`
+	ModelFallback *string `json:"model_fallback"`
+	Scopes        *string `json:"scopes"`
+	BudgetPeriod  *string `json:"budget_period"`
+	BudgetLimit   *int    `json:"budget_limit"`
+}
+
+func (f rateLimitFields) validate() error {
+	for _, v := range []*int{f.TpmLimit, f.OutputTpmLimit, f.ConcurrencyLimit} {
+		if v != nil && *v < 0 {
+			return errors.New("TPM 与并发限制不能为负数")
+		}
+	}
+	return nil
+}
+
+func (f rateLimitFields) applyTo(tpmLimit, outputTpmLimit, concurrencyLimit *int) {
+	if f.TpmLimit != nil {
+		*tpmLimit = *f.TpmLimit
+	}
+	if f.OutputTpmLimit != nil {
+		*outputTpmLimit = *f.OutputTpmLimit
+	}
+	if f.ConcurrencyLimit != nil {
+		*concurrencyLimit = *f.ConcurrencyLimit
+	}
+}
+
 func buildMaskedTokenResponse(token *model.Token) *model.Token {
 	if token == nil {
 		return nil
 	}
 	maskedToken := *token
 	maskedToken.Key = token.GetMaskedKey()
+	maskedToken.BudgetUsed = token.GetBudgetUsed(common.GetTimestamp())
 	return &maskedToken
 }
 
@@ -89,6 +131,10 @@ func GetTokenKey(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	if token.IsHashed() {
+		common.ApiErrorI18n(c, i18n.MsgTokenKeyHashed)
+		return
+	}
 	common.ApiSuccess(c, gin.H{
 		"key": token.GetFullKey(),
 	})
@@ -187,6 +233,18 @@ func AddToken(c *gin.Context) {
 			return
 		}
 	}
+	if err := (rateLimitFields{&token.TpmLimit, &token.OutputTpmLimit, &token.ConcurrencyLimit}).validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if _, err := service.ParseModelFallbackChains(token.ModelFallback); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if token.Scopes, err = service.NormalizeTokenScopes(token.Scopes); err != nil {
+		common.ApiError(c, err)
+		return
+	}
 	// 检查用户令牌数量是否已达上限
 	maxTokens := operation_setting.GetMaxUserTokens()
 	count, err := model.CountUserTokens(c.GetInt("id"))
@@ -221,15 +279,87 @@ func AddToken(c *gin.Context) {
 		AllowIps:           token.AllowIps,
 		Group:              token.Group,
 		CrossGroupRetry:    token.CrossGroupRetry,
+		TpmLimit:           token.TpmLimit,
+		OutputTpmLimit:     token.OutputTpmLimit,
+		ConcurrencyLimit:   token.ConcurrencyLimit,
+		ResponseCache:      token.ResponseCache,
+		ModelFallback:      token.ModelFallback,
+		Scopes:             token.Scopes,
+	}
+	if err = cleanToken.SetBudget(token.BudgetPeriod, token.BudgetLimit, time.Now()); err != nil {
+		common.ApiError(c, err)
+		return
 	}
 	err = cleanToken.Insert()
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	// 数据库只保存哈希，完整密钥只在这里返回一次
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
+		"data": gin.H{
+			"id":  cleanToken.Id,
+			"key": key,
+		},
+	})
+}
+
+// MigrateTokensToHash 把存量明文令牌迁移为只保存哈希，迁移后用户无法再查看完整密钥
+func MigrateTokensToHash(c *gin.Context) {
+	migrated, err := model.MigrateTokensToHash()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"migrated": migrated,
+	})
+}
+
+// MintChildToken 签发子令牌：使用令牌鉴权时父令牌为当前令牌，使用用户鉴权时通过 token_id 指定
+func MintChildToken(c *gin.Context) {
+	var req struct {
+		TokenId int `json:"token_id"`
+		service.ChildTokenOptions
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if service.GetChildTokenClaims(c) != nil {
+		common.ApiErrorMsg(c, "子令牌不能签发子令牌")
+		return
+	}
+	tokenId := c.GetInt("token_id")
+	if tokenId == 0 {
+		tokenId = req.TokenId
+	}
+	parent, err := model.GetTokenByIds(tokenId, c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	userGroup, err := model.GetUserGroup(parent.UserId, false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	claims, err := service.NewChildTokenClaims(parent, userGroup, req.ChildTokenOptions, time.Now())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	key, err := model.SignChildToken(claims)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"id":         claims.ID,
+		"token":      key,
+		"expires_at": claims.ExpiresAt.Unix(),
 	})
 }
 
@@ -250,12 +380,44 @@ func DeleteToken(c *gin.Context) {
 func UpdateToken(c *gin.Context) {
 	userId := c.GetInt("id")
 	statusOnly := c.Query("status_only")
-	token := model.Token{}
-	err := c.ShouldBindJSON(&token)
+	body, err := io.ReadAll(c.Request.Body)
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	token := model.Token{}
+	if err = common.Unmarshal(body, &token); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	limits := rateLimitFields{}
+	if err = common.Unmarshal(body, &limits); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err = limits.validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	optional := tokenOptionalFields{}
+	if err = common.Unmarshal(body, &optional); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if optional.ModelFallback != nil {
+		if _, err = service.ParseModelFallbackChains(*optional.ModelFallback); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	if optional.Scopes != nil {
+		scopes, err := service.NormalizeTokenScopes(*optional.Scopes)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		optional.Scopes = &scopes
+	}
 	if len(token.Name) > 50 {
 		common.ApiErrorI18n(c, i18n.MsgTokenNameTooLong)
 		return
@@ -299,6 +461,29 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.AllowIps = token.AllowIps
 		cleanToken.Group = token.Group
 		cleanToken.CrossGroupRetry = token.CrossGroupRetry
+		limits.applyTo(&cleanToken.TpmLimit, &cleanToken.OutputTpmLimit, &cleanToken.ConcurrencyLimit)
+		if optional.ResponseCache != nil {
+			cleanToken.ResponseCache = *optional.ResponseCache
+		}
+		if optional.ModelFallback != nil {
+			cleanToken.ModelFallback = *optional.ModelFallback
+		}
+		if optional.Scopes != nil {
+			cleanToken.Scopes = *optional.Scopes
+		}
+		if optional.BudgetPeriod != nil || optional.BudgetLimit != nil {
+			budgetPeriod, budgetLimit := cleanToken.BudgetPeriod, cleanToken.BudgetLimit
+			if optional.BudgetPeriod != nil {
+				budgetPeriod = *optional.BudgetPeriod
+			}
+			if optional.BudgetLimit != nil {
+				budgetLimit = *optional.BudgetLimit
+			}
+			if err = cleanToken.SetBudget(budgetPeriod, budgetLimit, time.Now()); err != nil {
+				common.ApiError(c, err)
+				return
+			}
+		}
 	}
 	err = cleanToken.Update()
 	if err != nil {
@@ -54,6 +54,7 @@ func setupTokenControllerTestDB(t *testing.T) *gorm.DB {
 	}
 	model.DB = db
 	model.LOG_DB = db
+	model.SetTokenHashSecret([]byte("token-controller-test-secret"))
 
 	if err := db.AutoMigrate(&model.Token{}); err != nil {
 		t.Fatalf("failed to migrate token table: %v", err)
@@ -273,3 +274,78 @@ func TestGetTokenKeyRequiresOwnershipAndReturnsFullKey(t *testing.T) {
 		t.Fatalf("unauthorized key response leaked raw token key: %s", unauthorizedRecorder.Body.String())
 	}
 }
+
+func TestAddTokenStoresHashAndReturnsKeyOnce(t *testing.T) {
+	db := setupTokenControllerTestDB(t)
+
+	body := map[string]any{
+		"name":            "hashed-token",
+		"expired_time":    -1,
+		"unlimited_quota": true,
+	}
+	ctx, recorder := newAuthenticatedContext(t, http.MethodPost, "/api/token/", body, 1)
+	AddToken(ctx)
+
+	response := decodeAPIResponse(t, recorder)
+	if !response.Success {
+		t.Fatalf("expected success response, got message: %s", response.Message)
+	}
+	var created struct {
+		ID  int    `json:"id"`
+		Key string `json:"key"`
+	}
+	if err := common.Unmarshal(response.Data, &created); err != nil {
+		t.Fatalf("failed to decode create response: %v", err)
+	}
+	if created.Key == "" {
+		t.Fatalf("expected full key in create response")
+	}
+
+	var stored model.Token
+	if err := db.First(&stored, created.ID).Error; err != nil {
+		t.Fatalf("failed to load created token: %v", err)
+	}
+	if !stored.IsHashed() || stored.Key == created.Key {
+		t.Fatalf("expected token key to be stored hashed, got %q", stored.Key)
+	}
+	if !strings.HasPrefix(created.Key, stored.KeyPrefix) || stored.KeyPrefix == "" {
+		t.Fatalf("expected display prefix of %q, got %q", created.Key, stored.KeyPrefix)
+	}
+
+	found, err := model.GetTokenByKey(created.Key, true)
+	if err != nil || found.Id != created.ID {
+		t.Fatalf("expected lookup by full key to succeed, got err %v", err)
+	}
+	if _, err := model.GetTokenByKey(stored.Key, true); err == nil {
+		t.Fatalf("expected lookup by stored hash to fail")
+	}
+
+	keyCtx, keyRecorder := newAuthenticatedContext(t, http.MethodPost, "/api/token/"+strconv.Itoa(created.ID)+"/key", nil, 1)
+	keyCtx.Params = gin.Params{{Key: "id", Value: strconv.Itoa(created.ID)}}
+	GetTokenKey(keyCtx)
+	if decodeAPIResponse(t, keyRecorder).Success {
+		t.Fatalf("expected key reveal to be refused for hashed token")
+	}
+}
+
+func TestMigrateTokensToHashKeepsLegacyKeyUsable(t *testing.T) {
+	db := setupTokenControllerTestDB(t)
+	token := seedToken(t, db, 1, "legacy-token", "legacy1234token5678")
+
+	migrated, err := model.MigrateTokensToHash()
+	if err != nil || migrated != 1 {
+		t.Fatalf("expected one migrated token, got %d, err %v", migrated, err)
+	}
+
+	var stored model.Token
+	if err := db.First(&stored, token.Id).Error; err != nil {
+		t.Fatalf("failed to load migrated token: %v", err)
+	}
+	if !stored.IsHashed() || stored.KeyPrefix != "lega" {
+		t.Fatalf("expected migrated token to be hashed with prefix, got key %q prefix %q", stored.Key, stored.KeyPrefix)
+	}
+	found, err := model.GetTokenByKey("legacy1234token5678", true)
+	if err != nil || found.Id != token.Id {
+		t.Fatalf("expected migrated token to remain usable, got err %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -538,11 +539,22 @@ func GetUserModels(c *gin.Context) {
 
 func UpdateUser(c *gin.Context) {
 	var updatedUser model.User
-	err := json.NewDecoder(c.Request.Body).Decode(&updatedUser)
+	var limits rateLimitFields
+	body, err := io.ReadAll(c.Request.Body)
+	if err == nil {
+		err = common.Unmarshal(body, &updatedUser)
+	}
+	if err == nil {
+		err = common.Unmarshal(body, &limits)
+	}
 	if err != nil || updatedUser.Id == 0 {
 		common.ApiErrorI18n(c, i18n.MsgInvalidParams)
 		return
 	}
+	if err := limits.validate(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
 	if updatedUser.Password == "" {
 		updatedUser.Password = "$I_LOVE_U" // make Validator happy :)
 	}
@@ -568,6 +580,10 @@ func UpdateUser(c *gin.Context) {
 		updatedUser.Password = "" // rollback to what it should be
 	}
 	updatePassword := updatedUser.Password != ""
+	updatedUser.TpmLimit = originUser.TpmLimit
+	updatedUser.OutputTpmLimit = originUser.OutputTpmLimit
+	updatedUser.ConcurrencyLimit = originUser.ConcurrencyLimit
+	limits.applyTo(&updatedUser.TpmLimit, &updatedUser.OutputTpmLimit, &updatedUser.ConcurrencyLimit)
 	if err := updatedUser.Edit(updatePassword); err != nil {
 		common.ApiError(c, err)
 		return
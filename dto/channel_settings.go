@@ -7,6 +7,20 @@ type ChannelSettings struct {
 	PassThroughBodyEnabled bool   `json:"pass_through_body_enabled,omitempty"`
 	SystemPrompt           string `json:"system_prompt,omitempty"`
 	SystemPromptOverride   bool   `json:"system_prompt_override,omitempty"`
+	// StreamFirstTokenTimeout 流式请求等待首个有效增量的秒数，0 表示不预读；超时或首包前出错会切换渠道重试，启用后不发送首包前的 ping
+	StreamFirstTokenTimeout int `json:"stream_first_token_timeout,omitempty"`
+	// 以下超时单位均为秒，0 表示使用全局配置
+	ConnectTimeout        int `json:"connect_timeout,omitempty"`         // 建立连接（含 TLS 握手）
+	ResponseHeaderTimeout int `json:"response_header_timeout,omitempty"` // 发出请求后等待响应头
+	StreamIdleTimeout     int `json:"stream_idle_timeout,omitempty"`     // 流式响应两次数据之间的最长间隔，覆盖 STREAMING_TIMEOUT
+	TotalTimeout          int `json:"total_timeout,omitempty"`           // 整个请求（含读取响应体），覆盖 RELAY_TIMEOUT
+	// 多密钥限流感知模式下每个密钥的 RPM / TPM 上限，0 表示未知，只按负载挑选
+	KeyRPMLimit int `json:"key_rpm_limit,omitempty"`
+	KeyTPMLimit int `json:"key_tpm_limit,omitempty"`
+	// 渠道消耗预算（额度），0 表示不限；达到 BudgetAlertPercent（默认 80）时提醒管理员，达到上限后暂停使用直到周期重置
+	DailyBudget        int `json:"daily_budget,omitempty"`
+	MonthlyBudget      int `json:"monthly_budget,omitempty"`
+	BudgetAlertPercent int `json:"budget_alert_percent,omitempty"`
 }
 
 type VertexKeyType string
@@ -34,6 +48,7 @@ type ChannelOtherSettings struct {
 	DisableStore                          bool          `json:"disable_store,omitempty"`             // 是否禁用 store 透传（默认允许透传，禁用后可能导致 Codex 无法使用）
 	AllowIncludeObfuscation               bool          `json:"allow_include_obfuscation,omitempty"` // 是否允许 stream_options.include_obfuscation 透传（默认过滤以避免关闭流混淆保护）
 	AwsKeyType                            AwsKeyType    `json:"aws_key_type,omitempty"`
+	AllowTracePropagation                 bool          `json:"allow_trace_propagation,omitempty"`                    // 是否向上游透传 W3C traceparent（默认不透传，避免暴露内部链路信息）
 	UpstreamModelUpdateCheckEnabled       bool          `json:"upstream_model_update_check_enabled,omitempty"`        // 是否检测上游模型更新
 	UpstreamModelUpdateAutoSyncEnabled    bool          `json:"upstream_model_update_auto_sync_enabled,omitempty"`    // 是否自动同步上游模型更新
 	UpstreamModelUpdateLastCheckTime      int64         `json:"upstream_model_update_last_check_time,omitempty"`      // 上次检测时间
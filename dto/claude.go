@@ -218,6 +218,29 @@ type ClaudeRequest struct {
 	ServiceTier string `json:"service_tier,omitempty"`
 }
 
+// ClaudeCountTokensRequest /v1/messages/count_tokens 的请求体，上游不接受 max_tokens 等生成参数
+type ClaudeCountTokensRequest struct {
+	Model      string          `json:"model,omitempty"`
+	System     any             `json:"system,omitempty"`
+	Messages   []ClaudeMessage `json:"messages"`
+	Tools      any             `json:"tools,omitempty"`
+	ToolChoice any             `json:"tool_choice,omitempty"`
+	Thinking   *Thinking       `json:"thinking,omitempty"`
+	McpServers json.RawMessage `json:"mcp_servers,omitempty"`
+}
+
+func (c *ClaudeRequest) ToCountTokensRequest() *ClaudeCountTokensRequest {
+	return &ClaudeCountTokensRequest{
+		Model:      c.Model,
+		System:     c.System,
+		Messages:   c.Messages,
+		Tools:      c.Tools,
+		ToolChoice: c.ToolChoice,
+		Thinking:   c.Thinking,
+		McpServers: c.McpServers,
+	}
+}
+
 // OutputConfigForEffort just for extract effort
 type OutputConfigForEffort struct {
 	Effort string `json:"effort,omitempty"`
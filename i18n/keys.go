@@ -42,6 +42,7 @@ const (
 	MsgTokenExhausted            = "token.exhausted"
 	MsgTokenStatusUnavailable    = "token.status_unavailable"
 	MsgTokenDbError              = "token.db_error"
+	MsgTokenKeyHashed            = "token.key_hashed"
 )
 
 // Redemption related messages
@@ -19,6 +19,10 @@ import (
 	"github.com/QuantumNous/new-api/middleware"
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/oauth"
+	"github.com/QuantumNous/new-api/pkg/circuitbreaker"
+	"github.com/QuantumNous/new-api/pkg/cooldown"
+	"github.com/QuantumNous/new-api/pkg/metrics"
+	"github.com/QuantumNous/new-api/pkg/tracing"
 	"github.com/QuantumNous/new-api/relay"
 	"github.com/QuantumNous/new-api/router"
 	"github.com/QuantumNous/new-api/service"
@@ -112,6 +116,12 @@ func main() {
 	// Subscription quota reset task (daily/weekly/monthly/custom)
 	service.StartSubscriptionQuotaResetTask()
 
+	// Channel daily/monthly spend budgets
+	service.StartChannelBudgetTask()
+
+	// Token daily/weekly/monthly budget reset task
+	service.StartTokenBudgetResetTask()
+
 	// Wire task polling adaptor factory (breaks service -> relay import cycle)
 	service.GetTaskAdaptorFunc = func(platform constant.TaskPlatform) service.TaskPollingAdaptor {
 		a := relay.GetTaskAdaptor(platform)
@@ -121,9 +131,22 @@ func main() {
 		return a
 	}
 
+	// Channel gauges for /metrics are computed from the channel cache at scrape time
+	metrics.SetChannelStatsProvider(model.GetChannelMetricsSnapshot)
+
+	// Share circuit breaker state between nodes through Redis
+	circuitbreaker.StartSync()
+	cooldown.StartSync()
+
 	// Channel upstream model update check task
 	controller.StartChannelUpstreamModelUpdateTask()
 
+	// Batch API worker (master node only)
+	controller.StartBatchWorker()
+
+	// Semantic response cache computes prompt embeddings through the in-process relay
+	controller.InitResponseCacheEmbedder()
+
 	if common.IsMasterNode && constant.UpdateTask {
 		gopool.Go(func() {
 			controller.UpdateMidjourneyTaskBulk()
@@ -259,6 +282,9 @@ func InitResources() error {
 
 	service.InitHttpClient()
 
+	// 链路追踪（配置 OTEL_EXPORTER_OTLP_ENDPOINT 后启用）
+	tracing.Init()
+
 	service.InitTokenEncoders()
 
 	// Initialize SQL Database
@@ -11,6 +11,7 @@ import (
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/pkg/tracing"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 	"github.com/QuantumNous/new-api/types"
@@ -247,6 +248,14 @@ func TokenAuthReadOnly() func(c *gin.Context) {
 
 func TokenAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		span := tracing.StartSpan(c, "TokenAuth")
+		defer func() {
+			// span 在 c.Next() 之前结束，这里仅兜底处理提前 abort 的情况
+			if c.IsAborted() {
+				span.SetError(fmt.Errorf("token auth rejected with status %d", c.Writer.Status()))
+			}
+			span.End()
+		}()
 		// 先检测是否为ws
 		if c.Request.Header.Get("Sec-WebSocket-Protocol") != "" {
 			// Sec-WebSocket-Protocol: realtime, openai-insecure-api-key.sk-xxx, openai-beta.realtime-v1
@@ -288,6 +297,11 @@ func TokenAuth() func(c *gin.Context) {
 		if strings.HasPrefix(key, "Bearer ") || strings.HasPrefix(key, "bearer ") {
 			key = strings.TrimSpace(key[7:])
 		}
+		// 子令牌是自包含的 JWT，不参与下面的密钥拆分
+		if model.IsChildToken(key) {
+			childTokenAuth(c, span, key)
+			return
+		}
 		if key == "" || key == "midjourney-proxy" {
 			key = c.Request.Header.Get("mj-api-secret")
 			if strings.HasPrefix(key, "Bearer ") || strings.HasPrefix(key, "bearer ") {
@@ -313,60 +327,151 @@ func TokenAuth() func(c *gin.Context) {
 			return
 		}
 
-		allowIps := token.GetIpLimits()
-		if len(allowIps) > 0 {
-			clientIp := c.ClientIP()
-			logger.LogDebug(c, "Token has IP restrictions, checking client IP %s", clientIp)
-			ip := net.ParseIP(clientIp)
-			if ip == nil {
-				abortWithOpenAiMessage(c, http.StatusForbidden, "无法解析客户端 IP 地址")
-				return
-			}
-			if common.IsIpInCIDRList(ip, allowIps) == false {
-				abortWithOpenAiMessage(c, http.StatusForbidden, "您的 IP 不在令牌允许访问的列表中", types.ErrorCodeAccessDenied)
-				return
-			}
-			logger.LogDebug(c, "Client IP %s passed the token IP restrictions check", clientIp)
+		if !checkTokenIpLimits(c, token) {
+			return
 		}
 
-		userCache, err := model.GetUserCache(token.UserId)
-		if err != nil {
-			abortWithOpenAiMessage(c, http.StatusInternalServerError, err.Error())
+		if !checkTokenScope(c, token) {
 			return
 		}
-		userEnabled := userCache.Status == common.UserStatusEnabled
-		if !userEnabled {
-			abortWithOpenAiMessage(c, http.StatusForbidden, "用户已被封禁")
+
+		userGroup, ok := setupTokenUserContext(c, token, parts...)
+		if !ok {
 			return
 		}
+		span.SetAttributes(
+			tracing.Int("token.id", token.Id),
+			tracing.Int("user.id", token.UserId),
+			tracing.String("group", userGroup),
+		)
+		span.End()
+		c.Next()
+	}
+}
 
-		userCache.WriteContext(c)
+// checkTokenIpLimits 检查客户端 IP 是否在令牌允许访问的列表中，失败时已 abort
+func checkTokenIpLimits(c *gin.Context, token *model.Token) bool {
+	allowIps := token.GetIpLimits()
+	if len(allowIps) == 0 {
+		return true
+	}
+	clientIp := c.ClientIP()
+	logger.LogDebug(c, "Token has IP restrictions, checking client IP %s", clientIp)
+	ip := net.ParseIP(clientIp)
+	if ip == nil {
+		abortWithOpenAiMessage(c, http.StatusForbidden, "无法解析客户端 IP 地址")
+		return false
+	}
+	if common.IsIpInCIDRList(ip, allowIps) == false {
+		abortWithOpenAiMessage(c, http.StatusForbidden, "您的 IP 不在令牌允许访问的列表中", types.ErrorCodeAccessDenied)
+		return false
+	}
+	logger.LogDebug(c, "Client IP %s passed the token IP restrictions check", clientIp)
+	return true
+}
 
-		userGroup := userCache.Group
-		tokenGroup := token.Group
-		if tokenGroup != "" {
-			// check common.UserUsableGroups[userGroup]
-			if _, ok := service.GetUserUsableGroups(userGroup)[tokenGroup]; !ok {
-				abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("无权访问 %s 分组", tokenGroup))
-				return
-			}
-			// check group in common.GroupRatio
-			if !ratio_setting.ContainsGroupRatio(tokenGroup) {
-				if tokenGroup != "auto" {
-					abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("分组 %s 已被弃用", tokenGroup))
-					return
-				}
-			}
-			userGroup = tokenGroup
-		}
-		common.SetContextKey(c, constant.ContextKeyUsingGroup, userGroup)
+// checkTokenScope 作用域在 Distribute 之前检查，未授权的接口不会占用渠道
+func checkTokenScope(c *gin.Context, token *model.Token) bool {
+	if token.Scopes == "" || service.IsTokenScopeExemptPath(c.Request.URL.Path) {
+		return true
+	}
+	scope := service.RequestTokenScope(c.Request.Method, c.Request.URL.Path)
+	if scope == "" {
+		abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("该令牌配置了作用域，无权调用该接口，允许的作用域：%s", token.Scopes), types.ErrorCodeAccessDenied)
+		return false
+	}
+	if !token.HasScope(scope) {
+		abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("该令牌无权调用 %s 类接口，允许的作用域：%s", scope, token.Scopes), types.ErrorCodeAccessDenied)
+		return false
+	}
+	return true
+}
 
-		err = SetupContextForToken(c, token, parts...)
-		if err != nil {
+// childTokenAuth 校验子令牌签名与额度并以父令牌身份写入上下文；
+// 父令牌的状态与 IP 限制每次请求重新检查
+func childTokenAuth(c *gin.Context, span *tracing.Span, key string) {
+	claims, err := model.ParseChildToken(key)
+	if err != nil {
+		abortWithOpenAiMessage(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	parent, err := model.ValidateChildTokenParent(claims)
+	if err != nil {
+		abortWithOpenAiMessage(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if !checkTokenIpLimits(c, parent) {
+		return
+	}
+	remainQuota := 0
+	if claims.MaxQuota > 0 {
+		remainQuota = claims.MaxQuota - service.GetChildTokenSpent(claims)
+		if remainQuota <= 0 {
+			abortWithOpenAiMessage(c, http.StatusForbidden, "子令牌额度已用尽", types.ErrorCodeAccessDenied)
 			return
 		}
-		c.Next()
 	}
+	token := claims.ParentToken(remainQuota)
+	if !checkTokenScope(c, token) {
+		return
+	}
+	userGroup, ok := setupTokenUserContext(c, token)
+	if !ok {
+		return
+	}
+	if claims.MaxQuota > 0 {
+		// 父令牌无限额度时也按子令牌剩余额度展示
+		c.Set("token_quota", remainQuota)
+	}
+	common.SetContextKey(c, constant.ContextKeyChildToken, claims)
+	span.SetAttributes(
+		tracing.Int("token.id", token.Id),
+		tracing.Int("user.id", token.UserId),
+		tracing.String("group", userGroup),
+		tracing.String("child_token.id", claims.ID),
+	)
+	span.End()
+	c.Next()
+}
+
+// setupTokenUserContext 校验令牌所属用户及分组并写入上下文，失败时已 abort
+func setupTokenUserContext(c *gin.Context, token *model.Token, parts ...string) (string, bool) {
+	userCache, err := model.GetUserCache(token.UserId)
+	if err != nil {
+		abortWithOpenAiMessage(c, http.StatusInternalServerError, err.Error())
+		return "", false
+	}
+	userEnabled := userCache.Status == common.UserStatusEnabled
+	if !userEnabled {
+		abortWithOpenAiMessage(c, http.StatusForbidden, "用户已被封禁")
+		return "", false
+	}
+
+	userCache.WriteContext(c)
+
+	userGroup := userCache.Group
+	tokenGroup := token.Group
+	if tokenGroup != "" {
+		// check common.UserUsableGroups[userGroup]
+		if _, ok := service.GetUserUsableGroups(userGroup)[tokenGroup]; !ok {
+			abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("无权访问 %s 分组", tokenGroup))
+			return "", false
+		}
+		// check group in common.GroupRatio
+		if !ratio_setting.ContainsGroupRatio(tokenGroup) {
+			if tokenGroup != "auto" {
+				abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("分组 %s 已被弃用", tokenGroup))
+				return "", false
+			}
+		}
+		userGroup = tokenGroup
+	}
+	common.SetContextKey(c, constant.ContextKeyUsingGroup, userGroup)
+
+	if err := SetupContextForToken(c, token, parts...); err != nil {
+		return "", false
+	}
+	return userGroup, true
 }
 
 func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) error {
@@ -389,6 +494,13 @@ func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) e
 	}
 	common.SetContextKey(c, constant.ContextKeyTokenGroup, token.Group)
 	common.SetContextKey(c, constant.ContextKeyTokenCrossGroupRetry, token.CrossGroupRetry)
+	common.SetContextKey(c, constant.ContextKeyTokenTpmLimit, token.TpmLimit)
+	common.SetContextKey(c, constant.ContextKeyTokenOutputTpmLimit, token.OutputTpmLimit)
+	common.SetContextKey(c, constant.ContextKeyTokenConcurrencyLimit, token.ConcurrencyLimit)
+	common.SetContextKey(c, constant.ContextKeyTokenResponseCache, token.ResponseCache)
+	common.SetContextKey(c, constant.ContextKeyTokenModelFallback, token.ModelFallback)
+	common.SetContextKey(c, constant.ContextKeyTokenBudget, token.HasBudget())
+	common.SetContextKey(c, constant.ContextKeyTokenScopes, token.Scopes)
 	if len(parts) > 1 {
 		if model.IsAdmin(token.UserId) {
 			c.Set("specific_channel_id", parts[1])
@@ -14,6 +14,8 @@ import (
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/i18n"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/pkg/metrics"
+	"github.com/QuantumNous/new-api/pkg/tracing"
 	relayconstant "github.com/QuantumNous/new-api/relay/constant"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
@@ -29,6 +31,13 @@ type ModelRequest struct {
 
 func Distribute() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		span := tracing.StartSpan(c, "Distribute")
+		defer func() {
+			if c.IsAborted() {
+				span.SetError(fmt.Errorf("distribute rejected with status %d", c.Writer.Status()))
+			}
+			span.End()
+		}()
 		var channel *model.Channel
 		channelId, ok := common.GetContextKey(c, constant.ContextKeyTokenSpecificChannelId)
 		modelRequest, shouldSelectChannel, err := getModelRequest(c)
@@ -99,7 +108,10 @@ func Distribute() func(c *gin.Context) {
 					}
 				}
 
-				if preferredChannelID, found := service.GetPreferredChannelByAffinity(c, modelRequest.Model, usingGroup); found {
+				// 虚拟模型需要在 Relay 中估算 token 后才能解析出真实模型，渠道留到那时再选择
+				isVirtualModel := service.IsVirtualModel(modelRequest.Model)
+
+				if preferredChannelID, found := service.GetPreferredChannelByAffinity(c, modelRequest.Model, usingGroup); found && !isVirtualModel {
 					preferred, err := model.CacheGetChannel(preferredChannelID)
 					if err == nil && preferred != nil && preferred.Status == common.ChannelStatusEnabled {
 						if usingGroup == "auto" {
@@ -122,13 +134,21 @@ func Distribute() func(c *gin.Context) {
 					}
 				}
 
-				if channel == nil {
+				if channel == nil && !isVirtualModel {
 					channel, selectGroup, err = service.CacheGetRandomSatisfiedChannel(&service.RetryParam{
 						Ctx:        c,
 						ModelName:  modelRequest.Model,
 						TokenGroup: usingGroup,
 						Retry:      common.GetPointer(0),
 					})
+					if err != nil || channel == nil {
+						if fallbackChannel, fallbackGroup, fallbackModel := selectFallbackChannel(c, modelRequest.Model, usingGroup); fallbackChannel != nil {
+							service.MarkModelFallback(c, modelRequest.Model, fallbackModel)
+							metrics.IncModelFallback(modelRequest.Model, fallbackModel, fallbackGroup)
+							channel, selectGroup, err = fallbackChannel, fallbackGroup, nil
+							modelRequest.Model = fallbackModel
+						}
+					}
 					if err != nil {
 						showGroup := usingGroup
 						if usingGroup == "auto" {
@@ -152,6 +172,11 @@ func Distribute() func(c *gin.Context) {
 		}
 		common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
 		SetupContextForSelectedChannel(c, channel, modelRequest.Model)
+		span.SetAttributes(tracing.String("model", modelRequest.Model))
+		if channel != nil {
+			span.SetAttributes(tracing.Int("channel.id", channel.Id), tracing.Int("channel.type", channel.Type))
+		}
+		span.End()
 		c.Next()
 		if channel != nil && c.Writer != nil && c.Writer.Status() < http.StatusBadRequest {
 			service.RecordChannelAffinity(c, channel.Id)
@@ -159,6 +184,22 @@ func Distribute() func(c *gin.Context) {
 	}
 }
 
+// selectFallbackChannel 请求的模型没有可用渠道时，按降级链选择第一个有可用渠道的模型
+func selectFallbackChannel(c *gin.Context, modelName string, usingGroup string) (*model.Channel, string, string) {
+	for _, fallbackModel := range service.GetModelFallbackChain(c, modelName, usingGroup) {
+		channel, selectGroup, err := service.CacheGetRandomSatisfiedChannel(&service.RetryParam{
+			Ctx:        c,
+			ModelName:  fallbackModel,
+			TokenGroup: usingGroup,
+			Retry:      common.GetPointer(0),
+		})
+		if err == nil && channel != nil {
+			return channel, selectGroup, fallbackModel
+		}
+	}
+	return nil, "", ""
+}
+
 // getModelFromRequest 从请求中读取模型信息
 // 根据 Content-Type 自动处理：
 // - application/json
@@ -260,11 +301,22 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 	} else if strings.HasPrefix(c.Request.URL.Path, "/v1beta/models/") || strings.HasPrefix(c.Request.URL.Path, "/v1/models/") {
 		// Gemini API 路径处理: /v1beta/models/gemini-2.0-flash:generateContent
 		relayMode := relayconstant.RelayModeGemini
+		if strings.HasSuffix(c.Request.URL.Path, ":countTokens") {
+			relayMode = relayconstant.RelayModeGeminiCountTokens
+		}
 		modelName := extractModelNameFromGeminiPath(c.Request.URL.Path)
 		if modelName != "" {
 			modelRequest.Model = modelName
 		}
 		c.Set("relay_mode", relayMode)
+	} else if strings.HasPrefix(c.Request.URL.Path, "/v1beta/cachedContents") {
+		// 创建上下文缓存: {"model": "models/gemini-2.5-flash", ...}
+		req, err := getModelFromRequest(c)
+		if err != nil {
+			return nil, false, err
+		}
+		modelRequest.Model = strings.TrimPrefix(req.Model, "models/")
+		c.Set("relay_mode", relayconstant.RelayModeGeminiCachedContents)
 	} else if !strings.HasPrefix(c.Request.URL.Path, "/v1/audio/transcriptions") && !strings.Contains(c.Request.Header.Get("Content-Type"), "multipart/form-data") {
 		req, err := getModelFromRequest(c)
 		if err != nil {
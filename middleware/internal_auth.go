@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+type internalTokenIdKey struct{}
+
+// WithInternalTokenId 将令牌 id 绑定到请求 context，供进程内调用（如批处理）使用
+func WithInternalTokenId(ctx context.Context, tokenId int) context.Context {
+	return context.WithValue(ctx, internalTokenIdKey{}, tokenId)
+}
+
+// InternalTokenAuth 进程内 relay 调用的鉴权：令牌由调用方通过 request context 传入，不读取任何请求头，
+// 因此只能挂载在不对外监听的内部 gin.Engine 上。
+func InternalTokenAuth() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		tokenId, ok := c.Request.Context().Value(internalTokenIdKey{}).(int)
+		if !ok || tokenId == 0 {
+			abortWithOpenAiMessage(c, http.StatusUnauthorized, "未提供令牌")
+			return
+		}
+		token, err := model.GetTokenById(tokenId)
+		if err != nil {
+			abortWithOpenAiMessage(c, http.StatusUnauthorized, "无效的令牌")
+			return
+		}
+		if token.Status != common.TokenStatusEnabled {
+			abortWithOpenAiMessage(c, http.StatusUnauthorized, "该令牌状态不可用")
+			return
+		}
+		if token.ExpiredTime != -1 && token.ExpiredTime < common.GetTimestamp() {
+			abortWithOpenAiMessage(c, http.StatusUnauthorized, "该令牌已过期")
+			return
+		}
+		if !token.UnlimitedQuota && token.RemainQuota <= 0 {
+			abortWithOpenAiMessage(c, http.StatusUnauthorized, "该令牌额度已用尽")
+			return
+		}
+		// 批处理创建后令牌作用域可能被修改，每个子请求都按内部路径重新检查
+		if !checkTokenScope(c, token) {
+			return
+		}
+		if _, ok := setupTokenUserContext(c, token); !ok {
+			return
+		}
+		c.Next()
+	}
+}
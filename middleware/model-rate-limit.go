@@ -10,6 +10,7 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/common/limiter"
 	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting"
 
 	"github.com/gin-gonic/gin"
@@ -74,6 +75,29 @@ func recordRedisRequest(ctx context.Context, rdb *redis.Client, key string, maxC
 	rdb.Expire(ctx, key, time.Duration(setting.ModelRequestRateLimitDurationMinutes)*time.Minute)
 }
 
+// 统计成功请求窗口内的请求数，以及最早一条请求移出窗口的剩余时间
+func redisRequestWindow(ctx context.Context, rdb *redis.Client, key string, duration int64) (int, time.Duration) {
+	records, err := rdb.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return 0, 0
+	}
+	now := time.Now()
+	used := 0
+	var resetAfter time.Duration
+	for _, record := range records {
+		recordTime, err := time.ParseInLocation(timeFormat, record, now.Location())
+		if err != nil {
+			continue
+		}
+		if remain := time.Duration(duration)*time.Second - now.Sub(recordTime); remain > 0 {
+			used++
+			// 列表从新到旧排列，最后一个窗口内的记录最早过期
+			resetAfter = remain
+		}
+	}
+	return used, resetAfter
+}
+
 // Redis限流处理器
 func redisRateLimitHandler(duration int64, totalMaxCount, successMaxCount int) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -118,6 +142,15 @@ func redisRateLimitHandler(duration int64, totalMaxCount, successMaxCount int) g
 			}
 		}
 
+		if successMaxCount > 0 {
+			used, resetAfter := redisRequestWindow(ctx, rdb, successKey, duration)
+			service.SetRequestRateLimitState(c, service.RequestRateLimitState{
+				Limit:      successMaxCount,
+				Remaining:  successMaxCount - used - 1,
+				ResetAfter: resetAfter,
+			})
+		}
+
 		// 4. 处理请求
 		c.Next()
 
@@ -153,6 +186,15 @@ func memoryRateLimitHandler(duration int64, totalMaxCount, successMaxCount int)
 			return
 		}
 
+		if successMaxCount > 0 {
+			used, resetAfter := inMemoryRateLimiter.Window(successKey, duration)
+			service.SetRequestRateLimitState(c, service.RequestRateLimitState{
+				Limit:      successMaxCount,
+				Remaining:  successMaxCount - used - 1,
+				ResetAfter: time.Duration(resetAfter) * time.Second,
+			})
+		}
+
 		// 3. 处理请求
 		c.Next()
 
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/pkg/tracing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tracing 为 relay 请求创建根 span，后续 TokenAuth / Distribute / 上游请求 / 结算均挂在其下
+func Tracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !tracing.Enabled() {
+			c.Next()
+			return
+		}
+		span := tracing.StartServerSpan(c, c.Request.Method+" "+c.FullPath(),
+			tracing.String("http.method", c.Request.Method),
+			tracing.String("http.route", c.FullPath()),
+			tracing.String("request.id", c.GetString(common.RequestIdKey)),
+		)
+		c.Next()
+		if c.GetString(RouteTagKey) != "relay" {
+			span.Drop()
+		}
+		status := c.Writer.Status()
+		span.SetAttributes(tracing.Int("http.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetError(fmt.Errorf("request failed with status %d", status))
+		}
+		span.End()
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 
 	"github.com/samber/lo"
 	"gorm.io/gorm"
@@ -58,91 +59,87 @@ func GetAllEnableAbilities() []Ability {
 	return abilities
 }
 
-func getPriority(group string, model string, retry int) (int, error) {
-
-	var priorities []int
-	err := DB.Model(&Ability{}).
-		Select("DISTINCT(priority)").
-		Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true).
-		Order("priority DESC").              // 按优先级降序排序
-		Pluck("priority", &priorities).Error // Pluck用于将查询的结果直接扫描到一个切片中
-
+// GetChannel 未启用内存缓存时直接从数据库选择渠道，过滤与加权规则与 GetRandomSatisfiedChannel 一致：
+// 熔断中、冷却中、已用完预算以及 excludeIds 中的渠道不参与选择，开启自适应选路时按健康状况调整权重
+func GetChannel(group string, model string, retry int, excludeIds ...int) (*Channel, error) {
+	var abilities []Ability
+	err := DB.Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true).
+		Order("priority DESC").
+		Order("weight DESC").
+		Find(&abilities).Error
 	if err != nil {
-		// 处理错误
-		return 0, err
+		return nil, err
+	}
+	channelIds := make([]int, 0, len(abilities))
+	for _, ability_ := range abilities {
+		channelIds = append(channelIds, ability_.ChannelId)
+	}
+	available := make(map[int]bool, len(channelIds))
+	for _, channelId := range filterUnavailableChannels(channelIds, excludeIds) {
+		available[channelId] = true
 	}
 
+	// 确定要使用的优先级，重试次数大于优先级数时使用最小的优先级
+	var priorities []int64
+	for _, ability_ := range abilities {
+		priority := ability_.GetPriority()
+		if available[ability_.ChannelId] && (len(priorities) == 0 || priorities[len(priorities)-1] != priority) {
+			priorities = append(priorities, priority)
+		}
+	}
 	if len(priorities) == 0 {
-		// 如果没有查询到优先级，则返回错误
-		return 0, errors.New("数据库一致性被破坏")
+		return nil, nil
 	}
-
-	// 确定要使用的优先级
-	var priorityToUse int
-	if retry >= len(priorities) {
-		// 如果重试次数大于优先级数，则使用最小的优先级
-		priorityToUse = priorities[len(priorities)-1]
-	} else {
-		priorityToUse = priorities[retry]
+	targetPriority := priorities[min(retry, len(priorities)-1)]
+	var targets []Ability
+	for _, ability_ := range abilities {
+		if available[ability_.ChannelId] && ability_.GetPriority() == targetPriority {
+			targets = append(targets, ability_)
+		}
 	}
-	return priorityToUse, nil
-}
 
-func getChannelQuery(group string, model string, retry int) (*gorm.DB, error) {
-	maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true)
-	channelQuery := DB.Where(commonGroupCol+" = ? and model = ? and enabled = ? and priority = (?)", group, model, true, maxPrioritySubQuery)
-	if retry != 0 {
-		priority, err := getPriority(group, model, retry)
-		if err != nil {
+	if operation_setting.GetChannelHealthSetting().AdaptiveSelectionEnabled {
+		ids := make([]int, 0, len(targets))
+		for _, ability_ := range targets {
+			ids = append(ids, ability_.ChannelId)
+		}
+		var channels []*Channel
+		if err := DB.Where("id IN ?", ids).Find(&channels).Error; err != nil {
 			return nil, err
-		} else {
-			channelQuery = DB.Where(commonGroupCol+" = ? and model = ? and enabled = ? and priority = ?", group, model, true, priority)
 		}
+		if len(channels) == 0 {
+			return nil, nil
+		}
+		// 数据库选路时每个渠道的权重额外加 10
+		return pickChannelByHealth(model, channels, 1, 10)
 	}
 
-	return channelQuery, nil
-}
-
-func GetChannel(group string, model string, retry int) (*Channel, error) {
-	var abilities []Ability
-
-	var err error = nil
-	channelQuery, err := getChannelQuery(group, model, retry)
-	if err != nil {
-		return nil, err
-	}
-	if common.UsingSQLite || common.UsingPostgreSQL {
-		err = channelQuery.Order("weight DESC").Find(&abilities).Error
-	} else {
-		err = channelQuery.Order("weight DESC").Find(&abilities).Error
-	}
-	if err != nil {
-		return nil, err
-	}
 	channel := Channel{}
-	if len(abilities) > 0 {
-		// Randomly choose one
-		weightSum := uint(0)
-		for _, ability_ := range abilities {
-			weightSum += ability_.Weight + 10
-		}
-		// Randomly choose one
-		weight := common.GetRandomInt(int(weightSum))
-		for _, ability_ := range abilities {
-			weight -= int(ability_.Weight) + 10
-			//log.Printf("weight: %d, ability weight: %d", weight, *ability_.Weight)
-			if weight <= 0 {
-				channel.Id = ability_.ChannelId
-				break
-			}
+	// Randomly choose one
+	weightSum := uint(0)
+	for _, ability_ := range targets {
+		weightSum += ability_.Weight + 10
+	}
+	weight := common.GetRandomInt(int(weightSum))
+	for _, ability_ := range targets {
+		weight -= int(ability_.Weight) + 10
+		if weight <= 0 {
+			channel.Id = ability_.ChannelId
+			break
 		}
-	} else {
-		return nil, nil
 	}
 	err = DB.First(&channel, "id = ?", channel.Id).Error
 	return &channel, err
 }
 
+// GetPriority 返回能力的优先级，未设置时为 0
+func (ability *Ability) GetPriority() int64 {
+	if ability.Priority == nil {
+		return 0
+	}
+	return *ability.Priority
+}
+
 func (channel *Channel) AddAbilities(tx *gorm.DB) error {
 	models_ := strings.Split(channel.Models, ",")
 	groups_ := strings.Split(channel.Group, ",")
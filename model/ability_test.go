@@ -0,0 +1,86 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/pkg/cooldown"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetChannelSkipsCoolingDownChannels(t *testing.T) {
+	initCol()
+	require.NoError(t, DB.AutoMigrate(&Ability{}))
+	truncateTables(t)
+	t.Cleanup(func() {
+		DB.Exec("DELETE FROM abilities")
+		cooldown.Reset(1)
+		cooldown.Reset(2)
+		cooldown.Reset(3)
+	})
+
+	high, low := int64(10), int64(0)
+	channels := []*Channel{
+		{Id: 1, Name: "high-1", Key: "k1", Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o", Priority: &high},
+		{Id: 2, Name: "high-2", Key: "k2", Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o", Priority: &high},
+		{Id: 3, Name: "low", Key: "k3", Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o", Priority: &low},
+	}
+	for _, channel := range channels {
+		require.NoError(t, DB.Create(channel).Error)
+		require.NoError(t, channel.AddAbilities(nil))
+	}
+
+	cooldown.Set(1, cooldown.ChannelScope, time.Now().Add(time.Minute))
+	for i := 0; i < 20; i++ {
+		channel, err := GetChannel("default", "gpt-4o", 0)
+		require.NoError(t, err)
+		require.Equal(t, 2, channel.Id)
+	}
+
+	// 最高优先级全部冷却时使用下一个优先级
+	cooldown.Set(2, cooldown.ChannelScope, time.Now().Add(time.Minute))
+	channel, err := GetChannel("default", "gpt-4o", 0)
+	require.NoError(t, err)
+	require.Equal(t, 3, channel.Id)
+
+	cooldown.Set(3, cooldown.ChannelScope, time.Now().Add(time.Minute))
+	channel, err = GetChannel("default", "gpt-4o", 0)
+	require.NoError(t, err)
+	require.Nil(t, channel)
+}
+
+func TestGetChannelSkipsExcludedChannels(t *testing.T) {
+	initCol()
+	require.NoError(t, DB.AutoMigrate(&Ability{}))
+	truncateTables(t)
+	t.Cleanup(func() {
+		DB.Exec("DELETE FROM abilities")
+	})
+
+	high, low := int64(10), int64(0)
+	channels := []*Channel{
+		{Id: 1, Name: "high-1", Key: "k1", Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o", Priority: &high},
+		{Id: 2, Name: "high-2", Key: "k2", Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o", Priority: &high},
+		{Id: 3, Name: "low", Key: "k3", Status: common.ChannelStatusEnabled, Group: "default", Models: "gpt-4o", Priority: &low},
+	}
+	for _, channel := range channels {
+		require.NoError(t, DB.Create(channel).Error)
+		require.NoError(t, channel.AddAbilities(nil))
+	}
+
+	// 被排除的渠道（半开探测名额已满）不会被再次选中
+	for i := 0; i < 20; i++ {
+		channel, err := GetRandomSatisfiedChannel("default", "gpt-4o", 0, 1)
+		require.NoError(t, err)
+		require.Equal(t, 2, channel.Id)
+	}
+
+	channel, err := GetRandomSatisfiedChannel("default", "gpt-4o", 0, 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, 3, channel.Id)
+
+	channel, err = GetRandomSatisfiedChannel("default", "gpt-4o", 0, 1, 2, 3)
+	require.NoError(t, err)
+	require.Nil(t, channel)
+}
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+// Batch OpenAI 兼容的批处理任务，输入文件的每一行都会经过正常的 relay / 计费链路执行
+type Batch struct {
+	Id               int    `json:"-"`
+	BatchId          string `json:"id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId           int    `json:"-" gorm:"index"`
+	TokenId          int    `json:"-" gorm:"index"`
+	Endpoint         string `json:"endpoint" gorm:"type:varchar(64)"`
+	InputFileId      string `json:"input_file_id" gorm:"type:varchar(64)"`
+	OutputFileId     string `json:"output_file_id" gorm:"type:varchar(64)"`
+	ErrorFileId      string `json:"error_file_id" gorm:"type:varchar(64)"`
+	CompletionWindow string `json:"completion_window" gorm:"type:varchar(16)"`
+	Status           string `json:"status" gorm:"type:varchar(20);index"`
+	Errors           string `json:"-" gorm:"type:text"`
+	Metadata         string `json:"-" gorm:"type:text"`
+	RequestTotal     int    `json:"-"`
+	RequestCompleted int    `json:"-"`
+	RequestFailed    int    `json:"-"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint;index"`
+	InProgressAt     int64  `json:"in_progress_at" gorm:"bigint"`
+	ExpiresAt        int64  `json:"expires_at" gorm:"bigint"`
+	FinalizingAt     int64  `json:"finalizing_at" gorm:"bigint"`
+	CompletedAt      int64  `json:"completed_at" gorm:"bigint"`
+	FailedAt         int64  `json:"failed_at" gorm:"bigint"`
+	ExpiredAt        int64  `json:"expired_at" gorm:"bigint"`
+	CancellingAt     int64  `json:"cancelling_at" gorm:"bigint"`
+	CancelledAt      int64  `json:"cancelled_at" gorm:"bigint"`
+}
+
+func (b *Batch) Insert() error {
+	return DB.Create(b).Error
+}
+
+// Update 全量更新，调用方需保证字段完整
+func (b *Batch) Update() error {
+	return DB.Model(b).Select("*").Updates(b).Error
+}
+
+// UpdateWithStatus CAS 更新：仅当数据库中的状态仍为 fromStatus 时才写入，返回是否抢占成功
+func (b *Batch) UpdateWithStatus(fromStatus string) (bool, error) {
+	result := DB.Model(b).Where("status = ?", fromStatus).Select("*").Updates(b)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func GetBatchByBatchId(batchId string, userId int) (*Batch, error) {
+	if batchId == "" {
+		return nil, errors.New("batch id is empty")
+	}
+	var batch Batch
+	err := DB.Where("batch_id = ? AND user_id = ?", batchId, userId).First(&batch).Error
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+func GetBatchStatus(id int) (string, error) {
+	var status string
+	err := DB.Model(&Batch{}).Where("id = ?", id).Select("status").Scan(&status).Error
+	return status, err
+}
+
+// GetUserBatches 按 id 倒序分页，after 为上一页最后一个 batch id（OpenAI 游标分页）
+func GetUserBatches(userId int, after string, limit int) ([]*Batch, error) {
+	var batches []*Batch
+	query := DB.Where("user_id = ?", userId)
+	if after != "" {
+		var cursor Batch
+		if err := DB.Select("id").Where("batch_id = ? AND user_id = ?", after, userId).First(&cursor).Error; err == nil {
+			query = query.Where("id < ?", cursor.Id)
+		}
+	}
+	err := query.Order("id desc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+func GetBatchesByStatus(status string, limit int) ([]*Batch, error) {
+	var batches []*Batch
+	err := DB.Where("status = ?", status).Order("id asc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+// UpdateBatchProgress 仅更新执行进度计数，避免覆盖并发写入的状态字段
+func UpdateBatchProgress(id int, completed int, failed int) error {
+	return DB.Model(&Batch{}).Where("id = ?", id).
+		Updates(map[string]any{"request_completed": completed, "request_failed": failed}).Error
+}
+
+// MarkBatchCancelling 将未结束的批处理置为 cancelling，由执行器负责收尾
+func MarkBatchCancelling(batch *Batch) (bool, error) {
+	now := common.GetTimestamp()
+	result := DB.Model(&Batch{}).
+		Where("id = ? AND status IN ?", batch.Id, []string{BatchStatusValidating, BatchStatusInProgress}).
+		Updates(map[string]any{"status": BatchStatusCancelling, "cancelling_at": now})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		batch.Status = BatchStatusCancelling
+		batch.CancellingAt = now
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func NewBatchId() string {
+	return "batch_" + common.GetRandomString(24)
+}
@@ -12,6 +12,9 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/pkg/circuitbreaker"
+	"github.com/QuantumNous/new-api/pkg/cooldown"
+	"github.com/QuantumNous/new-api/pkg/keystats"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/samber/lo"
@@ -21,7 +24,7 @@ import (
 type Channel struct {
 	Id                 int     `json:"id"`
 	Type               int     `json:"type" gorm:"default:0"`
-	Key                string  `json:"key" gorm:"not null"`
+	Key                string  `json:"key" gorm:"not null;serializer:secret"`
 	OpenAIOrganization *string `json:"openai_organization"`
 	TestModel          *string `json:"test_model"`
 	Status             int     `json:"status" gorm:"default:1"`
@@ -55,6 +58,12 @@ type Channel struct {
 
 	// cache info
 	Keys []string `json:"-" gorm:"-"`
+
+	// runtime state, only filled by the channel admin API
+	CircuitBreaker []circuitbreaker.Status `json:"circuit_breaker,omitempty" gorm:"-"`
+	Cooldown       []cooldown.Status       `json:"cooldown,omitempty" gorm:"-"`
+	KeyStats       []keystats.Status       `json:"key_stats,omitempty" gorm:"-"`
+	Budget         *ChannelBudgetStatus    `json:"budget,omitempty" gorm:"-"`
 }
 
 type ChannelInfo struct {
@@ -65,6 +74,7 @@ type ChannelInfo struct {
 	MultiKeyDisabledTime   map[int]int64         `json:"multi_key_disabled_time,omitempty"`   // key禁用时间列表，key index -> time
 	MultiKeyPollingIndex   int                   `json:"multi_key_polling_index"`             // 多Key模式下轮询的key索引
 	MultiKeyMode           constant.MultiKeyMode `json:"multi_key_mode"`
+	MultiKeyQuotaLimit     map[int]int           `json:"multi_key_quota_limit,omitempty"` // key额度上限列表，key index -> quota，累计用量超过后自动禁用该key
 }
 
 // Value implements driver.Valuer interface
@@ -145,10 +155,54 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 		return "", 0, types.NewError(errors.New("no enabled keys"), types.ErrorCodeChannelNoAvailableKey)
 	}
 
+	// Skip keys whose circuit breaker is open or that are cooling down; if none is left, fall back to
+	// all enabled keys (cooldown is advisory) and let acquire skip the ones whose breaker denies.
+	keyAvailable := make([]bool, len(keys))
+	availableIdx := make([]int, 0, len(enabledIdx))
+	for _, idx := range enabledIdx {
+		if circuitbreaker.Allow(channel.Id, idx) && !cooldown.Active(channel.Id, idx) {
+			availableIdx = append(availableIdx, idx)
+			keyAvailable[idx] = true
+		}
+	}
+	if len(availableIdx) == 0 {
+		availableIdx = enabledIdx
+		for _, idx := range enabledIdx {
+			keyAvailable[idx] = true
+		}
+	}
+	isAvailable := func(idx int) bool {
+		return keyAvailable[idx]
+	}
+	// acquire 占用选中密钥的半开探测名额，被拒绝时依次改用其他可用密钥；
+	// Acquire 被拒绝时不占用名额，因此只有最终返回的密钥会占用
+	acquire := func(preferred int) (int, bool) {
+		if circuitbreaker.Acquire(channel.Id, preferred) {
+			return preferred, true
+		}
+		for _, idx := range availableIdx {
+			if idx != preferred && circuitbreaker.Acquire(channel.Id, idx) {
+				return idx, true
+			}
+		}
+		return 0, false
+	}
+	allKeysDenied := types.NewError(errors.New("all keys are rejected by the circuit breaker"), types.ErrorCodeChannelNoAvailableKey)
+
 	switch channel.ChannelInfo.MultiKeyMode {
 	case constant.MultiKeyModeRandom:
 		// Randomly pick one enabled key
-		selectedIdx := enabledIdx[rand.Intn(len(enabledIdx))]
+		selectedIdx, ok := acquire(availableIdx[rand.Intn(len(availableIdx))])
+		if !ok {
+			return "", 0, allKeysDenied
+		}
+		return keys[selectedIdx], selectedIdx, nil
+	case constant.MultiKeyModeRateLimit:
+		setting := channel.GetSetting()
+		selectedIdx, ok := acquire(keystats.Pick(channel.Id, availableIdx, setting.KeyRPMLimit, setting.KeyTPMLimit))
+		if !ok {
+			return "", 0, allKeysDenied
+		}
 		return keys[selectedIdx], selectedIdx, nil
 	case constant.MultiKeyModePolling:
 		// Use channel-specific lock to ensure thread-safe polling
@@ -175,17 +229,21 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 		}
 		for i := 0; i < len(keys); i++ {
 			idx := (start + i) % len(keys)
-			if getStatus(idx) == common.ChannelStatusEnabled {
+			if isAvailable(idx) && circuitbreaker.Acquire(channel.Id, idx) {
 				// update polling index for next call (point to the next position)
 				channel.ChannelInfo.MultiKeyPollingIndex = (idx + 1) % len(keys)
 				return keys[idx], idx, nil
 			}
 		}
-		// Fallback – should not happen, but return first enabled key
-		return keys[enabledIdx[0]], enabledIdx[0], nil
+		// Every available key was rejected by its breaker
+		return "", 0, allKeysDenied
 	default:
 		// Unknown mode, default to first enabled key (or original key string)
-		return keys[enabledIdx[0]], enabledIdx[0], nil
+		selectedIdx, ok := acquire(availableIdx[0])
+		if !ok {
+			return "", 0, allKeysDenied
+		}
+		return keys[selectedIdx], selectedIdx, nil
 	}
 }
 
@@ -289,6 +347,7 @@ func GetChannelsByTag(tag string, idSort bool, selectAll bool) ([]*Channel, erro
 	return channels, err
 }
 
+// SearchChannels 按 ID、名称、API 地址搜索渠道；密钥可能加密存储（密文每次不同），因此不支持按密钥搜索
 func SearchChannels(keyword string, group string, model string, idSort bool) ([]*Channel, error) {
 	var channels []*Channel
 	modelsCol := "`models`"
@@ -323,11 +382,11 @@ func SearchChannels(keyword string, group string, model string, idSort bool) ([]
 			// sqlite, PostgreSQL
 			groupCondition = `(',' || ` + commonGroupCol + ` || ',') LIKE ?`
 		}
-		whereClause = "(id = ? OR name LIKE ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
+		whereClause = "(id = ? OR name LIKE ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
 	} else {
-		whereClause = "(id = ? OR name LIKE ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%")
+		whereClause = "(id = ? OR name LIKE ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", "%"+keyword+"%", "%"+model+"%")
 	}
 
 	// 执行查询
@@ -530,6 +589,9 @@ func (channel *Channel) Delete() error {
 	if err != nil {
 		return err
 	}
+	if err = DeleteChannelKeyUsages(channel.Id); err != nil {
+		return err
+	}
 	err = channel.DeleteAbilities()
 	return err
 }
@@ -754,6 +816,7 @@ func EditChannelByTag(tag string, newTag *string, modelMapping *string, models *
 }
 
 func UpdateChannelUsedQuota(id int, quota int) {
+	addChannelBudgetSpend(id, quota)
 	if common.BatchUpdateEnabled {
 		addNewRecord(BatchUpdateTypeChannelUsedQuota, id, quota)
 		return
@@ -784,6 +847,7 @@ func GetPaginatedTags(offset int, limit int) ([]*string, error) {
 	return tags, err
 }
 
+// SearchTags 搜索条件与 SearchChannels 相同，同样不支持按密钥搜索
 func SearchTags(keyword string, group string, model string, idSort bool) ([]*string, error) {
 	var tags []*string
 	modelsCol := "`models`"
@@ -818,11 +882,11 @@ func SearchTags(keyword string, group string, model string, idSort bool) ([]*str
 			// sqlite, PostgreSQL
 			groupCondition = `(',' || ` + commonGroupCol + ` || ',') LIKE ?`
 		}
-		whereClause = "(id = ? OR name LIKE ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
+		whereClause = "(id = ? OR name LIKE ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
 	} else {
-		whereClause = "(id = ? OR name LIKE ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%")
+		whereClause = "(id = ? OR name LIKE ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", "%"+keyword+"%", "%"+model+"%")
 	}
 
 	subQuery := baseQuery.Where(whereClause, args...).
@@ -0,0 +1,232 @@
+package model
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"gorm.io/gorm"
+)
+
+// ChannelBudgetUsage 渠道在一个预算周期内的消耗额度，Period 形如 "d:2006-01-02"（按日）或 "m:2006-01"（按月）
+type ChannelBudgetUsage struct {
+	ChannelId     int    `json:"channel_id" gorm:"primaryKey;autoIncrement:false"`
+	Period        string `json:"period" gorm:"primaryKey;type:varchar(16)"`
+	UsedQuota     int64  `json:"used_quota" gorm:"bigint;default:0"`
+	AlertNotified bool   `json:"alert_notified" gorm:"default:false"`
+	UpdatedTime   int64  `json:"updated_time" gorm:"bigint;index"`
+}
+
+func (ChannelBudgetUsage) TableName() string {
+	return "channel_budget_usages"
+}
+
+// ChannelBudgetStatus 渠道接口展示的预算状态
+type ChannelBudgetStatus struct {
+	DailyBudget   int   `json:"daily_budget,omitempty"`
+	DailyUsed     int64 `json:"daily_used"`
+	MonthlyBudget int   `json:"monthly_budget,omitempty"`
+	MonthlyUsed   int64 `json:"monthly_used"`
+	AlertPercent  int   `json:"alert_percent"`
+	DailyAlert    bool  `json:"daily_alert"`
+	MonthlyAlert  bool  `json:"monthly_alert"`
+	Exhausted     bool  `json:"exhausted"`
+	// 计算状态时所在的周期，选路时跨周期的用量视为 0
+	DailyPeriod   string `json:"-"`
+	MonthlyPeriod string `json:"-"`
+}
+
+func DailyBudgetPeriod(t time.Time) string {
+	return "d:" + t.Format("2006-01-02")
+}
+
+func MonthlyBudgetPeriod(t time.Time) string {
+	return "m:" + t.Format("2006-01")
+}
+
+// ChannelBudgetSpendKey 待写入的渠道消耗按渠道与周期累计，消耗发生时即确定所属周期
+type ChannelBudgetSpendKey struct {
+	ChannelId int
+	Period    string
+}
+
+var (
+	pendingBudgetSpendLock sync.Mutex
+	pendingBudgetSpend     = make(map[ChannelBudgetSpendKey]int64)
+
+	channelBudgetStatuses atomic.Value // map[int]*ChannelBudgetStatus
+)
+
+// addChannelBudgetSpend 先在内存中累计，由预算任务定期写入配置了预算的渠道
+func addChannelBudgetSpend(channelId int, quota int) {
+	if quota == 0 {
+		return
+	}
+	now := time.Now()
+	pendingBudgetSpendLock.Lock()
+	pendingBudgetSpend[ChannelBudgetSpendKey{ChannelId: channelId, Period: DailyBudgetPeriod(now)}] += int64(quota)
+	pendingBudgetSpend[ChannelBudgetSpendKey{ChannelId: channelId, Period: MonthlyBudgetPeriod(now)}] += int64(quota)
+	pendingBudgetSpendLock.Unlock()
+}
+
+// TakePendingChannelBudgetSpend 取出本节点尚未写入数据库的渠道消耗
+func TakePendingChannelBudgetSpend() map[ChannelBudgetSpendKey]int64 {
+	pendingBudgetSpendLock.Lock()
+	defer pendingBudgetSpendLock.Unlock()
+	pending := pendingBudgetSpend
+	pendingBudgetSpend = make(map[ChannelBudgetSpendKey]int64)
+	return pending
+}
+
+// RestorePendingChannelBudgetSpend 写入数据库失败时放回待写入的消耗，由下一轮预算任务重试
+func RestorePendingChannelBudgetSpend(key ChannelBudgetSpendKey, quota int64) {
+	pendingBudgetSpendLock.Lock()
+	pendingBudgetSpend[key] += quota
+	pendingBudgetSpendLock.Unlock()
+}
+
+func getPendingChannelBudgetSpend(key ChannelBudgetSpendKey) int64 {
+	pendingBudgetSpendLock.Lock()
+	defer pendingBudgetSpendLock.Unlock()
+	return pendingBudgetSpend[key]
+}
+
+// SetChannelBudgetStatuses 保存预算任务最近一次计算的预算状态，channel id -> status
+func SetChannelBudgetStatuses(statuses map[int]*ChannelBudgetStatus) {
+	channelBudgetStatuses.Store(statuses)
+}
+
+// GetChannelBudgetStatus 返回渠道最近一次计算的预算状态，未配置预算时为 nil
+func GetChannelBudgetStatus(channelId int) *ChannelBudgetStatus {
+	statuses, _ := channelBudgetStatuses.Load().(map[int]*ChannelBudgetStatus)
+	return statuses[channelId]
+}
+
+// ChannelBudgetExceeded 选路时判断渠道是否已用完预算：最近一次计算的周期用量加上本节点尚未写入的消耗，
+// 这样在预算任务暂停渠道之前就不再向其分配请求
+func ChannelBudgetExceeded(channelId int) bool {
+	status := GetChannelBudgetStatus(channelId)
+	if status == nil {
+		return false
+	}
+	now := time.Now()
+	exceeded := func(budget int, used int64, statusPeriod string, period string) bool {
+		if budget <= 0 {
+			return false
+		}
+		if statusPeriod != period {
+			used = 0
+		}
+		used += getPendingChannelBudgetSpend(ChannelBudgetSpendKey{ChannelId: channelId, Period: period})
+		return used >= int64(budget)
+	}
+	return exceeded(status.DailyBudget, status.DailyUsed, status.DailyPeriod, DailyBudgetPeriod(now)) ||
+		exceeded(status.MonthlyBudget, status.MonthlyUsed, status.MonthlyPeriod, MonthlyBudgetPeriod(now))
+}
+
+// IncreaseChannelBudgetUsage 累加渠道在指定周期内的消耗
+func IncreaseChannelBudgetUsage(channelId int, period string, quota int64) error {
+	now := common.GetTimestamp()
+	increase := func() (int64, error) {
+		result := DB.Model(&ChannelBudgetUsage{}).
+			Where("channel_id = ? AND period = ?", channelId, period).
+			Updates(map[string]interface{}{
+				"used_quota":   gorm.Expr("used_quota + ?", quota),
+				"updated_time": now,
+			})
+		return result.RowsAffected, result.Error
+	}
+	affected, err := increase()
+	if err != nil || affected > 0 {
+		return err
+	}
+	usage := ChannelBudgetUsage{
+		ChannelId:   channelId,
+		Period:      period,
+		UsedQuota:   quota,
+		UpdatedTime: now,
+	}
+	if err = DB.Create(&usage).Error; err == nil {
+		return nil
+	}
+	// 其他节点已先插入记录，改为累加
+	_, err = increase()
+	return err
+}
+
+// GetChannelBudgetUsages 返回渠道在指定周期内的消耗，channel id -> period -> usage
+func GetChannelBudgetUsages(channelIds []int, periods []string) (map[int]map[string]ChannelBudgetUsage, error) {
+	result := make(map[int]map[string]ChannelBudgetUsage, len(channelIds))
+	if len(channelIds) == 0 {
+		return result, nil
+	}
+	var usages []ChannelBudgetUsage
+	if err := DB.Where("channel_id IN ? AND period IN ?", channelIds, periods).Find(&usages).Error; err != nil {
+		return nil, err
+	}
+	for _, usage := range usages {
+		if result[usage.ChannelId] == nil {
+			result[usage.ChannelId] = make(map[string]ChannelBudgetUsage)
+		}
+		result[usage.ChannelId][usage.Period] = usage
+	}
+	return result, nil
+}
+
+// MarkChannelBudgetAlerted 标记周期内已发送过软阈值提醒，返回 false 表示已被其他节点标记
+func MarkChannelBudgetAlerted(channelId int, period string) (bool, error) {
+	result := DB.Model(&ChannelBudgetUsage{}).
+		Where("channel_id = ? AND period = ? AND alert_notified = ?", channelId, period, false).
+		Update("alert_notified", true)
+	return result.RowsAffected > 0, result.Error
+}
+
+// DeleteChannelBudgetUsagesBefore 清理早于 timestamp 未更新的历史周期记录
+func DeleteChannelBudgetUsagesBefore(timestamp int64) (int64, error) {
+	result := DB.Where("updated_time < ?", timestamp).Delete(&ChannelBudgetUsage{})
+	return result.RowsAffected, result.Error
+}
+
+// GetBudgetedChannels 返回可能配置了预算以及因预算暂停的渠道，只查询判断预算所需的字段
+func GetBudgetedChannels() ([]*Channel, error) {
+	var channels []*Channel
+	err := DB.Select("id", "name", "type", "status", "setting").
+		Where("setting LIKE ? OR status = ?", "%budget%", common.ChannelStatusBudgetExhausted).
+		Find(&channels).Error
+	return channels, err
+}
+
+// UpdateChannelBudgetStatus 预算耗尽时暂停渠道（状态为 ChannelStatusBudgetExhausted，与出错自动禁用区分），
+// 预算恢复后重新启用；只在状态确实变化时返回 true
+func UpdateChannelBudgetStatus(channelId int, exhausted bool, reason string) bool {
+	channelStatusLock.Lock()
+	defer channelStatusLock.Unlock()
+
+	channel, err := GetChannelById(channelId, true)
+	if err != nil {
+		return false
+	}
+	fromStatus, toStatus := common.ChannelStatusBudgetExhausted, common.ChannelStatusEnabled
+	if exhausted {
+		fromStatus, toStatus = common.ChannelStatusEnabled, common.ChannelStatusBudgetExhausted
+	}
+	if channel.Status != fromStatus {
+		return false
+	}
+	info := channel.GetOtherInfo()
+	info["status_reason"] = reason
+	info["status_time"] = common.GetTimestamp()
+	channel.SetOtherInfo(info)
+	channel.Status = toStatus
+	if err = channel.SaveWithoutKey(); err != nil {
+		common.SysLog(fmt.Sprintf("failed to update channel budget status: channel_id=%d, error=%v", channelId, err))
+		return false
+	}
+	if err = UpdateAbilityStatus(channelId, toStatus == common.ChannelStatusEnabled); err != nil {
+		common.SysLog(fmt.Sprintf("failed to update ability status: channel_id=%d, error=%v", channelId, err))
+	}
+	CacheUpdateChannelStatus(channelId, toStatus)
+	return true
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChannelBudgetExceeded(t *testing.T) {
+	TakePendingChannelBudgetSpend()
+	t.Cleanup(func() {
+		SetChannelBudgetStatuses(nil)
+		TakePendingChannelBudgetSpend()
+	})
+	now := time.Now()
+	daily, monthly := DailyBudgetPeriod(now), MonthlyBudgetPeriod(now)
+
+	SetChannelBudgetStatuses(map[int]*ChannelBudgetStatus{
+		1: {DailyBudget: 1000, DailyUsed: 900, MonthlyBudget: 10000, MonthlyUsed: 900, DailyPeriod: daily, MonthlyPeriod: monthly},
+		// 上一个周期计算的状态，选路时用量视为 0
+		2: {DailyBudget: 1000, DailyUsed: 1000, Exhausted: true, DailyPeriod: "d:2000-01-01", MonthlyPeriod: "m:2000-01"},
+	})
+	require.False(t, ChannelBudgetExceeded(1))
+	require.False(t, ChannelBudgetExceeded(2))
+	require.False(t, ChannelBudgetExceeded(3))
+
+	// 本节点尚未写入数据库的消耗同样计入
+	addChannelBudgetSpend(1, 100)
+	require.True(t, ChannelBudgetExceeded(1))
+
+	// 写入失败时放回待写入的消耗
+	pending := TakePendingChannelBudgetSpend()
+	require.Equal(t, int64(100), pending[ChannelBudgetSpendKey{ChannelId: 1, Period: daily}])
+	require.Equal(t, int64(100), pending[ChannelBudgetSpendKey{ChannelId: 1, Period: monthly}])
+	require.False(t, ChannelBudgetExceeded(1))
+	for key, quota := range pending {
+		RestorePendingChannelBudgetSpend(key, quota)
+	}
+	require.True(t, ChannelBudgetExceeded(1))
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -11,6 +12,10 @@ import (
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/pkg/channelhealth"
+	"github.com/QuantumNous/new-api/pkg/circuitbreaker"
+	"github.com/QuantumNous/new-api/pkg/cooldown"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 )
 
@@ -93,10 +98,12 @@ func SyncChannelCache(frequency int) {
 	}
 }
 
-func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+// GetRandomSatisfiedChannel 按优先级与权重随机选择渠道，excludeIds 中的渠道不参与选择
+// （例如熔断器半开探测名额已被占满的渠道）
+func GetRandomSatisfiedChannel(group string, model string, retry int, excludeIds ...int) (*Channel, error) {
 	// if memory cache is disabled, get channel directly from database
 	if !common.MemoryCacheEnabled {
-		return GetChannel(group, model, retry)
+		return GetChannel(group, model, retry, excludeIds...)
 	}
 
 	channelSyncLock.RLock()
@@ -111,6 +118,9 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		channels = group2model2channels[group][normalizedModel]
 	}
 
+	// 熔断中、冷却中、已用完预算的渠道不参与选择
+	channels = filterUnavailableChannels(channels, excludeIds)
+
 	if len(channels) == 0 {
 		return nil, nil
 	}
@@ -173,6 +183,11 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		smoothingFactor = 100
 	}
 
+	// adaptive selection: bias the weights by observed health, soft-ejected channels get weight 0
+	if operation_setting.GetChannelHealthSetting().AdaptiveSelectionEnabled {
+		return pickChannelByHealth(model, targetChannels, smoothingFactor, smoothingAdjustment)
+	}
+
 	// Calculate the total weight of all channels up to endIdx
 	totalWeight := sumWeight * smoothingFactor
 
@@ -190,6 +205,89 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 	return nil, errors.New("channel not found")
 }
 
+// GetHedgeChannel 在分组的该模型渠道中，随机返回满足 match 的最高优先级渠道；仅在开启内存缓存时可用
+func GetHedgeChannel(group string, model string, match func(*Channel) bool) *Channel {
+	if !common.MemoryCacheEnabled {
+		return nil
+	}
+
+	channelSyncLock.RLock()
+	defer channelSyncLock.RUnlock()
+
+	channels := group2model2channels[group][model]
+	if len(channels) == 0 {
+		channels = group2model2channels[group][ratio_setting.FormatMatchingModelName(model)]
+	}
+	channels = filterUnavailableChannels(channels, nil)
+
+	var candidates []*Channel
+	var bestPriority int64
+	for _, channelId := range channels {
+		channel, ok := channelsIDM[channelId]
+		if !ok || !match(channel) {
+			continue
+		}
+		priority := channel.GetPriority()
+		if len(candidates) == 0 || priority > bestPriority {
+			candidates = []*Channel{channel}
+			bestPriority = priority
+		} else if priority == bestPriority {
+			candidates = append(candidates, channel)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// filterUnavailableChannels 过滤掉熔断中、按上游 Retry-After 冷却中、已用完预算以及调用方排除的渠道
+func filterUnavailableChannels(channels []int, excludeIds []int) []int {
+	breakerEnabled := operation_setting.GetCircuitBreakerSetting().Enabled
+	available := make([]int, 0, len(channels))
+	for _, channelId := range channels {
+		if slices.Contains(excludeIds, channelId) {
+			continue
+		}
+		if breakerEnabled && !circuitbreaker.Allow(channelId, circuitbreaker.ChannelScope) {
+			continue
+		}
+		if cooldown.Active(channelId, cooldown.ChannelScope) {
+			continue
+		}
+		if ChannelBudgetExceeded(channelId) {
+			continue
+		}
+		available = append(available, channelId)
+	}
+	return available
+}
+
+func pickChannelByHealth(model string, targetChannels []*Channel, smoothingFactor int, smoothingAdjustment int) (*Channel, error) {
+	channelIds := make([]int, len(targetChannels))
+	weights := make([]float64, len(targetChannels))
+	for i, channel := range targetChannels {
+		channelIds[i] = channel.Id
+		weights[i] = float64(channel.GetWeight()*smoothingFactor + smoothingAdjustment)
+	}
+	weights = channelhealth.AdjustWeights(model, channelIds, weights)
+	totalWeight := 0.0
+	for _, weight := range weights {
+		totalWeight += weight
+	}
+	if totalWeight <= 0 {
+		return targetChannels[rand.Intn(len(targetChannels))], nil
+	}
+	randomWeight := rand.Float64() * totalWeight
+	for i, channel := range targetChannels {
+		randomWeight -= weights[i]
+		if randomWeight < 0 {
+			return channel, nil
+		}
+	}
+	return targetChannels[len(targetChannels)-1], nil
+}
+
 func CacheGetChannel(id int) (*Channel, error) {
 	if !common.MemoryCacheEnabled {
 		return GetChannelById(id, true)
@@ -231,6 +329,12 @@ func CacheUpdateChannelStatus(id int, status int) {
 	if channel, ok := channelsIDM[id]; ok {
 		channel.Status = status
 	}
+	if status == common.ChannelStatusEnabled {
+		// 重新启用后从零开始统计健康状况
+		channelhealth.Reset(id)
+		circuitbreaker.Reset(id)
+		cooldown.Reset(id)
+	}
 	if status != common.ChannelStatusEnabled {
 		// delete the channel from group2model2channels
 		for group, model2channels := range group2model2channels {
@@ -0,0 +1,42 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/pkg/circuitbreaker"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetNextEnabledKeySkipsBreakerDeniedKeys(t *testing.T) {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	saved := *setting
+	t.Cleanup(func() { *setting = saved })
+	setting.Enabled = true
+	setting.KeyLevelEnabled = true
+	setting.FailureThreshold = 1
+	setting.OpenSeconds = 60
+
+	const channelId = 9101
+	t.Cleanup(func() { circuitbreaker.Reset(channelId) })
+	channel := &Channel{Id: channelId, Key: "key-0\nkey-1"}
+	channel.ChannelInfo.IsMultiKey = true
+	channel.ChannelInfo.MultiKeyMode = constant.MultiKeyModeRandom
+
+	// 只剩一个密钥可用时，无论随机选中哪个都只返回该密钥
+	circuitbreaker.Record(channelId, 0, circuitbreaker.OutcomeFailure)
+	for i := 0; i < 10; i++ {
+		key, idx, err := channel.GetNextEnabledKey()
+		require.Nil(t, err)
+		require.Equal(t, 1, idx)
+		require.Equal(t, "key-1", key)
+	}
+
+	// 所有密钥都熔断时返回错误，而不是仍然返回被拒绝的密钥
+	circuitbreaker.Record(channelId, 1, circuitbreaker.OutcomeFailure)
+	_, _, err := channel.GetNextEnabledKey()
+	require.NotNil(t, err)
+	require.Equal(t, types.ErrorCodeChannelNoAvailableKey, err.GetErrorCode())
+}
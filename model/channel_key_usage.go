@@ -0,0 +1,106 @@
+package model
+
+import (
+	"github.com/QuantumNous/new-api/common"
+	"gorm.io/gorm"
+)
+
+// ChannelKeyUsage 多密钥渠道中单个密钥的累计用量，按 key index 记录，删除密钥时随下标重排
+type ChannelKeyUsage struct {
+	ChannelId    int   `json:"channel_id" gorm:"primaryKey;autoIncrement:false"`
+	KeyIndex     int   `json:"key_index" gorm:"primaryKey;autoIncrement:false"`
+	UsedQuota    int64 `json:"used_quota" gorm:"bigint;default:0"`
+	RequestCount int   `json:"request_count" gorm:"default:0"`
+	UpdatedTime  int64 `json:"updated_time" gorm:"bigint"`
+}
+
+func (ChannelKeyUsage) TableName() string {
+	return "channel_key_usages"
+}
+
+// IncreaseChannelKeyUsage 累加密钥用量并返回累加后的总用量
+func IncreaseChannelKeyUsage(channelId int, keyIndex int, quota int) (int64, error) {
+	now := common.GetTimestamp()
+	increase := func() (int64, error) {
+		result := DB.Model(&ChannelKeyUsage{}).
+			Where("channel_id = ? AND key_index = ?", channelId, keyIndex).
+			Updates(map[string]interface{}{
+				"used_quota":    gorm.Expr("used_quota + ?", quota),
+				"request_count": gorm.Expr("request_count + ?", 1),
+				"updated_time":  now,
+			})
+		return result.RowsAffected, result.Error
+	}
+	affected, err := increase()
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 {
+		usage := ChannelKeyUsage{
+			ChannelId:    channelId,
+			KeyIndex:     keyIndex,
+			UsedQuota:    int64(quota),
+			RequestCount: 1,
+			UpdatedTime:  now,
+		}
+		if err = DB.Create(&usage).Error; err == nil {
+			return usage.UsedQuota, nil
+		}
+		// 并发请求已先插入记录，改为累加
+		if _, err = increase(); err != nil {
+			return 0, err
+		}
+	}
+	var usage ChannelKeyUsage
+	err = DB.Where("channel_id = ? AND key_index = ?", channelId, keyIndex).First(&usage).Error
+	return usage.UsedQuota, err
+}
+
+// GetChannelKeyUsages 返回渠道下各密钥的累计用量，key index -> usage
+func GetChannelKeyUsages(channelId int) (map[int]ChannelKeyUsage, error) {
+	var usages []ChannelKeyUsage
+	if err := DB.Where("channel_id = ?", channelId).Find(&usages).Error; err != nil {
+		return nil, err
+	}
+	result := make(map[int]ChannelKeyUsage, len(usages))
+	for _, usage := range usages {
+		result[usage.KeyIndex] = usage
+	}
+	return result, nil
+}
+
+// ResetChannelKeyUsage 清零单个密钥的累计用量
+func ResetChannelKeyUsage(channelId int, keyIndex int) error {
+	return DB.Where("channel_id = ? AND key_index = ?", channelId, keyIndex).Delete(&ChannelKeyUsage{}).Error
+}
+
+// DeleteChannelKeyUsages 删除渠道下所有密钥用量（删除渠道或覆盖密钥时调用）
+func DeleteChannelKeyUsages(channelId int) error {
+	return DB.Where("channel_id = ?", channelId).Delete(&ChannelKeyUsage{}).Error
+}
+
+// RemapChannelKeyUsages 删除密钥后按新下标重写用量，oldToNew 中不存在的下标视为已删除
+func RemapChannelKeyUsages(channelId int, oldToNew map[int]int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var usages []ChannelKeyUsage
+		if err := tx.Where("channel_id = ?", channelId).Find(&usages).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("channel_id = ?", channelId).Delete(&ChannelKeyUsage{}).Error; err != nil {
+			return err
+		}
+		remapped := make([]ChannelKeyUsage, 0, len(usages))
+		for _, usage := range usages {
+			newIndex, ok := oldToNew[usage.KeyIndex]
+			if !ok {
+				continue
+			}
+			usage.KeyIndex = newIndex
+			remapped = append(remapped, usage)
+		}
+		if len(remapped) == 0 {
+			return nil
+		}
+		return tx.Create(&remapped).Error
+	})
+}
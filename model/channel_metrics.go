@@ -0,0 +1,55 @@
+package model
+
+import (
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/pkg/metrics"
+)
+
+// GetChannelMetricsSnapshot 返回渠道状态快照，供 /metrics 抓取时计算渠道与多 Key 状态指标。
+// 启用内存缓存时直接读取缓存，否则回退到数据库查询（不读取 key 字段）。
+func GetChannelMetricsSnapshot() []metrics.ChannelStat {
+	if common.MemoryCacheEnabled {
+		channelSyncLock.RLock()
+		defer channelSyncLock.RUnlock()
+		stats := make([]metrics.ChannelStat, 0, len(channelsIDM))
+		for _, channel := range channelsIDM {
+			stats = append(stats, buildChannelStat(channel))
+		}
+		return stats
+	}
+
+	var channels []*Channel
+	if err := DB.Select("id", "type", "status", "channel_info").Find(&channels).Error; err != nil {
+		common.SysError("failed to load channels for metrics: " + err.Error())
+		return nil
+	}
+	stats := make([]metrics.ChannelStat, 0, len(channels))
+	for _, channel := range channels {
+		stats = append(stats, buildChannelStat(channel))
+	}
+	return stats
+}
+
+func buildChannelStat(channel *Channel) metrics.ChannelStat {
+	stat := metrics.ChannelStat{
+		Id:         channel.Id,
+		Type:       channel.Type,
+		Enabled:    channel.Status == common.ChannelStatusEnabled,
+		IsMultiKey: channel.ChannelInfo.IsMultiKey,
+	}
+	if !stat.IsMultiKey {
+		return stat
+	}
+	disabled := 0
+	for idx, status := range channel.ChannelInfo.MultiKeyStatusList {
+		if idx < channel.ChannelInfo.MultiKeySize && status != common.ChannelStatusEnabled {
+			disabled++
+		}
+	}
+	stat.KeyDisabled = disabled
+	stat.KeyEnabled = channel.ChannelInfo.MultiKeySize - disabled
+	if stat.KeyEnabled < 0 {
+		stat.KeyEnabled = 0
+	}
+	return stat
+}
@@ -7,7 +7,9 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/pkg/metrics"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -149,12 +151,22 @@ type RecordConsumeLogParams struct {
 }
 
 func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams) {
+	metrics.AddQuotaConsumed(params.ChannelId, params.TokenId, params.ModelName, params.Group, params.Quota, params.PromptTokens, params.CompletionTokens)
 	if !common.LogConsumeEnabled {
 		return
 	}
 	logger.LogInfo(c, fmt.Sprintf("record consume log: userId=%d, params=%s", userId, common.GetJsonString(params)))
 	username := c.GetString("username")
 	requestId := c.GetString(common.RequestIdKey)
+	// 子令牌的请求记录子令牌声明，便于按终端用户追溯
+	if value, ok := common.GetContextKey(c, constant.ContextKeyChildToken); ok {
+		if claims, ok := value.(*ChildTokenClaims); ok {
+			if params.Other == nil {
+				params.Other = make(map[string]interface{})
+			}
+			params.Other["child_token"] = claims.LogInfo()
+		}
+	}
 	otherStr := common.MapToJsonStr(params.Other)
 	// 判断是否需要记录 IP
 	needRecordIp := false
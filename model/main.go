@@ -203,13 +203,33 @@ func InitDB() (err error) {
 		}
 		common.SysLog("database migration started")
 		err = migrateDB()
-		return err
+		if err != nil {
+			return err
+		}
+		migrateSecretEncryption()
+		return nil
 	} else {
 		common.FatalLog(err)
 	}
 	return err
 }
 
+// migrateSecretEncryption 启用加密后，把存量明文以及旧主密钥加密的渠道密钥与敏感配置项重新加密
+func migrateSecretEncryption() {
+	if !common.SecretEncryptionEnabled() {
+		return
+	}
+	result, err := ReencryptSecrets()
+	if err != nil {
+		common.SysError("secret encryption migration failed: " + err.Error())
+		return
+	}
+	if result.Channels > 0 || result.Options > 0 || result.Failed > 0 {
+		common.SysLog(fmt.Sprintf("secret encryption migration: %d channels, %d options encrypted, %d failed",
+			result.Channels, result.Options, result.Failed))
+	}
+}
+
 func InitLogDB() (err error) {
 	if os.Getenv("LOG_SQL_DSN") == "" {
 		LOG_DB = DB
@@ -280,6 +300,11 @@ func migrateDB() error {
 		&SubscriptionPreConsumeRecord{},
 		&CustomOAuthProvider{},
 		&UserOAuthBinding{},
+		&RelayFile{},
+		&RelayFileContent{},
+		&Batch{},
+		&ChannelKeyUsage{},
+		&ChannelBudgetUsage{},
 	)
 	if err != nil {
 		return err
@@ -328,6 +353,11 @@ func migrateDBFast() error {
 		{&SubscriptionPreConsumeRecord{}, "SubscriptionPreConsumeRecord"},
 		{&CustomOAuthProvider{}, "CustomOAuthProvider"},
 		{&UserOAuthBinding{}, "UserOAuthBinding"},
+		{&RelayFile{}, "RelayFile"},
+		{&RelayFileContent{}, "RelayFileContent"},
+		{&Batch{}, "Batch"},
+		{&ChannelKeyUsage{}, "ChannelKeyUsage"},
+		{&ChannelBudgetUsage{}, "ChannelBudgetUsage"},
 	}
 	// 动态计算migration数量，确保errChan缓冲区足够大
 	errChan := make(chan error, len(migrations))
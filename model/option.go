@@ -23,7 +23,21 @@ func AllOption() ([]*Option, error) {
 	var options []*Option
 	var err error
 	err = DB.Find(&options).Error
-	return options, err
+	if err != nil {
+		return options, err
+	}
+	// 加密存储的敏感配置项在这里解密，解密失败的配置项跳过，保留默认值
+	decrypted := options[:0]
+	for _, option := range options {
+		value, decryptErr := common.DecryptSecret(option.Value)
+		if decryptErr != nil {
+			common.SysError("failed to decrypt option " + option.Key + ": " + decryptErr.Error())
+			continue
+		}
+		option.Value = value
+		decrypted = append(decrypted, option)
+	}
+	return decrypted, nil
 }
 
 func InitOptionMap() {
@@ -156,6 +170,9 @@ func InitOptionMap() {
 	}
 
 	common.OptionMapRWMutex.Unlock()
+	if err := initTokenHashSecret(); err != nil {
+		common.FatalLog("failed to init token hash secret: " + err.Error())
+	}
 	loadOptionsFromDatabase()
 }
 
@@ -185,6 +202,13 @@ func UpdateOption(key string, value string) error {
 	// https://gorm.io/docs/update.html#Save-All-Fields
 	DB.FirstOrCreate(&option, Option{Key: key})
 	option.Value = value
+	if IsSecretOptionKey(key) {
+		encrypted, err := common.EncryptSecret(value)
+		if err != nil {
+			return err
+		}
+		option.Value = encrypted
+	}
 	// Save is a combination function.
 	// If save value does not contain primary key, it will execute Create,
 	// otherwise it will execute Update (with all fields).
@@ -0,0 +1,86 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/QuantumNous/new-api/common"
+	"gorm.io/gorm"
+)
+
+const (
+	RelayFilePurposeBatch       = "batch"
+	RelayFilePurposeBatchOutput = "batch_output"
+
+	RelayFileStorageDisk = "disk"
+	RelayFileStorageDB   = "db"
+)
+
+// RelayFile OpenAI 兼容的 /v1/files 文件元数据，内容按 Storage 存储在本地磁盘或 relay_file_contents 表
+type RelayFile struct {
+	Id        int    `json:"-"`
+	FileId    string `json:"id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId    int    `json:"-" gorm:"index"`
+	Purpose   string `json:"purpose" gorm:"type:varchar(32);index"`
+	Filename  string `json:"filename" gorm:"type:varchar(255)"`
+	Bytes     int64  `json:"bytes"`
+	Storage   string `json:"-" gorm:"type:varchar(16)"`
+	Path      string `json:"-" gorm:"type:varchar(512)"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+// RelayFileContent 数据库存储模式下的文件内容
+type RelayFileContent struct {
+	FileId  string `gorm:"type:varchar(64);primaryKey"`
+	Content []byte
+}
+
+func (f *RelayFile) Insert() error {
+	return DB.Create(f).Error
+}
+
+func GetRelayFileByFileId(fileId string, userId int) (*RelayFile, error) {
+	if fileId == "" {
+		return nil, errors.New("file id is empty")
+	}
+	var file RelayFile
+	err := DB.Where("file_id = ? AND user_id = ?", fileId, userId).First(&file).Error
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func GetUserRelayFiles(userId int, purpose string, limit int) ([]*RelayFile, error) {
+	var files []*RelayFile
+	query := DB.Where("user_id = ?", userId)
+	if purpose != "" {
+		query = query.Where("purpose = ?", purpose)
+	}
+	err := query.Order("id desc").Limit(limit).Find(&files).Error
+	return files, err
+}
+
+func DeleteRelayFile(file *RelayFile) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("file_id = ?", file.FileId).Delete(&RelayFileContent{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(file).Error
+	})
+}
+
+func SaveRelayFileContent(fileId string, content []byte) error {
+	return DB.Create(&RelayFileContent{FileId: fileId, Content: content}).Error
+}
+
+func GetRelayFileContent(fileId string) ([]byte, error) {
+	var content RelayFileContent
+	if err := DB.Where("file_id = ?", fileId).First(&content).Error; err != nil {
+		return nil, err
+	}
+	return content.Content, nil
+}
+
+func NewRelayFileId() string {
+	return "file-" + common.GetRandomString(24)
+}
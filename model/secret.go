@@ -0,0 +1,138 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"gorm.io/gorm/schema"
+)
+
+// secretSerializer 写入数据库时使用主密钥加密，读取时透明解密（兼容尚未加密的明文），
+// 用于 Channel.Key 等敏感字段。注意 Update("key", value) 这类按列名更新不会经过序列化器，需使用 UpdateChannelKey
+type secretSerializer struct{}
+
+func (secretSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case []byte:
+		value = string(v)
+	case string:
+		value = v
+	default:
+		return fmt.Errorf("unsupported secret value type %T", dbValue)
+	}
+	plaintext, err := common.DecryptSecret(value)
+	if err != nil {
+		return fmt.Errorf("decrypt %s failed: %w", field.Name, err)
+	}
+	return field.Set(ctx, dst, plaintext)
+}
+
+func (secretSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	value, _ := fieldValue.(string)
+	return common.EncryptSecret(value)
+}
+
+func init() {
+	schema.RegisterSerializer("secret", secretSerializer{})
+}
+
+// UpdateChannelKey 只更新渠道密钥，按列名更新不会经过序列化器，因此在这里显式加密
+func UpdateChannelKey(channelId int, key string) error {
+	encrypted, err := common.EncryptSecret(key)
+	if err != nil {
+		return err
+	}
+	return DB.Model(&Channel{}).Where("id = ?", channelId).Update("key", encrypted).Error
+}
+
+// IsSecretOptionKey 敏感配置项（各类 Token、Secret、Key），不在配置接口中返回，启用加密时加密存储
+func IsSecretOptionKey(key string) bool {
+	return strings.HasSuffix(key, "Token") ||
+		strings.HasSuffix(key, "Secret") ||
+		strings.HasSuffix(key, "Key") ||
+		strings.HasSuffix(key, "secret") ||
+		strings.HasSuffix(key, "api_key")
+}
+
+// SecretReencryptResult 重新加密的统计
+type SecretReencryptResult struct {
+	Channels int `json:"channels"`
+	Options  int `json:"options"`
+	Failed   int `json:"failed"`
+}
+
+const secretReencryptBatchSize = 100
+
+// ReencryptSecrets 使用当前主密钥重新加密明文或由旧主密钥加密的渠道密钥与敏感配置项，
+// 用于启用加密后的存量数据迁移以及主密钥轮换。未启用加密时不做任何处理
+func ReencryptSecrets() (*SecretReencryptResult, error) {
+	result := &SecretReencryptResult{}
+	if !common.SecretEncryptionEnabled() {
+		return result, nil
+	}
+
+	// 读取原始列值，绕过序列化器以判断是否需要重新加密
+	type channelKeyRow struct {
+		Id  int
+		Key string
+	}
+	lastId := 0
+	for {
+		var rows []channelKeyRow
+		err := DB.Model(&Channel{}).Select("id", "key").
+			Where("id > ?", lastId).Order("id").Limit(secretReencryptBatchSize).Scan(&rows).Error
+		if err != nil {
+			return result, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		lastId = rows[len(rows)-1].Id
+		for _, row := range rows {
+			if !common.SecretNeedsReencrypt(row.Key) {
+				continue
+			}
+			if err := reencryptChannelKey(row.Id, row.Key); err != nil {
+				result.Failed++
+				common.SysError(fmt.Sprintf("failed to re-encrypt channel #%d key: %v", row.Id, err))
+				continue
+			}
+			result.Channels++
+		}
+	}
+
+	var options []*Option
+	if err := DB.Find(&options).Error; err != nil {
+		return result, err
+	}
+	for _, option := range options {
+		if !IsSecretOptionKey(option.Key) || !common.SecretNeedsReencrypt(option.Value) {
+			continue
+		}
+		encrypted, err := common.EncryptSecret(option.Value)
+		if err == nil {
+			err = DB.Model(&Option{}).Where(commonKeyCol+" = ?", option.Key).Update("value", encrypted).Error
+		}
+		if err != nil {
+			result.Failed++
+			common.SysError(fmt.Sprintf("failed to re-encrypt option %s: %v", option.Key, err))
+			continue
+		}
+		result.Options++
+	}
+	return result, nil
+}
+
+func reencryptChannelKey(channelId int, raw string) error {
+	encrypted, err := common.EncryptSecret(raw)
+	if err != nil {
+		return err
+	}
+	// 只在密钥未被并发修改时写入
+	return DB.Model(&Channel{}).Where("id = ? AND "+commonKeyCol+" = ?", channelId, raw).Update("key", encrypted).Error
+}
@@ -9,12 +9,14 @@ import (
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/bytedance/gopkg/util/gopool"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Token struct {
 	Id                 int            `json:"id"`
 	UserId             int            `json:"user_id" gorm:"index"`
-	Key                string         `json:"key" gorm:"type:char(48);uniqueIndex"`
+	Key                string         `json:"key" gorm:"type:char(48);uniqueIndex"` // 新令牌保存哈希，见 token_hash.go
+	KeyPrefix          string         `json:"key_prefix" gorm:"type:varchar(16);default:''"`
 	Status             int            `json:"status" gorm:"default:1"`
 	Name               string         `json:"name" gorm:"index" `
 	CreatedTime        int64          `json:"created_time" gorm:"bigint"`
@@ -28,6 +30,16 @@ type Token struct {
 	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
 	Group              string         `json:"group" gorm:"default:''"`
 	CrossGroupRetry    bool           `json:"cross_group_retry"` // 跨分组重试，仅auto分组有效
+	TpmLimit           int            `json:"tpm_limit" gorm:"default:0"`
+	OutputTpmLimit     int            `json:"output_tpm_limit" gorm:"default:0"`
+	ConcurrencyLimit   int            `json:"concurrency_limit" gorm:"default:0"`
+	ResponseCache      bool           `json:"response_cache"`
+	ModelFallback      string         `json:"model_fallback" gorm:"type:text"` // 令牌级模型降级链，JSON: {"model": ["fallback"]}
+	Scopes             string         `json:"scopes" gorm:"type:text"`         // 允许调用的接口类型，逗号分隔，空表示不限制，见 constant.TokenScopes
+	BudgetPeriod       string         `json:"budget_period" gorm:"type:varchar(16);default:''"`
+	BudgetLimit        int            `json:"budget_limit" gorm:"default:0"`
+	BudgetUsed         int            `json:"budget_used" gorm:"default:0"`
+	BudgetResetTime    int64          `json:"budget_reset_time" gorm:"bigint;default:0;index"`
 	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
 
@@ -48,11 +60,22 @@ func MaskTokenKey(key string) string {
 	return key[:4] + "**********" + key[len(key)-4:]
 }
 
+// IsHashed 令牌只保存了哈希，无法再查看完整密钥
+func (token *Token) IsHashed() bool {
+	return IsHashedTokenKey(token.Key)
+}
+
 func (token *Token) GetFullKey() string {
+	if token.IsHashed() {
+		return ""
+	}
 	return token.Key
 }
 
 func (token *Token) GetMaskedKey() string {
+	if token.IsHashed() {
+		return token.KeyPrefix + "**********"
+	}
 	return MaskTokenKey(token.Key)
 }
 
@@ -166,7 +189,17 @@ func SearchUserTokens(userId int, keyword string, token string, offset int, limi
 		if err != nil {
 			return nil, 0, err
 		}
-		baseQuery = baseQuery.Where(commonKeyCol+" LIKE ? ESCAPE '!'", tokenPattern)
+		if strings.Contains(tokenPattern, "%") {
+			// 哈希令牌只能按展示前缀模糊搜索
+			baseQuery = baseQuery.Where("((? NOT LIKE ? AND ? LIKE ? ESCAPE '!') OR key_prefix LIKE ? ESCAPE '!')",
+				keyColumn, hashedTokenKeyPrefix+"%", keyColumn, tokenPattern, tokenPattern)
+		} else {
+			hashed, err := HashTokenKey(token)
+			if err != nil {
+				return nil, 0, err
+			}
+			baseQuery = baseQuery.Where(clause.IN{Column: keyColumn, Values: []interface{}{token, hashed}})
+		}
 	}
 
 	// 先查匹配总数（用于分页，受 maxTokens 上限保护，避免全表 COUNT）
@@ -272,6 +305,10 @@ func GetTokenByKey(key string, fromDB bool) (token *Token, err error) {
 			})
 		}
 	}()
+	// 客户端直接提交数据库中的哈希不能通过鉴权
+	if key == "" || IsHashedTokenKey(key) {
+		return nil, gorm.ErrRecordNotFound
+	}
 	if !fromDB && common.RedisEnabled {
 		// Try Redis first
 		token, err := cacheGetTokenByKey(key)
@@ -281,11 +318,29 @@ func GetTokenByKey(key string, fromDB bool) (token *Token, err error) {
 		// Don't return error - fall through to DB
 	}
 	fromDB = true
-	err = DB.Where(commonKeyCol+" = ?", key).First(&token).Error
+	// 新令牌按哈希匹配，未迁移的旧令牌按明文匹配
+	hashed, err := HashTokenKey(key)
+	if err != nil {
+		return nil, err
+	}
+	err = DB.Where(clause.IN{Column: keyColumn, Values: []interface{}{hashed, key}}).First(&token).Error
+	if err == nil {
+		// 后续的缓存与额度操作使用请求中的明文密钥
+		token.Key = key
+	}
 	return token, err
 }
 
+// Insert 保存令牌时只保留哈希与展示前缀，调用方需自行把完整密钥返回给用户
 func (token *Token) Insert() error {
+	if token.Key != "" && !token.IsHashed() {
+		hashed, err := HashTokenKey(token.Key)
+		if err != nil {
+			return err
+		}
+		token.KeyPrefix = TokenKeyDisplayPrefix(token.Key)
+		token.Key = hashed
+	}
 	var err error
 	err = DB.Create(token).Error
 	return err
@@ -304,7 +359,9 @@ func (token *Token) Update() (err error) {
 		}
 	}()
 	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
-		"model_limits_enabled", "model_limits", "allow_ips", "group", "cross_group_retry").Updates(token).Error
+		"model_limits_enabled", "model_limits", "allow_ips", "group", "cross_group_retry",
+		"tpm_limit", "output_tpm_limit", "concurrency_limit", "response_cache", "model_fallback", "scopes",
+		"budget_period", "budget_limit", "budget_used", "budget_reset_time").Updates(token).Error
 	return err
 }
 
@@ -349,6 +406,28 @@ func (token *Token) GetModelLimits() []string {
 	return strings.Split(token.ModelLimits, ",")
 }
 
+// HasScope 令牌是否允许访问 scope 对应的接口
+func (token *Token) HasScope(scope string) bool {
+	return HasTokenScope(token.Scopes, scope)
+}
+
+// HasTokenScope 未配置作用域（scopes 为空）时允许所有接口；配置了作用域时，
+// 无法归类的接口（scope 为空）一律拒绝
+func HasTokenScope(scopes string, scope string) bool {
+	if scopes == "" {
+		return true
+	}
+	if scope == "" {
+		return false
+	}
+	for _, s := range strings.Split(scopes, ",") {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
 func (token *Token) GetModelLimitsMap() map[string]bool {
 	limits := token.GetModelLimits()
 	limitsMap := make(map[string]bool)
@@ -405,6 +484,7 @@ func increaseTokenQuota(id int, quota int) (err error) {
 		map[string]interface{}{
 			"remain_quota":  gorm.Expr("remain_quota + ?", quota),
 			"used_quota":    gorm.Expr("used_quota - ?", quota),
+			"budget_used":   gorm.Expr("budget_used - ?", quota),
 			"accessed_time": common.GetTimestamp(),
 		},
 	).Error
@@ -435,6 +515,7 @@ func decreaseTokenQuota(id int, quota int) (err error) {
 		map[string]interface{}{
 			"remain_quota":  gorm.Expr("remain_quota - ?", quota),
 			"used_quota":    gorm.Expr("used_quota + ?", quota),
+			"budget_used":   gorm.Expr("budget_used + ?", quota),
 			"accessed_time": common.GetTimestamp(),
 		},
 	).Error
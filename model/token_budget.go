@@ -0,0 +1,105 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+)
+
+// 令牌周期预算：在剩余额度之外限制令牌每个周期（日/周/月）的消耗，
+// 周期边界与订阅额度重置一致（自然日、周一、每月 1 日），到期后由后台任务清零。
+// budget_used 随令牌额度一起增减，启用预算或切换周期时从 0 开始计算。
+
+// NormalizeTokenBudgetPeriod 校验预算周期，空字符串表示不启用
+func NormalizeTokenBudgetPeriod(period string) (string, error) {
+	switch period = strings.TrimSpace(period); period {
+	case "", SubscriptionResetNever:
+		return "", nil
+	case SubscriptionResetDaily, SubscriptionResetWeekly, SubscriptionResetMonthly:
+		return period, nil
+	default:
+		return "", fmt.Errorf("不支持的预算周期：%s", period)
+	}
+}
+
+func nextTokenBudgetResetTime(period string, now time.Time) int64 {
+	return calcNextResetTime(now, &SubscriptionPlan{QuotaResetPeriod: period}, 0)
+}
+
+// HasBudget 令牌是否配置了周期预算
+func (token *Token) HasBudget() bool {
+	return token.BudgetPeriod != "" && token.BudgetLimit > 0
+}
+
+// GetBudgetUsed 返回当前周期已用预算，周期已结束但尚未被后台任务重置时视为 0
+func (token *Token) GetBudgetUsed(now int64) int {
+	if token.BudgetResetTime > 0 && token.BudgetResetTime <= now {
+		return 0
+	}
+	// 跨周期退款可能让已用预算短暂为负
+	return max(token.BudgetUsed, 0)
+}
+
+// SetBudget 设置周期预算；启用预算或切换周期时从当前时间开始新的周期，只调整限额时保留本周期用量
+func (token *Token) SetBudget(period string, limit int, now time.Time) error {
+	period, err := NormalizeTokenBudgetPeriod(period)
+	if err != nil {
+		return err
+	}
+	if limit < 0 {
+		return fmt.Errorf("预算限额不能为负数")
+	}
+	if period == "" || limit == 0 {
+		token.BudgetPeriod, token.BudgetLimit, token.BudgetUsed, token.BudgetResetTime = "", 0, 0, 0
+		return nil
+	}
+	if period != token.BudgetPeriod || token.BudgetResetTime == 0 {
+		token.BudgetUsed = 0
+		token.BudgetResetTime = nextTokenBudgetResetTime(period, now)
+	}
+	token.BudgetPeriod, token.BudgetLimit = period, limit
+	return nil
+}
+
+// ResetDueTokenBudgets 清零周期已结束的令牌预算并计算下一次重置时间，返回处理的令牌数
+func ResetDueTokenBudgets(limit int) (int, error) {
+	if limit <= 0 {
+		limit = 200
+	}
+	now := common.GetTimestamp()
+	var tokens []Token
+	if err := DB.Select("id", "key", "budget_period", "budget_reset_time").
+		Where("budget_reset_time > 0 AND budget_reset_time <= ?", now).
+		Order("budget_reset_time asc").
+		Limit(limit).
+		Find(&tokens).Error; err != nil {
+		return 0, err
+	}
+	resetCount := 0
+	for _, token := range tokens {
+		next := int64(0)
+		if token.BudgetPeriod != "" {
+			next = nextTokenBudgetResetTime(token.BudgetPeriod, time.Unix(now, 0))
+		}
+		// 以旧的重置时间为条件，避免覆盖期间被修改的预算设置
+		result := DB.Model(&Token{}).
+			Where("id = ? AND budget_reset_time = ?", token.Id, token.BudgetResetTime).
+			Updates(map[string]interface{}{
+				"budget_used":       0,
+				"budget_reset_time": next,
+			})
+		if result.Error != nil {
+			return resetCount, result.Error
+		}
+		resetCount++
+		if result.RowsAffected > 0 && common.RedisEnabled {
+			_ = cacheSetTokenField(token.Key, constant.TokenFieldBudgetUsed, "0")
+			_ = cacheSetTokenField(token.Key, constant.TokenFieldBudgetResetTime, strconv.FormatInt(next, 10))
+		}
+	}
+	return resetCount, nil
+}
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenSetBudget(t *testing.T) {
+	now := time.Date(2026, 3, 18, 15, 30, 0, 0, time.Local) // 周三
+	token := &Token{BudgetUsed: 500}
+
+	require.NoError(t, token.SetBudget("daily", 1000, now))
+	require.True(t, token.HasBudget())
+	require.Equal(t, 0, token.BudgetUsed)
+	require.Equal(t, time.Date(2026, 3, 19, 0, 0, 0, 0, time.Local).Unix(), token.BudgetResetTime)
+
+	// 只调整限额时保留本周期用量
+	token.BudgetUsed = 300
+	require.NoError(t, token.SetBudget("daily", 2000, now))
+	require.Equal(t, 300, token.BudgetUsed)
+	require.Equal(t, 2000, token.BudgetLimit)
+
+	require.NoError(t, token.SetBudget("weekly", 2000, now))
+	require.Equal(t, 0, token.BudgetUsed)
+	require.Equal(t, time.Date(2026, 3, 23, 0, 0, 0, 0, time.Local).Unix(), token.BudgetResetTime)
+
+	require.NoError(t, token.SetBudget("monthly", 2000, now))
+	require.Equal(t, time.Date(2026, 4, 1, 0, 0, 0, 0, time.Local).Unix(), token.BudgetResetTime)
+
+	require.NoError(t, token.SetBudget("", 2000, now))
+	require.False(t, token.HasBudget())
+	require.Zero(t, token.BudgetResetTime)
+
+	require.Error(t, token.SetBudget("hourly", 100, now))
+	require.Error(t, token.SetBudget("daily", -1, now))
+}
+
+func TestTokenGetBudgetUsed(t *testing.T) {
+	token := &Token{BudgetPeriod: "daily", BudgetLimit: 100, BudgetUsed: 40, BudgetResetTime: 1000}
+	require.Equal(t, 40, token.GetBudgetUsed(999))
+	// 周期已结束但尚未被后台任务重置
+	require.Equal(t, 0, token.GetBudgetUsed(1000))
+
+	token.BudgetUsed = -5
+	require.Equal(t, 0, token.GetBudgetUsed(999))
+}
@@ -9,9 +9,12 @@ import (
 )
 
 func cacheSetToken(token Token) error {
-	key := common.GenerateHMAC(token.Key)
+	key, err := TokenLookupHash(token.Key)
+	if err != nil {
+		return err
+	}
 	token.Clean()
-	err := common.RedisHSetObj(fmt.Sprintf("token:%s", key), &token, time.Duration(common.RedisKeyCacheSeconds())*time.Second)
+	err = common.RedisHSetObj(fmt.Sprintf("token:%s", key), &token, time.Duration(common.RedisKeyCacheSeconds())*time.Second)
 	if err != nil {
 		return err
 	}
@@ -19,8 +22,11 @@ func cacheSetToken(token Token) error {
 }
 
 func cacheDeleteToken(key string) error {
-	key = common.GenerateHMAC(key)
-	err := common.RedisDelKey(fmt.Sprintf("token:%s", key))
+	key, err := TokenLookupHash(key)
+	if err != nil {
+		return err
+	}
+	err = common.RedisDelKey(fmt.Sprintf("token:%s", key))
 	if err != nil {
 		return err
 	}
@@ -28,12 +34,16 @@ func cacheDeleteToken(key string) error {
 }
 
 func cacheIncrTokenQuota(key string, increment int64) error {
-	key = common.GenerateHMAC(key)
-	err := common.RedisHIncrBy(fmt.Sprintf("token:%s", key), constant.TokenFiledRemainQuota, increment)
+	key, err := TokenLookupHash(key)
 	if err != nil {
 		return err
 	}
-	return nil
+	err = common.RedisHIncrBy(fmt.Sprintf("token:%s", key), constant.TokenFiledRemainQuota, increment)
+	if err != nil {
+		return err
+	}
+	// 周期预算用量与剩余额度反向变化
+	return common.RedisHIncrBy(fmt.Sprintf("token:%s", key), constant.TokenFieldBudgetUsed, -increment)
 }
 
 func cacheDecrTokenQuota(key string, decrement int64) error {
@@ -41,8 +51,11 @@ func cacheDecrTokenQuota(key string, decrement int64) error {
 }
 
 func cacheSetTokenField(key string, field string, value string) error {
-	key = common.GenerateHMAC(key)
-	err := common.RedisHSetField(fmt.Sprintf("token:%s", key), field, value)
+	key, err := TokenLookupHash(key)
+	if err != nil {
+		return err
+	}
+	err = common.RedisHSetField(fmt.Sprintf("token:%s", key), field, value)
 	if err != nil {
 		return err
 	}
@@ -51,12 +64,15 @@ func cacheSetTokenField(key string, field string, value string) error {
 
 // CacheGetTokenByKey 从缓存中获取 token，如果缓存中不存在，则从数据库中获取
 func cacheGetTokenByKey(key string) (*Token, error) {
-	hmacKey := common.GenerateHMAC(key)
 	if !common.RedisEnabled {
 		return nil, fmt.Errorf("redis is not enabled")
 	}
+	hmacKey, err := TokenLookupHash(key)
+	if err != nil {
+		return nil, err
+	}
 	var token Token
-	err := common.RedisHGetObj(fmt.Sprintf("token:%s", hmacKey), &token)
+	err = common.RedisHGetObj(fmt.Sprintf("token:%s", hmacKey), &token)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,194 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// 子令牌是由父令牌签发的短期 JWT（HS256），鉴权时校验签名与声明，并重新检查父令牌的状态与 IP 限制；
+// 消耗计入父令牌。签名密钥由令牌哈希密钥派生，所有节点一致，子令牌无法单独吊销，
+// 因此有效期必须很短。
+
+// ChildTokenPrefix 子令牌以该前缀开头，与普通令牌区分
+const ChildTokenPrefix = "sk-child-"
+
+// ChildTokenClaims 子令牌声明，除子令牌自身的限制外还带有鉴权所需的父令牌快照
+type ChildTokenClaims struct {
+	TokenId   int    `json:"tid"`
+	TokenHash string `json:"tkh"` // 父令牌的缓存索引（哈希），用于计费时定位父令牌
+	TokenName string `json:"tnm,omitempty"`
+	UserId    int    `json:"uid"`
+	// 父令牌快照
+	UnlimitedQuota   bool   `json:"unl,omitempty"`
+	Scopes           string `json:"scp,omitempty"`
+	CrossGroupRetry  bool   `json:"cgr,omitempty"`
+	TpmLimit         int    `json:"tpm,omitempty"`
+	OutputTpmLimit   int    `json:"otpm,omitempty"`
+	ConcurrencyLimit int    `json:"cc,omitempty"`
+	BudgetPeriod     string `json:"bgp,omitempty"`
+	BudgetLimit      int    `json:"bgl,omitempty"`
+	// 子令牌限制
+	Models   []string `json:"mdl,omitempty"` // 为空表示不限制
+	MaxQuota int      `json:"mq,omitempty"`  // 0 表示只受父令牌额度限制
+	Group    string   `json:"grp,omitempty"`
+	EndUser  string   `json:"eu,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// IsChildToken 判断请求中的密钥是否为子令牌
+func IsChildToken(key string) bool {
+	return strings.HasPrefix(key, ChildTokenPrefix)
+}
+
+func childTokenSigningKey() ([]byte, error) {
+	secret, err := loadTokenHashSecret()
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte("child-token"))
+	return h.Sum(nil), nil
+}
+
+func signChildToken(signingKey []byte, claims *ChildTokenClaims) (string, error) {
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(signingKey)
+	if err != nil {
+		return "", err
+	}
+	return ChildTokenPrefix + signed, nil
+}
+
+func parseChildToken(signingKey []byte, key string) (*ChildTokenClaims, error) {
+	if !IsChildToken(key) {
+		return nil, errors.New("不是子令牌")
+	}
+	claims := &ChildTokenClaims{}
+	_, err := jwt.ParseWithClaims(strings.TrimPrefix(key, ChildTokenPrefix), claims, func(t *jwt.Token) (interface{}, error) {
+		return signingKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, errors.New("子令牌已过期")
+		}
+		return nil, errors.New("无效的子令牌")
+	}
+	if claims.TokenId == 0 || claims.UserId == 0 || !IsHashedTokenKey(claims.TokenHash) {
+		return nil, errors.New("无效的子令牌")
+	}
+	return claims, nil
+}
+
+// SignChildToken 签发子令牌
+func SignChildToken(claims *ChildTokenClaims) (string, error) {
+	signingKey, err := childTokenSigningKey()
+	if err != nil {
+		return "", err
+	}
+	return signChildToken(signingKey, claims)
+}
+
+// ParseChildToken 校验子令牌签名与有效期并返回声明
+func ParseChildToken(key string) (*ChildTokenClaims, error) {
+	signingKey, err := childTokenSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	return parseChildToken(signingKey, key)
+}
+
+// ParentToken 根据声明构造鉴权使用的父令牌，remainQuota 为子令牌剩余额度（无上限时为 0）；
+// 是否无限额度沿用父令牌，预扣费时仍会检查父令牌的实际余额
+func (claims *ChildTokenClaims) ParentToken(remainQuota int) *Token {
+	return &Token{
+		Id:                 claims.TokenId,
+		UserId:             claims.UserId,
+		Key:                claims.TokenHash,
+		Status:             common.TokenStatusEnabled,
+		Name:               claims.TokenName,
+		ExpiredTime:        -1,
+		RemainQuota:        remainQuota,
+		UnlimitedQuota:     claims.UnlimitedQuota,
+		ModelLimitsEnabled: len(claims.Models) > 0,
+		ModelLimits:        strings.Join(claims.Models, ","),
+		Group:              claims.Group,
+		CrossGroupRetry:    claims.CrossGroupRetry,
+		TpmLimit:           claims.TpmLimit,
+		OutputTpmLimit:     claims.OutputTpmLimit,
+		ConcurrencyLimit:   claims.ConcurrencyLimit,
+		Scopes:             claims.Scopes,
+		BudgetPeriod:       claims.BudgetPeriod,
+		BudgetLimit:        claims.BudgetLimit,
+	}
+}
+
+// LogInfo 写入消费日志 other.child_token 的内容
+func (claims *ChildTokenClaims) LogInfo() map[string]interface{} {
+	info := map[string]interface{}{
+		"id":         claims.ID,
+		"expires_at": claims.ExpiresAt.Unix(),
+	}
+	if claims.EndUser != "" {
+		info["end_user"] = claims.EndUser
+	}
+	if len(claims.Models) > 0 {
+		info["models"] = claims.Models
+	}
+	if claims.MaxQuota > 0 {
+		info["max_quota"] = claims.MaxQuota
+	}
+	if claims.Group != "" {
+		info["group"] = claims.Group
+	}
+	return info
+}
+
+// GetTokenByLookupHash 按缓存索引获取令牌，用于只持有哈希的子令牌计费；
+// 返回的令牌 Key 为该哈希，可直接用于额度缓存操作
+func GetTokenByLookupHash(tokenId int, hash string) (*Token, error) {
+	if common.RedisEnabled {
+		if token, err := cacheGetTokenByKey(hash); err == nil && token.Id == tokenId {
+			return token, nil
+		}
+	}
+	token, err := GetTokenById(tokenId)
+	if err != nil {
+		return nil, err
+	}
+	if lookupHash, err := TokenLookupHash(token.Key); err != nil || lookupHash != hash {
+		return nil, fmt.Errorf("令牌 #%d 的密钥已变更", tokenId)
+	}
+	token.Key = hash
+	return token, nil
+}
+
+// ValidateChildTokenParent 每次鉴权时重新检查父令牌，父令牌被禁用、删除、过期、
+// 额度用尽或密钥变更后子令牌立即失效
+func ValidateChildTokenParent(claims *ChildTokenClaims) (*Token, error) {
+	parent, err := GetTokenByLookupHash(claims.TokenId, claims.TokenHash)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("父令牌不存在")
+		}
+		return nil, err
+	}
+	if parent.UserId != claims.UserId {
+		return nil, errors.New("父令牌不存在")
+	}
+	if parent.Status != common.TokenStatusEnabled {
+		return nil, errors.New("父令牌状态不可用")
+	}
+	if parent.ExpiredTime != -1 && parent.ExpiredTime < common.GetTimestamp() {
+		return nil, errors.New("父令牌已过期")
+	}
+	if !parent.UnlimitedQuota && parent.RemainQuota <= 0 {
+		return nil, errors.New("父令牌额度已用尽")
+	}
+	return parent, nil
+}
@@ -0,0 +1,179 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/QuantumNous/new-api/common"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 令牌只保存带密钥的哈希（HMAC-SHA256）与用于展示的前缀，完整密钥只在创建时返回一次。
+// 哈希存放在原 key 列中，形如 "h:<base64url>"，长度不超过原有的 char(48)；
+// 未迁移的旧令牌仍以明文保存，鉴权时两者都能匹配。
+// PostgreSQL 的 char 列读出时会在哈希后补空格，加载后与计算缓存索引前都要去掉。
+
+const (
+	hashedTokenKeyPrefix = "h:"
+	// TokenHashSecretOptionKey 哈希密钥保存在 options 表中，所有节点共享，以 Secret 结尾因此不会在配置接口中返回
+	TokenHashSecretOptionKey = "TokenHashSecret"
+	tokenKeyDisplayPrefixLen = 4
+	tokenHashMigrateBatch    = 100
+)
+
+var tokenHashSecret atomic.Value // []byte
+
+var errTokenHashSecretNotInitialized = errors.New("token hash secret is not initialized")
+
+// keyColumn tokens / options 表的 key 列，由 gorm 按方言转义，不依赖 InitDB 中设置的 commonKeyCol
+var keyColumn = clause.Column{Name: "key"}
+
+// IsHashedTokenKey 判断 key 列中保存的是否为哈希
+func IsHashedTokenKey(key string) bool {
+	return strings.HasPrefix(key, hashedTokenKeyPrefix)
+}
+
+func hashTokenKey(secret []byte, key string) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(key))
+	return hashedTokenKeyPrefix + base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+func loadTokenHashSecret() ([]byte, error) {
+	secret, _ := tokenHashSecret.Load().([]byte)
+	if len(secret) == 0 {
+		return nil, errTokenHashSecretNotInitialized
+	}
+	return secret, nil
+}
+
+// SetTokenHashSecret 设置令牌哈希密钥，正常情况下由 initTokenHashSecret 从 options 表加载
+func SetTokenHashSecret(secret []byte) {
+	tokenHashSecret.Store(secret)
+}
+
+// HashTokenKey 计算明文令牌的哈希，用于数据库与缓存查找；哈希密钥未初始化时返回错误
+func HashTokenKey(key string) (string, error) {
+	secret, err := loadTokenHashSecret()
+	if err != nil {
+		return "", err
+	}
+	return hashTokenKey(secret, key), nil
+}
+
+// TokenLookupHash 返回令牌在缓存中的索引：已是哈希时原样返回，明文（请求中的密钥或未迁移的旧令牌）先计算哈希
+func TokenLookupHash(key string) (string, error) {
+	key = strings.TrimSpace(key)
+	if IsHashedTokenKey(key) {
+		return key, nil
+	}
+	return HashTokenKey(key)
+}
+
+// AfterFind 去掉 char(48) 列补齐的空格
+func (token *Token) AfterFind(tx *gorm.DB) error {
+	token.Key = strings.TrimSpace(token.Key)
+	return nil
+}
+
+// TokenKeyDisplayPrefix 创建令牌时保存的展示前缀
+func TokenKeyDisplayPrefix(key string) string {
+	if len(key) <= tokenKeyDisplayPrefixLen*2 {
+		return ""
+	}
+	return key[:tokenKeyDisplayPrefixLen]
+}
+
+// initTokenHashSecret 读取令牌哈希密钥，不存在时生成并保存；并发启动的节点以先写入的为准
+func initTokenHashSecret() error {
+	load := func() (bool, error) {
+		var option Option
+		err := DB.Where(clause.Eq{Column: keyColumn, Value: TokenHashSecretOptionKey}).First(&option).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		value, err := common.DecryptSecret(option.Value)
+		if err != nil {
+			return false, err
+		}
+		secret, err := hex.DecodeString(value)
+		if err != nil || len(secret) == 0 {
+			return false, fmt.Errorf("invalid %s option", TokenHashSecretOptionKey)
+		}
+		SetTokenHashSecret(secret)
+		return true, nil
+	}
+	if ok, err := load(); ok || err != nil {
+		return err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	value, err := common.EncryptSecret(hex.EncodeToString(secret))
+	if err != nil {
+		return err
+	}
+	if err := DB.Create(&Option{Key: TokenHashSecretOptionKey, Value: value}).Error; err != nil {
+		common.SysLog("token hash secret already created by another node: " + err.Error())
+	}
+	ok, err := load()
+	if err == nil && !ok {
+		err = fmt.Errorf("failed to create %s option", TokenHashSecretOptionKey)
+	}
+	return err
+}
+
+// MigrateTokensToHash 把仍以明文保存的令牌改为只保存哈希与展示前缀，迁移后将无法再查看完整密钥
+func MigrateTokensToHash() (int, error) {
+	migrated := 0
+	lastId := 0
+	for {
+		var tokens []Token
+		err := DB.Unscoped().Select("id", "key").Where("id > ?", lastId).
+			Order("id").Limit(tokenHashMigrateBatch).Find(&tokens).Error
+		if err != nil {
+			return migrated, err
+		}
+		if len(tokens) == 0 {
+			return migrated, nil
+		}
+		lastId = tokens[len(tokens)-1].Id
+		for _, token := range tokens {
+			if token.Key == "" || IsHashedTokenKey(token.Key) {
+				continue
+			}
+			hashed, err := HashTokenKey(token.Key)
+			if err != nil {
+				return migrated, err
+			}
+			result := DB.Unscoped().Model(&Token{}).
+				Where(map[string]interface{}{"id": token.Id, "key": token.Key}).
+				Updates(map[string]interface{}{
+					"key":        hashed,
+					"key_prefix": TokenKeyDisplayPrefix(token.Key),
+				})
+			if result.Error != nil {
+				return migrated, result.Error
+			}
+			if result.RowsAffected > 0 {
+				migrated++
+				// 缓存索引本来就是哈希，只需清除旧缓存以带上新的 key 与前缀
+				if common.RedisEnabled {
+					_ = cacheDeleteToken(token.Key)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// PostgreSQL 的 char(48) 会把 45 位的哈希补齐为 48 位，读出后必须与缓存索引一致
+func TestTokenLookupHashPaddedKey(t *testing.T) {
+	truncateTables(t)
+	SetTokenHashSecret([]byte("test-secret"))
+
+	hash, err := HashTokenKey("sk-padded-token-key")
+	require.NoError(t, err)
+	padded := hash + strings.Repeat(" ", 48-len(hash))
+
+	lookup, err := TokenLookupHash(padded)
+	require.NoError(t, err)
+	require.Equal(t, hash, lookup)
+
+	require.NoError(t, DB.Exec(
+		"INSERT INTO tokens (id, user_id, "+"`key`"+", status, name, expired_time) VALUES (?, ?, ?, ?, ?, ?)",
+		9001, 1, padded, 1, "padded", -1,
+	).Error)
+
+	token, err := GetTokenById(9001)
+	require.NoError(t, err)
+	require.Equal(t, hash, token.Key)
+
+	token, err = GetTokenByLookupHash(9001, hash)
+	require.NoError(t, err)
+	require.Equal(t, hash, token.Key)
+}
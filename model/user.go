@@ -50,6 +50,9 @@ type User struct {
 	Setting          string         `json:"setting" gorm:"type:text;column:setting"`
 	Remark           string         `json:"remark,omitempty" gorm:"type:varchar(255)" validate:"max=255"`
 	StripeCustomer   string         `json:"stripe_customer" gorm:"type:varchar(64);column:stripe_customer;index"`
+	TpmLimit         int            `json:"tpm_limit" gorm:"type:int;default:0"`         // 每分钟输入 token 上限，0 表示不限制
+	OutputTpmLimit   int            `json:"output_tpm_limit" gorm:"type:int;default:0"`  // 每分钟输出 token 上限
+	ConcurrencyLimit int            `json:"concurrency_limit" gorm:"type:int;default:0"` // 并发请求数上限
 }
 
 func (user *User) ToBaseUser() *UserBase {
@@ -61,6 +64,10 @@ func (user *User) ToBaseUser() *UserBase {
 		Username: user.Username,
 		Setting:  user.Setting,
 		Email:    user.Email,
+
+		TpmLimit:         user.TpmLimit,
+		OutputTpmLimit:   user.OutputTpmLimit,
+		ConcurrencyLimit: user.ConcurrencyLimit,
 	}
 	return cache
 }
@@ -525,6 +532,10 @@ func (user *User) Edit(updatePassword bool) error {
 		"group":        newUser.Group,
 		"quota":        newUser.Quota,
 		"remark":       newUser.Remark,
+
+		"tpm_limit":         newUser.TpmLimit,
+		"output_tpm_limit":  newUser.OutputTpmLimit,
+		"concurrency_limit": newUser.ConcurrencyLimit,
 	}
 	if updatePassword {
 		updates["password"] = newUser.Password
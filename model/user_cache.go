@@ -22,6 +22,10 @@ type UserBase struct {
 	Status   int    `json:"status"`
 	Username string `json:"username"`
 	Setting  string `json:"setting"`
+
+	TpmLimit         int `json:"tpm_limit"`
+	OutputTpmLimit   int `json:"output_tpm_limit"`
+	ConcurrencyLimit int `json:"concurrency_limit"`
 }
 
 func (user *UserBase) WriteContext(c *gin.Context) {
@@ -31,6 +35,9 @@ func (user *UserBase) WriteContext(c *gin.Context) {
 	common.SetContextKey(c, constant.ContextKeyUserEmail, user.Email)
 	common.SetContextKey(c, constant.ContextKeyUserName, user.Username)
 	common.SetContextKey(c, constant.ContextKeyUserSetting, user.GetSetting())
+	common.SetContextKey(c, constant.ContextKeyUserTpmLimit, user.TpmLimit)
+	common.SetContextKey(c, constant.ContextKeyUserOutputTpmLimit, user.OutputTpmLimit)
+	common.SetContextKey(c, constant.ContextKeyUserConcurrencyLimit, user.ConcurrencyLimit)
 }
 
 func (user *UserBase) GetSetting() dto.UserSetting {
@@ -99,15 +106,7 @@ func GetUserCache(userId int) (userCache *UserBase, err error) {
 	}
 
 	// Create cache object from user data
-	userCache = &UserBase{
-		Id:       user.Id,
-		Group:    user.Group,
-		Quota:    user.Quota,
-		Status:   user.Status,
-		Username: user.Username,
-		Setting:  user.Setting,
-		Email:    user.Email,
-	}
+	userCache = user.ToBaseUser()
 
 	return userCache, nil
 }
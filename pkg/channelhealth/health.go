@@ -0,0 +1,360 @@
+// Package channelhealth 按真实流量统计渠道 / 模型维度的健康状况（EWMA 成功率、TTFT、p95、在途请求数），
+// 供选路时在同一优先级内调整权重，并对持续失败的渠道做软摘除与逐步恢复（outlier detection）。
+// 统计仅保存在本进程内存中，长时间没有流量的条目会被清理。
+package channelhealth
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+type Outcome int
+
+const (
+	OutcomeSuccess Outcome = iota
+	OutcomeFailure
+	// OutcomeIgnored 与渠道健康无关的结果（如客户端参数错误），只释放在途计数
+	OutcomeIgnored
+)
+
+const (
+	latencyWindowSize = 64
+	// 软摘除恢复期的起始流量比例
+	recoveryMinFactor = 0.1
+	minSuccessFactor  = 0.01
+	minLatencyFactor  = 0.05
+	// 超过该时长没有请求的统计视为过期，清理后重新从零开始
+	statsIdleTTL       = 30 * time.Minute
+	statsPurgeInterval = time.Minute
+)
+
+type statsKey struct {
+	channelId int
+	model     string
+}
+
+type stats struct {
+	inflight atomic.Int64
+	lastUsed atomic.Int64 // unix nano
+
+	mu                  sync.Mutex
+	samples             int64
+	successEwma         float64
+	latencyEwma         float64 // ms
+	latencyWindow       [latencyWindowSize]float64
+	latencyCount        int
+	latencyPos          int
+	consecutiveFailures int
+	ejectCount          int
+	ejectedUntil        time.Time
+	recoverAt           time.Time
+}
+
+var (
+	store     sync.Map // statsKey -> *stats
+	lastPurge atomic.Int64
+)
+
+// now 便于测试替换
+var now = time.Now
+
+func getStats(channelId int, model string) *stats {
+	t := now()
+	maybePurge(t)
+	key := statsKey{channelId: channelId, model: model}
+	v, ok := store.Load(key)
+	if !ok {
+		v, _ = store.LoadOrStore(key, &stats{successEwma: 1})
+	}
+	s := v.(*stats)
+	s.lastUsed.Store(t.UnixNano())
+	return s
+}
+
+// maybePurge 每隔 statsPurgeInterval 清理一次过期统计，避免渠道 / 模型组合无限增长
+func maybePurge(t time.Time) {
+	last := lastPurge.Load()
+	if t.UnixNano()-last < int64(statsPurgeInterval) || !lastPurge.CompareAndSwap(last, t.UnixNano()) {
+		return
+	}
+	purgeIdle(t)
+}
+
+// purgeIdle 删除空闲超过 statsIdleTTL 且没有在途请求、不处于摘除或恢复期的统计
+func purgeIdle(t time.Time) {
+	recovery := time.Duration(operation_setting.GetChannelHealthSetting().RecoverySeconds) * time.Second
+	store.Range(func(k, v any) bool {
+		s := v.(*stats)
+		if s.inflight.Load() > 0 || t.Sub(time.Unix(0, s.lastUsed.Load())) < statsIdleTTL {
+			return true
+		}
+		s.mu.Lock()
+		active := t.Before(s.ejectedUntil) || (!s.recoverAt.IsZero() && t.Sub(s.recoverAt) < recovery)
+		s.mu.Unlock()
+		if !active {
+			store.Delete(k)
+		}
+		return true
+	})
+}
+
+func lookupStats(channelId int, model string) *stats {
+	if v, ok := store.Load(statsKey{channelId: channelId, model: model}); ok {
+		return v.(*stats)
+	}
+	return nil
+}
+
+// Begin 在向上游发起请求前调用，返回的 done 必须在请求结束后调用一次；
+// latency 为首字延迟（非流式即完整响应耗时），失败时可传 0
+func Begin(channelId int, model string) func(outcome Outcome, latency time.Duration) {
+	s := getStats(channelId, model)
+	s.inflight.Add(1)
+	var once sync.Once
+	return func(outcome Outcome, latency time.Duration) {
+		once.Do(func() {
+			s.inflight.Add(-1)
+			if outcome == OutcomeIgnored {
+				return
+			}
+			s.record(channelId, model, outcome == OutcomeSuccess, latency, operation_setting.GetChannelHealthSetting(), now())
+		})
+	}
+}
+
+func (s *stats) record(channelId int, model string, success bool, latency time.Duration, setting *operation_setting.ChannelHealthSetting, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alpha := setting.EwmaAlpha
+	if alpha <= 0 || alpha > 1 {
+		alpha = 0.2
+	}
+	x := 0.0
+	if success {
+		x = 1
+	}
+	s.successEwma += alpha * (x - s.successEwma)
+	s.samples++
+
+	if success {
+		s.consecutiveFailures = 0
+		if latency > 0 {
+			ms := float64(latency) / float64(time.Millisecond)
+			if s.latencyCount == 0 {
+				s.latencyEwma = ms
+			} else {
+				s.latencyEwma += alpha * (ms - s.latencyEwma)
+			}
+			s.latencyWindow[s.latencyPos] = ms
+			s.latencyPos = (s.latencyPos + 1) % latencyWindowSize
+			if s.latencyCount < latencyWindowSize {
+				s.latencyCount++
+			}
+		}
+		// 恢复期结束后仍然成功，清零摘除次数
+		if s.ejectCount > 0 && !t.Before(s.recoverAt.Add(time.Duration(setting.RecoverySeconds)*time.Second)) {
+			s.ejectCount = 0
+		}
+		return
+	}
+
+	s.consecutiveFailures++
+	if t.Before(s.ejectedUntil) {
+		return
+	}
+	reason := ""
+	if setting.EjectConsecutiveFailures > 0 && s.consecutiveFailures >= setting.EjectConsecutiveFailures {
+		reason = fmt.Sprintf("%d consecutive failures", s.consecutiveFailures)
+	} else if setting.EjectErrorRate > 0 && s.samples >= int64(setting.MinRequests) && 1-s.successEwma >= setting.EjectErrorRate {
+		reason = fmt.Sprintf("error rate %.2f", 1-s.successEwma)
+	}
+	if reason == "" {
+		return
+	}
+	duration := time.Duration(setting.EjectBaseSeconds) * time.Second << min(s.ejectCount, 10)
+	if maxDuration := time.Duration(setting.EjectMaxSeconds) * time.Second; maxDuration > 0 && duration > maxDuration {
+		duration = maxDuration
+	}
+	s.ejectCount++
+	s.ejectedUntil = t.Add(duration)
+	s.recoverAt = s.ejectedUntil
+	// 重新开始统计，恢复期内按新样本判断
+	s.samples = 0
+	s.successEwma = 1
+	s.consecutiveFailures = 0
+	common.SysLog(fmt.Sprintf("channel #%d model %s soft-ejected for %s: %s", channelId, model, duration, reason))
+}
+
+func (s *stats) p95() float64 {
+	if s.latencyCount == 0 {
+		return 0
+	}
+	window := make([]float64, s.latencyCount)
+	copy(window, s.latencyWindow[:s.latencyCount])
+	sort.Float64s(window)
+	idx := int(math.Ceil(float64(len(window))*0.95)) - 1
+	return window[max(idx, 0)]
+}
+
+// AdjustWeights 按健康状况调整同一优先级内候选渠道的权重，返回与 channelIds 同序的新权重。
+// 软摘除的渠道权重为 0；被摘除比例超过 MaxEjectPercent 或调整后全部为 0 时放行，避免无渠道可用
+func AdjustWeights(model string, channelIds []int, weights []float64) []float64 {
+	setting := operation_setting.GetChannelHealthSetting()
+	if !setting.AdaptiveSelectionEnabled || len(channelIds) == 0 {
+		return weights
+	}
+	t := now()
+
+	type view struct {
+		samples      int64
+		successEwma  float64
+		latencyEwma  float64
+		inflight     int64
+		ejected      bool
+		recoverAt    time.Time
+		hasLatencies bool
+	}
+	views := make([]*view, len(channelIds))
+	ejectedCount := 0
+	for i, channelId := range channelIds {
+		s := lookupStats(channelId, model)
+		if s == nil {
+			continue
+		}
+		s.mu.Lock()
+		v := &view{
+			samples:      s.samples,
+			successEwma:  s.successEwma,
+			latencyEwma:  s.latencyEwma,
+			inflight:     s.inflight.Load(),
+			ejected:      t.Before(s.ejectedUntil),
+			recoverAt:    s.recoverAt,
+			hasLatencies: s.latencyCount > 0,
+		}
+		s.mu.Unlock()
+		views[i] = v
+		if v.ejected {
+			ejectedCount++
+		}
+	}
+	allowEject := ejectedCount < len(channelIds) && ejectedCount*100 <= len(channelIds)*setting.MaxEjectPercent
+
+	// least-loaded：以 EWMA 延迟 × (在途请求 + 1) 作为代价，与最小代价相比
+	costs := make([]float64, len(channelIds))
+	minCost := 0.0
+	if setting.LatencyAware {
+		for i, v := range views {
+			if v == nil || !v.hasLatencies || v.samples < int64(setting.MinRequests) || (v.ejected && allowEject) {
+				continue
+			}
+			costs[i] = v.latencyEwma * float64(v.inflight+1)
+			if costs[i] > 0 && (minCost == 0 || costs[i] < minCost) {
+				minCost = costs[i]
+			}
+		}
+	}
+
+	recovery := time.Duration(setting.RecoverySeconds) * time.Second
+	adjusted := make([]float64, len(weights))
+	total := 0.0
+	for i, v := range views {
+		factor := 1.0
+		if v != nil {
+			if v.ejected && allowEject {
+				factor = 0
+			} else {
+				if v.samples >= int64(setting.MinRequests) {
+					factor *= math.Max(v.successEwma*v.successEwma, minSuccessFactor)
+				}
+				if minCost > 0 && costs[i] > 0 {
+					factor *= math.Max(minCost/costs[i], minLatencyFactor)
+				}
+				if !v.recoverAt.IsZero() && recovery > 0 && !v.ejected {
+					if elapsed := t.Sub(v.recoverAt); elapsed < recovery {
+						factor *= recoveryMinFactor + (1-recoveryMinFactor)*float64(elapsed)/float64(recovery)
+					}
+				}
+			}
+		}
+		adjusted[i] = weights[i] * factor
+		total += adjusted[i]
+	}
+	if total <= 0 {
+		return weights
+	}
+	return adjusted
+}
+
+type Snapshot struct {
+	ChannelId           int     `json:"channel_id"`
+	Model               string  `json:"model"`
+	Samples             int64   `json:"samples"`
+	SuccessRate         float64 `json:"success_rate"`
+	TtftEwmaMs          float64 `json:"ttft_ewma_ms"`
+	TtftP95Ms           float64 `json:"ttft_p95_ms"`
+	Inflight            int64   `json:"inflight"`
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+	EjectCount          int     `json:"eject_count"`
+	Ejected             bool    `json:"ejected"`
+	EjectedUntil        int64   `json:"ejected_until"`
+	Recovering          bool    `json:"recovering"`
+}
+
+// GetSnapshots 返回健康统计快照，channelId 为 0 时返回全部
+func GetSnapshots(channelId int) []Snapshot {
+	t := now()
+	recovery := time.Duration(operation_setting.GetChannelHealthSetting().RecoverySeconds) * time.Second
+	snapshots := make([]Snapshot, 0)
+	store.Range(func(k, v any) bool {
+		key := k.(statsKey)
+		if channelId != 0 && key.channelId != channelId {
+			return true
+		}
+		s := v.(*stats)
+		s.mu.Lock()
+		snapshot := Snapshot{
+			ChannelId:           key.channelId,
+			Model:               key.model,
+			Samples:             s.samples,
+			SuccessRate:         s.successEwma,
+			TtftEwmaMs:          s.latencyEwma,
+			TtftP95Ms:           s.p95(),
+			Inflight:            s.inflight.Load(),
+			ConsecutiveFailures: s.consecutiveFailures,
+			EjectCount:          s.ejectCount,
+			Ejected:             t.Before(s.ejectedUntil),
+			Recovering:          !s.recoverAt.IsZero() && !t.Before(s.recoverAt) && t.Sub(s.recoverAt) < recovery,
+		}
+		if snapshot.Ejected {
+			snapshot.EjectedUntil = s.ejectedUntil.Unix()
+		}
+		s.mu.Unlock()
+		snapshots = append(snapshots, snapshot)
+		return true
+	})
+	sort.Slice(snapshots, func(i, j int) bool {
+		if snapshots[i].ChannelId != snapshots[j].ChannelId {
+			return snapshots[i].ChannelId < snapshots[j].ChannelId
+		}
+		return snapshots[i].Model < snapshots[j].Model
+	})
+	return snapshots
+}
+
+// Reset 清除渠道的全部统计（渠道被手动启用或更新后调用）
+func Reset(channelId int) {
+	store.Range(func(k, _ any) bool {
+		if k.(statsKey).channelId == channelId {
+			store.Delete(k)
+		}
+		return true
+	})
+}
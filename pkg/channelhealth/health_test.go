@@ -0,0 +1,174 @@
+package channelhealth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/stretchr/testify/require"
+)
+
+func setupHealthTest(t *testing.T) *time.Time {
+	t.Helper()
+	setting := operation_setting.GetChannelHealthSetting()
+	saved := *setting
+	setting.AdaptiveSelectionEnabled = true
+	setting.EwmaAlpha = 0.2
+	setting.MinRequests = 3
+	setting.LatencyAware = true
+	setting.EjectConsecutiveFailures = 3
+	setting.EjectErrorRate = 0
+	setting.EjectBaseSeconds = 30
+	setting.EjectMaxSeconds = 300
+	setting.MaxEjectPercent = 50
+	setting.RecoverySeconds = 60
+
+	current := time.Unix(1700000000, 0)
+	now = func() time.Time { return current }
+	store.Range(func(k, _ any) bool {
+		store.Delete(k)
+		return true
+	})
+	lastPurge.Store(current.UnixNano())
+	t.Cleanup(func() {
+		*setting = saved
+		now = time.Now
+	})
+	return &current
+}
+
+func report(channelId int, model string, outcome Outcome, latency time.Duration) {
+	Begin(channelId, model)(outcome, latency)
+}
+
+func TestSoftEjectAndRecover(t *testing.T) {
+	current := setupHealthTest(t)
+	for i := 0; i < 3; i++ {
+		report(1, "gpt-4o", OutcomeSuccess, 100*time.Millisecond)
+		report(2, "gpt-4o", OutcomeSuccess, 100*time.Millisecond)
+	}
+	for i := 0; i < 3; i++ {
+		report(1, "gpt-4o", OutcomeFailure, 0)
+	}
+
+	weights := AdjustWeights("gpt-4o", []int{1, 2}, []float64{100, 100})
+	require.Equal(t, 0.0, weights[0])
+	require.Equal(t, 100.0, weights[1])
+
+	// 其他模型不受影响
+	weights = AdjustWeights("gpt-4.1", []int{1, 2}, []float64{100, 100})
+	require.Equal(t, []float64{100, 100}, weights)
+
+	// 摘除结束后从 10% 开始逐步恢复
+	*current = current.Add(30 * time.Second)
+	weights = AdjustWeights("gpt-4o", []int{1, 2}, []float64{100, 100})
+	require.InDelta(t, 10.0, weights[0], 0.001)
+
+	*current = current.Add(30 * time.Second)
+	weights = AdjustWeights("gpt-4o", []int{1, 2}, []float64{100, 100})
+	require.InDelta(t, 55.0, weights[0], 0.001)
+
+	*current = current.Add(30 * time.Second)
+	weights = AdjustWeights("gpt-4o", []int{1, 2}, []float64{100, 100})
+	require.InDelta(t, 100.0, weights[0], 0.001)
+}
+
+func TestEjectBackoffDoubles(t *testing.T) {
+	current := setupHealthTest(t)
+	for i := 0; i < 3; i++ {
+		report(1, "m", OutcomeFailure, 0)
+	}
+	*current = current.Add(31 * time.Second)
+	for i := 0; i < 3; i++ {
+		report(1, "m", OutcomeFailure, 0)
+	}
+	snapshots := GetSnapshots(1)
+	require.Len(t, snapshots, 1)
+	require.True(t, snapshots[0].Ejected)
+	require.Equal(t, 2, snapshots[0].EjectCount)
+	require.Equal(t, current.Add(60*time.Second).Unix(), snapshots[0].EjectedUntil)
+}
+
+func TestEjectFailOpen(t *testing.T) {
+	setupHealthTest(t)
+	for i := 0; i < 3; i++ {
+		report(1, "m", OutcomeFailure, 0)
+		report(2, "m", OutcomeFailure, 0)
+	}
+	// 全部被摘除时放行
+	weights := AdjustWeights("m", []int{1, 2}, []float64{10, 20})
+	require.Equal(t, []float64{10, 20}, weights)
+
+	// 摘除比例超过 MaxEjectPercent 时放行
+	weights = AdjustWeights("m", []int{1, 2, 3}, []float64{10, 20, 30})
+	require.Equal(t, []float64{10, 20, 30}, weights)
+}
+
+func TestIgnoredOutcomeDoesNotCount(t *testing.T) {
+	setupHealthTest(t)
+	for i := 0; i < 10; i++ {
+		report(1, "m", OutcomeIgnored, 0)
+	}
+	snapshots := GetSnapshots(1)
+	require.Len(t, snapshots, 1)
+	require.Equal(t, int64(0), snapshots[0].Samples)
+	require.Equal(t, int64(0), snapshots[0].Inflight)
+	require.False(t, snapshots[0].Ejected)
+}
+
+func TestLatencyBias(t *testing.T) {
+	setupHealthTest(t)
+	for i := 0; i < 5; i++ {
+		report(1, "m", OutcomeSuccess, 100*time.Millisecond)
+		report(2, "m", OutcomeSuccess, 400*time.Millisecond)
+	}
+	weights := AdjustWeights("m", []int{1, 2, 3}, []float64{100, 100, 100})
+	require.InDelta(t, 100.0, weights[0], 0.001)
+	require.InDelta(t, 25.0, weights[1], 0.001)
+	// 没有统计数据的渠道保持原权重
+	require.InDelta(t, 100.0, weights[2], 0.001)
+
+	// 在途请求越多代价越高
+	done := Begin(1, "m")
+	weights = AdjustWeights("m", []int{1, 2}, []float64{100, 100})
+	require.InDelta(t, 100.0, weights[0], 0.001)
+	require.InDelta(t, 50.0, weights[1], 0.001)
+	done(OutcomeSuccess, 100*time.Millisecond)
+}
+
+func TestDisabled(t *testing.T) {
+	setupHealthTest(t)
+	operation_setting.GetChannelHealthSetting().AdaptiveSelectionEnabled = false
+	for i := 0; i < 3; i++ {
+		report(1, "m", OutcomeFailure, 0)
+	}
+	weights := AdjustWeights("m", []int{1, 2}, []float64{100, 100})
+	require.Equal(t, []float64{100, 100}, weights)
+}
+
+func TestPurgeIdleStats(t *testing.T) {
+	current := setupHealthTest(t)
+	setting := operation_setting.GetChannelHealthSetting()
+	setting.EjectBaseSeconds = 7200
+	setting.EjectMaxSeconds = 7200
+	report(1, "gpt-4o", OutcomeSuccess, 100*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		report(2, "gpt-4o", OutcomeFailure, 0)
+	}
+	done := Begin(3, "gpt-4o")
+
+	// 空闲未超过 statsIdleTTL 时保留
+	*current = current.Add(statsIdleTTL - time.Second)
+	report(4, "gpt-4o", OutcomeSuccess, 100*time.Millisecond)
+	require.Len(t, GetSnapshots(0), 4)
+
+	// 空闲超时的统计被清理，摘除中与有在途请求的保留
+	*current = current.Add(statsPurgeInterval)
+	report(4, "gpt-4o", OutcomeSuccess, 100*time.Millisecond)
+	channelIds := make([]int, 0)
+	for _, snapshot := range GetSnapshots(0) {
+		channelIds = append(channelIds, snapshot.ChannelId)
+	}
+	require.Equal(t, []int{2, 3, 4}, channelIds)
+	done(OutcomeSuccess, 0)
+}
@@ -0,0 +1,417 @@
+// Package circuitbreaker 渠道级与多密钥级熔断器：closed → open → half_open → closed。
+// 计数保存在本进程内存中；状态变化在启用 Redis 时写入同一个 hash，各节点定期同步，
+// 因此一个节点触发的熔断 / 恢复会在数秒内对所有节点生效。
+package circuitbreaker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+type State string
+
+const (
+	StateClosed   State = "closed"
+	StateOpen     State = "open"
+	StateHalfOpen State = "half_open"
+)
+
+type Outcome int
+
+const (
+	OutcomeSuccess Outcome = iota
+	// OutcomeFailure 5xx、超时、网络错误等可归因于上游的失败
+	OutcomeFailure
+	// OutcomeIgnored 与上游健康无关的结果，只归还半开状态的探测名额
+	OutcomeIgnored
+)
+
+// ChannelScope 渠道级熔断器使用的 key index
+const ChannelScope = -1
+
+const (
+	redisHashKey = "new-api:circuit_breaker"
+	syncInterval = 2 * time.Second
+	// 超过该时长仍未更新的 Redis 记录视为残留
+	staleEntryAge = time.Hour
+)
+
+type breakerKey struct {
+	channelId int
+	keyIndex  int
+}
+
+func (k breakerKey) field() string {
+	return fmt.Sprintf("%d:%d", k.channelId, k.keyIndex)
+}
+
+func parseField(field string) (breakerKey, bool) {
+	parts := strings.SplitN(field, ":", 2)
+	if len(parts) != 2 {
+		return breakerKey{}, false
+	}
+	channelId, err1 := strconv.Atoi(parts[0])
+	keyIndex, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return breakerKey{}, false
+	}
+	return breakerKey{channelId: channelId, keyIndex: keyIndex}, true
+}
+
+type breaker struct {
+	mu                  sync.Mutex
+	state               State
+	consecutiveFailures int
+	openUntil           time.Time
+	halfOpenSince       time.Time
+	probes              int
+	probeSuccesses      int
+	updatedAt           int64 // unix nano，用于多节点同步时比较新旧
+}
+
+// redisEntry 写入 Redis hash 的状态
+type redisEntry struct {
+	State     State `json:"state"`
+	OpenUntil int64 `json:"open_until"`
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+// Status 渠道接口展示的熔断状态
+type Status struct {
+	KeyIndex            int   `json:"key_index"`
+	State               State `json:"state"`
+	ConsecutiveFailures int   `json:"consecutive_failures"`
+	OpenUntil           int64 `json:"open_until,omitempty"`
+	HalfOpenProbes      int   `json:"half_open_probes,omitempty"`
+}
+
+var breakers sync.Map // breakerKey -> *breaker
+
+// now 便于测试替换
+var now = time.Now
+
+func enabledFor(keyIndex int, setting *operation_setting.CircuitBreakerSetting) bool {
+	if !setting.Enabled {
+		return false
+	}
+	return keyIndex == ChannelScope || setting.KeyLevelEnabled
+}
+
+func lookup(key breakerKey) *breaker {
+	if v, ok := breakers.Load(key); ok {
+		return v.(*breaker)
+	}
+	return nil
+}
+
+func getOrCreate(key breakerKey) *breaker {
+	if b := lookup(key); b != nil {
+		return b
+	}
+	v, _ := breakers.LoadOrStore(key, &breaker{state: StateClosed})
+	return v.(*breaker)
+}
+
+func openDuration(setting *operation_setting.CircuitBreakerSetting) time.Duration {
+	if setting.OpenSeconds <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(setting.OpenSeconds) * time.Second
+}
+
+func halfOpenMax(setting *operation_setting.CircuitBreakerSetting) int {
+	return max(setting.HalfOpenMaxRequests, 1)
+}
+
+// allow 判断是否放行；consume 为 true 时占用半开状态的探测名额
+func (b *breaker) allow(key breakerKey, setting *operation_setting.CircuitBreakerSetting, t time.Time, consume bool) bool {
+	if b.state == StateClosed {
+		return true
+	}
+	if b.state == StateOpen {
+		if t.Before(b.openUntil) {
+			return false
+		}
+		if !consume {
+			return true
+		}
+		b.transition(key, StateHalfOpen, t)
+	}
+	// 探测请求长时间没有结果（例如未真正发出），重新开放名额
+	if t.Sub(b.halfOpenSince) > openDuration(setting) {
+		b.halfOpenSince = t
+		b.probes = 0
+		b.probeSuccesses = 0
+	}
+	if b.probes >= halfOpenMax(setting) {
+		return false
+	}
+	if consume {
+		b.probes++
+	}
+	return true
+}
+
+func (b *breaker) transition(key breakerKey, state State, t time.Time) {
+	b.state = state
+	b.updatedAt = t.UnixNano()
+	b.probes = 0
+	b.probeSuccesses = 0
+	switch state {
+	case StateOpen:
+		common.SysLog(fmt.Sprintf("circuit breaker opened: channel #%d key %d, until %s", key.channelId, key.keyIndex, b.openUntil.Format(time.RFC3339)))
+	case StateHalfOpen:
+		b.halfOpenSince = t
+	case StateClosed:
+		b.consecutiveFailures = 0
+		b.openUntil = time.Time{}
+		common.SysLog(fmt.Sprintf("circuit breaker closed: channel #%d key %d", key.channelId, key.keyIndex))
+	}
+	publish(key, redisEntry{State: state, OpenUntil: b.openUntil.Unix(), UpdatedAt: b.updatedAt})
+}
+
+// Allow 选路时判断渠道（keyIndex 为 ChannelScope）或某个密钥当前是否可用，不占用探测名额
+func Allow(channelId int, keyIndex int) bool {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	if !enabledFor(keyIndex, setting) {
+		return true
+	}
+	key := breakerKey{channelId: channelId, keyIndex: keyIndex}
+	b := lookup(key)
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.allow(key, setting, now(), false)
+}
+
+// Acquire 选中渠道 / 密钥后调用：熔断到期时转为半开并占用一个探测名额，返回是否放行
+func Acquire(channelId int, keyIndex int) bool {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	if !enabledFor(keyIndex, setting) {
+		return true
+	}
+	key := breakerKey{channelId: channelId, keyIndex: keyIndex}
+	b := lookup(key)
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.allow(key, setting, now(), true)
+}
+
+// Record 记录一次上游请求的结果
+func Record(channelId int, keyIndex int, outcome Outcome) {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	if !enabledFor(keyIndex, setting) {
+		return
+	}
+	key := breakerKey{channelId: channelId, keyIndex: keyIndex}
+	var b *breaker
+	if outcome == OutcomeFailure {
+		b = getOrCreate(key)
+	} else if b = lookup(key); b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := now()
+	switch b.state {
+	case StateClosed:
+		if outcome == OutcomeSuccess {
+			b.consecutiveFailures = 0
+		} else if outcome == OutcomeFailure {
+			b.consecutiveFailures++
+			if b.consecutiveFailures >= max(setting.FailureThreshold, 1) {
+				b.openUntil = t.Add(openDuration(setting))
+				b.transition(key, StateOpen, t)
+			}
+		}
+	case StateHalfOpen:
+		switch outcome {
+		case OutcomeSuccess:
+			b.probeSuccesses++
+			if b.probeSuccesses >= halfOpenMax(setting) {
+				b.transition(key, StateClosed, t)
+			}
+		case OutcomeFailure:
+			b.consecutiveFailures++
+			b.openUntil = t.Add(openDuration(setting))
+			b.transition(key, StateOpen, t)
+		default:
+			if b.probes > 0 {
+				b.probes--
+			}
+		}
+	default:
+		// open 状态下收到的是熔断前发出的请求结果，忽略
+	}
+}
+
+// GetStatuses 返回渠道下所有非默认状态的熔断器（渠道级 key_index 为 -1）
+func GetStatuses(channelId int) []Status {
+	if !operation_setting.GetCircuitBreakerSetting().Enabled {
+		return nil
+	}
+	t := now()
+	var statuses []Status
+	breakers.Range(func(k, v any) bool {
+		key := k.(breakerKey)
+		if key.channelId != channelId {
+			return true
+		}
+		b := v.(*breaker)
+		b.mu.Lock()
+		status := Status{
+			KeyIndex:            key.keyIndex,
+			State:               b.state,
+			ConsecutiveFailures: b.consecutiveFailures,
+		}
+		if b.state == StateOpen {
+			status.OpenUntil = b.openUntil.Unix()
+			if !t.Before(b.openUntil) {
+				// 已到期，下一次请求会转为半开
+				status.State = StateHalfOpen
+			}
+		}
+		if b.state == StateHalfOpen {
+			status.HalfOpenProbes = b.probes
+		}
+		b.mu.Unlock()
+		if status.State != StateClosed || status.ConsecutiveFailures > 0 {
+			statuses = append(statuses, status)
+		}
+		return true
+	})
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].KeyIndex < statuses[j].KeyIndex
+	})
+	return statuses
+}
+
+// Reset 清除渠道下所有熔断状态（渠道被重新启用时调用）
+func Reset(channelId int) {
+	var fields []string
+	breakers.Range(func(k, _ any) bool {
+		key := k.(breakerKey)
+		if key.channelId == channelId {
+			breakers.Delete(k)
+			fields = append(fields, key.field())
+		}
+		return true
+	})
+	if len(fields) > 0 && common.RedisEnabled {
+		gopool.Go(func() {
+			if err := common.RDB.HDel(context.Background(), redisHashKey, fields...).Err(); err != nil {
+				common.SysError("reset circuit breaker in redis failed: " + err.Error())
+			}
+		})
+	}
+}
+
+func publish(key breakerKey, entry redisEntry) {
+	if !common.RedisEnabled {
+		return
+	}
+	gopool.Go(func() {
+		ctx := context.Background()
+		var err error
+		if entry.State == StateClosed {
+			err = common.RDB.HDel(ctx, redisHashKey, key.field()).Err()
+		} else {
+			data, marshalErr := common.Marshal(entry)
+			if marshalErr != nil {
+				return
+			}
+			err = common.RDB.HSet(ctx, redisHashKey, key.field(), string(data)).Err()
+		}
+		if err != nil {
+			common.SysError("publish circuit breaker state failed: " + err.Error())
+		}
+	})
+}
+
+var syncOnce sync.Once
+
+// StartSync 启用 Redis 时定期同步其他节点写入的熔断状态
+func StartSync() {
+	if !common.RedisEnabled {
+		return
+	}
+	syncOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(syncInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				syncFromRedis()
+			}
+		}()
+	})
+}
+
+func syncFromRedis() {
+	ctx := context.Background()
+	values, err := common.RDB.HGetAll(ctx, redisHashKey).Result()
+	if err != nil {
+		common.SysError("sync circuit breaker state failed: " + err.Error())
+		return
+	}
+	t := now()
+	seen := make(map[breakerKey]bool, len(values))
+	for field, raw := range values {
+		key, ok := parseField(field)
+		if !ok {
+			continue
+		}
+		var entry redisEntry
+		if err := common.UnmarshalJsonStr(raw, &entry); err != nil {
+			continue
+		}
+		if t.Sub(time.Unix(0, entry.UpdatedAt)) > staleEntryAge {
+			common.RDB.HDel(ctx, redisHashKey, field)
+			continue
+		}
+		seen[key] = true
+		b := getOrCreate(key)
+		b.mu.Lock()
+		if entry.UpdatedAt > b.updatedAt {
+			b.state = entry.State
+			b.openUntil = time.Unix(entry.OpenUntil, 0)
+			b.halfOpenSince = t
+			b.probes = 0
+			b.probeSuccesses = 0
+			b.updatedAt = entry.UpdatedAt
+		}
+		b.mu.Unlock()
+	}
+	// Redis 中已删除的记录表示其他节点已恢复；刚在本地变化、可能尚未写入 Redis 的除外
+	threshold := t.Add(-2 * syncInterval).UnixNano()
+	breakers.Range(func(k, v any) bool {
+		if seen[k.(breakerKey)] {
+			return true
+		}
+		b := v.(*breaker)
+		b.mu.Lock()
+		if b.state != StateClosed && b.updatedAt < threshold {
+			b.state = StateClosed
+			b.consecutiveFailures = 0
+			b.openUntil = time.Time{}
+			b.probes = 0
+			b.probeSuccesses = 0
+		}
+		b.mu.Unlock()
+		return true
+	})
+}
@@ -0,0 +1,130 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/stretchr/testify/require"
+)
+
+func setupBreakerTest(t *testing.T) *time.Time {
+	t.Helper()
+	setting := operation_setting.GetCircuitBreakerSetting()
+	saved := *setting
+	savedRedis := common.RedisEnabled
+	setting.Enabled = true
+	setting.KeyLevelEnabled = true
+	setting.FailureThreshold = 3
+	setting.OpenSeconds = 60
+	setting.HalfOpenMaxRequests = 2
+	common.RedisEnabled = false
+
+	current := time.Unix(1700000000, 0)
+	now = func() time.Time { return current }
+	breakers.Range(func(k, _ any) bool {
+		breakers.Delete(k)
+		return true
+	})
+	t.Cleanup(func() {
+		*setting = saved
+		common.RedisEnabled = savedRedis
+		now = time.Now
+	})
+	return &current
+}
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	setupBreakerTest(t)
+	Record(1, ChannelScope, OutcomeFailure)
+	Record(1, ChannelScope, OutcomeFailure)
+	Record(1, ChannelScope, OutcomeSuccess)
+	Record(1, ChannelScope, OutcomeFailure)
+	Record(1, ChannelScope, OutcomeFailure)
+	require.True(t, Allow(1, ChannelScope))
+
+	Record(1, ChannelScope, OutcomeFailure)
+	require.False(t, Allow(1, ChannelScope))
+	require.False(t, Acquire(1, ChannelScope))
+	// 其他渠道不受影响
+	require.True(t, Allow(2, ChannelScope))
+
+	statuses := GetStatuses(1)
+	require.Len(t, statuses, 1)
+	require.Equal(t, StateOpen, statuses[0].State)
+}
+
+func TestBreakerHalfOpenProbing(t *testing.T) {
+	current := setupBreakerTest(t)
+	for i := 0; i < 3; i++ {
+		Record(1, ChannelScope, OutcomeFailure)
+	}
+	*current = current.Add(61 * time.Second)
+
+	require.True(t, Allow(1, ChannelScope))
+	require.True(t, Acquire(1, ChannelScope))
+	require.True(t, Acquire(1, ChannelScope))
+	// 探测名额用完
+	require.False(t, Allow(1, ChannelScope))
+	require.False(t, Acquire(1, ChannelScope))
+
+	// 无关的错误归还名额
+	Record(1, ChannelScope, OutcomeIgnored)
+	require.True(t, Acquire(1, ChannelScope))
+
+	Record(1, ChannelScope, OutcomeSuccess)
+	require.Equal(t, StateHalfOpen, GetStatuses(1)[0].State)
+	Record(1, ChannelScope, OutcomeSuccess)
+	require.Empty(t, GetStatuses(1))
+	require.True(t, Allow(1, ChannelScope))
+}
+
+func TestBreakerHalfOpenFailureReopens(t *testing.T) {
+	current := setupBreakerTest(t)
+	for i := 0; i < 3; i++ {
+		Record(1, ChannelScope, OutcomeFailure)
+	}
+	*current = current.Add(61 * time.Second)
+	require.True(t, Acquire(1, ChannelScope))
+	Record(1, ChannelScope, OutcomeFailure)
+	require.False(t, Allow(1, ChannelScope))
+
+	statuses := GetStatuses(1)
+	require.Equal(t, StateOpen, statuses[0].State)
+	require.Equal(t, current.Add(60*time.Second).Unix(), statuses[0].OpenUntil)
+}
+
+func TestBreakerKeyLevel(t *testing.T) {
+	setupBreakerTest(t)
+	for i := 0; i < 3; i++ {
+		Record(1, 2, OutcomeFailure)
+	}
+	require.False(t, Allow(1, 2))
+	require.True(t, Allow(1, 0))
+	require.True(t, Allow(1, ChannelScope))
+
+	operation_setting.GetCircuitBreakerSetting().KeyLevelEnabled = false
+	require.True(t, Allow(1, 2))
+}
+
+func TestBreakerReset(t *testing.T) {
+	setupBreakerTest(t)
+	for i := 0; i < 3; i++ {
+		Record(1, ChannelScope, OutcomeFailure)
+		Record(1, 0, OutcomeFailure)
+	}
+	Reset(1)
+	require.True(t, Allow(1, ChannelScope))
+	require.True(t, Allow(1, 0))
+	require.Empty(t, GetStatuses(1))
+}
+
+func TestParseField(t *testing.T) {
+	key, ok := parseField(breakerKey{channelId: 12, keyIndex: ChannelScope}.field())
+	require.True(t, ok)
+	require.Equal(t, breakerKey{channelId: 12, keyIndex: -1}, key)
+
+	_, ok = parseField("bad")
+	require.False(t, ok)
+}
@@ -0,0 +1,205 @@
+// Package cooldown 上游限流（429 + Retry-After / x-ratelimit-reset-*）后的渠道级与多密钥级冷却：
+// 冷却截止前选路时跳过，到期自动恢复，无需管理员操作。
+// 截止时间写入 Redis hash，各节点定期同步，因此一个节点收到的限流会在数秒内对所有节点生效。
+package cooldown
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// ChannelScope 渠道级冷却使用的 key index
+const ChannelScope = -1
+
+const (
+	redisHashKey = "new-api:channel_cooldown"
+	syncInterval = 2 * time.Second
+)
+
+type cooldownKey struct {
+	channelId int
+	keyIndex  int
+}
+
+func (k cooldownKey) field() string {
+	return fmt.Sprintf("%d:%d", k.channelId, k.keyIndex)
+}
+
+func parseField(field string) (cooldownKey, bool) {
+	parts := strings.SplitN(field, ":", 2)
+	if len(parts) != 2 {
+		return cooldownKey{}, false
+	}
+	channelId, err1 := strconv.Atoi(parts[0])
+	keyIndex, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return cooldownKey{}, false
+	}
+	return cooldownKey{channelId: channelId, keyIndex: keyIndex}, true
+}
+
+type entry struct {
+	Until     int64 `json:"until"`      // unix milli
+	UpdatedAt int64 `json:"updated_at"` // unix nano，用于多节点同步时比较新旧
+}
+
+// Status 渠道接口展示的冷却状态
+type Status struct {
+	KeyIndex int   `json:"key_index"`
+	Until    int64 `json:"until"` // unix 秒
+}
+
+var (
+	entriesLock sync.RWMutex
+	entries     = make(map[cooldownKey]entry)
+)
+
+// now 便于测试替换
+var now = time.Now
+
+// Set 让渠道（keyIndex 为 ChannelScope）或某个密钥冷却到 until，已有更晚的截止时间时保持不变
+func Set(channelId int, keyIndex int, until time.Time) {
+	key := cooldownKey{channelId: channelId, keyIndex: keyIndex}
+	t := now()
+	entriesLock.Lock()
+	current, ok := entries[key]
+	if ok && current.Until >= until.UnixMilli() {
+		entriesLock.Unlock()
+		return
+	}
+	e := entry{Until: until.UnixMilli(), UpdatedAt: t.UnixNano()}
+	entries[key] = e
+	entriesLock.Unlock()
+
+	common.SysLog(fmt.Sprintf("channel #%d key %d cooling down until %s", channelId, keyIndex, until.Format(time.RFC3339)))
+	publish(key, e)
+}
+
+// Active 判断渠道或密钥当前是否处于冷却中
+func Active(channelId int, keyIndex int) bool {
+	entriesLock.RLock()
+	e, ok := entries[cooldownKey{channelId: channelId, keyIndex: keyIndex}]
+	entriesLock.RUnlock()
+	return ok && now().UnixMilli() < e.Until
+}
+
+// GetStatuses 返回渠道下仍在冷却中的渠道级（key_index 为 -1）与密钥级状态
+func GetStatuses(channelId int) []Status {
+	t := now().UnixMilli()
+	var statuses []Status
+	entriesLock.RLock()
+	for key, e := range entries {
+		if key.channelId == channelId && t < e.Until {
+			statuses = append(statuses, Status{KeyIndex: key.keyIndex, Until: e.Until / 1000})
+		}
+	}
+	entriesLock.RUnlock()
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].KeyIndex < statuses[j].KeyIndex
+	})
+	return statuses
+}
+
+// Reset 清除渠道下所有冷却状态（渠道被重新启用时调用）
+func Reset(channelId int) {
+	var fields []string
+	entriesLock.Lock()
+	for key := range entries {
+		if key.channelId == channelId {
+			delete(entries, key)
+			fields = append(fields, key.field())
+		}
+	}
+	entriesLock.Unlock()
+	if len(fields) > 0 && common.RedisEnabled {
+		gopool.Go(func() {
+			if err := common.RDB.HDel(context.Background(), redisHashKey, fields...).Err(); err != nil {
+				common.SysError("reset channel cooldown in redis failed: " + err.Error())
+			}
+		})
+	}
+}
+
+func publish(key cooldownKey, e entry) {
+	if !common.RedisEnabled {
+		return
+	}
+	gopool.Go(func() {
+		data, err := common.Marshal(e)
+		if err != nil {
+			return
+		}
+		if err := common.RDB.HSet(context.Background(), redisHashKey, key.field(), string(data)).Err(); err != nil {
+			common.SysError("publish channel cooldown failed: " + err.Error())
+		}
+	})
+}
+
+var syncOnce sync.Once
+
+// StartSync 启用 Redis 时定期同步其他节点写入的冷却状态
+func StartSync() {
+	if !common.RedisEnabled {
+		return
+	}
+	syncOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(syncInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				syncFromRedis()
+			}
+		}()
+	})
+}
+
+func syncFromRedis() {
+	ctx := context.Background()
+	values, err := common.RDB.HGetAll(ctx, redisHashKey).Result()
+	if err != nil {
+		common.SysError("sync channel cooldown failed: " + err.Error())
+		return
+	}
+	t := now()
+	seen := make(map[cooldownKey]bool, len(values))
+	var expired []string
+	entriesLock.Lock()
+	for field, raw := range values {
+		key, ok := parseField(field)
+		if !ok {
+			continue
+		}
+		var e entry
+		if err := common.UnmarshalJsonStr(raw, &e); err != nil {
+			continue
+		}
+		if e.Until <= t.UnixMilli() {
+			expired = append(expired, field)
+			continue
+		}
+		seen[key] = true
+		if current, ok := entries[key]; !ok || e.UpdatedAt > current.UpdatedAt {
+			entries[key] = e
+		}
+	}
+	// Redis 中已删除的记录表示其他节点已重置；刚在本地设置、可能尚未写入 Redis 的除外
+	threshold := t.Add(-2 * syncInterval).UnixNano()
+	for key, e := range entries {
+		if e.Until <= t.UnixMilli() || (!seen[key] && e.UpdatedAt < threshold) {
+			delete(entries, key)
+		}
+	}
+	entriesLock.Unlock()
+	if len(expired) > 0 {
+		common.RDB.HDel(ctx, redisHashKey, expired...)
+	}
+}
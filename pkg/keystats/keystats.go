@@ -0,0 +1,267 @@
+// Package keystats 多密钥渠道按密钥统计在途请求、最近一分钟的请求数 / token 数、错误与 429，
+// 供限流感知的多密钥调度挑选余量最大的密钥，并在渠道接口中展示各密钥的饱和情况。
+// 统计仅保存在本进程内存中。
+package keystats
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	windowSeconds = 60
+	// 最近返回过 429 的密钥在该时长内尽量不再选择
+	rateLimitedAvoidance = 60 * time.Second
+)
+
+type statsKey struct {
+	channelId int
+	keyIndex  int
+}
+
+type stats struct {
+	inflight atomic.Int64
+
+	mu              sync.Mutex
+	requests        int64
+	tokens          int64
+	errors          int64
+	rateLimited     int64
+	lastUsed        time.Time
+	lastRateLimited time.Time
+	// 按秒分桶的滑动窗口，bucketTime 记录桶对应的 unix 秒，过期的桶视为 0
+	bucketTime     [windowSeconds]int64
+	bucketRequests [windowSeconds]int64
+	bucketTokens   [windowSeconds]int64
+}
+
+// Status 渠道接口展示的单个密钥统计
+type Status struct {
+	KeyIndex        int   `json:"key_index"`
+	Inflight        int64 `json:"inflight"`
+	Requests        int64 `json:"requests"`
+	Tokens          int64 `json:"tokens"`
+	Errors          int64 `json:"errors"`
+	RateLimited     int64 `json:"rate_limited"`
+	RPM             int64 `json:"rpm"`
+	TPM             int64 `json:"tpm"`
+	LastUsed        int64 `json:"last_used,omitempty"`         // unix 秒
+	LastRateLimited int64 `json:"last_rate_limited,omitempty"` // unix 秒
+}
+
+var store sync.Map // statsKey -> *stats
+
+// now 便于测试替换
+var now = time.Now
+
+func getStats(channelId int, keyIndex int) *stats {
+	key := statsKey{channelId: channelId, keyIndex: keyIndex}
+	if v, ok := store.Load(key); ok {
+		return v.(*stats)
+	}
+	v, _ := store.LoadOrStore(key, &stats{})
+	return v.(*stats)
+}
+
+func lookupStats(channelId int, keyIndex int) *stats {
+	if v, ok := store.Load(statsKey{channelId: channelId, keyIndex: keyIndex}); ok {
+		return v.(*stats)
+	}
+	return nil
+}
+
+// bucket 返回 t 所在秒的桶下标，桶已过期时先清零；调用方需持有 s.mu
+func (s *stats) bucket(t time.Time) int {
+	sec := t.Unix()
+	idx := int(sec % windowSeconds)
+	if s.bucketTime[idx] != sec {
+		s.bucketTime[idx] = sec
+		s.bucketRequests[idx] = 0
+		s.bucketTokens[idx] = 0
+	}
+	return idx
+}
+
+// window 返回最近一分钟的请求数与 token 数；调用方需持有 s.mu
+func (s *stats) window(t time.Time) (requests int64, tokens int64) {
+	sec := t.Unix()
+	for i := 0; i < windowSeconds; i++ {
+		if sec-s.bucketTime[i] < windowSeconds {
+			requests += s.bucketRequests[i]
+			tokens += s.bucketTokens[i]
+		}
+	}
+	return requests, tokens
+}
+
+// Begin 在使用密钥向上游发起请求前调用，返回的 done 必须在请求结束后调用一次。
+// statusCode 为本次请求的结果状态码（成功为 200），0 表示没有实际请求上游，只释放在途计数
+func Begin(channelId int, keyIndex int) func(statusCode int) {
+	s := getStats(channelId, keyIndex)
+	s.inflight.Add(1)
+	s.mu.Lock()
+	s.lastUsed = now()
+	s.mu.Unlock()
+
+	var once sync.Once
+	return func(statusCode int) {
+		once.Do(func() {
+			s.inflight.Add(-1)
+			if statusCode == 0 {
+				return
+			}
+			t := now()
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			// 在途请求已单独计入余量，结束后才计入窗口，避免重复计算
+			s.requests++
+			s.bucketRequests[s.bucket(t)]++
+			if statusCode >= 200 && statusCode < 300 {
+				return
+			}
+			s.errors++
+			if statusCode == 429 {
+				s.rateLimited++
+				s.lastRateLimited = t
+			}
+		})
+	}
+}
+
+// AddTokens 记录密钥实际消耗的 token 数（输入 + 输出）
+func AddTokens(channelId int, keyIndex int, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	s := getStats(channelId, keyIndex)
+	s.mu.Lock()
+	s.tokens += int64(tokens)
+	s.bucketTokens[s.bucket(now())] += int64(tokens)
+	s.mu.Unlock()
+}
+
+type candidate struct {
+	keyIndex    int
+	headroom    float64
+	inflight    int64
+	requests    int64
+	lastUsed    time.Time
+	rateLimited bool
+}
+
+// headroom 返回密钥剩余额度比例，未配置上限时为 1
+func headroom(requests int64, tokens int64, inflight int64, rpmLimit int, tpmLimit int) float64 {
+	h := 1.0
+	if rpmLimit > 0 {
+		h = min(h, 1-float64(requests+inflight)/float64(rpmLimit))
+	}
+	if tpmLimit > 0 {
+		h = min(h, 1-float64(tokens)/float64(tpmLimit))
+	}
+	return h
+}
+
+// Pick 从 keyIndexes 中挑选余量最大的密钥：跳过最近返回过 429 的密钥（全部都是时不跳过），
+// 余量相同时依次比较在途请求数、最近一分钟请求数与最后使用时间。
+// rpmLimit / tpmLimit 为每个密钥的上限，0 表示未知，只按负载比较
+func Pick(channelId int, keyIndexes []int, rpmLimit int, tpmLimit int) int {
+	t := now()
+	candidates := make([]candidate, 0, len(keyIndexes))
+	for _, idx := range keyIndexes {
+		c := candidate{keyIndex: idx, headroom: 1}
+		if s := lookupStats(channelId, idx); s != nil {
+			c.inflight = s.inflight.Load()
+			s.mu.Lock()
+			requests, tokens := s.window(t)
+			c.lastUsed = s.lastUsed
+			c.rateLimited = !s.lastRateLimited.IsZero() && t.Sub(s.lastRateLimited) < rateLimitedAvoidance
+			s.mu.Unlock()
+			c.requests = requests
+			c.headroom = headroom(requests, tokens, c.inflight, rpmLimit, tpmLimit)
+		}
+		candidates = append(candidates, c)
+	}
+	return pickCandidate(candidates)
+}
+
+func pickCandidate(candidates []candidate) int {
+	if len(candidates) == 0 {
+		return 0
+	}
+	allRateLimited := true
+	for _, c := range candidates {
+		if !c.rateLimited {
+			allRateLimited = false
+			break
+		}
+	}
+	var best *candidate
+	for i := range candidates {
+		c := &candidates[i]
+		if c.rateLimited && !allRateLimited {
+			continue
+		}
+		if best == nil || better(c, best) {
+			best = c
+		}
+	}
+	return best.keyIndex
+}
+
+func better(a *candidate, b *candidate) bool {
+	if a.headroom != b.headroom {
+		return a.headroom > b.headroom
+	}
+	if a.inflight != b.inflight {
+		return a.inflight < b.inflight
+	}
+	if a.requests != b.requests {
+		return a.requests < b.requests
+	}
+	return a.lastUsed.Before(b.lastUsed)
+}
+
+// GetStatuses 返回渠道下有统计的密钥状态，按 key index 排序
+func GetStatuses(channelId int) []Status {
+	t := now()
+	var statuses []Status
+	store.Range(func(k, v any) bool {
+		key := k.(statsKey)
+		if key.channelId != channelId {
+			return true
+		}
+		s := v.(*stats)
+		status := Status{KeyIndex: key.keyIndex, Inflight: s.inflight.Load()}
+		s.mu.Lock()
+		status.Requests = s.requests
+		status.Tokens = s.tokens
+		status.Errors = s.errors
+		status.RateLimited = s.rateLimited
+		status.RPM, status.TPM = s.window(t)
+		if !s.lastUsed.IsZero() {
+			status.LastUsed = s.lastUsed.Unix()
+		}
+		if !s.lastRateLimited.IsZero() {
+			status.LastRateLimited = s.lastRateLimited.Unix()
+		}
+		s.mu.Unlock()
+		statuses = append(statuses, status)
+		return true
+	})
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].KeyIndex < statuses[j].KeyIndex
+	})
+	return statuses
+}
+
+// Reset 清除渠道下所有密钥的统计（密钥列表变化时调用，避免下标错位）
+func Reset(channelId int) {
+	store.Range(func(k, _ any) bool {
+		if k.(statsKey).channelId == channelId {
+			store.Delete(k)
+		}
+		return true
+	})
+}
@@ -0,0 +1,83 @@
+package keystats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func setupKeyStatsTest(t *testing.T) *time.Time {
+	t.Helper()
+	current := time.Unix(1700000000, 0)
+	now = func() time.Time { return current }
+	store.Range(func(k, _ any) bool {
+		store.Delete(k)
+		return true
+	})
+	t.Cleanup(func() {
+		now = time.Now
+	})
+	return &current
+}
+
+func TestPickPrefersLeastLoadedKey(t *testing.T) {
+	current := setupKeyStatsTest(t)
+
+	// key 0 有一个在途请求，key 1 最近一分钟完成过请求，key 2 没有流量
+	done := Begin(1, 0)
+	Begin(1, 1)(200)
+	require.Equal(t, 2, Pick(1, []int{0, 1, 2}, 0, 0))
+
+	*current = current.Add(time.Second)
+	Begin(1, 2)(200)
+	Begin(1, 2)(200)
+	require.Equal(t, 1, Pick(1, []int{0, 1, 2}, 0, 0))
+
+	done(200)
+	// 窗口过期后选择最久未使用的密钥
+	*current = current.Add(2 * time.Minute)
+	Begin(1, 0)(200)
+	require.Equal(t, 1, Pick(1, []int{0, 1, 2}, 0, 0))
+}
+
+func TestPickUsesConfiguredLimits(t *testing.T) {
+	setupKeyStatsTest(t)
+
+	Begin(2, 0)(200)
+	AddTokens(2, 0, 100)
+	Begin(2, 1)(200)
+	Begin(2, 1)(200)
+	AddTokens(2, 1, 900)
+
+	// 只有 RPM 上限时 key 0 余量更大
+	require.Equal(t, 0, Pick(2, []int{0, 1}, 10, 0))
+	// TPM 上限下 key 1 已用 90%
+	require.Equal(t, 0, Pick(2, []int{0, 1}, 0, 1000))
+	require.InDelta(t, 0.9, headroom(1, 100, 0, 10, 1000), 1e-9)
+}
+
+func TestPickAvoidsRecentlyRateLimitedKey(t *testing.T) {
+	current := setupKeyStatsTest(t)
+
+	Begin(3, 0)(429)
+	require.Equal(t, 1, Pick(3, []int{0, 1}, 0, 0))
+
+	// 全部密钥都被限流时仍然返回一个
+	Begin(3, 1)(429)
+	require.Contains(t, []int{0, 1}, Pick(3, []int{0, 1}, 0, 0))
+
+	*current = current.Add(rateLimitedAvoidance + time.Second)
+	Begin(3, 1)(200)
+	require.Equal(t, 0, Pick(3, []int{0, 1}, 0, 0))
+
+	statuses := GetStatuses(3)
+	require.Len(t, statuses, 2)
+	require.Equal(t, int64(1), statuses[0].RateLimited)
+	require.Equal(t, int64(1), statuses[0].Errors)
+	require.Equal(t, int64(2), statuses[1].Requests)
+	require.Equal(t, int64(1), statuses[1].RPM)
+
+	Reset(3)
+	require.Empty(t, GetStatuses(3))
+}
@@ -0,0 +1,257 @@
+// Package metrics exposes Prometheus collectors for relay traffic, channels and billing.
+//
+// The package deliberately has no dependency on model/service so that every layer
+// (controller, relay, model) can feed it without introducing import cycles.
+// Channel gauges are computed lazily at scrape time through a provider registered
+// from main (see SetChannelStatsProvider).
+package metrics
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "newapi"
+
+// 延迟分桶：覆盖从几十毫秒的首字到数分钟的长输出
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 4, 8, 15, 30, 60, 120, 300}
+
+var (
+	relayRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "relay_requests_total",
+		Help:      "Total relay requests by final outcome.",
+	}, []string{"relay_format", "model", "group", "status_code"})
+
+	relayAttemptsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "relay_upstream_attempts_total",
+		Help:      "Upstream attempts per channel, including retries.",
+	}, []string{"channel_id", "channel_type", "model", "outcome"})
+
+	relayRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "relay_retries_total",
+		Help:      "Retries performed after a failed upstream attempt.",
+	}, []string{"model", "group"})
+
+	relayModelFallbacksTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "relay_model_fallbacks_total",
+		Help:      "Model fallback hops taken after every channel of a model failed or none was available.",
+	}, []string{"from_model", "to_model", "group"})
+
+	relayDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "relay_request_duration_seconds",
+		Help:      "Total relay latency from request start to response completion.",
+		Buckets:   latencyBuckets,
+	}, []string{"relay_format", "model", "group", "is_stream"})
+
+	relayFirstToken = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "relay_first_token_seconds",
+		Help:      "Latency until the first upstream response chunk was received.",
+		Buckets:   latencyBuckets,
+	}, []string{"channel_id", "model", "is_stream"})
+
+	channelAutoDisabledTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "channel_auto_disabled_total",
+		Help:      "Channels (or multi-key entries) automatically disabled due to upstream errors.",
+	}, []string{"channel_id", "channel_type"})
+
+	quotaConsumedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "quota_consumed_total",
+		Help:      "Quota consumed by successful relay requests.",
+	}, []string{"channel_id", "model", "group"})
+
+	tokensConsumedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_consumed_total",
+		Help:      "Prompt and completion tokens billed by successful relay requests.",
+	}, []string{"channel_id", "model", "type"})
+
+	// 按令牌拆分的指标单独成组，不与渠道维度交叉，避免序列数按渠道数成倍增长
+	tokenRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_requests_total",
+		Help:      "Total relay requests per API token by final outcome.",
+	}, []string{"token_id", "model", "status_code"})
+
+	tokenQuotaConsumedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_quota_consumed_total",
+		Help:      "Quota consumed per API token.",
+	}, []string{"token_id", "model"})
+
+	tokenTokensConsumedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_tokens_consumed_total",
+		Help:      "Prompt and completion tokens billed per API token.",
+	}, []string{"token_id", "model", "type"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		relayRequestsTotal,
+		relayAttemptsTotal,
+		relayRetriesTotal,
+		relayModelFallbacksTotal,
+		relayDuration,
+		relayFirstToken,
+		channelAutoDisabledTotal,
+		quotaConsumedTotal,
+		tokensConsumedTotal,
+		tokenRequestsTotal,
+		tokenQuotaConsumedTotal,
+		tokenTokensConsumedTotal,
+		channelCollector,
+	)
+}
+
+// ObserveRelayRequest 记录一次完整的 relay 请求（含所有重试），tokenId 为 0 时不记录令牌维度
+func ObserveRelayRequest(relayFormat, model, group string, tokenId int, statusCode int, isStream bool, duration time.Duration) {
+	status := strconv.Itoa(statusCode)
+	relayRequestsTotal.WithLabelValues(relayFormat, model, group, status).Inc()
+	relayDuration.WithLabelValues(relayFormat, model, group, strconv.FormatBool(isStream)).Observe(duration.Seconds())
+	if tokenId > 0 {
+		tokenRequestsTotal.WithLabelValues(strconv.Itoa(tokenId), model, status).Inc()
+	}
+}
+
+// ObserveUpstreamAttempt 记录单次上游尝试的结果，outcome 为 success / error
+func ObserveUpstreamAttempt(channelId, channelType int, model string, success bool) {
+	outcome := "success"
+	if !success {
+		outcome = "error"
+	}
+	relayAttemptsTotal.WithLabelValues(strconv.Itoa(channelId), strconv.Itoa(channelType), model, outcome).Inc()
+}
+
+// ObserveFirstToken 记录首字延迟
+func ObserveFirstToken(channelId int, model string, isStream bool, latency time.Duration) {
+	if latency <= 0 {
+		return
+	}
+	relayFirstToken.WithLabelValues(strconv.Itoa(channelId), model, strconv.FormatBool(isStream)).Observe(latency.Seconds())
+}
+
+func IncRetry(model, group string) {
+	relayRetriesTotal.WithLabelValues(model, group).Inc()
+}
+
+// IncModelFallback 记录一次模型降级，与渠道重试分开统计
+func IncModelFallback(fromModel, toModel, group string) {
+	relayModelFallbacksTotal.WithLabelValues(fromModel, toModel, group).Inc()
+}
+
+func IncChannelAutoDisabled(channelId, channelType int) {
+	channelAutoDisabledTotal.WithLabelValues(strconv.Itoa(channelId), strconv.Itoa(channelType)).Inc()
+}
+
+// AddQuotaConsumed 记录消费额度与 token 数，tokenId 为 0 时不记录令牌维度
+func AddQuotaConsumed(channelId int, tokenId int, model, group string, quota, promptTokens, completionTokens int) {
+	channel := strconv.Itoa(channelId)
+	token := strconv.Itoa(tokenId)
+	if quota > 0 {
+		quotaConsumedTotal.WithLabelValues(channel, model, group).Add(float64(quota))
+		if tokenId > 0 {
+			tokenQuotaConsumedTotal.WithLabelValues(token, model).Add(float64(quota))
+		}
+	}
+	if promptTokens > 0 {
+		tokensConsumedTotal.WithLabelValues(channel, model, "prompt").Add(float64(promptTokens))
+		if tokenId > 0 {
+			tokenTokensConsumedTotal.WithLabelValues(token, model, "prompt").Add(float64(promptTokens))
+		}
+	}
+	if completionTokens > 0 {
+		tokensConsumedTotal.WithLabelValues(channel, model, "completion").Add(float64(completionTokens))
+		if tokenId > 0 {
+			tokenTokensConsumedTotal.WithLabelValues(token, model, "completion").Add(float64(completionTokens))
+		}
+	}
+}
+
+// ChannelStat 渠道在抓取时刻的状态快照
+type ChannelStat struct {
+	Id          int
+	Type        int
+	Enabled     bool
+	IsMultiKey  bool
+	KeyEnabled  int
+	KeyDisabled int
+}
+
+var (
+	channelStatsProviderMu sync.RWMutex
+	channelStatsProvider   func() []ChannelStat
+)
+
+// SetChannelStatsProvider 注册渠道快照来源（由 main 注入，避免 metrics -> model 的依赖）
+func SetChannelStatsProvider(provider func() []ChannelStat) {
+	channelStatsProviderMu.Lock()
+	channelStatsProvider = provider
+	channelStatsProviderMu.Unlock()
+}
+
+var channelCollector = &channelStatsCollector{
+	channelsDesc: prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "channels"),
+		"Number of channels by type and status.",
+		[]string{"channel_type", "status"}, nil,
+	),
+	multiKeyDesc: prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "channel_multi_keys"),
+		"Number of keys of a multi-key channel by key status.",
+		[]string{"channel_id", "channel_type", "status"}, nil,
+	),
+}
+
+type channelStatsCollector struct {
+	channelsDesc *prometheus.Desc
+	multiKeyDesc *prometheus.Desc
+}
+
+func (c *channelStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.channelsDesc
+	ch <- c.multiKeyDesc
+}
+
+func (c *channelStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	channelStatsProviderMu.RLock()
+	provider := channelStatsProvider
+	channelStatsProviderMu.RUnlock()
+	if provider == nil {
+		return
+	}
+
+	type typeStatus struct {
+		channelType int
+		status      string
+	}
+	counts := make(map[typeStatus]int)
+	for _, stat := range provider() {
+		status := "disabled"
+		if stat.Enabled {
+			status = "enabled"
+		}
+		counts[typeStatus{stat.Type, status}]++
+
+		if !stat.IsMultiKey {
+			continue
+		}
+		channelId := strconv.Itoa(stat.Id)
+		channelType := strconv.Itoa(stat.Type)
+		ch <- prometheus.MustNewConstMetric(c.multiKeyDesc, prometheus.GaugeValue, float64(stat.KeyEnabled), channelId, channelType, "enabled")
+		ch <- prometheus.MustNewConstMetric(c.multiKeyDesc, prometheus.GaugeValue, float64(stat.KeyDisabled), channelId, channelType, "disabled")
+	}
+	for k, v := range counts {
+		ch <- prometheus.MustNewConstMetric(c.channelsDesc, prometheus.GaugeValue, float64(v), strconv.Itoa(k.channelType), k.status)
+	}
+}
@@ -0,0 +1,236 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+const (
+	exportQueueSize    = 4096
+	exportBatchSize    = 256
+	exportInterval     = 5 * time.Second
+	exportTimeout      = 10 * time.Second
+	defaultServiceName = "new-api"
+)
+
+type otlpExporter struct {
+	endpoint    string
+	headers     map[string]string
+	serviceName string
+	client      *http.Client
+	queue       chan *Span
+}
+
+var exporter *otlpExporter
+var sampleRatio = 1.0
+
+// Init 按照 OpenTelemetry 标准环境变量初始化 OTLP/HTTP 导出器：
+//
+//	OTEL_EXPORTER_OTLP_TRACES_ENDPOINT / OTEL_EXPORTER_OTLP_ENDPOINT 导出端点（未配置则不启用）
+//	OTEL_EXPORTER_OTLP_HEADERS          额外请求头，格式 k1=v1,k2=v2
+//	OTEL_SERVICE_NAME                   服务名，默认 new-api
+//	OTEL_TRACES_SAMPLER_ARG             采样率 0~1，默认 1（仅对无上游 traceparent 的请求生效）
+func Init() {
+	if strings.EqualFold(os.Getenv("OTEL_TRACES_EXPORTER"), "none") || strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return
+	}
+	endpoint := strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"))
+	if endpoint == "" {
+		base := strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"))
+		if base == "" {
+			return
+		}
+		endpoint = strings.TrimRight(base, "/") + "/v1/traces"
+	}
+	if ratio, err := strconv.ParseFloat(os.Getenv("OTEL_TRACES_SAMPLER_ARG"), 64); err == nil && ratio >= 0 && ratio <= 1 {
+		sampleRatio = ratio
+	}
+	serviceName := common.GetEnvOrDefaultString("OTEL_SERVICE_NAME", defaultServiceName)
+
+	exporter = &otlpExporter{
+		endpoint:    endpoint,
+		headers:     parseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")),
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: exportTimeout},
+		queue:       make(chan *Span, exportQueueSize),
+	}
+	go exporter.run()
+	common.SysLog("tracing enabled, exporting spans to " + endpoint)
+}
+
+func shouldSample() bool {
+	if sampleRatio >= 1 {
+		return true
+	}
+	if sampleRatio <= 0 {
+		return false
+	}
+	return rand.Float64() < sampleRatio
+}
+
+func parseHeaders(raw string) map[string]string {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		headers[k] = strings.TrimSpace(v)
+	}
+	return headers
+}
+
+func (e *otlpExporter) enqueue(span *Span) {
+	select {
+	case e.queue <- span:
+	default:
+		// 队列已满时丢弃，避免阻塞请求链路
+	}
+}
+
+func (e *otlpExporter) run() {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, exportBatchSize)
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= exportBatchSize {
+				e.export(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				e.export(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+func (e *otlpExporter) export(spans []*Span) {
+	payload, err := common.Marshal(e.buildPayload(spans))
+	if err != nil {
+		common.SysError("tracing: failed to marshal spans: " + err.Error())
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		common.SysError("tracing: failed to build export request: " + err.Error())
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		common.SysError("tracing: failed to export spans: " + err.Error())
+		return
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		common.SysError("tracing: collector responded with status " + strconv.Itoa(resp.StatusCode))
+	}
+}
+
+// OTLP JSON 编码（https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding）
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceId           string         `json:"traceId"`
+	SpanId            string         `json:"spanId"`
+	ParentSpanId      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+func (e *otlpExporter) buildPayload(spans []*Span) map[string]any {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		item := otlpSpan{
+			TraceId:           hex.EncodeToString(s.traceID[:]),
+			SpanId:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              int(s.kind),
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        toOtlpAttributes(s.attrs),
+			Status:            otlpStatus{Code: s.statusCode, Message: s.statusMessage},
+		}
+		if s.parentID != ([8]byte{}) {
+			item.ParentSpanId = hex.EncodeToString(s.parentID[:])
+		}
+		s.mu.Unlock()
+		otlpSpans = append(otlpSpans, item)
+	}
+	return map[string]any{
+		"resourceSpans": []any{
+			map[string]any{
+				"resource": map[string]any{
+					"attributes": toOtlpAttributes([]Attribute{
+						String("service.name", e.serviceName),
+						String("service.version", common.Version),
+					}),
+				},
+				"scopeSpans": []any{
+					map[string]any{
+						"scope": map[string]any{"name": "github.com/QuantumNous/new-api"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
+
+func toOtlpAttributes(attrs []Attribute) []otlpKeyValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+	result := make([]otlpKeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		var value map[string]any
+		switch v := attr.Value.(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		default:
+			continue
+		}
+		result = append(result, otlpKeyValue{Key: attr.Key, Value: value})
+	}
+	return result
+}
@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const traceParentVersion = "00"
+
+// ParseTraceParent 解析 W3C traceparent 头：version-traceid-parentid-flags
+func ParseTraceParent(header string) (traceID [16]byte, spanID [8]byte, sampled bool, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 {
+		return
+	}
+	version, traceHex, spanHex, flagsHex := parts[0], parts[1], parts[2], parts[3]
+	// 版本 ff 无效；00 版本必须恰好 4 段，未来版本允许追加字段
+	if len(version) != 2 || version == "ff" || (version == traceParentVersion && len(parts) != 4) {
+		return
+	}
+	if len(traceHex) != 32 || len(spanHex) != 16 || len(flagsHex) != 2 {
+		return
+	}
+	if _, err := hex.Decode(traceID[:], []byte(traceHex)); err != nil {
+		return
+	}
+	if _, err := hex.Decode(spanID[:], []byte(spanHex)); err != nil {
+		return
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(flagsHex)); err != nil {
+		return
+	}
+	if traceID == ([16]byte{}) || spanID == ([8]byte{}) {
+		return
+	}
+	return traceID, spanID, flags[0]&0x01 == 0x01, true
+}
+
+func FormatTraceParent(traceID [16]byte, spanID [8]byte, sampled bool) string {
+	flags := "00"
+	if sampled {
+		flags = "01"
+	}
+	return traceParentVersion + "-" + hex.EncodeToString(traceID[:]) + "-" + hex.EncodeToString(spanID[:]) + "-" + flags
+}
+
+// InjectTraceParent 将 span 上下文写入上游请求头
+func InjectTraceParent(span *Span, header http.Header) {
+	if span == nil || header == nil {
+		return
+	}
+	header.Set("traceparent", span.TraceParent())
+}
@@ -0,0 +1,237 @@
+// Package tracing implements lightweight request tracing with W3C Trace Context
+// propagation and an OTLP/HTTP (JSON) exporter.
+//
+// Spans are bound to the gin.Context of a request: StartSpan creates a child of the
+// currently active span and makes it active, End restores the parent. All methods are
+// nil-safe so call sites do not need to check whether tracing is enabled.
+package tracing
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SpanKind int
+
+// 与 OTLP SpanKind 取值保持一致
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+const (
+	statusUnset = 0
+	statusOk    = 1
+	statusError = 2
+)
+
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: int64(value)}
+}
+
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+type Span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	sampled  bool
+
+	name  string
+	kind  SpanKind
+	start time.Time
+
+	mu            sync.Mutex
+	end           time.Time
+	ended         bool
+	attrs         []Attribute
+	statusCode    int
+	statusMessage string
+
+	c      *gin.Context
+	parent *Span
+}
+
+// Enabled 是否启用了链路追踪（配置了 OTLP 导出端点）
+func Enabled() bool {
+	return exporter != nil
+}
+
+// StartServerSpan 创建请求的根 span，若请求头携带合法的 traceparent 则延续上游链路
+func StartServerSpan(c *gin.Context, name string, attrs ...Attribute) *Span {
+	if !Enabled() || c == nil {
+		return nil
+	}
+	span := &Span{
+		name:  name,
+		kind:  SpanKindServer,
+		start: time.Now(),
+		attrs: attrs,
+		c:     c,
+	}
+	if c.Request != nil {
+		if traceID, parentID, sampled, ok := ParseTraceParent(c.Request.Header.Get("traceparent")); ok {
+			span.traceID = traceID
+			span.parentID = parentID
+			span.sampled = sampled
+		}
+	}
+	if span.traceID == ([16]byte{}) {
+		_, _ = rand.Read(span.traceID[:])
+		span.sampled = shouldSample()
+	}
+	_, _ = rand.Read(span.spanID[:])
+	common.SetContextKey(c, constant.ContextKeyTraceSpan, span)
+	return span
+}
+
+// StartSpan 在当前活跃 span 下创建子 span；没有根 span 时返回 nil
+func StartSpan(c *gin.Context, name string, attrs ...Attribute) *Span {
+	return startChild(c, name, SpanKindInternal, attrs)
+}
+
+// StartClientSpan 创建表示上游调用的子 span
+func StartClientSpan(c *gin.Context, name string, attrs ...Attribute) *Span {
+	return startChild(c, name, SpanKindClient, attrs)
+}
+
+func startChild(c *gin.Context, name string, kind SpanKind, attrs []Attribute) *Span {
+	parent := CurrentSpan(c)
+	if parent == nil {
+		return nil
+	}
+	span := &Span{
+		traceID:  parent.traceID,
+		parentID: parent.spanID,
+		sampled:  parent.sampled,
+		name:     name,
+		kind:     kind,
+		start:    time.Now(),
+		attrs:    attrs,
+		c:        c,
+		parent:   parent,
+	}
+	_, _ = rand.Read(span.spanID[:])
+	common.SetContextKey(c, constant.ContextKeyTraceSpan, span)
+	return span
+}
+
+// CurrentSpan 返回请求当前活跃的 span
+func CurrentSpan(c *gin.Context) *Span {
+	if c == nil {
+		return nil
+	}
+	span, _ := common.GetContextKeyType[*Span](c, constant.ContextKeyTraceSpan)
+	return span
+}
+
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// SetError 标记 span 失败，err 为 nil 时忽略
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.statusCode = statusError
+	s.statusMessage = err.Error()
+}
+
+func (s *Span) SetOk() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended || s.statusCode == statusError {
+		return
+	}
+	s.statusCode = statusOk
+}
+
+// Drop 放弃导出该 span（例如非 relay 的静态资源请求）
+func (s *Span) Drop() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.sampled = false
+	s.mu.Unlock()
+}
+
+// End 结束 span 并恢复父 span 为当前活跃 span，重复调用是安全的
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	sampled := s.sampled
+	s.mu.Unlock()
+
+	if s.c != nil && CurrentSpan(s.c) == s {
+		if s.parent != nil {
+			common.SetContextKey(s.c, constant.ContextKeyTraceSpan, s.parent)
+		}
+	}
+	if sampled && exporter != nil {
+		exporter.enqueue(s)
+	}
+}
+
+// TraceParent 返回用于向下游透传的 W3C traceparent 头
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+	return FormatTraceParent(s.traceID, s.spanID, s.sampled)
+}
+
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", s.traceID)
+}
@@ -78,6 +78,21 @@ type TaskAdaptor interface {
 	ParseTaskResult(respBody []byte) (*relaycommon.TaskInfo, error)
 }
 
+// ClaudeTokenCounter 支持原生 Claude count_tokens 接口的适配器
+type ClaudeTokenCounter interface {
+	// SupportClaudeCountTokens 当前渠道与模型是否可以转发 count_tokens
+	SupportClaudeCountTokens(info *relaycommon.RelayInfo) bool
+	CountClaudeTokens(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (int, *types.NewAPIError)
+}
+
+// GeminiResourceRequester 支持 Gemini 原生附加接口（countTokens、cachedContents）的适配器
+type GeminiResourceRequester interface {
+	// GetGeminiResourceURL resource 为 Gemini API 路径，如 models/gemini-2.5-flash:countTokens、cachedContents/{id}
+	GetGeminiResourceURL(info *relaycommon.RelayInfo, resource string) (string, error)
+	// GetGeminiModelResource 请求体中 model 字段使用的模型资源名
+	GetGeminiModelResource(info *relaycommon.RelayInfo, modelName string) (string, error)
+}
+
 type OpenAIVideoConverter interface {
 	ConvertToOpenAIVideo(originTask *model.Task) ([]byte, error)
 }
@@ -13,6 +13,7 @@ import (
 
 	common2 "github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/pkg/tracing"
 	"github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/relay/constant"
 	"github.com/QuantumNous/new-api/relay/helper"
@@ -292,6 +293,11 @@ func DoApiRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody
 	if err != nil {
 		return nil, fmt.Errorf("get request url failed: %w", err)
 	}
+	return DoApiRequestWithURL(a, c, info, fullRequestURL, requestBody)
+}
+
+// DoApiRequestWithURL 与 DoApiRequest 相同，但请求地址由调用方指定（如 count_tokens 等附加接口）
+func DoApiRequestWithURL(a Adaptor, c *gin.Context, info *common.RelayInfo, fullRequestURL string, requestBody io.Reader) (*http.Response, error) {
 	if common2.DebugEnabled {
 		println("fullRequestURL:", fullRequestURL)
 	}
@@ -484,24 +490,17 @@ func DoRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 	return doRequest(c, req, info)
 }
 func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http.Response, error) {
-	var client *http.Client
-	var err error
-	if info.ChannelSetting.Proxy != "" {
-		client, err = service.NewProxyHttpClient(info.ChannelSetting.Proxy)
-		if err != nil {
-			return nil, fmt.Errorf("new proxy http client failed: %w", err)
-		}
-	} else {
-		client = service.GetHttpClient()
+	client, err := service.GetChannelHttpClient(info.ChannelSetting)
+	if err != nil {
+		return nil, fmt.Errorf("new proxy http client failed: %w", err)
 	}
 
 	var stopPinger context.CancelFunc
 	if info.IsStream {
 		helper.SetEventStreamHeaders(c)
 		// 处理流式请求的 ping 保活
-		generalSettings := operation_setting.GetGeneralSetting()
-		if generalSettings.PingIntervalEnabled && !info.DisablePing {
-			pingInterval := time.Duration(generalSettings.PingIntervalSeconds) * time.Second
+		if streamPingEnabled(info) {
+			pingInterval := time.Duration(operation_setting.GetGeneralSetting().PingIntervalSeconds) * time.Second
 			stopPinger = startPingKeepAlive(c, pingInterval)
 			// 使用defer确保在任何情况下都能停止ping goroutine
 			defer func() {
@@ -515,20 +514,74 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 		}
 	}
 
+	span := tracing.StartClientSpan(c, "DoRequest",
+		tracing.String("request.id", info.RequestId),
+		tracing.Int("channel.id", info.ChannelId),
+		tracing.Int("channel.type", info.ChannelType),
+		tracing.String("upstream.model", info.UpstreamModelName),
+		tracing.Int("retry.index", info.RetryIndex),
+		tracing.Bool("is_stream", info.IsStream),
+	)
+	defer span.End()
+	if info.ChannelOtherSettings.AllowTracePropagation {
+		tracing.InjectTraceParent(span, req.Header)
+	}
+
+	if info.UpstreamContext != nil {
+		req = req.WithContext(info.UpstreamContext)
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		logger.LogError(c, "do request failed: "+err.Error())
+		span.SetError(err)
+		if timeoutCode, ok := service.TimeoutErrorCode(err); ok {
+			return nil, types.NewErrorWithStatusCode(err, timeoutCode, http.StatusGatewayTimeout, types.ErrOptionWithHideErrMsg("upstream error: "+string(timeoutCode)))
+		}
 		return nil, types.NewError(err, types.ErrorCodeDoRequestFailed, types.ErrOptionWithHideErrMsg("upstream error: do request failed"))
 	}
 	if resp == nil {
 		return nil, errors.New("resp is nil")
 	}
+	span.SetAttributes(tracing.Int("http.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetError(fmt.Errorf("upstream responded with status %d", resp.StatusCode))
+	}
+	if err := awaitStreamFirstToken(c, resp, info); err != nil {
+		span.SetError(err)
+		return nil, err
+	}
 
 	_ = req.Body.Close()
 	_ = c.Request.Body.Close()
 	return resp, nil
 }
 
+// streamPingEnabled 渠道配置了首包超时时不发送 ping：ping 会提前向客户端写出响应，
+// 首包前失败时就无法再切换渠道重试
+func streamPingEnabled(info *common.RelayInfo) bool {
+	generalSettings := operation_setting.GetGeneralSetting()
+	return generalSettings.PingIntervalEnabled && !info.DisablePing && info.ChannelSetting.StreamFirstTokenTimeout <= 0
+}
+
+// awaitStreamFirstToken 渠道配置了首包超时时，在返回响应前预读到第一个有效增量，
+// 首包前的上游错误和超时作为普通错误返回，由上层切换渠道重试
+func awaitStreamFirstToken(c *gin.Context, resp *http.Response, info *common.RelayInfo) *types.NewAPIError {
+	timeoutSeconds := info.ChannelSetting.StreamFirstTokenTimeout
+	if !info.IsStream || timeoutSeconds <= 0 || resp.StatusCode != http.StatusOK ||
+		!strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return nil
+	}
+	err := helper.AwaitStreamFirstToken(c.Request.Context(), resp, time.Duration(timeoutSeconds)*time.Second)
+	if err == nil {
+		return nil
+	}
+	logger.LogWarn(c, "stream failed before first token: "+err.Error())
+	if errors.Is(err, helper.ErrStreamFirstTokenTimeout) {
+		return types.NewErrorWithStatusCode(err, types.ErrorCodeStreamFirstTokenTimeout, http.StatusBadGateway)
+	}
+	return types.NewErrorWithStatusCode(err, types.ErrorCodeBadResponse, http.StatusBadGateway)
+}
+
 func DoTaskApiRequest(a TaskAdaptor, c *gin.Context, info *common.RelayInfo, requestBody io.Reader) (*http.Response, error) {
 	fullRequestURL, err := a.BuildRequestURL(info)
 	if err != nil {
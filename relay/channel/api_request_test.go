@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/require"
 )
@@ -191,3 +192,21 @@ func TestProcessHeaderOverride_PassHeadersTemplateSetsRuntimeHeaders(t *testing.
 	require.Equal(t, "sess-123", upstreamReq.Header.Get("Session_id"))
 	require.Empty(t, upstreamReq.Header.Get("X-Codex-Beta-Features"))
 }
+
+func TestStreamPingDisabledWithFirstTokenTimeout(t *testing.T) {
+	generalSettings := operation_setting.GetGeneralSetting()
+	original := generalSettings.PingIntervalEnabled
+	generalSettings.PingIntervalEnabled = true
+	t.Cleanup(func() { generalSettings.PingIntervalEnabled = original })
+
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}}
+	require.True(t, streamPingEnabled(info))
+
+	// 首包前的 ping 会提前提交响应，导致超时后无法切换渠道重试
+	info.ChannelSetting.StreamFirstTokenTimeout = 5
+	require.False(t, streamPingEnabled(info))
+
+	info.ChannelSetting.StreamFirstTokenTimeout = 0
+	info.DisablePing = true
+	require.False(t, streamPingEnabled(info))
+}
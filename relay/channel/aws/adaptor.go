@@ -30,7 +30,7 @@ type Adaptor struct {
 	AwsClient  *bedrockruntime.Client
 	AwsModelId string
 	AwsReq     any
-	IsNova     bool
+	IsConverse bool
 }
 
 func (a *Adaptor) ConvertGeminiRequest(*gin.Context, *relaycommon.RelayInfo, *dto.GeminiChatRequest) (any, error) {
@@ -39,6 +39,9 @@ func (a *Adaptor) ConvertGeminiRequest(*gin.Context, *relaycommon.RelayInfo, *dt
 }
 
 func (a *Adaptor) ConvertClaudeRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (any, error) {
+	if isConverseModel(getAwsModelID(info.UpstreamModelName)) {
+		return nil, fmt.Errorf("claude messages format is not supported for bedrock model %s, use chat completions instead", info.UpstreamModelName)
+	}
 	for i, message := range request.Messages {
 		updated := false
 		if !message.IsStringContent() {
@@ -115,14 +118,13 @@ func (a *Adaptor) ConvertOpenAIRequest(c *gin.Context, info *relaycommon.RelayIn
 	if request == nil {
 		return nil, errors.New("request is nil")
 	}
-	// 检查是否为Nova模型
-	if isNovaModel(request.Model) {
-		novaReq := convertToNovaRequest(request)
-		a.IsNova = true
-		return novaReq, nil
+	// 非 Anthropic 模型（Nova、Llama、Mistral、Cohere、DeepSeek 等）走 Converse API
+	if isConverseModel(getAwsModelID(info.UpstreamModelName)) {
+		a.IsConverse = true
+		return convertOpenAI2ConverseRequest(c, request)
 	}
 
-	// 原有的Claude模型处理逻辑
+	// Claude 模型走 InvokeModel
 	claudeReq, err := claude.RequestOpenAI2ClaudeMessage(c, *request)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to convert openai request to claude request")
@@ -146,6 +148,9 @@ func (a *Adaptor) ConvertOpenAIResponsesRequest(c *gin.Context, info *relaycommo
 }
 
 func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
+	if a.IsConverse {
+		return doAwsConverseRequest(c, info, a, requestBody)
+	}
 	if a.ClientMode == ClientModeApiKey {
 		return channel.DoApiRequest(a, c, info, requestBody)
 	} else {
@@ -154,18 +159,20 @@ func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, request
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage any, err *types.NewAPIError) {
-	if a.ClientMode == ClientModeApiKey {
+	if a.IsConverse {
+		if info.IsStream {
+			err, usage = awsConverseStreamHandler(c, info, a)
+		} else {
+			err, usage = awsConverseHandler(c, info, a)
+		}
+	} else if a.ClientMode == ClientModeApiKey {
 		claudeAdaptor := claude.Adaptor{}
 		usage, err = claudeAdaptor.DoResponse(c, resp, info)
 	} else {
-		if a.IsNova {
-			err, usage = handleNovaRequest(c, info, a)
+		if info.IsStream {
+			err, usage = awsStreamHandler(c, info, a)
 		} else {
-			if info.IsStream {
-				err, usage = awsStreamHandler(c, info, a)
-			} else {
-				err, usage = awsHandler(c, info, a)
-			}
+			err, usage = awsHandler(c, info, a)
 		}
 	}
 	return
@@ -27,6 +27,15 @@ var awsModelIDMap = map[string]string{
 	"nova-reel-v1:0":    "amazon.nova-reel-v1:0",
 	"nova-reel-v1:1":    "amazon.nova-reel-v1:1",
 	"nova-sonic-v1:0":   "amazon.nova-sonic-v1:0",
+	// Converse models
+	"llama3-3-70b-instruct": "meta.llama3-3-70b-instruct-v1:0",
+	"llama4-maverick-17b":   "meta.llama4-maverick-17b-instruct-v1:0",
+	"llama4-scout-17b":      "meta.llama4-scout-17b-instruct-v1:0",
+	"mistral-large-2407":    "mistral.mistral-large-2407-v1:0",
+	"pixtral-large-2502":    "mistral.pixtral-large-2502-v1:0",
+	"command-r-plus":        "cohere.command-r-plus-v1:0",
+	"command-r":             "cohere.command-r-v1:0",
+	"deepseek-r1":           "deepseek.r1-v1:0",
 }
 
 var awsModelCanCrossRegionMap = map[string]map[string]bool{
@@ -133,6 +142,22 @@ var awsModelCanCrossRegionMap = map[string]map[string]bool{
 		"eu":   true,
 		"apac": true,
 	},
+	"meta.llama3-3-70b-instruct-v1:0": {
+		"us": true,
+	},
+	"meta.llama4-maverick-17b-instruct-v1:0": {
+		"us": true,
+	},
+	"meta.llama4-scout-17b-instruct-v1:0": {
+		"us": true,
+	},
+	"mistral.pixtral-large-2502-v1:0": {
+		"us": true,
+		"eu": true,
+	},
+	"deepseek.r1-v1:0": {
+		"us": true,
+	},
 }
 
 var awsRegionCrossModelPrefixMap = map[string]string{
@@ -143,7 +168,21 @@ var awsRegionCrossModelPrefixMap = map[string]string{
 
 var ChannelName = "aws"
 
-// 判断是否为Nova模型
-func isNovaModel(modelId string) bool {
-	return strings.Contains(modelId, "nova-")
+// converseModelProviders 使用 Converse API 的模型提供方，Anthropic 模型仍走 InvokeModel
+var converseModelProviders = []string{"amazon.", "meta.", "mistral.", "cohere.", "deepseek.", "ai21.", "writer.", "qwen.", "openai."}
+
+// 判断是否使用 Converse API，兼容跨区域推理前缀（如 us.meta.llama...）
+func isConverseModel(modelId string) bool {
+	for _, prefix := range []string{"us.", "eu.", "apac.", "global."} {
+		if strings.HasPrefix(modelId, prefix) {
+			modelId = strings.TrimPrefix(modelId, prefix)
+			break
+		}
+	}
+	for _, provider := range converseModelProviders {
+		if strings.HasPrefix(modelId, provider) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,637 @@
+package aws
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/document"
+	bedrockruntimeTypes "github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// ConverseRequest Bedrock Converse API 的 JSON 形式，经过参数覆盖后在 DoRequest 中转换为 SDK 输入
+// https://docs.aws.amazon.com/bedrock/latest/APIReference/API_runtime_Converse.html
+type ConverseRequest struct {
+	Messages                     []ConverseMessage        `json:"messages"`
+	System                       []ConverseSystemBlock    `json:"system,omitempty"`
+	InferenceConfig              *ConverseInferenceConfig `json:"inferenceConfig,omitempty"`
+	ToolConfig                   *ConverseToolConfig      `json:"toolConfig,omitempty"`
+	AdditionalModelRequestFields map[string]any           `json:"additionalModelRequestFields,omitempty"`
+}
+
+type ConverseSystemBlock struct {
+	Text string `json:"text"`
+}
+
+type ConverseMessage struct {
+	Role    string                 `json:"role"`
+	Content []ConverseContentBlock `json:"content"`
+}
+
+type ConverseContentBlock struct {
+	Text       *string             `json:"text,omitempty"`
+	Image      *ConverseImageBlock `json:"image,omitempty"`
+	ToolUse    *ConverseToolUse    `json:"toolUse,omitempty"`
+	ToolResult *ConverseToolResult `json:"toolResult,omitempty"`
+}
+
+type ConverseImageBlock struct {
+	Format string              `json:"format"`
+	Source ConverseImageSource `json:"source"`
+}
+
+type ConverseImageSource struct {
+	// Bytes base64 编码的图片数据
+	Bytes string `json:"bytes"`
+}
+
+type ConverseToolUse struct {
+	ToolUseId string `json:"toolUseId"`
+	Name      string `json:"name"`
+	Input     any    `json:"input"`
+}
+
+type ConverseToolResult struct {
+	ToolUseId string                      `json:"toolUseId"`
+	Content   []ConverseToolResultContent `json:"content"`
+	Status    string                      `json:"status,omitempty"`
+}
+
+type ConverseToolResultContent struct {
+	Text string `json:"text"`
+}
+
+type ConverseInferenceConfig struct {
+	MaxTokens     *int32   `json:"maxTokens,omitempty"`
+	Temperature   *float32 `json:"temperature,omitempty"`
+	TopP          *float32 `json:"topP,omitempty"`
+	StopSequences []string `json:"stopSequences,omitempty"`
+}
+
+type ConverseToolConfig struct {
+	Tools      []ConverseTool      `json:"tools"`
+	ToolChoice *ConverseToolChoice `json:"toolChoice,omitempty"`
+}
+
+type ConverseTool struct {
+	ToolSpec ConverseToolSpec `json:"toolSpec"`
+}
+
+type ConverseToolSpec struct {
+	Name        string                  `json:"name"`
+	Description string                  `json:"description,omitempty"`
+	InputSchema ConverseToolInputSchema `json:"inputSchema"`
+}
+
+type ConverseToolInputSchema struct {
+	Json any `json:"json"`
+}
+
+type ConverseToolChoice struct {
+	Auto *struct{}                   `json:"auto,omitempty"`
+	Any  *struct{}                   `json:"any,omitempty"`
+	Tool *ConverseSpecificToolChoice `json:"tool,omitempty"`
+}
+
+type ConverseSpecificToolChoice struct {
+	Name string `json:"name"`
+}
+
+// convertOpenAI2ConverseRequest 将 OpenAI Chat 请求转换为 Converse 请求
+// Converse 要求 user / assistant 严格交替，连续的同角色消息（包括多个 tool 结果）会被合并
+func convertOpenAI2ConverseRequest(c *gin.Context, request *dto.GeneralOpenAIRequest) (*ConverseRequest, error) {
+	converseReq := &ConverseRequest{}
+
+	for _, message := range request.Messages {
+		switch message.Role {
+		case "system", "developer":
+			if text := message.StringContent(); text != "" {
+				converseReq.System = append(converseReq.System, ConverseSystemBlock{Text: text})
+			}
+			continue
+		case "tool":
+			converseReq.appendContent("user", ConverseContentBlock{
+				ToolResult: &ConverseToolResult{
+					ToolUseId: message.ToolCallId,
+					Content:   []ConverseToolResultContent{{Text: message.StringContent()}},
+				},
+			})
+			continue
+		}
+
+		role := "user"
+		if message.Role == "assistant" {
+			role = "assistant"
+		}
+		blocks := make([]ConverseContentBlock, 0)
+		if message.IsStringContent() {
+			if text := message.StringContent(); text != "" {
+				blocks = append(blocks, ConverseContentBlock{Text: common.GetPointer(text)})
+			}
+		} else {
+			for _, mediaMessage := range message.ParseContent() {
+				switch mediaMessage.Type {
+				case dto.ContentTypeText:
+					if mediaMessage.Text != "" {
+						blocks = append(blocks, ConverseContentBlock{Text: common.GetPointer(mediaMessage.Text)})
+					}
+				case dto.ContentTypeImageURL:
+					imageBlock, err := buildConverseImageBlock(c, mediaMessage.GetImageMedia().Url)
+					if err != nil {
+						return nil, err
+					}
+					blocks = append(blocks, ConverseContentBlock{Image: imageBlock})
+				}
+			}
+		}
+		if message.ToolCalls != nil {
+			for _, toolCall := range message.ParseToolCalls() {
+				input := make(map[string]any)
+				if toolCall.Function.Arguments != "" {
+					if err := common.UnmarshalJsonStr(toolCall.Function.Arguments, &input); err != nil {
+						common.SysLog("tool call function arguments is not a map[string]any: " + toolCall.Function.Arguments)
+						continue
+					}
+				}
+				blocks = append(blocks, ConverseContentBlock{
+					ToolUse: &ConverseToolUse{
+						ToolUseId: toolCall.ID,
+						Name:      toolCall.Function.Name,
+						Input:     input,
+					},
+				})
+			}
+		}
+		converseReq.appendContent(role, blocks...)
+	}
+
+	inferenceConfig := &ConverseInferenceConfig{}
+	hasInferenceConfig := false
+	if maxTokens := request.GetMaxTokens(); maxTokens > 0 {
+		inferenceConfig.MaxTokens = common.GetPointer(int32(maxTokens))
+		hasInferenceConfig = true
+	}
+	if request.Temperature != nil {
+		inferenceConfig.Temperature = common.GetPointer(float32(*request.Temperature))
+		hasInferenceConfig = true
+	}
+	if request.TopP != nil {
+		inferenceConfig.TopP = common.GetPointer(float32(*request.TopP))
+		hasInferenceConfig = true
+	}
+	if stopSequences := parseStopSequences(request.Stop); len(stopSequences) > 0 {
+		inferenceConfig.StopSequences = stopSequences
+		hasInferenceConfig = true
+	}
+	if hasInferenceConfig {
+		converseReq.InferenceConfig = inferenceConfig
+	}
+
+	converseReq.ToolConfig = buildConverseToolConfig(request.Tools, request.ToolChoice)
+	return converseReq, nil
+}
+
+func (r *ConverseRequest) appendContent(role string, blocks ...ConverseContentBlock) {
+	if len(blocks) == 0 {
+		return
+	}
+	if len(r.Messages) > 0 && r.Messages[len(r.Messages)-1].Role == role {
+		last := &r.Messages[len(r.Messages)-1]
+		last.Content = append(last.Content, blocks...)
+		return
+	}
+	r.Messages = append(r.Messages, ConverseMessage{Role: role, Content: blocks})
+}
+
+func buildConverseImageBlock(c *gin.Context, url string) (*ConverseImageBlock, error) {
+	var source *types.FileSource
+	if strings.HasPrefix(url, "http") {
+		source = types.NewURLFileSource(url)
+	} else {
+		source = types.NewBase64FileSource(url, "")
+	}
+	base64Data, mimeType, err := service.GetBase64Data(c, source, "formatting image for Bedrock Converse")
+	if err != nil {
+		return nil, fmt.Errorf("get file data failed: %s", err.Error())
+	}
+	format := strings.TrimPrefix(mimeType, "image/")
+	switch format {
+	case "jpg":
+		format = string(bedrockruntimeTypes.ImageFormatJpeg)
+	case string(bedrockruntimeTypes.ImageFormatPng), string(bedrockruntimeTypes.ImageFormatJpeg),
+		string(bedrockruntimeTypes.ImageFormatGif), string(bedrockruntimeTypes.ImageFormatWebp):
+	default:
+		return nil, fmt.Errorf("unsupported image format for Bedrock Converse: %s", mimeType)
+	}
+	return &ConverseImageBlock{
+		Format: format,
+		Source: ConverseImageSource{Bytes: base64Data},
+	}, nil
+}
+
+// buildConverseToolConfig tool_choice 为 none 时 Converse 没有对应值，直接不传工具
+func buildConverseToolConfig(tools []dto.ToolCallRequest, toolChoice any) *ConverseToolConfig {
+	if len(tools) == 0 {
+		return nil
+	}
+	toolConfig := &ConverseToolConfig{}
+	switch choice := toolChoice.(type) {
+	case string:
+		switch choice {
+		case "none":
+			return nil
+		case "required":
+			toolConfig.ToolChoice = &ConverseToolChoice{Any: &struct{}{}}
+		case "auto":
+			toolConfig.ToolChoice = &ConverseToolChoice{Auto: &struct{}{}}
+		}
+	case map[string]any:
+		if function, ok := choice["function"].(map[string]any); ok {
+			if name, ok := function["name"].(string); ok && name != "" {
+				toolConfig.ToolChoice = &ConverseToolChoice{Tool: &ConverseSpecificToolChoice{Name: name}}
+			}
+		}
+	}
+	for _, tool := range tools {
+		if tool.Function.Name == "" {
+			continue
+		}
+		schema := tool.Function.Parameters
+		if schema == nil {
+			schema = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+		toolConfig.Tools = append(toolConfig.Tools, ConverseTool{
+			ToolSpec: ConverseToolSpec{
+				Name:        tool.Function.Name,
+				Description: tool.Function.Description,
+				InputSchema: ConverseToolInputSchema{Json: schema},
+			},
+		})
+	}
+	if len(toolConfig.Tools) == 0 {
+		return nil
+	}
+	return toolConfig
+}
+
+// toSDKInput 将 JSON 形式的请求转换为 SDK 的 ConverseInput，ConverseStreamInput 字段与之相同
+func (r *ConverseRequest) toSDKInput(modelId string) (*bedrockruntime.ConverseInput, error) {
+	input := &bedrockruntime.ConverseInput{
+		ModelId: aws.String(modelId),
+	}
+	for _, system := range r.System {
+		input.System = append(input.System, &bedrockruntimeTypes.SystemContentBlockMemberText{Value: system.Text})
+	}
+	for _, message := range r.Messages {
+		sdkMessage := bedrockruntimeTypes.Message{
+			Role: bedrockruntimeTypes.ConversationRole(message.Role),
+		}
+		for _, block := range message.Content {
+			sdkBlock, err := block.toSDKContentBlock()
+			if err != nil {
+				return nil, err
+			}
+			if sdkBlock != nil {
+				sdkMessage.Content = append(sdkMessage.Content, sdkBlock)
+			}
+		}
+		input.Messages = append(input.Messages, sdkMessage)
+	}
+	if r.InferenceConfig != nil {
+		input.InferenceConfig = &bedrockruntimeTypes.InferenceConfiguration{
+			MaxTokens:     r.InferenceConfig.MaxTokens,
+			Temperature:   r.InferenceConfig.Temperature,
+			TopP:          r.InferenceConfig.TopP,
+			StopSequences: r.InferenceConfig.StopSequences,
+		}
+	}
+	if r.ToolConfig != nil && len(r.ToolConfig.Tools) > 0 {
+		toolConfig := &bedrockruntimeTypes.ToolConfiguration{}
+		for _, tool := range r.ToolConfig.Tools {
+			toolConfig.Tools = append(toolConfig.Tools, &bedrockruntimeTypes.ToolMemberToolSpec{
+				Value: bedrockruntimeTypes.ToolSpecification{
+					Name:        aws.String(tool.ToolSpec.Name),
+					Description: optionalString(tool.ToolSpec.Description),
+					InputSchema: &bedrockruntimeTypes.ToolInputSchemaMemberJson{
+						Value: document.NewLazyDocument(tool.ToolSpec.InputSchema.Json),
+					},
+				},
+			})
+		}
+		if choice := r.ToolConfig.ToolChoice; choice != nil {
+			switch {
+			case choice.Tool != nil:
+				toolConfig.ToolChoice = &bedrockruntimeTypes.ToolChoiceMemberTool{
+					Value: bedrockruntimeTypes.SpecificToolChoice{Name: aws.String(choice.Tool.Name)},
+				}
+			case choice.Any != nil:
+				toolConfig.ToolChoice = &bedrockruntimeTypes.ToolChoiceMemberAny{}
+			case choice.Auto != nil:
+				toolConfig.ToolChoice = &bedrockruntimeTypes.ToolChoiceMemberAuto{}
+			}
+		}
+		input.ToolConfig = toolConfig
+	}
+	if len(r.AdditionalModelRequestFields) > 0 {
+		input.AdditionalModelRequestFields = document.NewLazyDocument(r.AdditionalModelRequestFields)
+	}
+	return input, nil
+}
+
+func (b ConverseContentBlock) toSDKContentBlock() (bedrockruntimeTypes.ContentBlock, error) {
+	switch {
+	case b.Text != nil:
+		return &bedrockruntimeTypes.ContentBlockMemberText{Value: *b.Text}, nil
+	case b.Image != nil:
+		data, err := base64.StdEncoding.DecodeString(b.Image.Source.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "decode image bytes")
+		}
+		return &bedrockruntimeTypes.ContentBlockMemberImage{
+			Value: bedrockruntimeTypes.ImageBlock{
+				Format: bedrockruntimeTypes.ImageFormat(b.Image.Format),
+				Source: &bedrockruntimeTypes.ImageSourceMemberBytes{Value: data},
+			},
+		}, nil
+	case b.ToolUse != nil:
+		input := b.ToolUse.Input
+		if input == nil {
+			input = map[string]any{}
+		}
+		return &bedrockruntimeTypes.ContentBlockMemberToolUse{
+			Value: bedrockruntimeTypes.ToolUseBlock{
+				ToolUseId: aws.String(b.ToolUse.ToolUseId),
+				Name:      aws.String(b.ToolUse.Name),
+				Input:     document.NewLazyDocument(input),
+			},
+		}, nil
+	case b.ToolResult != nil:
+		toolResult := bedrockruntimeTypes.ToolResultBlock{
+			ToolUseId: aws.String(b.ToolResult.ToolUseId),
+			Status:    bedrockruntimeTypes.ToolResultStatus(b.ToolResult.Status),
+		}
+		for _, content := range b.ToolResult.Content {
+			toolResult.Content = append(toolResult.Content, &bedrockruntimeTypes.ToolResultContentBlockMemberText{Value: content.Text})
+		}
+		return &bedrockruntimeTypes.ContentBlockMemberToolResult{Value: toolResult}, nil
+	}
+	return nil, nil
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
+
+// converseFinishReason 将 Converse 的 stopReason 映射为 OpenAI finish_reason
+func converseFinishReason(stopReason bedrockruntimeTypes.StopReason) string {
+	switch stopReason {
+	case bedrockruntimeTypes.StopReasonToolUse:
+		return constant.FinishReasonToolCalls
+	case bedrockruntimeTypes.StopReasonMaxTokens:
+		return constant.FinishReasonLength
+	case bedrockruntimeTypes.StopReasonGuardrailIntervened, bedrockruntimeTypes.StopReasonContentFiltered:
+		return constant.FinishReasonContentFilter
+	default:
+		return constant.FinishReasonStop
+	}
+}
+
+func converseUsage(tokenUsage *bedrockruntimeTypes.TokenUsage) *dto.Usage {
+	usage := &dto.Usage{}
+	if tokenUsage == nil {
+		return usage
+	}
+	usage.PromptTokens = int(aws.ToInt32(tokenUsage.InputTokens))
+	usage.CompletionTokens = int(aws.ToInt32(tokenUsage.OutputTokens))
+	usage.TotalTokens = int(aws.ToInt32(tokenUsage.TotalTokens))
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	usage.PromptTokensDetails.CachedTokens = int(aws.ToInt32(tokenUsage.CacheReadInputTokens))
+	usage.PromptTokensDetails.CachedCreationTokens = int(aws.ToInt32(tokenUsage.CacheWriteInputTokens))
+	return usage
+}
+
+// doAwsConverseRequest Converse 走 SDK，AK/SK 与 API Key 两种认证都由 newAwsClient 处理
+func doAwsConverseRequest(c *gin.Context, info *relaycommon.RelayInfo, a *Adaptor, requestBody io.Reader) (any, error) {
+	awsCli, err := newAwsClient(c, info)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeChannelAwsClientError)
+	}
+	a.AwsClient = awsCli
+
+	awsModelId := getAwsModelID(info.UpstreamModelName)
+	awsRegionPrefix := getAwsRegionPrefix(awsCli.Options().Region)
+	if awsModelCanCrossRegion(awsModelId, awsRegionPrefix) {
+		awsModelId = awsModelCrossRegion(awsModelId, awsRegionPrefix)
+	}
+
+	var converseReq ConverseRequest
+	if err := common.DecodeJson(requestBody, &converseReq); err != nil {
+		return nil, types.NewError(errors.Wrap(err, "decode converse request fail"), types.ErrorCodeBadRequestBody)
+	}
+	input, err := converseReq.toSDKInput(awsModelId)
+	if err != nil {
+		return nil, types.NewError(errors.Wrap(err, "build converse request fail"), types.ErrorCodeBadRequestBody)
+	}
+	if info.IsStream {
+		a.AwsReq = &bedrockruntime.ConverseStreamInput{
+			ModelId:                      input.ModelId,
+			AdditionalModelRequestFields: input.AdditionalModelRequestFields,
+			InferenceConfig:              input.InferenceConfig,
+			Messages:                     input.Messages,
+			System:                       input.System,
+			ToolConfig:                   input.ToolConfig,
+		}
+	} else {
+		a.AwsReq = input
+	}
+	return nil, nil
+}
+
+func awsConverseHandler(c *gin.Context, info *relaycommon.RelayInfo, a *Adaptor) (*types.NewAPIError, *dto.Usage) {
+	ctx, cancel := newAwsInvokeContext()
+	defer cancel()
+
+	awsResp, err := a.AwsClient.Converse(ctx, a.AwsReq.(*bedrockruntime.ConverseInput))
+	if err != nil {
+		return types.NewOpenAIError(errors.Wrap(err, "Converse"), types.ErrorCodeAwsInvokeError, getAwsErrorStatusCode(err)), nil
+	}
+
+	message := dto.Message{Role: "assistant"}
+	var text, reasoning strings.Builder
+	var toolCalls []dto.ToolCallResponse
+	if output, ok := awsResp.Output.(*bedrockruntimeTypes.ConverseOutputMemberMessage); ok {
+		for _, block := range output.Value.Content {
+			switch v := block.(type) {
+			case *bedrockruntimeTypes.ContentBlockMemberText:
+				text.WriteString(v.Value)
+			case *bedrockruntimeTypes.ContentBlockMemberReasoningContent:
+				if reasoningText, ok := v.Value.(*bedrockruntimeTypes.ReasoningContentBlockMemberReasoningText); ok {
+					reasoning.WriteString(aws.ToString(reasoningText.Value.Text))
+				}
+			case *bedrockruntimeTypes.ContentBlockMemberToolUse:
+				arguments := "{}"
+				if v.Value.Input != nil {
+					if raw, err := v.Value.Input.MarshalSmithyDocument(); err == nil {
+						arguments = string(raw)
+					}
+				}
+				toolCalls = append(toolCalls, dto.ToolCallResponse{
+					ID:   aws.ToString(v.Value.ToolUseId),
+					Type: "function",
+					Function: dto.FunctionResponse{
+						Name:      aws.ToString(v.Value.Name),
+						Arguments: arguments,
+					},
+				})
+			}
+		}
+	}
+	message.SetStringContent(text.String())
+	if reasoning.Len() > 0 {
+		message.ReasoningContent = reasoning.String()
+	}
+	if len(toolCalls) > 0 {
+		message.SetToolCalls(toolCalls)
+	}
+
+	usage := converseUsage(awsResp.Usage)
+	response := dto.OpenAITextResponse{
+		Id:      helper.GetResponseID(c),
+		Object:  "chat.completion",
+		Created: common.GetTimestamp(),
+		Model:   info.UpstreamModelName,
+		Choices: []dto.OpenAITextResponseChoice{{
+			Index:        0,
+			Message:      message,
+			FinishReason: converseFinishReason(awsResp.StopReason),
+		}},
+		Usage: *usage,
+	}
+	c.JSON(http.StatusOK, response)
+	return nil, usage
+}
+
+func awsConverseStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, a *Adaptor) (*types.NewAPIError, *dto.Usage) {
+	ctx, cancel := newAwsInvokeContext()
+	defer cancel()
+
+	awsResp, err := a.AwsClient.ConverseStream(ctx, a.AwsReq.(*bedrockruntime.ConverseStreamInput))
+	if err != nil {
+		return types.NewOpenAIError(errors.Wrap(err, "ConverseStream"), types.ErrorCodeAwsInvokeError, getAwsErrorStatusCode(err)), nil
+	}
+	stream := awsResp.GetStream()
+	defer stream.Close()
+
+	helper.SetEventStreamHeaders(c)
+	responseId := helper.GetResponseID(c)
+	created := common.GetTimestamp()
+	model := info.UpstreamModelName
+	usage := &dto.Usage{}
+	finishReason := constant.FinishReasonStop
+	// Converse 以 contentBlockIndex 标识内容块，工具调用需映射为 OpenAI 的 tool_calls 序号
+	toolCallIndexes := make(map[int32]int)
+
+	sendDelta := func(delta dto.ChatCompletionsStreamResponseChoiceDelta) {
+		_ = helper.ObjectData(c, dto.ChatCompletionsStreamResponse{
+			Id:      responseId,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   model,
+			Choices: []dto.ChatCompletionsStreamResponseChoice{{
+				Index: 0,
+				Delta: delta,
+			}},
+		})
+	}
+
+	for event := range stream.Events() {
+		switch v := event.(type) {
+		case *bedrockruntimeTypes.ConverseStreamOutputMemberMessageStart:
+			info.SetFirstResponseTime()
+			_ = helper.ObjectData(c, helper.GenerateStartEmptyResponse(responseId, created, model, nil))
+		case *bedrockruntimeTypes.ConverseStreamOutputMemberContentBlockStart:
+			toolUse, ok := v.Value.Start.(*bedrockruntimeTypes.ContentBlockStartMemberToolUse)
+			if !ok {
+				continue
+			}
+			index := len(toolCallIndexes)
+			toolCallIndexes[aws.ToInt32(v.Value.ContentBlockIndex)] = index
+			toolCall := dto.ToolCallResponse{
+				ID:   aws.ToString(toolUse.Value.ToolUseId),
+				Type: "function",
+				Function: dto.FunctionResponse{
+					Name: aws.ToString(toolUse.Value.Name),
+				},
+			}
+			toolCall.SetIndex(index)
+			sendDelta(dto.ChatCompletionsStreamResponseChoiceDelta{ToolCalls: []dto.ToolCallResponse{toolCall}})
+		case *bedrockruntimeTypes.ConverseStreamOutputMemberContentBlockDelta:
+			info.SetFirstResponseTime()
+			delta := dto.ChatCompletionsStreamResponseChoiceDelta{}
+			switch d := v.Value.Delta.(type) {
+			case *bedrockruntimeTypes.ContentBlockDeltaMemberText:
+				delta.SetContentString(d.Value)
+			case *bedrockruntimeTypes.ContentBlockDeltaMemberReasoningContent:
+				reasoningText, ok := d.Value.(*bedrockruntimeTypes.ReasoningContentBlockDeltaMemberText)
+				if !ok {
+					continue
+				}
+				delta.SetReasoningContent(reasoningText.Value)
+			case *bedrockruntimeTypes.ContentBlockDeltaMemberToolUse:
+				index, ok := toolCallIndexes[aws.ToInt32(v.Value.ContentBlockIndex)]
+				if !ok {
+					continue
+				}
+				toolCall := dto.ToolCallResponse{
+					Type: "function",
+					Function: dto.FunctionResponse{
+						Arguments: aws.ToString(d.Value.Input),
+					},
+				}
+				toolCall.SetIndex(index)
+				delta.ToolCalls = []dto.ToolCallResponse{toolCall}
+			default:
+				continue
+			}
+			sendDelta(delta)
+		case *bedrockruntimeTypes.ConverseStreamOutputMemberMessageStop:
+			finishReason = converseFinishReason(v.Value.StopReason)
+		case *bedrockruntimeTypes.ConverseStreamOutputMemberMetadata:
+			usage = converseUsage(v.Value.Usage)
+		case *bedrockruntimeTypes.ConverseStreamOutputMemberContentBlockStop:
+		case *bedrockruntimeTypes.UnknownUnionMember:
+			return types.NewError(fmt.Errorf("unknown converse stream event: %s", v.Tag), types.ErrorCodeBadResponse), nil
+		default:
+			return types.NewError(errors.New("nil or unknown converse stream event"), types.ErrorCodeBadResponse), nil
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return types.NewOpenAIError(errors.Wrap(err, "ConverseStream"), types.ErrorCodeAwsInvokeError, getAwsErrorStatusCode(err)), nil
+	}
+
+	_ = helper.ObjectData(c, helper.GenerateStopResponse(responseId, created, model, finishReason))
+	if info.ShouldIncludeUsage {
+		_ = helper.ObjectData(c, helper.GenerateFinalUsageResponse(responseId, created, model, *usage))
+	}
+	helper.Done(c)
+	return nil, usage
+}
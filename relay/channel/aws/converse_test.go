@@ -0,0 +1,82 @@
+package aws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/dto"
+	bedrockruntimeTypes "github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsConverseModel(t *testing.T) {
+	require.True(t, isConverseModel("meta.llama3-3-70b-instruct-v1:0"))
+	require.True(t, isConverseModel("us.meta.llama3-3-70b-instruct-v1:0"))
+	require.True(t, isConverseModel("apac.amazon.nova-pro-v1:0"))
+	require.True(t, isConverseModel("deepseek.r1-v1:0"))
+	require.False(t, isConverseModel("anthropic.claude-3-5-sonnet-20240620-v1:0"))
+	require.False(t, isConverseModel("us.anthropic.claude-sonnet-4-20250514-v1:0"))
+	require.False(t, isConverseModel("claude-3-5-sonnet-20240620"))
+}
+
+func TestConvertOpenAI2ConverseRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	assistant := dto.Message{Role: "assistant"}
+	assistant.SetToolCalls([]dto.ToolCallRequest{
+		{ID: "call_1", Type: "function", Function: dto.FunctionRequest{Name: "weather", Arguments: `{"city":"Paris"}`}},
+		{ID: "call_2", Type: "function", Function: dto.FunctionRequest{Name: "weather", Arguments: `{"city":"Tokyo"}`}},
+	})
+	request := &dto.GeneralOpenAIRequest{
+		Model: "meta.llama3-3-70b-instruct-v1:0",
+		Messages: []dto.Message{
+			{Role: "system", Content: "be brief"},
+			{Role: "user", Content: "weather?"},
+			assistant,
+			{Role: "tool", ToolCallId: "call_1", Content: "sunny"},
+			{Role: "tool", ToolCallId: "call_2", Content: "rainy"},
+		},
+		Tools: []dto.ToolCallRequest{
+			{Type: "function", Function: dto.FunctionRequest{Name: "weather", Parameters: map[string]any{"type": "object"}}},
+		},
+		ToolChoice: "required",
+	}
+
+	converseReq, err := convertOpenAI2ConverseRequest(ctx, request)
+	require.NoError(t, err)
+	require.Equal(t, []ConverseSystemBlock{{Text: "be brief"}}, converseReq.System)
+	require.Len(t, converseReq.Messages, 3)
+	require.Equal(t, "assistant", converseReq.Messages[1].Role)
+	require.Len(t, converseReq.Messages[1].Content, 2)
+	require.Equal(t, "call_2", converseReq.Messages[1].Content[1].ToolUse.ToolUseId)
+
+	// 连续的 tool 结果合并为同一条 user 消息
+	require.Equal(t, "user", converseReq.Messages[2].Role)
+	require.Len(t, converseReq.Messages[2].Content, 2)
+	require.Equal(t, "rainy", converseReq.Messages[2].Content[1].ToolResult.Content[0].Text)
+
+	require.NotNil(t, converseReq.ToolConfig)
+	require.NotNil(t, converseReq.ToolConfig.ToolChoice.Any)
+
+	input, err := converseReq.toSDKInput("us.meta.llama3-3-70b-instruct-v1:0")
+	require.NoError(t, err)
+	require.Len(t, input.Messages, 3)
+	_, ok := input.ToolConfig.ToolChoice.(*bedrockruntimeTypes.ToolChoiceMemberAny)
+	require.True(t, ok)
+}
+
+func TestBuildConverseToolConfigNone(t *testing.T) {
+	tools := []dto.ToolCallRequest{{Type: "function", Function: dto.FunctionRequest{Name: "weather"}}}
+	require.Nil(t, buildConverseToolConfig(tools, "none"))
+	require.NotNil(t, buildConverseToolConfig(tools, nil))
+}
+
+func TestConverseFinishReason(t *testing.T) {
+	require.Equal(t, "tool_calls", converseFinishReason(bedrockruntimeTypes.StopReasonToolUse))
+	require.Equal(t, "length", converseFinishReason(bedrockruntimeTypes.StopReasonMaxTokens))
+	require.Equal(t, "stop", converseFinishReason(bedrockruntimeTypes.StopReasonEndTurn))
+}
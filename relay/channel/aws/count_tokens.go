@@ -0,0 +1,73 @@
+package aws
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	bedrockruntimeTypes "github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// SupportClaudeCountTokens Bedrock CountTokens 只对 Claude 模型开放
+func (a *Adaptor) SupportClaudeCountTokens(info *relaycommon.RelayInfo) bool {
+	return !isConverseModel(getAwsModelID(info.UpstreamModelName))
+}
+
+func (a *Adaptor) CountClaudeTokens(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (int, *types.NewAPIError) {
+	awsCli, err := newAwsClient(c, info)
+	if err != nil {
+		return 0, types.NewError(err, types.ErrorCodeChannelAwsClientError)
+	}
+
+	// CountTokens 的 body 与 InvokeModel 相同，max_tokens 为必填
+	maxTokens := uint(model_setting.GetClaudeSettings().GetDefaultMaxTokens(request.Model))
+	if request.MaxTokens != nil && *request.MaxTokens > 0 {
+		maxTokens = *request.MaxTokens
+	}
+	awsClaudeReq := &AwsClaudeRequest{
+		AnthropicVersion: "bedrock-2023-05-31",
+		System:           request.System,
+		Messages:         request.Messages,
+		MaxTokens:        maxTokens,
+		Tools:            request.Tools,
+		ToolChoice:       request.ToolChoice,
+		Thinking:         request.Thinking,
+	}
+	if anthropicBeta := c.Request.Header.Get("anthropic-beta"); anthropicBeta != "" {
+		betaJson, err := json.Marshal(strings.Split(anthropicBeta, ","))
+		if err == nil {
+			awsClaudeReq.AnthropicBeta = betaJson
+		}
+	}
+	body, err := common.Marshal(awsClaudeReq)
+	if err != nil {
+		return 0, types.NewError(errors.Wrap(err, "marshal aws request fail"), types.ErrorCodeBadRequestBody)
+	}
+
+	ctx, cancel := newAwsInvokeContext()
+	defer cancel()
+
+	// CountTokens 不支持跨区域推理配置，直接使用基础模型 ID
+	output, err := awsCli.CountTokens(ctx, &bedrockruntime.CountTokensInput{
+		ModelId: aws.String(getAwsModelID(info.UpstreamModelName)),
+		Input: &bedrockruntimeTypes.CountTokensInputMemberInvokeModel{
+			Value: bedrockruntimeTypes.InvokeModelTokensRequest{Body: body},
+		},
+	})
+	if err != nil {
+		return 0, types.NewOpenAIError(errors.Wrap(err, "CountTokens"), types.ErrorCodeAwsInvokeError, getAwsErrorStatusCode(err))
+	}
+	if output.InputTokens == nil {
+		return 0, types.NewError(errors.New("CountTokens response missing inputTokens"), types.ErrorCodeBadResponseBody)
+	}
+	return int(*output.InputTokens), nil
+}
@@ -0,0 +1,61 @@
+package claude
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+type countTokensResponse struct {
+	InputTokens *int `json:"input_tokens"`
+}
+
+func (a *Adaptor) SupportClaudeCountTokens(info *relaycommon.RelayInfo) bool {
+	return true
+}
+
+func (a *Adaptor) CountClaudeTokens(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (int, *types.NewAPIError) {
+	jsonData, err := common.Marshal(request.ToCountTokensRequest())
+	if err != nil {
+		return 0, types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+	fullRequestURL := fmt.Sprintf("%s/v1/messages/count_tokens", info.ChannelBaseUrl)
+	if info.IsClaudeBetaQuery {
+		fullRequestURL = fullRequestURL + "?beta=true"
+	}
+	resp, err := channel.DoApiRequestWithURL(a, c, info, fullRequestURL, bytes.NewReader(jsonData))
+	if err != nil {
+		return 0, types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+	}
+	return HandleCountTokensResponse(c, resp)
+}
+
+// HandleCountTokensResponse 解析 Anthropic 格式的 count_tokens 响应（Anthropic / Vertex 共用）
+func HandleCountTokensResponse(c *gin.Context, resp *http.Response) (int, *types.NewAPIError) {
+	if resp.StatusCode != http.StatusOK {
+		return 0, service.RelayErrorHandler(c.Request.Context(), resp, false)
+	}
+	defer service.CloseResponseBodyGracefully(resp)
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, types.NewError(err, types.ErrorCodeReadResponseBodyFailed)
+	}
+	var result countTokensResponse
+	if err := common.Unmarshal(responseBody, &result); err != nil {
+		return 0, types.NewError(err, types.ErrorCodeBadResponseBody)
+	}
+	if result.InputTokens == nil {
+		return 0, types.NewError(fmt.Errorf("count_tokens response missing input_tokens: %s", string(responseBody)), types.ErrorCodeBadResponseBody)
+	}
+	return *result.InputTokens, nil
+}
@@ -0,0 +1,22 @@
+package gemini
+
+import (
+	"fmt"
+	"strings"
+
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+)
+
+func (a *Adaptor) GetGeminiResourceURL(info *relaycommon.RelayInfo, resource string) (string, error) {
+	version := "v1beta"
+	if modelAction, ok := strings.CutPrefix(resource, "models/"); ok {
+		modelName, _, _ := strings.Cut(modelAction, ":")
+		version = model_setting.GetGeminiVersionSetting(modelName)
+	}
+	return fmt.Sprintf("%s/%s/%s", info.ChannelBaseUrl, version, resource), nil
+}
+
+func (a *Adaptor) GetGeminiModelResource(info *relaycommon.RelayInfo, modelName string) (string, error) {
+	return "models/" + modelName, nil
+}
@@ -0,0 +1,40 @@
+package vertex
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/relay/channel"
+	"github.com/QuantumNous/new-api/relay/channel/claude"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SupportClaudeCountTokens Vertex 只有服务账号方式的 Claude 模型提供 count-tokens
+func (a *Adaptor) SupportClaudeCountTokens(info *relaycommon.RelayInfo) bool {
+	return a.RequestMode == RequestModeClaude && info.ChannelOtherSettings.VertexKeyType != dto.VertexKeyTypeAPIKey
+}
+
+func (a *Adaptor) CountClaudeTokens(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (int, *types.NewAPIError) {
+	countReq := request.ToCountTokensRequest()
+	if v, ok := claudeModelMap[info.UpstreamModelName]; ok {
+		countReq.Model = v
+	}
+	jsonData, err := common.Marshal(countReq)
+	if err != nil {
+		return 0, types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+	fullRequestURL, err := a.getRequestUrl(info, "count-tokens", "rawPredict")
+	if err != nil {
+		return 0, types.NewError(err, types.ErrorCodeChannelInvalidKey, types.ErrOptionWithSkipRetry())
+	}
+	resp, err := channel.DoApiRequestWithURL(a, c, info, fullRequestURL, bytes.NewReader(jsonData))
+	if err != nil {
+		return 0, types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+	}
+	return claude.HandleCountTokensResponse(c, resp)
+}
@@ -0,0 +1,62 @@
+package vertex
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+)
+
+// locationResource 返回 projects/{project}/locations/{region}，cachedContents 只支持服务账号方式
+func (a *Adaptor) locationResource(info *relaycommon.RelayInfo) (string, error) {
+	if info.ChannelOtherSettings.VertexKeyType == dto.VertexKeyTypeAPIKey {
+		return "", errors.New("vertex api key does not support cachedContents, use service account credentials instead")
+	}
+	adc := &Credentials{}
+	if err := common.Unmarshal([]byte(info.ApiKey), adc); err != nil {
+		return "", fmt.Errorf("failed to decode credentials file: %w", err)
+	}
+	a.AccountCredentials = *adc
+	region := GetModelRegion(info.ApiVersion, info.OriginModelName)
+	return fmt.Sprintf("projects/%s/locations/%s", adc.ProjectID, region), nil
+}
+
+func (a *Adaptor) GetGeminiResourceURL(info *relaycommon.RelayInfo, resource string) (string, error) {
+	if modelAction, ok := strings.CutPrefix(resource, "models/"); ok {
+		modelName, action, _ := strings.Cut(modelAction, ":")
+		return a.getRequestUrl(info, modelName, action)
+	}
+	location, err := a.locationResource(info)
+	if err != nil {
+		return "", err
+	}
+	region := GetModelRegion(info.ApiVersion, info.OriginModelName)
+	if region == "global" {
+		return fmt.Sprintf("https://aiplatform.googleapis.com/v1/%s/%s", location, resource), nil
+	}
+	return fmt.Sprintf("https://%s-aiplatform.googleapis.com/v1/%s/%s", region, location, resource), nil
+}
+
+func (a *Adaptor) GetGeminiModelResource(info *relaycommon.RelayInfo, modelName string) (string, error) {
+	location, err := a.locationResource(info)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/publishers/google/models/%s", location, modelName), nil
+}
+
+// expandCachedContentName Vertex 的 generateContent 需要完整的 cachedContent 资源路径
+func (a *Adaptor) expandCachedContentName(info *relaycommon.RelayInfo, request *dto.GeminiChatRequest) error {
+	if !strings.HasPrefix(request.CachedContent, "cachedContents/") {
+		return nil
+	}
+	location, err := a.locationResource(info)
+	if err != nil {
+		return err
+	}
+	request.CachedContent = location + "/" + request.CachedContent
+	return nil
+}
@@ -0,0 +1,69 @@
+package relay
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClaudeCountTokensHelper 处理 /v1/messages/count_tokens，渠道支持时转发上游，否则本地估算；不产生计费
+func ClaudeCountTokensHelper(c *gin.Context, info *relaycommon.RelayInfo) *types.NewAPIError {
+	info.InitChannelMeta(c)
+
+	claudeReq, ok := info.Request.(*dto.ClaudeRequest)
+	if !ok {
+		return types.NewErrorWithStatusCode(fmt.Errorf("invalid request type, expected *dto.ClaudeRequest, got %T", info.Request), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+
+	request, err := common.DeepCopy(claudeReq)
+	if err != nil {
+		return types.NewError(fmt.Errorf("failed to copy request to ClaudeRequest: %w", err), types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+	}
+
+	err = helper.ModelMappedHelper(c, info, request)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeChannelModelMappedError, types.ErrOptionWithSkipRetry())
+	}
+
+	if adaptor := GetAdaptor(info.ApiType); adaptor != nil {
+		adaptor.Init(info)
+		if counter, ok := adaptor.(channel.ClaudeTokenCounter); ok && counter.SupportClaudeCountTokens(info) {
+			tokens, newAPIError := counter.CountClaudeTokens(c, info, request)
+			if newAPIError == nil {
+				c.JSON(http.StatusOK, gin.H{"input_tokens": tokens})
+				return nil
+			}
+			if !shouldEstimateCountTokensLocally(newAPIError) {
+				service.ResetStatusCode(newAPIError, c.GetString("status_code_mapping"))
+				return newAPIError
+			}
+			logger.LogWarn(c, fmt.Sprintf("count_tokens upstream failed, fallback to local estimation: %s", newAPIError.Error()))
+		}
+	}
+
+	tokens, err := service.EstimateRequestToken(c, claudeReq.GetTokenCountMeta(), info)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeCountTokenFailed, types.ErrOptionWithSkipRetry())
+	}
+	c.JSON(http.StatusOK, gin.H{"input_tokens": tokens})
+	return nil
+}
+
+// shouldEstimateCountTokensLocally 上游未实现 count_tokens 或不可用时回退到本地估算，请求参数错误等直接返回给客户端
+func shouldEstimateCountTokensLocally(err *types.NewAPIError) bool {
+	switch err.StatusCode {
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return true
+	}
+	return err.StatusCode >= http.StatusInternalServerError || err.GetErrorCode() == types.ErrorCodeDoRequestFailed
+}
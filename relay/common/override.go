@@ -497,6 +497,11 @@ func tryParseOperations(paramOverride map[string]interface{}) ([]ParamOperation,
 	return operations, true
 }
 
+// CheckConditions 复用参数覆盖的条件匹配，jsonStr 中找不到的路径再到 contextJSON 中查找
+func CheckConditions(jsonStr, contextJSON string, conditions []ConditionOperation, logic string) (bool, error) {
+	return checkConditions(jsonStr, contextJSON, conditions, logic)
+}
+
 func checkConditions(jsonStr, contextJSON string, conditions []ConditionOperation, logic string) (bool, error) {
 	if len(conditions) == 0 {
 		return true, nil // 没有条件，直接通过
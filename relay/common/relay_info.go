@@ -1,6 +1,7 @@
 package common
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -103,7 +104,9 @@ type RelayInfo struct {
 	RequestURLPath         string
 	RequestHeaders         map[string]string
 	ShouldIncludeUsage     bool
-	DisablePing            bool // 是否禁止向下游发送自定义 Ping
+	DisablePing            bool            // 是否禁止向下游发送自定义 Ping
+	UpstreamContext        context.Context // 上游请求使用的 context，为空时不可取消；对冲请求用它取消落后的一方
+	StreamInterruptCode    types.ErrorCode // 流式响应因超时被中断时的错误码（stream_idle_timeout、total_timeout），记录到日志
 	ClientWs               *websocket.Conn
 	TargetWs               *websocket.Conn
 	InputAudioFormat       string
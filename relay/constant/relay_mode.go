@@ -52,6 +52,11 @@ const (
 	RelayModeGemini
 
 	RelayModeResponsesCompact
+
+	RelayModeClaudeCountTokens
+
+	RelayModeGeminiCountTokens
+	RelayModeGeminiCachedContents
 )
 
 func Path2RelayMode(path string) int {
@@ -84,10 +89,16 @@ func Path2RelayMode(path string) int {
 		relayMode = RelayModeAudioTranslation
 	} else if strings.HasPrefix(path, "/v1/rerank") {
 		relayMode = RelayModeRerank
+	} else if strings.HasPrefix(path, "/v1/messages/count_tokens") {
+		relayMode = RelayModeClaudeCountTokens
 	} else if strings.HasPrefix(path, "/v1/realtime") {
 		relayMode = RelayModeRealtime
+	} else if (strings.HasPrefix(path, "/v1beta/models") || strings.HasPrefix(path, "/v1/models")) && strings.HasSuffix(path, ":countTokens") {
+		relayMode = RelayModeGeminiCountTokens
 	} else if strings.HasPrefix(path, "/v1beta/models") || strings.HasPrefix(path, "/v1/models") {
 		relayMode = RelayModeGemini
+	} else if strings.HasPrefix(path, "/v1beta/cachedContents") {
+		relayMode = RelayModeGeminiCachedContents
 	} else if strings.HasPrefix(path, "/mj") {
 		relayMode = Path2RelayModeMidjourney(path)
 	}
@@ -24,6 +24,12 @@ func EmbeddingHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *
 		return types.NewErrorWithStatusCode(fmt.Errorf("invalid request type, expected *dto.EmbeddingRequest, got %T", info.Request), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
 	}
 
+	// embeddings 的结果是确定的，不需要检查 temperature
+	cacheSession := service.PrepareResponseCache(c, info, true, "")
+	if entry := cacheSession.Lookup(c, info); entry != nil {
+		return replayResponseCache(c, info, entry)
+	}
+
 	request, err := common.DeepCopy(embeddingReq)
 	if err != nil {
 		return types.NewError(fmt.Errorf("failed to copy request to EmbeddingRequest: %w", err), types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
@@ -60,7 +66,7 @@ func EmbeddingHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *
 	logger.LogDebug(c, fmt.Sprintf("converted embedding request body: %s", string(jsonData)))
 	requestBody := bytes.NewBuffer(jsonData)
 	statusCodeMappingStr := c.GetString("status_code_mapping")
-	resp, err := adaptor.DoRequest(c, info, requestBody)
+	resp, err := doRequestWithHedge(c, adaptor, info, requestBody)
 	if err != nil {
 		return types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
 	}
@@ -76,12 +82,15 @@ func EmbeddingHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *
 		}
 	}
 
+	restoreWriter := cacheSession.Capture(c)
 	usage, newAPIError := adaptor.DoResponse(c, httpResp, info)
+	restoreWriter()
 	if newAPIError != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
 		return newAPIError
 	}
+	cacheSession.Store(info, usage.(*dto.Usage))
 	postConsumeQuota(c, info, usage.(*dto.Usage))
 	return nil
 }
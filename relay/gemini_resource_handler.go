@@ -0,0 +1,267 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+type geminiCachedContentResponse struct {
+	Name          string `json:"name"`
+	CreateTime    string `json:"createTime"`
+	ExpireTime    string `json:"expireTime"`
+	UsageMetadata struct {
+		TotalTokenCount int `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+func getGeminiResourceRequester(info *relaycommon.RelayInfo) (channel.GeminiResourceRequester, bool) {
+	adaptor := GetAdaptor(info.ApiType)
+	if adaptor == nil {
+		return nil, false
+	}
+	adaptor.Init(info)
+	requester, ok := adaptor.(channel.GeminiResourceRequester)
+	return requester, ok
+}
+
+// doGeminiResourceRequest 使用请求本身的 HTTP 方法转发到上游资源地址
+func doGeminiResourceRequest(c *gin.Context, info *relaycommon.RelayInfo, requester channel.GeminiResourceRequester, resource string, body []byte) (*http.Response, *types.NewAPIError) {
+	adaptor, ok := requester.(channel.Adaptor)
+	if !ok {
+		return nil, types.NewError(fmt.Errorf("invalid adaptor type %T", requester), types.ErrorCodeInvalidApiType, types.ErrOptionWithSkipRetry())
+	}
+	fullRequestURL, err := requester.GetGeminiResourceURL(info, resource)
+	if err != nil {
+		return nil, types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	var requestBody io.Reader
+	if body != nil {
+		requestBody = bytes.NewReader(body)
+	}
+	resp, err := channel.DoApiRequestWithURL(adaptor, c, info, fullRequestURL, requestBody)
+	if err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+	}
+	if resp.StatusCode != http.StatusOK {
+		newAPIError := service.RelayErrorHandler(c.Request.Context(), resp, false)
+		service.ResetStatusCode(newAPIError, c.GetString("status_code_mapping"))
+		return nil, newAPIError
+	}
+	return resp, nil
+}
+
+// GeminiCountTokensHelper 处理 models/{model}:countTokens，渠道支持时转发上游，否则本地估算；不产生计费
+func GeminiCountTokensHelper(c *gin.Context, info *relaycommon.RelayInfo) *types.NewAPIError {
+	info.InitChannelMeta(c)
+
+	geminiReq, ok := info.Request.(*dto.GeminiChatRequest)
+	if !ok {
+		return types.NewErrorWithStatusCode(fmt.Errorf("invalid request type, expected *dto.GeminiChatRequest, got %T", info.Request), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+
+	err := helper.ModelMappedHelper(c, info, geminiReq)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeChannelModelMappedError, types.ErrOptionWithSkipRetry())
+	}
+
+	if requester, ok := getGeminiResourceRequester(info); ok {
+		responseBody, newAPIError := forwardGeminiCountTokens(c, info, requester)
+		if newAPIError == nil {
+			c.Data(http.StatusOK, "application/json", responseBody)
+			return nil
+		}
+		if !shouldEstimateCountTokensLocally(newAPIError) {
+			return newAPIError
+		}
+		logger.LogWarn(c, fmt.Sprintf("countTokens upstream failed, fallback to local estimation: %s", newAPIError.Error()))
+	}
+
+	tokens, err := service.EstimateRequestToken(c, geminiReq.GetTokenCountMeta(), info)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeCountTokenFailed, types.ErrOptionWithSkipRetry())
+	}
+	c.JSON(http.StatusOK, gin.H{"totalTokens": tokens})
+	return nil
+}
+
+func forwardGeminiCountTokens(c *gin.Context, info *relaycommon.RelayInfo, requester channel.GeminiResourceRequester) ([]byte, *types.NewAPIError) {
+	storage, err := common.GetBodyStorage(c)
+	if err != nil {
+		return nil, types.NewErrorWithStatusCode(err, types.ErrorCodeReadRequestBodyFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	body, err := storage.Bytes()
+	if err != nil {
+		return nil, types.NewErrorWithStatusCode(err, types.ErrorCodeReadRequestBodyFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	// generateContentRequest 中的 model 需要与上游模型一致
+	if gjson.GetBytes(body, "generateContentRequest.model").Exists() {
+		modelResource, err := requester.GetGeminiModelResource(info, info.UpstreamModelName)
+		if err != nil {
+			return nil, types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		}
+		body, err = sjson.SetBytes(body, "generateContentRequest.model", modelResource)
+		if err != nil {
+			return nil, types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+		}
+	}
+
+	resp, newAPIError := doGeminiResourceRequest(c, info, requester, "models/"+info.UpstreamModelName+":countTokens", body)
+	if newAPIError != nil {
+		return nil, newAPIError
+	}
+	defer service.CloseResponseBodyGracefully(resp)
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeReadResponseBodyFailed)
+	}
+	return responseBody, nil
+}
+
+// GeminiCachedContentCreateHelper 创建上下文缓存，按上游返回的 token 数与存储时长计费，并把缓存绑定到当前渠道
+func GeminiCachedContentCreateHelper(c *gin.Context, info *relaycommon.RelayInfo) *types.NewAPIError {
+	info.InitChannelMeta(c)
+
+	geminiReq, ok := info.Request.(*dto.GeminiChatRequest)
+	if !ok {
+		return types.NewErrorWithStatusCode(fmt.Errorf("invalid request type, expected *dto.GeminiChatRequest, got %T", info.Request), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	err := helper.ModelMappedHelper(c, info, geminiReq)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeChannelModelMappedError, types.ErrOptionWithSkipRetry())
+	}
+
+	requester, ok := getGeminiResourceRequester(info)
+	if !ok {
+		return types.NewErrorWithStatusCode(fmt.Errorf("channel type %d does not support cachedContents", info.ChannelType), types.ErrorCodeInvalidApiType, http.StatusBadRequest)
+	}
+
+	storage, err := common.GetBodyStorage(c)
+	if err != nil {
+		return types.NewErrorWithStatusCode(err, types.ErrorCodeReadRequestBodyFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	body, err := storage.Bytes()
+	if err != nil {
+		return types.NewErrorWithStatusCode(err, types.ErrorCodeReadRequestBodyFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	modelResource, err := requester.GetGeminiModelResource(info, info.UpstreamModelName)
+	if err != nil {
+		return types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	body, err = sjson.SetBytes(body, "model", modelResource)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+
+	resp, newAPIError := doGeminiResourceRequest(c, info, requester, "cachedContents", body)
+	if newAPIError != nil {
+		return newAPIError
+	}
+	defer service.CloseResponseBodyGracefully(resp)
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeReadResponseBodyFailed)
+	}
+	var created geminiCachedContentResponse
+	if err := common.Unmarshal(responseBody, &created); err != nil {
+		return types.NewError(err, types.ErrorCodeBadResponseBody)
+	}
+	name := service.NormalizeGeminiCachedContentName(created.Name)
+	if name == "" {
+		return types.NewError(fmt.Errorf("cachedContents response missing name: %s", string(responseBody)), types.ErrorCodeBadResponseBody)
+	}
+
+	now := time.Now()
+	createTime := parseGeminiTime(created.CreateTime, now)
+	expireTime := parseGeminiTime(created.ExpireTime, createTime.Add(time.Hour))
+	service.BindGeminiCachedContent(service.GeminiCachedContentBinding{
+		Name:      name,
+		UserId:    info.UserId,
+		ChannelId: info.ChannelId,
+		Model:     info.OriginModelName,
+		Group:     info.UsingGroup,
+	}, expireTime.Sub(now))
+
+	c.Data(http.StatusOK, "application/json", NormalizeGeminiCachedContentBody(responseBody))
+
+	// 存储费用 = 缓存 token 数 × 输入价格 × 存储小时数 × 存储倍率
+	storageHours := expireTime.Sub(createTime).Hours()
+	if storageHours < 0 {
+		storageHours = 0
+	}
+	if info.PriceData.OtherRatios == nil {
+		info.PriceData.OtherRatios = make(map[string]float64)
+	}
+	info.PriceData.OtherRatios["cache_storage_hours"] = storageHours
+	info.PriceData.OtherRatios["cache_storage_ratio"] = model_setting.GetGeminiSettings().CachedContentStorageRatio
+	usage := &dto.Usage{
+		PromptTokens: created.UsageMetadata.TotalTokenCount,
+		TotalTokens:  created.UsageMetadata.TotalTokenCount,
+	}
+	postConsumeQuota(c, info, usage, fmt.Sprintf("上下文缓存 %s", name))
+	return nil
+}
+
+// DoGeminiCachedContentRequest 在绑定渠道上查询 / 删除单个上下文缓存，返回已统一资源名的响应体
+func DoGeminiCachedContentRequest(c *gin.Context, info *relaycommon.RelayInfo, name string) ([]byte, *types.NewAPIError) {
+	info.InitChannelMeta(c)
+	requester, ok := getGeminiResourceRequester(info)
+	if !ok {
+		return nil, types.NewErrorWithStatusCode(fmt.Errorf("channel type %d does not support cachedContents", info.ChannelType), types.ErrorCodeInvalidApiType, http.StatusBadRequest)
+	}
+	resp, newAPIError := doGeminiResourceRequest(c, info, requester, name, nil)
+	if newAPIError != nil {
+		return nil, newAPIError
+	}
+	defer service.CloseResponseBodyGracefully(resp)
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeReadResponseBodyFailed)
+	}
+	return NormalizeGeminiCachedContentBody(responseBody), nil
+}
+
+// NormalizeGeminiCachedContentBody 把 Vertex 返回的完整资源路径改写为 Gemini API 格式
+func NormalizeGeminiCachedContentBody(body []byte) []byte {
+	if name := gjson.GetBytes(body, "name"); name.Exists() {
+		if updated, err := sjson.SetBytes(body, "name", service.NormalizeGeminiCachedContentName(name.String())); err == nil {
+			body = updated
+		}
+	}
+	if model := gjson.GetBytes(body, "model"); model.Exists() {
+		if idx := strings.LastIndex(model.String(), "models/"); idx > 0 {
+			if updated, err := sjson.SetBytes(body, "model", model.String()[idx:]); err == nil {
+				body = updated
+			}
+		}
+	}
+	return body
+}
+
+func parseGeminiTime(value string, fallback time.Time) time.Time {
+	if value == "" {
+		return fallback
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return fallback
+	}
+	return t
+}
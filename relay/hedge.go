@@ -0,0 +1,317 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/pkg/channelhealth"
+	"github.com/QuantumNous/new-api/pkg/circuitbreaker"
+	"github.com/QuantumNous/new-api/pkg/keystats"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+)
+
+type hedgeAttempt struct {
+	adaptor channel.Adaptor
+	info    *relaycommon.RelayInfo
+	channel *model.Channel
+	cancel  context.CancelFunc
+	done    chan struct{}
+	resp    any
+	err     error
+	elapsed time.Duration
+	// 在途请求统计，对冲请求发出时开始，主请求落败时才补记
+	healthDone func(outcome channelhealth.Outcome, latency time.Duration)
+	keyDone    func(statusCode int)
+}
+
+// doRequestWithHedge 替代 adaptor.DoRequest：主请求在对冲延迟内没有返回响应头时，
+// 用同一请求体向另一个同类型渠道再发一次，先成功返回的一方胜出，另一方被取消。
+// 计费只按胜出的请求结算，被取消的渠道记录在日志的 admin_info 中
+func doRequestWithHedge(c *gin.Context, adaptor channel.Adaptor, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
+	// 重试时不能沿用上一次对冲请求的 context
+	info.UpstreamContext = nil
+	delay, ok := service.GetHedgeDelay(c, info)
+	if !ok {
+		return adaptor.DoRequest(c, info, requestBody)
+	}
+	return hedgeRequest(c, adaptor, info, requestBody, delay, func(baseInfo *relaycommon.RelayInfo) *model.Channel {
+		return service.GetHedgeChannel(c, baseInfo)
+	})
+}
+
+func hedgeRequest(c *gin.Context, adaptor channel.Adaptor, info *relaycommon.RelayInfo, requestBody io.Reader, delay time.Duration, getHedgeChannel func(*relaycommon.RelayInfo) *model.Channel) (any, error) {
+	// 对冲请求使用独立的 adaptor、RelayInfo 与 gin.Context 副本，必须在主请求开始前复制，避免与主请求并发读写
+	hedgeAdaptor, ok := cloneAdaptor(adaptor)
+	if !ok {
+		return adaptor.DoRequest(c, info, requestBody)
+	}
+	var body []byte
+	if requestBody != nil {
+		var err error
+		body, err = io.ReadAll(requestBody)
+		if err != nil {
+			return nil, fmt.Errorf("read request body failed: %w", err)
+		}
+	}
+	if info.IsStream {
+		// 提前设置好响应头，两个请求都不会再写 header
+		helper.SetEventStreamHeaders(c)
+	}
+	hedgeCtx := c.Copy()
+	baseInfo := *info
+	baseMeta := *info.ChannelMeta
+	baseInfo.ChannelMeta = &baseMeta
+
+	primary := startHedgeAttempt(c, adaptor, info, nil, body)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-primary.done:
+		return primary.result()
+	case <-timer.C:
+	}
+
+	hedgeChannel := getHedgeChannel(&baseInfo)
+	if hedgeChannel == nil {
+		<-primary.done
+		return primary.result()
+	}
+	hedgeInfo, err := newHedgeRelayInfo(&baseInfo, hedgeChannel)
+	if err != nil {
+		// 没有发出请求，归还选择对冲渠道时占用的半开探测名额
+		circuitbreaker.Record(hedgeChannel.Id, circuitbreaker.ChannelScope, circuitbreaker.OutcomeIgnored)
+		logger.LogWarn(c, fmt.Sprintf("hedge channel #%d unavailable: %s", hedgeChannel.Id, err.Error()))
+		<-primary.done
+		return primary.result()
+	}
+	logger.LogInfo(c, fmt.Sprintf("channel #%d no response after %s, hedging to channel #%d", baseInfo.ChannelId, delay, hedgeChannel.Id))
+	hedge := startHedgeAttempt(hedgeCtx, hedgeAdaptor, hedgeInfo, hedgeChannel, body)
+
+	winner, loser := waitHedgeWinner(primary, hedge)
+	finished := loser.finished()
+	loser.abort()
+	recordHedgeLoser(loser, finished)
+	service.RecordHedgeCancelled(c, loser.info.ChannelId)
+	if winner == hedge {
+		// 胜出请求的最终结果由调用方按实际服务的渠道记录，这里只结束在途统计
+		hedge.healthDone(channelhealth.OutcomeIgnored, 0)
+		hedge.keyDone(0)
+		useHedgeChannel(c, adaptor, info, hedge)
+	}
+	return winner.result()
+}
+
+// cloneAdaptor 浅拷贝 adaptor，对冲请求在副本上执行 DoRequest，不会与主请求争用 adaptor 的字段
+func cloneAdaptor(adaptor channel.Adaptor) (channel.Adaptor, bool) {
+	v := reflect.ValueOf(adaptor)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, false
+	}
+	clone := reflect.New(v.Elem().Type())
+	clone.Elem().Set(v.Elem())
+	cloned, ok := clone.Interface().(channel.Adaptor)
+	return cloned, ok
+}
+
+func startHedgeAttempt(c *gin.Context, adaptor channel.Adaptor, info *relaycommon.RelayInfo, channel *model.Channel, body []byte) *hedgeAttempt {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	info.UpstreamContext = ctx
+	attempt := &hedgeAttempt{
+		adaptor: adaptor,
+		info:    info,
+		channel: channel,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	if channel != nil {
+		// 对冲请求的 gin.Context 是副本，使用独立的 http.Request，请求体由 body 提供
+		c.Request = c.Request.Clone(ctx)
+		c.Request.Body = http.NoBody
+		attempt.beginStats()
+	}
+	start := time.Now()
+	gopool.Go(func() {
+		defer close(attempt.done)
+		defer func() {
+			if r := recover(); r != nil {
+				attempt.err = fmt.Errorf("hedge request panic: %v", r)
+			}
+		}()
+		attempt.resp, attempt.err = adaptor.DoRequest(c, info, bytes.NewReader(body))
+		attempt.elapsed = time.Since(start)
+	})
+	return attempt
+}
+
+func (a *hedgeAttempt) succeeded() bool {
+	if a.err != nil {
+		return false
+	}
+	if resp, ok := a.resp.(*http.Response); ok {
+		return resp != nil && resp.StatusCode < http.StatusBadRequest
+	}
+	return a.resp != nil
+}
+
+func (a *hedgeAttempt) finished() bool {
+	select {
+	case <-a.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *hedgeAttempt) beginStats() {
+	a.healthDone = channelhealth.Begin(a.info.ChannelId, a.info.OriginModelName)
+	a.keyDone = func(int) {}
+	if a.info.ChannelIsMultiKey {
+		a.keyDone = keystats.Begin(a.info.ChannelId, a.info.ChannelMultiKeyIndex)
+	}
+}
+
+// apiError 把已结束请求的结果转换为与正常流程一致的错误，成功时返回 nil
+func (a *hedgeAttempt) apiError() *types.NewAPIError {
+	if a.err != nil {
+		return types.NewError(a.err, types.ErrorCodeDoRequestFailed)
+	}
+	if resp, ok := a.resp.(*http.Response); ok && resp != nil && resp.StatusCode >= http.StatusBadRequest {
+		// 与 service.RelayErrorHandler 一致，上游错误状态码按上游错误处理，响应体随请求一起丢弃
+		return types.InitOpenAIError(types.ErrorCodeBadResponseStatusCode, resp.StatusCode)
+	}
+	return nil
+}
+
+// recordHedgeLoser 把落败请求的结果计入熔断器、健康统计与密钥统计。
+// 被取消的请求不代表渠道状况，记为忽略并归还半开探测名额；已经结束的请求按实际结果记录
+func recordHedgeLoser(a *hedgeAttempt, finished bool) {
+	breakerOutcome, healthOutcome, statusCode := circuitbreaker.OutcomeIgnored, channelhealth.OutcomeIgnored, 0
+	if finished {
+		apiErr := a.apiError()
+		breakerOutcome = service.CircuitBreakerOutcome(apiErr)
+		healthOutcome = service.ChannelHealthOutcome(apiErr)
+		statusCode = http.StatusOK
+		if apiErr != nil {
+			statusCode = apiErr.StatusCode
+		}
+	}
+	if a.healthDone == nil {
+		// 主请求的在途统计由调用方负责，落败结果在这里单独补记
+		a.beginStats()
+	}
+	service.RecordCircuitBreaker(a.info.ChannelId, a.info.ChannelIsMultiKey, a.info.ChannelMultiKeyIndex, breakerOutcome)
+	a.healthDone(healthOutcome, a.elapsed)
+	a.keyDone(statusCode)
+}
+
+// result 返回胜出请求的结果；响应体关闭（即响应处理完毕）时取消该请求的 context，没有响应体时立即取消
+func (a *hedgeAttempt) result() (any, error) {
+	if resp, ok := a.resp.(*http.Response); ok && resp != nil && resp.Body != nil {
+		resp.Body = &cancelOnCloseBody{ReadCloser: resp.Body, cancel: a.cancel}
+	} else {
+		a.cancel()
+	}
+	return a.resp, a.err
+}
+
+// abort 取消请求并等待其结束，已经拿到的响应直接丢弃
+func (a *hedgeAttempt) abort() {
+	a.cancel()
+	<-a.done
+	if resp, ok := a.resp.(*http.Response); ok && resp != nil && resp.Body != nil {
+		_ = resp.Body.Close()
+	}
+}
+
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// waitHedgeWinner 先成功返回的请求胜出；两个都失败时以主请求的结果为准，保持原有的重试与错误处理逻辑
+func waitHedgeWinner(primary, hedge *hedgeAttempt) (*hedgeAttempt, *hedgeAttempt) {
+	first, second := primary, hedge
+	select {
+	case <-primary.done:
+	case <-hedge.done:
+		first, second = hedge, primary
+	}
+	if first.succeeded() {
+		return first, second
+	}
+	<-second.done
+	if second.succeeded() {
+		return second, first
+	}
+	return primary, hedge
+}
+
+func newHedgeRelayInfo(info *relaycommon.RelayInfo, hedgeChannel *model.Channel) (*relaycommon.RelayInfo, error) {
+	key, index, newAPIError := hedgeChannel.GetNextEnabledKey()
+	if newAPIError != nil {
+		return nil, newAPIError
+	}
+	channelMeta := *info.ChannelMeta
+	channelMeta.ChannelId = hedgeChannel.Id
+	channelMeta.ChannelIsMultiKey = hedgeChannel.ChannelInfo.IsMultiKey
+	channelMeta.ChannelMultiKeyIndex = index
+	channelMeta.ChannelBaseUrl = hedgeChannel.GetBaseURL()
+	channelMeta.ApiKey = key
+	channelMeta.Organization = lo.FromPtr(hedgeChannel.OpenAIOrganization)
+	channelMeta.ChannelCreateTime = hedgeChannel.CreatedTime
+	channelMeta.HeadersOverride = hedgeChannel.GetHeaderOverride()
+	channelMeta.ChannelSetting = hedgeChannel.GetSetting()
+	channelMeta.ChannelOtherSettings = hedgeChannel.GetOtherSettings()
+
+	hedgeInfo := *info
+	hedgeInfo.ChannelMeta = &channelMeta
+	// ping 保活由主请求负责，避免两个协程同时写下游
+	hedgeInfo.DisablePing = true
+	return &hedgeInfo, nil
+}
+
+// useHedgeChannel 对冲请求胜出后，后续的响应处理、计费和日志都按对冲渠道进行；
+// 此时主请求已结束，把对冲请求 adaptor 的状态复制回调用方持有的 adaptor，供 DoResponse 使用
+func useHedgeChannel(c *gin.Context, adaptor channel.Adaptor, info *relaycommon.RelayInfo, hedge *hedgeAttempt) {
+	reflect.ValueOf(adaptor).Elem().Set(reflect.ValueOf(hedge.adaptor).Elem())
+	info.ChannelMeta = hedge.info.ChannelMeta
+	info.UpstreamContext = hedge.info.UpstreamContext
+	hedgeChannel := hedge.channel
+	common.SetContextKey(c, constant.ContextKeyChannelId, hedgeChannel.Id)
+	common.SetContextKey(c, constant.ContextKeyChannelName, hedgeChannel.Name)
+	common.SetContextKey(c, constant.ContextKeyChannelCreateTime, hedgeChannel.CreatedTime)
+	common.SetContextKey(c, constant.ContextKeyChannelSetting, info.ChannelSetting)
+	common.SetContextKey(c, constant.ContextKeyChannelOtherSetting, info.ChannelOtherSettings)
+	common.SetContextKey(c, constant.ContextKeyChannelHeaderOverride, info.HeadersOverride)
+	common.SetContextKey(c, constant.ContextKeyChannelAutoBan, hedgeChannel.GetAutoBan())
+	common.SetContextKey(c, constant.ContextKeyChannelStatusCodeMapping, hedgeChannel.GetStatusCodeMapping())
+	common.SetContextKey(c, constant.ContextKeyChannelIsMultiKey, info.ChannelIsMultiKey)
+	common.SetContextKey(c, constant.ContextKeyChannelMultiKeyIndex, info.ChannelMultiKeyIndex)
+	common.SetContextKey(c, constant.ContextKeyChannelKey, info.ApiKey)
+	common.SetContextKey(c, constant.ContextKeyChannelBaseUrl, info.ChannelBaseUrl)
+	useChannel := c.GetStringSlice("use_channel")
+	c.Set("use_channel", append(useChannel, fmt.Sprintf("%d", hedgeChannel.Id)))
+}
@@ -0,0 +1,230 @@
+package relay
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/pkg/channelhealth"
+	"github.com/QuantumNous/new-api/pkg/circuitbreaker"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// hedgeTestUpstream 模拟各渠道的上游延迟，记录每个渠道请求使用的 context
+type hedgeTestUpstream struct {
+	mu     sync.Mutex
+	delays map[int]time.Duration
+	ctxs   map[int]context.Context
+	// statuses 各渠道返回的状态码，未设置时为 200
+	statuses map[int]int
+}
+
+func (u *hedgeTestUpstream) ctx(channelId int) context.Context {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.ctxs[channelId]
+}
+
+type hedgeTestAdaptor struct {
+	channel.Adaptor
+	upstream *hedgeTestUpstream
+	// 与 aws 等 adaptor 的 AwsReq 类似，在 DoRequest 中写入、DoResponse 中读取的状态
+	requestChannelId int
+}
+
+func (a *hedgeTestAdaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
+	a.requestChannelId = info.ChannelId
+	ctx := info.UpstreamContext
+	a.upstream.mu.Lock()
+	a.upstream.ctxs[info.ChannelId] = ctx
+	delay := a.upstream.delays[info.ChannelId]
+	status := a.upstream.statuses[info.ChannelId]
+	a.upstream.mu.Unlock()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	body, _ := io.ReadAll(requestBody)
+	select {
+	case <-time.After(delay):
+		return &http.Response{
+			StatusCode: status,
+			Body:       io.NopCloser(strings.NewReader(strconv.Itoa(info.ChannelId) + ":" + string(body))),
+		}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func newHedgeTestContext(t *testing.T, delays map[int]time.Duration) (*gin.Context, *hedgeTestAdaptor, *relaycommon.RelayInfo) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	common.SetContextKey(c, constant.ContextKeyChannelId, 1)
+	adaptor := &hedgeTestAdaptor{upstream: &hedgeTestUpstream{delays: delays, ctxs: map[int]context.Context{}}}
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{ChannelId: 1, ApiKey: "key-1"}}
+	return c, adaptor, info
+}
+
+func readHedgeResponse(t *testing.T, resp any) string {
+	t.Helper()
+	httpResp, ok := resp.(*http.Response)
+	require.True(t, ok)
+	body, err := io.ReadAll(httpResp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestHedgeRequestHedgeWins(t *testing.T) {
+	c, adaptor, info := newHedgeTestContext(t, map[int]time.Duration{1: time.Minute, 2: 0})
+	hedgeChannel := &model.Channel{Id: 2, Name: "hedge", Key: "key-2"}
+
+	resp, err := hedgeRequest(c, adaptor, info, strings.NewReader("body"), 10*time.Millisecond, func(*relaycommon.RelayInfo) *model.Channel {
+		return hedgeChannel
+	})
+	require.NoError(t, err)
+	require.Equal(t, "2:body", readHedgeResponse(t, resp))
+
+	// 主请求被取消，只记录为被取消的渠道
+	require.ErrorIs(t, adaptor.upstream.ctx(1).Err(), context.Canceled)
+	cancelled, _ := common.GetContextKeyType[[]int](c, constant.ContextKeyHedgeCancelledChannels)
+	require.Equal(t, []int{1}, cancelled)
+
+	// 后续的响应处理与计费按胜出的对冲渠道进行
+	require.Equal(t, 2, info.ChannelId)
+	require.Equal(t, "key-2", info.ApiKey)
+	require.Equal(t, 2, common.GetContextKeyInt(c, constant.ContextKeyChannelId))
+	require.Equal(t, []string{"2"}, c.GetStringSlice("use_channel"))
+	require.Equal(t, 2, adaptor.requestChannelId)
+
+	// 胜出请求的 context 在响应体关闭后才取消
+	require.NoError(t, adaptor.upstream.ctx(2).Err())
+	require.NoError(t, resp.(*http.Response).Body.Close())
+	require.ErrorIs(t, adaptor.upstream.ctx(2).Err(), context.Canceled)
+}
+
+func TestHedgeRequestPrimaryWins(t *testing.T) {
+	c, adaptor, info := newHedgeTestContext(t, map[int]time.Duration{1: 50 * time.Millisecond, 2: time.Minute})
+	hedgeChannel := &model.Channel{Id: 2, Name: "hedge", Key: "key-2"}
+
+	resp, err := hedgeRequest(c, adaptor, info, strings.NewReader("body"), 10*time.Millisecond, func(*relaycommon.RelayInfo) *model.Channel {
+		return hedgeChannel
+	})
+	require.NoError(t, err)
+	require.Equal(t, "1:body", readHedgeResponse(t, resp))
+
+	require.ErrorIs(t, adaptor.upstream.ctx(2).Err(), context.Canceled)
+	cancelled, _ := common.GetContextKeyType[[]int](c, constant.ContextKeyHedgeCancelledChannels)
+	require.Equal(t, []int{2}, cancelled)
+
+	// 对冲请求在 adaptor 副本上执行，不影响主请求的状态与计费渠道
+	require.Equal(t, 1, info.ChannelId)
+	require.Equal(t, 1, common.GetContextKeyInt(c, constant.ContextKeyChannelId))
+	require.Equal(t, 1, adaptor.requestChannelId)
+	require.Empty(t, c.GetStringSlice("use_channel"))
+	require.NoError(t, resp.(*http.Response).Body.Close())
+}
+
+func TestHedgeRequestNotStartedWhenPrimaryIsFast(t *testing.T) {
+	c, adaptor, info := newHedgeTestContext(t, map[int]time.Duration{1: 0})
+
+	resp, err := hedgeRequest(c, adaptor, info, strings.NewReader("body"), time.Minute, func(*relaycommon.RelayInfo) *model.Channel {
+		t.Fatal("hedge channel should not be selected")
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "1:body", readHedgeResponse(t, resp))
+	require.Nil(t, adaptor.upstream.ctx(2))
+	_, ok := common.GetContextKey(c, constant.ContextKeyHedgeCancelledChannels)
+	require.False(t, ok)
+}
+
+// setupHedgeStatsTest 开启熔断并清空测试渠道的熔断与健康统计
+func setupHedgeStatsTest(t *testing.T, channelIds ...int) {
+	t.Helper()
+	setting := operation_setting.GetCircuitBreakerSetting()
+	saved := *setting
+	setting.Enabled = true
+	setting.FailureThreshold = 3
+	reset := func() {
+		for _, channelId := range channelIds {
+			circuitbreaker.Reset(channelId)
+			channelhealth.Reset(channelId)
+		}
+	}
+	reset()
+	t.Cleanup(func() {
+		*setting = saved
+		reset()
+	})
+}
+
+func hedgeHealthSnapshot(t *testing.T, channelId int) channelhealth.Snapshot {
+	t.Helper()
+	snapshots := channelhealth.GetSnapshots(channelId)
+	require.Len(t, snapshots, 1)
+	return snapshots[0]
+}
+
+func TestHedgeRequestRecordsFailedLoser(t *testing.T) {
+	setupHedgeStatsTest(t, 1, 2)
+	c, adaptor, info := newHedgeTestContext(t, map[int]time.Duration{1: 30 * time.Millisecond, 2: 80 * time.Millisecond})
+	adaptor.upstream.statuses = map[int]int{1: http.StatusBadGateway}
+	info.OriginModelName = "gpt-4o"
+	hedgeChannel := &model.Channel{Id: 2, Name: "hedge", Key: "key-2"}
+
+	resp, err := hedgeRequest(c, adaptor, info, strings.NewReader("body"), 10*time.Millisecond, func(*relaycommon.RelayInfo) *model.Channel {
+		return hedgeChannel
+	})
+	require.NoError(t, err)
+	require.Equal(t, "2:body", readHedgeResponse(t, resp))
+	require.NoError(t, resp.(*http.Response).Body.Close())
+
+	// 主请求在对冲请求胜出前已经返回 502，按失败计入熔断与健康统计
+	statuses := circuitbreaker.GetStatuses(1)
+	require.Len(t, statuses, 1)
+	require.Equal(t, circuitbreaker.ChannelScope, statuses[0].KeyIndex)
+	require.Equal(t, 1, statuses[0].ConsecutiveFailures)
+	primary := hedgeHealthSnapshot(t, 1)
+	require.EqualValues(t, 1, primary.Samples)
+	require.Equal(t, 1, primary.ConsecutiveFailures)
+
+	// 胜出的对冲请求只结束在途统计，最终结果由调用方记录
+	hedge := hedgeHealthSnapshot(t, 2)
+	require.EqualValues(t, 0, hedge.Inflight)
+	require.EqualValues(t, 0, hedge.Samples)
+	require.Empty(t, circuitbreaker.GetStatuses(2))
+}
+
+func TestHedgeRequestCancelledLoserNotCountedAsFailure(t *testing.T) {
+	setupHedgeStatsTest(t, 1, 2)
+	c, adaptor, info := newHedgeTestContext(t, map[int]time.Duration{1: 50 * time.Millisecond, 2: time.Minute})
+	info.OriginModelName = "gpt-4o"
+	hedgeChannel := &model.Channel{Id: 2, Name: "hedge", Key: "key-2"}
+
+	resp, err := hedgeRequest(c, adaptor, info, strings.NewReader("body"), 10*time.Millisecond, func(*relaycommon.RelayInfo) *model.Channel {
+		return hedgeChannel
+	})
+	require.NoError(t, err)
+	require.Equal(t, "1:body", readHedgeResponse(t, resp))
+	require.NoError(t, resp.(*http.Response).Body.Close())
+
+	// 被取消的对冲请求不代表渠道状况：在途统计结束，但不计入失败
+	hedge := hedgeHealthSnapshot(t, 2)
+	require.EqualValues(t, 0, hedge.Inflight)
+	require.EqualValues(t, 0, hedge.Samples)
+	require.Empty(t, circuitbreaker.GetStatuses(2))
+}
@@ -0,0 +1,158 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// maxFirstTokenBufferSize 预读超过该大小仍未遇到有效增量时不再等待，直接透传，避免无限缓冲
+const maxFirstTokenBufferSize = 1 << 20
+
+var (
+	ErrStreamFirstTokenTimeout = errors.New("stream first token timeout")
+	ErrStreamClosedBeforeToken = errors.New("upstream stream closed before first token")
+)
+
+type streamLineKind int
+
+const (
+	streamLineSkip streamLineKind = iota
+	streamLineContent
+	streamLineError
+)
+
+type replayBody struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (r *replayBody) Close() error {
+	return r.closer.Close()
+}
+
+// AwaitStreamFirstToken 预读 SSE 响应，直到出现第一个有效增量（正文、工具调用、思考内容或结束标记）。
+// 在此之前上游报错、断开或超时都返回 error，此时尚未向客户端写出任何数据，调用方可以按普通渠道错误重试；
+// 成功时预读的数据会原样回放到 resp.Body，后续流处理不受影响
+func AwaitStreamFirstToken(ctx context.Context, resp *http.Response, timeout time.Duration) error {
+	reader := bufio.NewReaderSize(resp.Body, InitialScannerBufferSize)
+	var buffered bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		for {
+			line, err := reader.ReadBytes('\n')
+			buffered.Write(line)
+			switch classifyStreamLine(line) {
+			case streamLineContent:
+				done <- nil
+				return
+			case streamLineError:
+				done <- fmt.Errorf("upstream stream error before first token: %s", strings.TrimSpace(string(line)))
+				return
+			}
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					done <- ErrStreamClosedBeforeToken
+				} else {
+					done <- fmt.Errorf("read upstream stream failed before first token: %w", err)
+				}
+				return
+			}
+			if buffered.Len() > maxFirstTokenBufferSize {
+				done <- nil
+				return
+			}
+		}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case err = <-done:
+	case <-timer.C:
+		err = fmt.Errorf("%w: no token received within %s", ErrStreamFirstTokenTimeout, timeout)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		// 关闭响应体让预读协程退出
+		_ = resp.Body.Close()
+		return err
+	}
+	resp.Body = &replayBody{
+		Reader: io.MultiReader(bytes.NewReader(buffered.Bytes()), reader),
+		closer: resp.Body,
+	}
+	return nil
+}
+
+// classifyStreamLine 判断一行 SSE 数据是否为有效增量。只识别常见的“前导”事件（角色、ping、开始事件）和错误事件，
+// 其余未知格式一律视为有效增量，保证不认识的上游格式也能立即透传
+func classifyStreamLine(line []byte) streamLineKind {
+	data := strings.TrimSpace(string(line))
+	if !strings.HasPrefix(data, "data:") {
+		return streamLineSkip
+	}
+	data = strings.TrimSpace(strings.TrimPrefix(data, "data:"))
+	if data == "" {
+		return streamLineSkip
+	}
+	if strings.HasPrefix(data, "[DONE]") || !gjson.Valid(data) {
+		return streamLineContent
+	}
+	event := gjson.Parse(data)
+	if errorField := event.Get("error"); errorField.Exists() && errorField.Type != gjson.Null {
+		return streamLineError
+	}
+
+	switch eventType := event.Get("type").String(); eventType {
+	case "error", "response.failed":
+		return streamLineError
+	// Claude
+	case "message_start", "ping":
+		return streamLineSkip
+	case "content_block_start":
+		if event.Get("content_block.type").String() == "tool_use" {
+			return streamLineContent
+		}
+		return streamLineSkip
+	// Responses API
+	case "response.created", "response.in_progress", "response.content_part.added":
+		return streamLineSkip
+	case "response.output_item.added":
+		if event.Get("item.type").String() == "function_call" {
+			return streamLineContent
+		}
+		return streamLineSkip
+	case "":
+	default:
+		return streamLineContent
+	}
+
+	// OpenAI Chat Completions：只有角色、空内容且未结束的 chunk 视为前导
+	choices := event.Get("choices")
+	if !choices.Exists() {
+		return streamLineContent
+	}
+	for _, choice := range choices.Array() {
+		if choice.Get("finish_reason").String() != "" ||
+			choice.Get("text").String() != "" ||
+			choice.Get("delta.content").String() != "" ||
+			choice.Get("delta.reasoning_content").String() != "" ||
+			choice.Get("delta.reasoning").String() != "" ||
+			choice.Get("delta.tool_calls").Exists() ||
+			choice.Get("delta.function_call").Exists() {
+			return streamLineContent
+		}
+	}
+	return streamLineSkip
+}
@@ -0,0 +1,65 @@
+package helper
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyStreamLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want streamLineKind
+	}{
+		{`event: message_start`, streamLineSkip},
+		{`data: {"choices":[{"index":0,"delta":{"role":"assistant","content":""}}]}`, streamLineSkip},
+		{`data: {"choices":[{"index":0,"delta":{"content":"Hi"}}]}`, streamLineContent},
+		{`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0}]}}]}`, streamLineContent},
+		{`data: {"choices":[{"index":0,"delta":{"reasoning_content":"hmm"}}]}`, streamLineContent},
+		{`data: {"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`, streamLineContent},
+		{`data: {"choices":[],"error":null}`, streamLineSkip},
+		{`data: {"error":{"message":"overloaded"}}`, streamLineError},
+		{`data: {"type":"message_start","message":{}}`, streamLineSkip},
+		{`data: {"type":"content_block_start","content_block":{"type":"text","text":""}}`, streamLineSkip},
+		{`data: {"type":"content_block_start","content_block":{"type":"tool_use"}}`, streamLineContent},
+		{`data: {"type":"content_block_delta","delta":{"text":"Hi"}}`, streamLineContent},
+		{`data: {"type":"error","error":{"type":"overloaded_error"}}`, streamLineError},
+		{`data: {"type":"response.created"}`, streamLineSkip},
+		{`data: {"type":"response.output_text.delta","delta":"Hi"}`, streamLineContent},
+		{`data: {"candidates":[{"content":{"parts":[{"text":"Hi"}]}}]}`, streamLineContent},
+		{`data: [DONE]`, streamLineContent},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, classifyStreamLine([]byte(tt.line)), tt.line)
+	}
+}
+
+func TestAwaitStreamFirstTokenReplaysBufferedData(t *testing.T) {
+	body := "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n" +
+		"data: {\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\n" +
+		"data: [DONE]\n\n"
+	resp := &http.Response{Body: io.NopCloser(strings.NewReader(body))}
+
+	require.NoError(t, AwaitStreamFirstToken(context.Background(), resp, time.Second))
+	replayed, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, body, string(replayed))
+}
+
+func TestAwaitStreamFirstTokenErrors(t *testing.T) {
+	resp := &http.Response{Body: io.NopCloser(strings.NewReader("data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n"))}
+	require.ErrorIs(t, AwaitStreamFirstToken(context.Background(), resp, time.Second), ErrStreamClosedBeforeToken)
+
+	resp = &http.Response{Body: io.NopCloser(strings.NewReader("data: {\"error\":{\"message\":\"overloaded\"}}\n\n"))}
+	require.ErrorContains(t, AwaitStreamFirstToken(context.Background(), resp, time.Second), "overloaded")
+
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	resp = &http.Response{Body: reader}
+	require.ErrorIs(t, AwaitStreamFirstToken(context.Background(), resp, 50*time.Millisecond), ErrStreamFirstTokenTimeout)
+}
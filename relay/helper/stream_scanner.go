@@ -15,6 +15,7 @@ import (
 	"github.com/QuantumNous/new-api/logger"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
 
 	"github.com/bytedance/gopkg/util/gopool"
 
@@ -48,6 +49,9 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 	}()
 
 	streamingTimeout := time.Duration(constant.StreamingTimeout) * time.Second
+	if info.ChannelMeta != nil && info.ChannelSetting.StreamIdleTimeout > 0 {
+		streamingTimeout = time.Duration(info.ChannelSetting.StreamIdleTimeout) * time.Second
+	}
 
 	var (
 		stopChan   = make(chan bool, 3) // 增加缓冲区避免阻塞
@@ -265,6 +269,9 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 			if err != io.EOF {
 				logger.LogError(c, "scanner error: "+err.Error())
 			}
+			if strings.Contains(err.Error(), "Client.Timeout exceeded") {
+				info.StreamInterruptCode = types.ErrorCodeTotalTimeout
+			}
 		}
 	})
 
@@ -273,6 +280,7 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 	case <-ticker.C:
 		// 超时处理逻辑
 		logger.LogError(c, "streaming timeout")
+		info.StreamInterruptCode = types.ErrorCodeStreamIdleTimeout
 	case <-stopChan:
 		// 正常结束
 		logger.LogInfo(c, "streaming finished")
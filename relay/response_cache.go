@@ -0,0 +1,35 @@
+package relay
+
+import (
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responseCacheSemanticText 语义缓存只对对话补全生效，按全部消息文本计算向量
+func responseCacheSemanticText(info *relaycommon.RelayInfo, request *dto.GeneralOpenAIRequest) string {
+	setting := operation_setting.GetResponseCacheSetting()
+	if !setting.Enabled || !setting.SemanticEnabled || info.RelayMode != relayconstant.RelayModeChatCompletions {
+		return ""
+	}
+	meta := request.GetTokenCountMeta()
+	if meta == nil {
+		return ""
+	}
+	return meta.CombineText
+}
+
+// replayResponseCache 使用缓存的响应回复客户端，并按命中倍率结算
+func replayResponseCache(c *gin.Context, info *relaycommon.RelayInfo, entry *service.ResponseCacheEntry) *types.NewAPIError {
+	service.ReplayResponseCache(c, entry)
+	info.IsStream = entry.IsStream
+	service.ApplyResponseCacheHitBilling(info)
+	usage := entry.Usage
+	postConsumeQuota(c, info, &usage, "命中响应缓存")
+	return nil
+}
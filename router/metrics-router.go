@@ -0,0 +1,13 @@
+package router
+
+import (
+	"github.com/QuantumNous/new-api/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// SetMetricsRouter 注册 Prometheus 抓取端点，仅在 METRICS_ENABLED=true 时启用
+func SetMetricsRouter(router *gin.Engine) {
+	router.GET("/metrics", middleware.RouteTag("metrics"), middleware.MetricsAuth(), gin.WrapH(promhttp.Handler()))
+}
@@ -15,6 +15,7 @@ func SetRelayRouter(router *gin.Engine) {
 	router.Use(middleware.DecompressRequestMiddleware())
 	router.Use(middleware.BodyStorageCleanup()) // 清理请求体存储
 	router.Use(middleware.StatsMiddleware())
+	router.Use(middleware.Tracing())
 	// https://platform.openai.com/docs/api-reference/introduction
 	modelsRouter := router.Group("/v1/models")
 	modelsRouter.Use(middleware.RouteTag("relay"))
@@ -79,6 +80,19 @@ func SetRelayRouter(router *gin.Engine) {
 			controller.Relay(c, types.RelayFormatOpenAIRealtime)
 		})
 	}
+	{
+		// files & batches 不直接转发上游，无需 Distribute
+		batchRouter := relayV1Router.Group("")
+		batchRouter.GET("/files", controller.ListRelayFiles)
+		batchRouter.POST("/files", controller.UploadRelayFile)
+		batchRouter.GET("/files/:id", controller.GetRelayFile)
+		batchRouter.DELETE("/files/:id", controller.DeleteRelayFile)
+		batchRouter.GET("/files/:id/content", controller.GetRelayFileContent)
+		batchRouter.POST("/batches", controller.CreateBatch)
+		batchRouter.GET("/batches", controller.ListBatches)
+		batchRouter.GET("/batches/:id", controller.GetBatch)
+		batchRouter.POST("/batches/:id/cancel", controller.CancelBatch)
+	}
 	{
 		//http router
 		httpRouter := relayV1Router.Group("")
@@ -88,6 +102,7 @@ func SetRelayRouter(router *gin.Engine) {
 		httpRouter.POST("/messages", func(c *gin.Context) {
 			controller.Relay(c, types.RelayFormatClaude)
 		})
+		httpRouter.POST("/messages/count_tokens", controller.RelayClaudeCountTokens)
 
 		// chat related routes
 		httpRouter.POST("/completions", func(c *gin.Context) {
@@ -141,9 +156,7 @@ func SetRelayRouter(router *gin.Engine) {
 		httpRouter.POST("/engines/:model/embeddings", func(c *gin.Context) {
 			controller.Relay(c, types.RelayFormatGemini)
 		})
-		httpRouter.POST("/models/*path", func(c *gin.Context) {
-			controller.Relay(c, types.RelayFormatGemini)
-		})
+		httpRouter.POST("/models/*path", controller.RelayGemini)
 
 		// other relay routes
 		httpRouter.POST("/moderations", func(c *gin.Context) {
@@ -152,11 +165,6 @@ func SetRelayRouter(router *gin.Engine) {
 
 		// not implemented
 		httpRouter.POST("/images/variations", controller.RelayNotImplemented)
-		httpRouter.GET("/files", controller.RelayNotImplemented)
-		httpRouter.POST("/files", controller.RelayNotImplemented)
-		httpRouter.DELETE("/files/:id", controller.RelayNotImplemented)
-		httpRouter.GET("/files/:id", controller.RelayNotImplemented)
-		httpRouter.GET("/files/:id/content", controller.RelayNotImplemented)
 		httpRouter.POST("/fine-tunes", controller.RelayNotImplemented)
 		httpRouter.GET("/fine-tunes", controller.RelayNotImplemented)
 		httpRouter.GET("/fine-tunes/:id", controller.RelayNotImplemented)
@@ -191,10 +199,18 @@ func SetRelayRouter(router *gin.Engine) {
 	relayGeminiRouter.Use(middleware.SystemPerformanceCheck())
 	relayGeminiRouter.Use(middleware.TokenAuth())
 	relayGeminiRouter.Use(middleware.ModelRequestRateLimit())
-	relayGeminiRouter.Use(middleware.Distribute())
+	{
+		// 上下文缓存的查询 / 删除固定转发到创建渠道，不经过 Distribute
+		relayGeminiRouter.GET("/cachedContents", controller.ListGeminiCachedContents)
+		relayGeminiRouter.GET("/cachedContents/:id", controller.GetGeminiCachedContent)
+		relayGeminiRouter.DELETE("/cachedContents/:id", controller.DeleteGeminiCachedContent)
+	}
+	relayGeminiDistributeRouter := relayGeminiRouter.Group("")
+	relayGeminiDistributeRouter.Use(middleware.Distribute())
 	{
 		// Gemini API 路径格式: /v1beta/models/{model_name}:{action}
-		relayGeminiRouter.POST("/models/*path", func(c *gin.Context) {
+		relayGeminiDistributeRouter.POST("/models/*path", controller.RelayGemini)
+		relayGeminiDistributeRouter.POST("/cachedContents", func(c *gin.Context) {
 			controller.Relay(c, types.RelayFormatGemini)
 		})
 	}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/pkg/tracing"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/types"
 	"github.com/gin-gonic/gin"
@@ -31,6 +32,7 @@ func PreConsumeBilling(c *gin.Context, preConsumedQuota int, relayInfo *relaycom
 
 // SettleBilling 执行计费结算。如果 RelayInfo 上有 BillingSession 则通过 session 结算，
 // 否则回退到旧的 PostConsumeQuota 路径（兼容按次计费等场景）。
+// 结算成功后把实际消耗累计到多密钥渠道的当前密钥。
 func SettleBilling(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, actualQuota int) error {
 	if relayInfo.Billing != nil {
 		preConsumed := relayInfo.Billing.GetPreConsumedQuota()
@@ -54,9 +56,22 @@ func SettleBilling(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, actualQuo
 			))
 		}
 
+		span := tracing.StartSpan(ctx, "BillingSession.Settle",
+			tracing.String("request.id", relayInfo.RequestId),
+			tracing.String("billing.source", relayInfo.BillingSource),
+			tracing.Int("billing.pre_consumed", preConsumed),
+			tracing.Int("billing.actual", actualQuota),
+		)
 		if err := relayInfo.Billing.Settle(actualQuota); err != nil {
+			span.SetAttributes(tracing.String("billing.outcome", "failed"))
+			span.SetError(err)
+			span.End()
 			return err
 		}
+		span.SetAttributes(tracing.String("billing.outcome", settleOutcome(delta)))
+		span.SetOk()
+		span.End()
+		RecordChannelKeyQuota(relayInfo, actualQuota)
 
 		// 发送额度通知（订阅计费使用订阅剩余额度）
 		if actualQuota != 0 {
@@ -72,7 +87,22 @@ func SettleBilling(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, actualQuo
 	// 回退：无 BillingSession 时使用旧路径
 	quotaDelta := actualQuota - relayInfo.FinalPreConsumedQuota
 	if quotaDelta != 0 {
-		return PostConsumeQuota(relayInfo, quotaDelta, relayInfo.FinalPreConsumedQuota, true)
+		if err := PostConsumeQuota(relayInfo, quotaDelta, relayInfo.FinalPreConsumedQuota, true); err != nil {
+			return err
+		}
 	}
+	recordChildTokenSpend(ctx, actualQuota)
+	RecordChannelKeyQuota(relayInfo, actualQuota)
 	return nil
 }
+
+func settleOutcome(delta int) string {
+	switch {
+	case delta > 0:
+		return "supplement"
+	case delta < 0:
+		return "refund"
+	default:
+		return "exact"
+	}
+}
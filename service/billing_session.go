@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
@@ -25,8 +26,10 @@ import (
 type BillingSession struct {
 	relayInfo        *relaycommon.RelayInfo
 	funding          FundingSource
-	preConsumedQuota int  // 实际预扣额度（信任用户可能为 0）
-	tokenConsumed    int  // 令牌额度实际扣减量
+	preConsumedQuota int // 实际预扣额度（信任用户可能为 0）
+	tokenConsumed    int // 令牌额度实际扣减量
+	childToken       *model.ChildTokenClaims
+	childReserved    int  // 子令牌 max_quota 的占用量
 	fundingSettled   bool // funding.Settle 已成功，资金来源已提交
 	settled          bool // Settle 全部完成（资金 + 令牌）
 	refunded         bool // Refund 已调用
@@ -68,7 +71,13 @@ func (s *BillingSession) Settle(actualQuota int) error {
 				s.relayInfo.UserId, s.relayInfo.TokenId, delta, tokenErr.Error()))
 		}
 	}
-	// 3) 更新 relayInfo 上的订阅 PostDelta（用于日志）
+	// 3) 调整子令牌已用额度
+	if s.childToken != nil {
+		if err := addChildTokenSpend(s.childToken, delta); err != nil {
+			common.SysLog(fmt.Sprintf("failed to adjust child token %s spend (delta=%d): %s", s.childToken.ID, delta, err.Error()))
+		}
+	}
+	// 4) 更新 relayInfo 上的订阅 PostDelta（用于日志）
 	if s.funding.Source() == BillingSourceSubscription {
 		s.relayInfo.SubscriptionPostDelta += int64(delta)
 	}
@@ -97,6 +106,7 @@ func (s *BillingSession) Refund(c *gin.Context) {
 	tokenKey := s.relayInfo.TokenKey
 	isPlayground := s.relayInfo.IsPlayground
 	tokenConsumed := s.tokenConsumed
+	childToken, childReserved := s.childToken, s.childReserved
 	funding := s.funding
 
 	gopool.Go(func() {
@@ -110,6 +120,12 @@ func (s *BillingSession) Refund(c *gin.Context) {
 				common.SysLog("error refunding token quota: " + err.Error())
 			}
 		}
+		// 3) 释放子令牌额度占用
+		if childToken != nil && childReserved > 0 {
+			if err := addChildTokenSpend(childToken, -childReserved); err != nil {
+				common.SysLog("error releasing child token spend: " + err.Error())
+			}
+		}
 	})
 }
 
@@ -148,6 +164,7 @@ func (s *BillingSession) GetPreConsumedQuota() int {
 // 任一步骤失败时原子回滚已完成的步骤。
 func (s *BillingSession) preConsume(c *gin.Context, quota int) *types.NewAPIError {
 	effectiveQuota := quota
+	s.childToken = getLimitedChildTokenClaims(c)
 
 	// ---- 信任额度旁路 ----
 	if s.shouldTrust(c) {
@@ -157,9 +174,16 @@ func (s *BillingSession) preConsume(c *gin.Context, quota int) *types.NewAPIErro
 		logger.LogInfo(c, fmt.Sprintf("用户 %d 需要预扣费 %s (funding=%s)", s.relayInfo.UserId, logger.FormatQuota(effectiveQuota), s.funding.Source()))
 	}
 
-	// ---- 1) 预扣令牌额度 ----
+	// ---- 1) 预扣令牌额度（子令牌先占用 max_quota） ----
 	if effectiveQuota > 0 {
+		if s.childToken != nil {
+			if err := reserveChildTokenSpend(s.childToken, effectiveQuota); err != nil {
+				return types.NewErrorWithStatusCode(err, types.ErrorCodePreConsumeTokenQuotaFailed, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+			}
+			s.childReserved = effectiveQuota
+		}
 		if err := PreConsumeTokenQuota(s.relayInfo, effectiveQuota); err != nil {
+			s.releaseChildReserved()
 			return types.NewErrorWithStatusCode(err, types.ErrorCodePreConsumeTokenQuotaFailed, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
 		}
 		s.tokenConsumed = effectiveQuota
@@ -175,6 +199,7 @@ func (s *BillingSession) preConsume(c *gin.Context, quota int) *types.NewAPIErro
 			}
 			s.tokenConsumed = 0
 		}
+		s.releaseChildReserved()
 		// TODO: model 层应定义哨兵错误（如 ErrNoActiveSubscription），用 errors.Is 替代字符串匹配
 		errMsg := err.Error()
 		if strings.Contains(errMsg, "no active subscription") || strings.Contains(errMsg, "subscription quota insufficient") {
@@ -191,6 +216,17 @@ func (s *BillingSession) preConsume(c *gin.Context, quota int) *types.NewAPIErro
 	return nil
 }
 
+// releaseChildReserved 预扣失败时释放子令牌额度占用
+func (s *BillingSession) releaseChildReserved() {
+	if s.childToken == nil || s.childReserved <= 0 {
+		return
+	}
+	if err := addChildTokenSpend(s.childToken, -s.childReserved); err != nil {
+		common.SysLog(fmt.Sprintf("error releasing child token %s spend: %s", s.childToken.ID, err.Error()))
+	}
+	s.childReserved = 0
+}
+
 // shouldTrust 统一信任额度检查，适用于钱包和订阅。
 func (s *BillingSession) shouldTrust(c *gin.Context) bool {
 	// 异步任务（ForcePreConsume=true）必须预扣全额，不允许信任旁路
@@ -203,6 +239,16 @@ func (s *BillingSession) shouldTrust(c *gin.Context) bool {
 		return false
 	}
 
+	// 配置了周期预算的令牌需要预扣，才能在 PreConsumeTokenQuota 中检查预算
+	if common.GetContextKeyBool(c, constant.ContextKeyTokenBudget) {
+		return false
+	}
+
+	// 设置了 max_quota 的子令牌需要预扣以占用额度
+	if s.childToken != nil {
+		return false
+	}
+
 	// 检查令牌是否充足
 	tokenTrusted := s.relayInfo.TokenUnlimited
 	if !tokenTrusted {
@@ -8,9 +8,15 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/pkg/keystats"
+	"github.com/QuantumNous/new-api/pkg/metrics"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
 )
 
 func formatNotifyType(channelId int, status int) string {
@@ -29,6 +35,7 @@ func DisableChannel(channelError types.ChannelError, reason string) {
 
 	success := model.UpdateChannelStatus(channelError.ChannelId, channelError.UsingKey, common.ChannelStatusAutoDisabled, reason)
 	if success {
+		metrics.IncChannelAutoDisabled(channelError.ChannelId, channelError.ChannelType)
 		subject := fmt.Sprintf("通道「%s」（#%d）已被禁用", channelError.ChannelName, channelError.ChannelId)
 		content := fmt.Sprintf("通道「%s」（#%d）已被禁用，原因：%s", channelError.ChannelName, channelError.ChannelId, reason)
 		NotifyRootUser(formatNotifyType(channelError.ChannelId, common.ChannelStatusAutoDisabled), subject, content)
@@ -57,6 +64,10 @@ func ShouldDisableChannel(channelType int, err *types.NewAPIError) bool {
 	if types.IsSkipRetryError(err) {
 		return false
 	}
+	// 响应慢属于暂时性问题，不自动禁用；连接超时仍按状态码规则处理
+	if types.IsTimeoutError(err) && err.GetErrorCode() != types.ErrorCodeConnectTimeout {
+		return false
+	}
 	if operation_setting.ShouldDisableByStatusCode(err.StatusCode) {
 		return true
 	}
@@ -113,3 +124,55 @@ func ShouldEnableChannel(newAPIError *types.NewAPIError, status int) bool {
 	}
 	return true
 }
+
+// RecordChannelKeyTokens 多密钥渠道记录当前密钥实际消耗的 token，供限流感知调度计算 TPM 余量
+func RecordChannelKeyTokens(relayInfo *relaycommon.RelayInfo, tokens int) {
+	if !relayInfo.ChannelIsMultiKey {
+		return
+	}
+	keystats.AddTokens(relayInfo.ChannelId, relayInfo.ChannelMultiKeyIndex, tokens)
+}
+
+// RecordChannelKeyQuota 多密钥渠道把实际消耗的额度累计到当前密钥，超过密钥额度上限时只禁用该密钥；
+// 由结算路径（SettleBilling、实时音频、任务差额结算等）调用
+func RecordChannelKeyQuota(relayInfo *relaycommon.RelayInfo, quota int) {
+	if !relayInfo.ChannelIsMultiKey {
+		return
+	}
+	recordChannelKeyQuota(relayInfo.ChannelId, relayInfo.ChannelMultiKeyIndex, quota)
+}
+
+func recordChannelKeyQuota(channelId int, keyIndex int, quota int) {
+	if quota <= 0 {
+		return
+	}
+	gopool.Go(func() {
+		used, err := model.IncreaseChannelKeyUsage(channelId, keyIndex, quota)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to update key usage of channel #%d key %d: %s", channelId, keyIndex, err.Error()))
+			return
+		}
+		checkChannelKeyQuotaLimit(channelId, keyIndex, used)
+	})
+}
+
+func checkChannelKeyQuotaLimit(channelId int, keyIndex int, used int64) {
+	channel, err := model.CacheGetChannel(channelId)
+	if err != nil {
+		return
+	}
+	limit := channel.ChannelInfo.MultiKeyQuotaLimit[keyIndex]
+	keys := channel.GetKeys()
+	if limit <= 0 || used < int64(limit) || keyIndex >= len(keys) {
+		return
+	}
+	if status, ok := channel.ChannelInfo.MultiKeyStatusList[keyIndex]; ok && status != common.ChannelStatusEnabled {
+		return
+	}
+	reason := fmt.Sprintf("密钥 #%d 累计用量 %s 已达到额度上限 %s", keyIndex, logger.FormatQuota(int(used)), logger.FormatQuota(limit))
+	if model.UpdateChannelStatus(channelId, keys[keyIndex], common.ChannelStatusAutoDisabled, reason) {
+		subject := fmt.Sprintf("通道「%s」（#%d）的密钥 #%d 已被禁用", channel.Name, channelId, keyIndex)
+		content := fmt.Sprintf("通道「%s」（#%d）的密钥 #%d 已被禁用，原因：%s", channel.Name, channelId, keyIndex, reason)
+		NotifyRootUser(formatNotifyType(channelId, common.ChannelStatusAutoDisabled), subject, content)
+	}
+}
@@ -686,6 +686,47 @@ func RecordChannelAffinity(c *gin.Context, channelID int) {
 	}
 }
 
+// BindChannelAffinity 不经过请求直接写入亲和绑定（如资源创建后把资源 ID 固定到创建它的渠道），键与 GetPreferredChannelByAffinity 的计算方式一致
+func BindChannelAffinity(ruleName string, usingGroup string, affinityValue string, channelID int, ttl time.Duration) bool {
+	setting := operation_setting.GetChannelAffinitySetting()
+	if setting == nil || !setting.Enabled || channelID <= 0 || affinityValue == "" {
+		return false
+	}
+	for _, rule := range setting.Rules {
+		if rule.Name != ruleName {
+			continue
+		}
+		if ttl <= 0 {
+			ttl = time.Duration(setting.DefaultTTLSeconds) * time.Second
+		}
+		cacheKey := channelAffinityCacheNamespace + ":" + buildChannelAffinityCacheKeySuffix(rule, usingGroup, affinityValue)
+		if err := getChannelAffinityCache().SetWithTTL(cacheKey, channelID, ttl); err != nil {
+			common.SysError(fmt.Sprintf("channel affinity cache set failed: key=%s, err=%v", cacheKey, err))
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// UnbindChannelAffinity 删除 BindChannelAffinity 写入的绑定
+func UnbindChannelAffinity(ruleName string, usingGroup string, affinityValue string) {
+	setting := operation_setting.GetChannelAffinitySetting()
+	if setting == nil || affinityValue == "" {
+		return
+	}
+	for _, rule := range setting.Rules {
+		if rule.Name != ruleName {
+			continue
+		}
+		cacheKey := channelAffinityCacheNamespace + ":" + buildChannelAffinityCacheKeySuffix(rule, usingGroup, affinityValue)
+		if _, err := getChannelAffinityCache().DeleteMany([]string{cacheKey}); err != nil {
+			common.SysError(fmt.Sprintf("channel affinity cache delete failed: key=%s, err=%v", cacheKey, err))
+		}
+		return
+	}
+}
+
 type ChannelAffinityUsageCacheStats struct {
 	RuleName            string `json:"rule_name"`
 	UsingGroup          string `json:"using_group"`
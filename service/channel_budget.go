@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+const (
+	channelBudgetTickInterval    = 15 * time.Second
+	channelBudgetCleanupInterval = time.Hour
+	// 历史周期记录保留时长，覆盖上一个自然月
+	channelBudgetRetention      = 62 * 24 * time.Hour
+	defaultChannelBudgetPercent = 80
+)
+
+var (
+	channelBudgetOnce        sync.Once
+	channelBudgetRunning     atomic.Bool
+	channelBudgetCleanupLast atomic.Int64
+)
+
+// StartChannelBudgetTask 每个节点定期把本节点的渠道消耗写入预算周期并刷新预算状态，
+// 主节点额外负责提醒、暂停与恢复渠道
+func StartChannelBudgetTask() {
+	channelBudgetOnce.Do(func() {
+		gopool.Go(func() {
+			logger.LogInfo(context.Background(), fmt.Sprintf("channel budget task started: tick=%s", channelBudgetTickInterval))
+			ticker := time.NewTicker(channelBudgetTickInterval)
+			defer ticker.Stop()
+
+			runChannelBudgetOnce()
+			for range ticker.C {
+				runChannelBudgetOnce()
+			}
+		})
+	})
+}
+
+// evaluateChannelBudget 按当前周期消耗计算预算状态，未配置预算返回 nil
+func evaluateChannelBudget(setting dto.ChannelSettings, dailyUsed int64, monthlyUsed int64) *model.ChannelBudgetStatus {
+	if setting.DailyBudget <= 0 && setting.MonthlyBudget <= 0 {
+		return nil
+	}
+	percent := setting.BudgetAlertPercent
+	if percent <= 0 || percent > 100 {
+		percent = defaultChannelBudgetPercent
+	}
+	status := &model.ChannelBudgetStatus{
+		DailyBudget:   setting.DailyBudget,
+		DailyUsed:     dailyUsed,
+		MonthlyBudget: setting.MonthlyBudget,
+		MonthlyUsed:   monthlyUsed,
+		AlertPercent:  percent,
+	}
+	check := func(budget int, used int64) (alert bool, exhausted bool) {
+		if budget <= 0 {
+			return false, false
+		}
+		return used*100 >= int64(budget)*int64(percent), used >= int64(budget)
+	}
+	var dailyExhausted, monthlyExhausted bool
+	status.DailyAlert, dailyExhausted = check(setting.DailyBudget, dailyUsed)
+	status.MonthlyAlert, monthlyExhausted = check(setting.MonthlyBudget, monthlyUsed)
+	status.Exhausted = dailyExhausted || monthlyExhausted
+	return status
+}
+
+func runChannelBudgetOnce() {
+	if !channelBudgetRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer channelBudgetRunning.Store(false)
+
+	ctx := context.Background()
+	channels, err := model.GetBudgetedChannels()
+	if err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("channel budget task failed: %v", err))
+		return
+	}
+	settings := make(map[int]dto.ChannelSettings, len(channels))
+	channelIds := make([]int, 0, len(channels))
+	for _, channel := range channels {
+		setting := channel.GetSetting()
+		if setting.DailyBudget <= 0 && setting.MonthlyBudget <= 0 {
+			continue
+		}
+		settings[channel.Id] = setting
+		channelIds = append(channelIds, channel.Id)
+	}
+
+	now := time.Now()
+	dailyPeriod, monthlyPeriod := model.DailyBudgetPeriod(now), model.MonthlyBudgetPeriod(now)
+	// 未配置预算的渠道不需要记录周期消耗
+	for key, quota := range model.TakePendingChannelBudgetSpend() {
+		if _, ok := settings[key.ChannelId]; !ok {
+			continue
+		}
+		if err := model.IncreaseChannelBudgetUsage(key.ChannelId, key.Period, quota); err != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("update channel #%d budget usage failed: %v", key.ChannelId, err))
+			model.RestorePendingChannelBudgetSpend(key, quota)
+		}
+	}
+
+	usages, err := model.GetChannelBudgetUsages(channelIds, []string{dailyPeriod, monthlyPeriod})
+	if err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("channel budget task failed: %v", err))
+		return
+	}
+	statuses := make(map[int]*model.ChannelBudgetStatus, len(channelIds))
+	for _, channelId := range channelIds {
+		status := evaluateChannelBudget(settings[channelId],
+			usages[channelId][dailyPeriod].UsedQuota, usages[channelId][monthlyPeriod].UsedQuota)
+		if status != nil {
+			status.DailyPeriod, status.MonthlyPeriod = dailyPeriod, monthlyPeriod
+		}
+		statuses[channelId] = status
+	}
+	model.SetChannelBudgetStatuses(statuses)
+
+	if !common.IsMasterNode {
+		return
+	}
+	for _, channel := range channels {
+		status := statuses[channel.Id]
+		if status == nil {
+			// 预算已被取消
+			status = &model.ChannelBudgetStatus{}
+		}
+		if status.DailyAlert {
+			notifyChannelBudgetAlert(channel, dailyPeriod, "今日", status.DailyUsed, status.DailyBudget)
+		}
+		if status.MonthlyAlert {
+			notifyChannelBudgetAlert(channel, monthlyPeriod, "本月", status.MonthlyUsed, status.MonthlyBudget)
+		}
+		applyChannelBudgetStatus(channel, status)
+	}
+
+	if last := channelBudgetCleanupLast.Load(); now.Unix()-last >= int64(channelBudgetCleanupInterval/time.Second) {
+		channelBudgetCleanupLast.Store(now.Unix())
+		if _, err := model.DeleteChannelBudgetUsagesBefore(now.Add(-channelBudgetRetention).Unix()); err != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("channel budget cleanup failed: %v", err))
+		}
+	}
+}
+
+func notifyChannelBudgetAlert(channel *model.Channel, period string, periodName string, used int64, budget int) {
+	marked, err := model.MarkChannelBudgetAlerted(channel.Id, period)
+	if err != nil || !marked {
+		return
+	}
+	subject := fmt.Sprintf("通道「%s」（#%d）%s预算即将耗尽", channel.Name, channel.Id, periodName)
+	content := fmt.Sprintf("通道「%s」（#%d）%s已消耗 %s，预算 %s", channel.Name, channel.Id, periodName,
+		logger.FormatQuota(int(used)), logger.FormatQuota(budget))
+	NotifyRootUser(fmt.Sprintf("%s_%d_budget_%s", dto.NotifyTypeChannelUpdate, channel.Id, period), subject, content)
+}
+
+// applyChannelBudgetStatus 预算耗尽时暂停已启用的渠道，进入新周期或取消预算后恢复因预算暂停的渠道；
+// 手动禁用与出错自动禁用的渠道不受影响
+func applyChannelBudgetStatus(channel *model.Channel, status *model.ChannelBudgetStatus) {
+	if status.Exhausted && channel.Status == common.ChannelStatusEnabled {
+		reason := fmt.Sprintf("预算耗尽：今日已消耗 %s / %s，本月已消耗 %s / %s",
+			logger.FormatQuota(int(status.DailyUsed)), formatChannelBudget(status.DailyBudget),
+			logger.FormatQuota(int(status.MonthlyUsed)), formatChannelBudget(status.MonthlyBudget))
+		if model.UpdateChannelBudgetStatus(channel.Id, true, reason) {
+			subject := fmt.Sprintf("通道「%s」（#%d）预算已耗尽，已暂停使用", channel.Name, channel.Id)
+			content := fmt.Sprintf("通道「%s」（#%d）已暂停使用，周期重置后自动恢复，原因：%s", channel.Name, channel.Id, reason)
+			NotifyRootUser(formatNotifyType(channel.Id, common.ChannelStatusBudgetExhausted), subject, content)
+		}
+		return
+	}
+	if !status.Exhausted && channel.Status == common.ChannelStatusBudgetExhausted {
+		if model.UpdateChannelBudgetStatus(channel.Id, false, "") {
+			subject := fmt.Sprintf("通道「%s」（#%d）预算已重置，已恢复使用", channel.Name, channel.Id)
+			NotifyRootUser(formatNotifyType(channel.Id, common.ChannelStatusEnabled), subject, subject)
+		}
+	}
+}
+
+func formatChannelBudget(budget int) string {
+	if budget <= 0 {
+		return "不限"
+	}
+	return logger.FormatQuota(budget)
+}
@@ -0,0 +1,32 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluateChannelBudget(t *testing.T) {
+	require.Nil(t, evaluateChannelBudget(dto.ChannelSettings{}, 100, 100))
+
+	status := evaluateChannelBudget(dto.ChannelSettings{DailyBudget: 1000, MonthlyBudget: 10000}, 500, 5000)
+	require.Equal(t, defaultChannelBudgetPercent, status.AlertPercent)
+	require.False(t, status.DailyAlert)
+	require.False(t, status.Exhausted)
+
+	status = evaluateChannelBudget(dto.ChannelSettings{DailyBudget: 1000, MonthlyBudget: 10000}, 800, 5000)
+	require.True(t, status.DailyAlert)
+	require.False(t, status.MonthlyAlert)
+	require.False(t, status.Exhausted)
+
+	// 任一周期达到上限即暂停
+	status = evaluateChannelBudget(dto.ChannelSettings{DailyBudget: 1000, MonthlyBudget: 10000}, 200, 10000)
+	require.True(t, status.MonthlyAlert)
+	require.True(t, status.Exhausted)
+
+	status = evaluateChannelBudget(dto.ChannelSettings{MonthlyBudget: 10000, BudgetAlertPercent: 50}, 999999, 5000)
+	require.False(t, status.DailyAlert)
+	require.True(t, status.MonthlyAlert)
+	require.False(t, status.Exhausted)
+}
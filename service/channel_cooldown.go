@@ -0,0 +1,134 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/pkg/cooldown"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// ParseUpstreamRetryAfter 从上游限流响应头中解析需要等待的时间：
+// 优先使用 Retry-After / retry-after-ms；否则使用已耗尽额度对应的 x-ratelimit-reset-* / anthropic-ratelimit-*-reset，
+// 无法判断哪项额度耗尽时取最短的重置时间。解析不到返回 0
+func ParseUpstreamRetryAfter(header http.Header, t time.Time) time.Duration {
+	if header == nil {
+		return 0
+	}
+	if ms, err := strconv.ParseFloat(strings.TrimSpace(header.Get("retry-after-ms")), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	if value := strings.TrimSpace(header.Get("Retry-After")); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			if seconds > 0 {
+				return time.Duration(seconds * float64(time.Second))
+			}
+		} else if date, err := http.ParseTime(value); err == nil && date.After(t) {
+			return date.Sub(t)
+		}
+	}
+
+	var exhausted, shortest time.Duration
+	for name, values := range header {
+		name = strings.ToLower(name)
+		if len(values) == 0 {
+			continue
+		}
+		var limit string
+		switch {
+		case strings.HasPrefix(name, "x-ratelimit-reset"):
+			limit = strings.TrimPrefix(strings.TrimPrefix(name, "x-ratelimit-reset"), "-")
+		case strings.HasPrefix(name, "anthropic-ratelimit-") && strings.HasSuffix(name, "-reset"):
+			limit = strings.TrimSuffix(strings.TrimPrefix(name, "anthropic-ratelimit-"), "-reset")
+		default:
+			continue
+		}
+		wait := parseRateLimitReset(values[0], t)
+		if wait <= 0 {
+			continue
+		}
+		if shortest == 0 || wait < shortest {
+			shortest = wait
+		}
+		if rateLimitExhausted(header, limit) && wait > exhausted {
+			exhausted = wait
+		}
+	}
+	if exhausted > 0 {
+		return exhausted
+	}
+	return shortest
+}
+
+// parseRateLimitReset 支持 Go duration（OpenAI："1s"、"6m0s"、"20ms"）、RFC3339 时间（Anthropic）、
+// unix 时间戳与秒数
+func parseRateLimitReset(value string, t time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return d
+	}
+	if reset, err := time.Parse(time.RFC3339, value); err == nil {
+		return reset.Sub(t)
+	}
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		if number > 1e9 {
+			return time.Unix(int64(number), 0).Sub(t)
+		}
+		return time.Duration(number * float64(time.Second))
+	}
+	return 0
+}
+
+func rateLimitExhausted(header http.Header, limit string) bool {
+	var remaining string
+	if limit == "" {
+		remaining = header.Get("x-ratelimit-remaining")
+	} else {
+		remaining = header.Get("x-ratelimit-remaining-" + limit)
+		if remaining == "" {
+			remaining = header.Get("anthropic-ratelimit-" + limit + "-remaining")
+		}
+	}
+	return strings.TrimSpace(remaining) == "0"
+}
+
+// ApplyUpstreamCooldown 上游 429 并给出等待时间时让渠道冷却（多密钥渠道只冷却当前密钥，
+// 全部密钥都在冷却时整个渠道冷却），返回 true 表示已冷却、不应再自动禁用渠道
+func ApplyUpstreamCooldown(channelError types.ChannelError, keyIndex int, err *types.NewAPIError) bool {
+	setting := operation_setting.GetChannelCooldownSetting()
+	if !setting.Enabled || err == nil || err.StatusCode != http.StatusTooManyRequests || err.RetryAfter <= 0 {
+		return false
+	}
+	until := time.Now().Add(setting.Clamp(err.RetryAfter))
+	if !channelError.IsMultiKey {
+		cooldown.Set(channelError.ChannelId, cooldown.ChannelScope, until)
+		return true
+	}
+
+	cooldown.Set(channelError.ChannelId, keyIndex, until)
+	channel, cacheErr := model.CacheGetChannel(channelError.ChannelId)
+	if cacheErr != nil {
+		return true
+	}
+	for i := range channel.GetKeys() {
+		status, ok := channel.ChannelInfo.MultiKeyStatusList[i]
+		if ok && status != common.ChannelStatusEnabled {
+			continue
+		}
+		if !cooldown.Active(channel.Id, i) {
+			return true
+		}
+	}
+	common.SysLog(fmt.Sprintf("all keys of channel #%d are cooling down", channel.Id))
+	cooldown.Set(channel.Id, cooldown.ChannelScope, until)
+	return true
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUpstreamRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	header := func(kv ...string) http.Header {
+		h := http.Header{}
+		for i := 0; i+1 < len(kv); i += 2 {
+			h.Set(kv[i], kv[i+1])
+		}
+		return h
+	}
+
+	require.Equal(t, time.Duration(0), ParseUpstreamRetryAfter(nil, now))
+	require.Equal(t, time.Duration(0), ParseUpstreamRetryAfter(header(), now))
+	require.Equal(t, 30*time.Second, ParseUpstreamRetryAfter(header("Retry-After", "30"), now))
+	require.Equal(t, 90*time.Second, ParseUpstreamRetryAfter(header("Retry-After", now.Add(90*time.Second).Format(http.TimeFormat)), now))
+	require.Equal(t, 1500*time.Millisecond, ParseUpstreamRetryAfter(header("retry-after-ms", "1500", "Retry-After", "2"), now))
+
+	// OpenAI：取已耗尽额度的重置时间
+	require.Equal(t, 6*time.Minute, ParseUpstreamRetryAfter(header(
+		"x-ratelimit-reset-requests", "1s",
+		"x-ratelimit-remaining-requests", "10",
+		"x-ratelimit-reset-tokens", "6m0s",
+		"x-ratelimit-remaining-tokens", "0",
+	), now))
+	// 无法判断哪项耗尽时取最短
+	require.Equal(t, 20*time.Millisecond, ParseUpstreamRetryAfter(header(
+		"x-ratelimit-reset-requests", "20ms",
+		"x-ratelimit-reset-tokens", "3s",
+	), now))
+
+	// Anthropic
+	require.Equal(t, 45*time.Second, ParseUpstreamRetryAfter(header(
+		"anthropic-ratelimit-tokens-reset", now.Add(45*time.Second).Format(time.RFC3339),
+		"anthropic-ratelimit-tokens-remaining", "0",
+		"anthropic-ratelimit-requests-reset", now.Add(5*time.Second).Format(time.RFC3339),
+	), now))
+
+	// unix 时间戳
+	require.Equal(t, 12*time.Second, ParseUpstreamRetryAfter(header("x-ratelimit-reset", "1735689612"), now))
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func seedMultiKeyChannel(t *testing.T, id int, quotaLimit map[int]int) {
+	t.Helper()
+	require.NoError(t, model.DB.AutoMigrate(&model.ChannelKeyUsage{}))
+	t.Cleanup(func() {
+		model.DB.Exec("DELETE FROM channel_key_usages")
+	})
+	ch := &model.Channel{
+		Id:     id,
+		Name:   "multi_key_channel",
+		Key:    "key-0\nkey-1",
+		Status: common.ChannelStatusEnabled,
+		ChannelInfo: model.ChannelInfo{
+			IsMultiKey:         true,
+			MultiKeySize:       2,
+			MultiKeyQuotaLimit: quotaLimit,
+		},
+	}
+	require.NoError(t, model.DB.Create(ch).Error)
+}
+
+func channelKeyUsedQuota(t *testing.T, channelId int, keyIndex int) int64 {
+	t.Helper()
+	usages, err := model.GetChannelKeyUsages(channelId)
+	require.NoError(t, err)
+	return usages[keyIndex].UsedQuota
+}
+
+func channelKeyStatus(t *testing.T, channelId int, keyIndex int) int {
+	t.Helper()
+	ch, err := model.GetChannelById(channelId, true)
+	require.NoError(t, err)
+	if status, ok := ch.ChannelInfo.MultiKeyStatusList[keyIndex]; ok {
+		return status
+	}
+	return common.ChannelStatusEnabled
+}
+
+func TestSettleBillingRecordsChannelKeyQuota(t *testing.T) {
+	truncate(t)
+	const channelId = 1
+	seedMultiKeyChannel(t, channelId, map[int]int{1: 100})
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	settle := func(quota int) {
+		relayInfo := &relaycommon.RelayInfo{
+			FinalPreConsumedQuota: quota,
+			ChannelMeta: &relaycommon.ChannelMeta{
+				ChannelId:            channelId,
+				ChannelIsMultiKey:    true,
+				ChannelMultiKeyIndex: 1,
+			},
+		}
+		require.NoError(t, SettleBilling(c, relayInfo, quota))
+	}
+
+	// 结算时累计到当前密钥，未达到上限时密钥保持启用
+	settle(60)
+	require.Eventually(t, func() bool { return channelKeyUsedQuota(t, channelId, 1) == 60 }, time.Second, 10*time.Millisecond)
+	require.Zero(t, channelKeyUsedQuota(t, channelId, 0))
+	require.Equal(t, common.ChannelStatusEnabled, channelKeyStatus(t, channelId, 1))
+
+	// 累计用量达到上限后只禁用该密钥
+	settle(60)
+	require.Eventually(t, func() bool {
+		return channelKeyStatus(t, channelId, 1) == common.ChannelStatusAutoDisabled
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, int64(120), channelKeyUsedQuota(t, channelId, 1))
+	require.Equal(t, common.ChannelStatusEnabled, channelKeyStatus(t, channelId, 0))
+	ch, err := model.GetChannelById(channelId, true)
+	require.NoError(t, err)
+	require.Equal(t, common.ChannelStatusEnabled, ch.Status)
+}
+
+func TestTaskDeltaRecordsChannelKeyQuota(t *testing.T) {
+	truncate(t)
+	const channelId = 1
+	seedMultiKeyChannel(t, channelId, nil)
+
+	task := makeTask(1, channelId, 0, 1, BillingSourceWallet, 0)
+	task.PrivateData.Key = "key-1"
+	recordTaskChannelKeyQuota(task, 30)
+	require.Eventually(t, func() bool { return channelKeyUsedQuota(t, channelId, 1) == 30 }, time.Second, 10*time.Millisecond)
+	require.Zero(t, channelKeyUsedQuota(t, channelId, 0))
+}
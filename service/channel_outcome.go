@@ -0,0 +1,61 @@
+package service
+
+import (
+	"net/http"
+
+	"github.com/QuantumNous/new-api/pkg/channelhealth"
+	"github.com/QuantumNous/new-api/pkg/circuitbreaker"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// ChannelHealthOutcome 只把可归因于渠道的错误计入健康统计，客户端参数错误等忽略
+func ChannelHealthOutcome(err *types.NewAPIError) channelhealth.Outcome {
+	if err == nil {
+		return channelhealth.OutcomeSuccess
+	}
+	if types.IsChannelError(err) {
+		return channelhealth.OutcomeFailure
+	}
+	code := err.StatusCode
+	if code == http.StatusTooManyRequests || code >= 500 || code < 100 {
+		return channelhealth.OutcomeFailure
+	}
+	return channelhealth.OutcomeIgnored
+}
+
+// upstreamFailureCodes 本地产生但可归因于上游的错误（请求失败、超时、响应异常）
+var upstreamFailureCodes = map[types.ErrorCode]bool{
+	types.ErrorCodeDoRequestFailed:             true,
+	types.ErrorCodeReadResponseBodyFailed:      true,
+	types.ErrorCodeBadResponse:                 true,
+	types.ErrorCodeBadResponseBody:             true,
+	types.ErrorCodeEmptyResponse:               true,
+	types.ErrorCodeStreamFirstTokenTimeout:     true,
+	types.ErrorCodeConnectTimeout:              true,
+	types.ErrorCodeResponseHeaderTimeout:       true,
+	types.ErrorCodeTotalTimeout:                true,
+	types.ErrorCodeAwsInvokeError:              true,
+	types.ErrorCodeChannelResponseTimeExceeded: true,
+}
+
+// CircuitBreakerOutcome 只有上游 5xx、超时与网络错误计入熔断
+func CircuitBreakerOutcome(err *types.NewAPIError) circuitbreaker.Outcome {
+	if err == nil {
+		return circuitbreaker.OutcomeSuccess
+	}
+	if err.StatusCode >= 100 && err.StatusCode < 500 {
+		return circuitbreaker.OutcomeIgnored
+	}
+	if err.GetErrorType() != types.ErrorTypeNewAPIError || upstreamFailureCodes[err.GetErrorCode()] {
+		return circuitbreaker.OutcomeFailure
+	}
+	return circuitbreaker.OutcomeIgnored
+}
+
+// RecordCircuitBreaker 记录渠道级的熔断结果，多密钥渠道同时记录所用密钥
+func RecordCircuitBreaker(channelId int, isMultiKey bool, keyIndex int, outcome circuitbreaker.Outcome) {
+	circuitbreaker.Record(channelId, circuitbreaker.ChannelScope, outcome)
+	if isMultiKey {
+		circuitbreaker.Record(channelId, keyIndex, outcome)
+	}
+}
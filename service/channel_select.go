@@ -7,6 +7,8 @@ import (
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/pkg/circuitbreaker"
+	"github.com/QuantumNous/new-api/pkg/tracing"
 	"github.com/QuantumNous/new-api/setting"
 	"github.com/gin-gonic/gin"
 )
@@ -45,6 +47,28 @@ func (p *RetryParam) ResetRetryNextTry() {
 	p.resetNextTry = true
 }
 
+// maxBreakerAcquireAttempts 选中的渠道半开探测名额已被占满时最多重新选择的次数
+const maxBreakerAcquireAttempts = 3
+
+// acquireSatisfiedChannel 在分组内选择渠道并占用熔断器的半开探测名额。
+// 熔断到期的渠道在这里转为半开；名额已被并发请求占满时排除该渠道，在同一分组与优先级下重新选择，
+// 因此 auto 分组的状态只按最终选中的结果更新
+func acquireSatisfiedChannel(param *RetryParam, group string, retry int) (*model.Channel, error) {
+	var excludeIds []int
+	for attempt := 0; attempt < maxBreakerAcquireAttempts; attempt++ {
+		channel, err := model.GetRandomSatisfiedChannel(group, param.ModelName, retry, excludeIds...)
+		if err != nil || channel == nil {
+			return nil, err
+		}
+		if circuitbreaker.Acquire(channel.Id, circuitbreaker.ChannelScope) {
+			return channel, nil
+		}
+		logger.LogDebug(param.Ctx, "channel #%d half-open probes exhausted, reselecting", channel.Id)
+		excludeIds = append(excludeIds, channel.Id)
+	}
+	return nil, nil
+}
+
 // CacheGetRandomSatisfiedChannel tries to get a random channel that satisfies the requirements.
 // 尝试获取一个满足要求的随机渠道。
 //
@@ -81,6 +105,22 @@ func (p *RetryParam) ResetRetryNextTry() {
 //	Retry=3: GroupB, priority1 (startRetryIndex=2, priorityRetry=1)
 //	         分组B, 优先级1
 func CacheGetRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string, error) {
+	span := tracing.StartSpan(param.Ctx, "CacheGetRandomSatisfiedChannel",
+		tracing.String("model", param.ModelName),
+		tracing.String("group", param.TokenGroup),
+		tracing.Int("retry.index", param.GetRetry()),
+	)
+	channel, selectGroup, err := cacheGetRandomSatisfiedChannel(param)
+	span.SetAttributes(tracing.String("select_group", selectGroup))
+	if channel != nil {
+		span.SetAttributes(tracing.Int("channel.id", channel.Id), tracing.Int("channel.type", channel.Type))
+	}
+	span.SetError(err)
+	span.End()
+	return channel, selectGroup, err
+}
+
+func cacheGetRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string, error) {
 	var channel *model.Channel
 	var err error
 	selectGroup := param.TokenGroup
@@ -115,7 +155,7 @@ func CacheGetRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string,
 			}
 			logger.LogDebug(param.Ctx, "Auto selecting group: %s, priorityRetry: %d", autoGroup, priorityRetry)
 
-			channel, _ = model.GetRandomSatisfiedChannel(autoGroup, param.ModelName, priorityRetry)
+			channel, _ = acquireSatisfiedChannel(param, autoGroup, priorityRetry)
 			if channel == nil {
 				// Current group has no available channel for this model, try next group
 				// 当前分组没有该模型的可用渠道，尝试下一个分组
@@ -153,7 +193,7 @@ func CacheGetRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string,
 			break
 		}
 	} else {
-		channel, err = model.GetRandomSatisfiedChannel(param.TokenGroup, param.ModelName, param.GetRetry())
+		channel, err = acquireSatisfiedChannel(param, param.TokenGroup, param.GetRetry())
 		if err != nil {
 			return nil, param.TokenGroup, err
 		}
@@ -0,0 +1,262 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	DefaultChildTokenTTL = 10 * time.Minute
+	// MaxChildTokenTTL 子令牌无法吊销，有效期不宜过长
+	MaxChildTokenTTL          = time.Hour
+	maxChildTokenEndUserLen   = 64
+	childTokenSpendKeyPrefix  = "child_token_spend:"
+	childTokenSpendPurgeEvery = time.Minute
+)
+
+// ChildTokenOptions 签发子令牌时可设置的限制
+type ChildTokenOptions struct {
+	ExpiresIn int      `json:"expires_in"` // 秒，默认 600
+	Models    []string `json:"models"`
+	MaxQuota  int      `json:"max_quota"`
+	Group     string   `json:"group"`
+	EndUser   string   `json:"end_user"`
+}
+
+// NewChildTokenClaims 校验签发参数并生成子令牌声明，子令牌的限制不能超出父令牌；
+// userGroup 为父令牌所属用户的分组，父令牌未指定分组时子令牌固定使用该分组
+func NewChildTokenClaims(parent *model.Token, userGroup string, opts ChildTokenOptions, now time.Time) (*model.ChildTokenClaims, error) {
+	if parent.Status != common.TokenStatusEnabled {
+		return nil, errors.New("父令牌状态不可用")
+	}
+	if !parent.UnlimitedQuota && parent.RemainQuota <= 0 {
+		return nil, errors.New("父令牌额度已用尽")
+	}
+	ttl := DefaultChildTokenTTL
+	if opts.ExpiresIn < 0 {
+		return nil, errors.New("expires_in 不能为负数")
+	}
+	if opts.ExpiresIn > 0 {
+		ttl = time.Duration(opts.ExpiresIn) * time.Second
+	}
+	if ttl > MaxChildTokenTTL {
+		return nil, fmt.Errorf("子令牌有效期不能超过 %d 秒", int(MaxChildTokenTTL/time.Second))
+	}
+	expiresAt := now.Add(ttl)
+	if parent.ExpiredTime != -1 && parent.ExpiredTime < expiresAt.Unix() {
+		expiresAt = time.Unix(parent.ExpiredTime, 0)
+		if !expiresAt.After(now) {
+			return nil, errors.New("父令牌已过期")
+		}
+	}
+	if opts.MaxQuota < 0 {
+		return nil, errors.New("max_quota 不能为负数")
+	}
+	if len(opts.EndUser) > maxChildTokenEndUserLen {
+		return nil, fmt.Errorf("end_user 长度不能超过 %d", maxChildTokenEndUserLen)
+	}
+
+	models := make([]string, 0, len(opts.Models))
+	for _, m := range opts.Models {
+		m = strings.TrimSpace(m)
+		if m != "" && !slices.Contains(models, m) {
+			models = append(models, m)
+		}
+	}
+	if parent.ModelLimitsEnabled {
+		parentModels := parent.GetModelLimits()
+		if len(models) == 0 {
+			models = parentModels
+		}
+		for _, m := range models {
+			if !slices.Contains(parentModels, m) {
+				return nil, fmt.Errorf("父令牌无权使用模型 %s", m)
+			}
+		}
+	}
+
+	parentGroup := parent.Group
+	if parentGroup == "" {
+		parentGroup = userGroup
+	}
+	group := strings.TrimSpace(opts.Group)
+	if group == "" {
+		group = parentGroup
+	} else if group != parentGroup {
+		return nil, fmt.Errorf("父令牌只能使用 %s 分组", parentGroup)
+	}
+
+	tokenHash, err := model.TokenLookupHash(parent.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &model.ChildTokenClaims{
+		TokenId:          parent.Id,
+		TokenHash:        tokenHash,
+		TokenName:        parent.Name,
+		UserId:           parent.UserId,
+		UnlimitedQuota:   parent.UnlimitedQuota,
+		Scopes:           parent.Scopes,
+		CrossGroupRetry:  parent.CrossGroupRetry,
+		TpmLimit:         parent.TpmLimit,
+		OutputTpmLimit:   parent.OutputTpmLimit,
+		ConcurrencyLimit: parent.ConcurrencyLimit,
+		BudgetPeriod:     parent.BudgetPeriod,
+		BudgetLimit:      parent.BudgetLimit,
+		Models:           models,
+		MaxQuota:         opts.MaxQuota,
+		Group:            group,
+		EndUser:          opts.EndUser,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        common.GetUUID(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}, nil
+}
+
+// 子令牌已用额度按 jti 记录，启用 Redis 时多节点共享，否则记录在本节点内存中；
+// 记录在子令牌过期后清除
+type childTokenSpend struct {
+	quota     int
+	expiresAt time.Time
+}
+
+var (
+	childTokenSpendMu        sync.Mutex
+	childTokenSpends         = make(map[string]*childTokenSpend)
+	childTokenSpendLastPurge time.Time
+)
+
+// GetChildTokenSpent 返回子令牌已消耗的额度
+func GetChildTokenSpent(claims *model.ChildTokenClaims) int {
+	if common.RedisEnabled {
+		spent, err := common.RDB.Get(context.Background(), childTokenSpendKeyPrefix+claims.ID).Int()
+		if err != nil {
+			return 0
+		}
+		return spent
+	}
+	childTokenSpendMu.Lock()
+	defer childTokenSpendMu.Unlock()
+	if spend, ok := childTokenSpends[claims.ID]; ok {
+		return spend.quota
+	}
+	return 0
+}
+
+// reserveChildTokenSpend 预扣时占用子令牌额度，占用后超出 max_quota 则撤销并返回错误，
+// 并发请求因此不会越过上限
+func reserveChildTokenSpend(claims *model.ChildTokenClaims, quota int) error {
+	if common.RedisEnabled {
+		ctx := context.Background()
+		key := childTokenSpendKeyPrefix + claims.ID
+		pipe := common.RDB.TxPipeline()
+		incr := pipe.IncrBy(ctx, key, int64(quota))
+		pipe.ExpireAt(ctx, key, claims.ExpiresAt.Time)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+		if spent := int(incr.Val()); spent > claims.MaxQuota {
+			if err := common.RDB.DecrBy(ctx, key, int64(quota)).Err(); err != nil {
+				common.SysLog(fmt.Sprintf("failed to release child token %s spend: %s", claims.ID, err.Error()))
+			}
+			return childTokenQuotaError(claims, spent-quota, quota)
+		}
+		return nil
+	}
+	childTokenSpendMu.Lock()
+	defer childTokenSpendMu.Unlock()
+	spend := getChildTokenSpendLocked(claims)
+	if spend.quota+quota > claims.MaxQuota {
+		return childTokenQuotaError(claims, spend.quota, quota)
+	}
+	spend.quota += quota
+	return nil
+}
+
+func childTokenQuotaError(claims *model.ChildTokenClaims, spent int, quota int) error {
+	return fmt.Errorf("子令牌额度不足, 剩余额度: %s, 需要预扣费额度: %s",
+		logger.FormatQuota(max(claims.MaxQuota-spent, 0)), logger.FormatQuota(quota))
+}
+
+// addChildTokenSpend 调整子令牌已用额度，quota 为负时释放占用
+func addChildTokenSpend(claims *model.ChildTokenClaims, quota int) error {
+	if common.RedisEnabled {
+		ctx := context.Background()
+		key := childTokenSpendKeyPrefix + claims.ID
+		pipe := common.RDB.TxPipeline()
+		pipe.IncrBy(ctx, key, int64(quota))
+		pipe.ExpireAt(ctx, key, claims.ExpiresAt.Time)
+		_, err := pipe.Exec(ctx)
+		return err
+	}
+	childTokenSpendMu.Lock()
+	defer childTokenSpendMu.Unlock()
+	getChildTokenSpendLocked(claims).quota += quota
+	return nil
+}
+
+func getChildTokenSpendLocked(claims *model.ChildTokenClaims) *childTokenSpend {
+	now := time.Now()
+	if now.Sub(childTokenSpendLastPurge) >= childTokenSpendPurgeEvery {
+		childTokenSpendLastPurge = now
+		for id, spend := range childTokenSpends {
+			if now.After(spend.expiresAt) {
+				delete(childTokenSpends, id)
+			}
+		}
+	}
+	spend, ok := childTokenSpends[claims.ID]
+	if !ok {
+		spend = &childTokenSpend{expiresAt: claims.ExpiresAt.Time}
+		childTokenSpends[claims.ID] = spend
+	}
+	return spend
+}
+
+// GetChildTokenClaims 返回当前请求使用的子令牌声明，非子令牌请求返回 nil
+func GetChildTokenClaims(c *gin.Context) *model.ChildTokenClaims {
+	value, ok := common.GetContextKey(c, constant.ContextKeyChildToken)
+	if !ok {
+		return nil
+	}
+	claims, _ := value.(*model.ChildTokenClaims)
+	return claims
+}
+
+// getLimitedChildTokenClaims 返回设置了 max_quota 的子令牌声明
+func getLimitedChildTokenClaims(c *gin.Context) *model.ChildTokenClaims {
+	claims := GetChildTokenClaims(c)
+	if claims == nil || claims.MaxQuota <= 0 {
+		return nil
+	}
+	return claims
+}
+
+// recordChildTokenSpend 无计费会话的旧结算路径在结算后累加子令牌已用额度；
+// 计费会话在预扣时占用额度并在结算、退款时调整
+func recordChildTokenSpend(c *gin.Context, quota int) {
+	if quota <= 0 {
+		return
+	}
+	claims := getLimitedChildTokenClaims(c)
+	if claims == nil {
+		return
+	}
+	if err := addChildTokenSpend(claims, quota); err != nil {
+		common.SysLog(fmt.Sprintf("failed to record child token %s spend: %s", claims.ID, err.Error()))
+	}
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewChildTokenClaims(t *testing.T) {
+	model.SetTokenHashSecret([]byte("child-token-test-secret"))
+	now := time.Now()
+	parent := &model.Token{
+		Id:                 7,
+		UserId:             3,
+		Key:                "abcdefghijklmnop",
+		Status:             common.TokenStatusEnabled,
+		ExpiredTime:        -1,
+		RemainQuota:        1000,
+		ModelLimitsEnabled: true,
+		ModelLimits:        "gpt-4o,gpt-4o-mini",
+		Group:              "default",
+	}
+
+	claims, err := NewChildTokenClaims(parent, "default", ChildTokenOptions{Models: []string{"gpt-4o-mini"}, MaxQuota: 100, EndUser: "u-1"}, now)
+	require.NoError(t, err)
+	require.Equal(t, []string{"gpt-4o-mini"}, claims.Models)
+	require.Equal(t, "default", claims.Group)
+	tokenHash, err := model.TokenLookupHash(parent.Key)
+	require.NoError(t, err)
+	require.Equal(t, tokenHash, claims.TokenHash)
+	require.Equal(t, now.Add(DefaultChildTokenTTL).Unix(), claims.ExpiresAt.Unix())
+
+	// 未指定模型时继承父令牌的模型限制
+	claims, err = NewChildTokenClaims(parent, "default", ChildTokenOptions{}, now)
+	require.NoError(t, err)
+	require.Equal(t, []string{"gpt-4o", "gpt-4o-mini"}, claims.Models)
+
+	_, err = NewChildTokenClaims(parent, "default", ChildTokenOptions{Models: []string{"o3"}}, now)
+	require.Error(t, err)
+	_, err = NewChildTokenClaims(parent, "default", ChildTokenOptions{Group: "vip"}, now)
+	require.Error(t, err)
+	_, err = NewChildTokenClaims(parent, "default", ChildTokenOptions{ExpiresIn: int(MaxChildTokenTTL/time.Second) + 1}, now)
+	require.Error(t, err)
+
+	// 父令牌未指定分组时固定为用户分组
+	parent.Group = ""
+	claims, err = NewChildTokenClaims(parent, "vip", ChildTokenOptions{}, now)
+	require.NoError(t, err)
+	require.Equal(t, "vip", claims.Group)
+	_, err = NewChildTokenClaims(parent, "vip", ChildTokenOptions{Group: "svip"}, now)
+	require.Error(t, err)
+
+	// 有效期不超过父令牌
+	parent.ExpiredTime = now.Add(time.Minute).Unix()
+	claims, err = NewChildTokenClaims(parent, "default", ChildTokenOptions{}, now)
+	require.NoError(t, err)
+	require.Equal(t, parent.ExpiredTime, claims.ExpiresAt.Unix())
+}
+
+func TestChildTokenSignAndParse(t *testing.T) {
+	model.SetTokenHashSecret([]byte("child-token-test-secret"))
+	parent := &model.Token{Id: 7, UserId: 3, Key: "abcdefghijklmnop", Status: common.TokenStatusEnabled, ExpiredTime: -1, UnlimitedQuota: true}
+	claims, err := NewChildTokenClaims(parent, "default", ChildTokenOptions{Models: []string{"gpt-4o"}, MaxQuota: 50}, time.Now())
+	require.NoError(t, err)
+
+	key, err := model.SignChildToken(claims)
+	require.NoError(t, err)
+	require.True(t, model.IsChildToken(key))
+
+	parsed, err := model.ParseChildToken(key)
+	require.NoError(t, err)
+	require.Equal(t, claims.ID, parsed.ID)
+	require.Equal(t, 7, parsed.ParentToken(50).Id)
+	require.True(t, parsed.ParentToken(50).GetModelLimitsMap()["gpt-4o"])
+
+	_, err = model.ParseChildToken(key + "x")
+	require.Error(t, err)
+
+	expired, err := NewChildTokenClaims(parent, "default", ChildTokenOptions{}, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	key, err = model.SignChildToken(expired)
+	require.NoError(t, err)
+	_, err = model.ParseChildToken(key)
+	require.Error(t, err)
+}
+
+func seedChildTokenParent(t *testing.T) (*model.Token, *model.ChildTokenClaims) {
+	t.Helper()
+	model.SetTokenHashSecret([]byte("child-token-test-secret"))
+	truncate(t)
+	seedUser(t, 1, 10000)
+	seedToken(t, 1, 1, "child-parent-key", 5000)
+	parent, err := model.GetTokenById(1)
+	require.NoError(t, err)
+	claims, err := NewChildTokenClaims(parent, "default", ChildTokenOptions{MaxQuota: 100}, time.Now())
+	require.NoError(t, err)
+	return parent, claims
+}
+
+func newChildTokenBillingSession(t *testing.T, claims *model.ChildTokenClaims) (*gin.Context, *BillingSession) {
+	t.Helper()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	common.SetContextKey(c, constant.ContextKeyChildToken, claims)
+	session := &BillingSession{
+		relayInfo: &relaycommon.RelayInfo{UserId: claims.UserId, TokenId: claims.TokenId, TokenKey: claims.TokenHash},
+		funding:   &WalletFunding{userId: claims.UserId},
+	}
+	return c, session
+}
+
+func TestChildTokenQuotaReservedAtPreConsume(t *testing.T) {
+	_, claims := seedChildTokenParent(t)
+
+	// 并发请求各自占用额度，超出 max_quota 的预扣被拒绝
+	c1, first := newChildTokenBillingSession(t, claims)
+	require.Nil(t, first.preConsume(c1, 60))
+	require.Equal(t, 60, GetChildTokenSpent(claims))
+	c2, second := newChildTokenBillingSession(t, claims)
+	require.NotNil(t, second.preConsume(c2, 60))
+	require.Equal(t, 60, GetChildTokenSpent(claims))
+	require.Equal(t, 5000-60, getTokenRemainQuota(t, 1))
+
+	// 结算按实际消耗调整占用
+	require.NoError(t, first.Settle(30))
+	require.Equal(t, 30, GetChildTokenSpent(claims))
+
+	c3, third := newChildTokenBillingSession(t, claims)
+	require.Nil(t, third.preConsume(c3, 70))
+	require.Equal(t, 100, GetChildTokenSpent(claims))
+	third.releaseChildReserved()
+	require.Equal(t, 30, GetChildTokenSpent(claims))
+}
+
+func TestValidateChildTokenParent(t *testing.T) {
+	parent, claims := seedChildTokenParent(t)
+
+	got, err := model.ValidateChildTokenParent(claims)
+	require.NoError(t, err)
+	require.Equal(t, parent.Id, got.Id)
+
+	require.NoError(t, model.DB.Model(&model.Token{}).Where("id = ?", parent.Id).Update("status", common.TokenStatusDisabled).Error)
+	_, err = model.ValidateChildTokenParent(claims)
+	require.Error(t, err)
+
+	require.NoError(t, model.DB.Model(&model.Token{}).Where("id = ?", parent.Id).Updates(map[string]interface{}{
+		"status":       common.TokenStatusEnabled,
+		"expired_time": time.Now().Add(-time.Minute).Unix(),
+	}).Error)
+	_, err = model.ValidateChildTokenParent(claims)
+	require.Error(t, err)
+
+	require.NoError(t, model.DB.Delete(&model.Token{}, parent.Id).Error)
+	_, err = model.ValidateChildTokenParent(claims)
+	require.Error(t, err)
+}
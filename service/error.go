@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/dto"
@@ -85,6 +86,13 @@ func ClaudeErrorWrapperLocal(err error, code string, statusCode int) *dto.Claude
 
 func RelayErrorHandler(ctx context.Context, resp *http.Response, showBodyWhenFail bool) (newApiErr *types.NewAPIError) {
 	newApiErr = types.InitOpenAIError(types.ErrorCodeBadResponseStatusCode, resp.StatusCode)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		defer func() {
+			if newApiErr != nil {
+				newApiErr.RetryAfter = ParseUpstreamRetryAfter(resp.Header, time.Now())
+			}
+		}()
+	}
 
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
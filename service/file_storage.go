@@ -0,0 +1,67 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+)
+
+// SaveRelayFile 按 FILE_STORAGE_TYPE 保存文件内容并写入元数据
+func SaveRelayFile(userId int, purpose string, filename string, content []byte) (*model.RelayFile, error) {
+	file := &model.RelayFile{
+		FileId:    model.NewRelayFileId(),
+		UserId:    userId,
+		Purpose:   purpose,
+		Filename:  filename,
+		Bytes:     int64(len(content)),
+		CreatedAt: common.GetTimestamp(),
+	}
+	if constant.FileStorageType == model.RelayFileStorageDB {
+		file.Storage = model.RelayFileStorageDB
+		if err := model.SaveRelayFileContent(file.FileId, content); err != nil {
+			return nil, fmt.Errorf("save file content failed: %w", err)
+		}
+	} else {
+		file.Storage = model.RelayFileStorageDisk
+		if err := os.MkdirAll(constant.FileStorageDir, 0755); err != nil {
+			return nil, fmt.Errorf("create file storage dir failed: %w", err)
+		}
+		file.Path = filepath.Join(constant.FileStorageDir, file.FileId)
+		if err := os.WriteFile(file.Path, content, 0600); err != nil {
+			return nil, fmt.Errorf("write file failed: %w", err)
+		}
+	}
+	if err := file.Insert(); err != nil {
+		removeRelayFileContent(file)
+		return nil, err
+	}
+	return file, nil
+}
+
+func ReadRelayFileContent(file *model.RelayFile) ([]byte, error) {
+	if file.Storage == model.RelayFileStorageDB {
+		return model.GetRelayFileContent(file.FileId)
+	}
+	return os.ReadFile(file.Path)
+}
+
+func DeleteRelayFile(file *model.RelayFile) error {
+	if err := model.DeleteRelayFile(file); err != nil {
+		return err
+	}
+	removeRelayFileContent(file)
+	return nil
+}
+
+func removeRelayFileContent(file *model.RelayFile) {
+	if file.Storage != model.RelayFileStorageDisk || file.Path == "" {
+		return
+	}
+	if err := os.Remove(file.Path); err != nil && !os.IsNotExist(err) {
+		common.SysError(fmt.Sprintf("remove file %s failed: %s", file.Path, err.Error()))
+	}
+}
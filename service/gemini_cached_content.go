@@ -0,0 +1,125 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/pkg/cachex"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/samber/hot"
+)
+
+const geminiCachedContentNamespace = "new-api:gemini_cached_content:v1"
+
+// GeminiCachedContentBinding 记录上下文缓存的归属用户与创建渠道，查询 / 删除 / 列表按此转发
+type GeminiCachedContentBinding struct {
+	Name      string `json:"name"`
+	UserId    int    `json:"user_id"`
+	ChannelId int    `json:"channel_id"`
+	Model     string `json:"model"`
+	Group     string `json:"group"`
+}
+
+var (
+	geminiCachedContentOnce  sync.Once
+	geminiCachedContentCache *cachex.HybridCache[GeminiCachedContentBinding]
+)
+
+func getGeminiCachedContentCache() *cachex.HybridCache[GeminiCachedContentBinding] {
+	geminiCachedContentOnce.Do(func() {
+		geminiCachedContentCache = cachex.NewHybridCache[GeminiCachedContentBinding](cachex.HybridCacheConfig[GeminiCachedContentBinding]{
+			Namespace: cachex.Namespace(geminiCachedContentNamespace),
+			Redis:     common.RDB,
+			RedisEnabled: func() bool {
+				return common.RedisEnabled && common.RDB != nil
+			},
+			RedisCodec: cachex.JSONCodec[GeminiCachedContentBinding]{},
+			Memory: func() *hot.HotCache[string, GeminiCachedContentBinding] {
+				return hot.NewHotCache[string, GeminiCachedContentBinding](hot.LRU, 100_000).
+					WithJanitor().
+					Build()
+			},
+		})
+	})
+	return geminiCachedContentCache
+}
+
+// NormalizeGeminiCachedContentName 统一为 Gemini API 的 cachedContents/{id} 形式（Vertex 返回的是完整资源路径）
+func NormalizeGeminiCachedContentName(name string) string {
+	name = strings.TrimSpace(name)
+	if idx := strings.LastIndex(name, "cachedContents/"); idx >= 0 {
+		return name[idx:]
+	}
+	if name == "" || strings.Contains(name, "/") {
+		return name
+	}
+	return "cachedContents/" + name
+}
+
+func geminiCachedContentKey(userId int, name string) string {
+	return strconv.Itoa(userId) + ":" + name
+}
+
+// BindGeminiCachedContent 创建成功后记录归属，并通过渠道亲和把引用该缓存的生成请求固定到创建渠道
+func BindGeminiCachedContent(binding GeminiCachedContentBinding, ttl time.Duration) {
+	binding.Name = NormalizeGeminiCachedContentName(binding.Name)
+	if binding.Name == "" || binding.ChannelId <= 0 {
+		return
+	}
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	if err := getGeminiCachedContentCache().SetWithTTL(geminiCachedContentKey(binding.UserId, binding.Name), binding, ttl); err != nil {
+		common.SysError(fmt.Sprintf("gemini cached content binding set failed: name=%s, err=%v", binding.Name, err))
+	}
+	if !BindChannelAffinity(operation_setting.GeminiCachedContentAffinityRule, binding.Group, binding.Name, binding.ChannelId, ttl) {
+		common.SysLog(fmt.Sprintf("gemini cached content %s not bound to channel #%d: channel affinity rule %q is disabled or missing", binding.Name, binding.ChannelId, operation_setting.GeminiCachedContentAffinityRule))
+	}
+}
+
+func GetGeminiCachedContentBinding(userId int, name string) (GeminiCachedContentBinding, bool) {
+	name = NormalizeGeminiCachedContentName(name)
+	if name == "" {
+		return GeminiCachedContentBinding{}, false
+	}
+	binding, found, err := getGeminiCachedContentCache().Get(geminiCachedContentKey(userId, name))
+	if err != nil {
+		common.SysError(fmt.Sprintf("gemini cached content binding get failed: name=%s, err=%v", name, err))
+		return GeminiCachedContentBinding{}, false
+	}
+	return binding, found
+}
+
+// ListGeminiCachedContentBindings 列出用户仍在有效期内的上下文缓存
+func ListGeminiCachedContentBindings(userId int) []GeminiCachedContentBinding {
+	cache := getGeminiCachedContentCache()
+	keys, err := cache.Keys()
+	if err != nil {
+		common.SysError(fmt.Sprintf("gemini cached content binding list failed: err=%v", err))
+		return nil
+	}
+	prefix := cache.FullKey(geminiCachedContentKey(userId, ""))
+	bindings := make([]GeminiCachedContentBinding, 0)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		binding, found, err := cache.Get(key)
+		if err != nil || !found {
+			continue
+		}
+		bindings = append(bindings, binding)
+	}
+	return bindings
+}
+
+func UnbindGeminiCachedContent(binding GeminiCachedContentBinding) {
+	if _, err := getGeminiCachedContentCache().DeleteMany([]string{geminiCachedContentKey(binding.UserId, binding.Name)}); err != nil {
+		common.SysError(fmt.Sprintf("gemini cached content binding delete failed: name=%s, err=%v", binding.Name, err))
+	}
+	UnbindChannelAffinity(operation_setting.GeminiCachedContentAffinityRule, binding.Group, binding.Name)
+}
@@ -0,0 +1,14 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeGeminiCachedContentName(t *testing.T) {
+	require.Equal(t, "cachedContents/abc", NormalizeGeminiCachedContentName("abc"))
+	require.Equal(t, "cachedContents/abc", NormalizeGeminiCachedContentName(" cachedContents/abc "))
+	require.Equal(t, "cachedContents/abc", NormalizeGeminiCachedContentName("projects/p/locations/us-central1/cachedContents/abc"))
+	require.Equal(t, "", NormalizeGeminiCachedContentName(""))
+}
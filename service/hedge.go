@@ -0,0 +1,97 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/pkg/circuitbreaker"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+)
+
+// GetHedgeDelay 返回当前请求的对冲延迟。任务类（Midjourney、Suno、视频）和图片生成等非幂等请求、
+// 指定渠道的请求以及渠道测试不做对冲
+func GetHedgeDelay(c *gin.Context, info *relaycommon.RelayInfo) (time.Duration, bool) {
+	if info == nil || info.ChannelMeta == nil || info.IsChannelTest || info.TaskRelayInfo != nil {
+		return 0, false
+	}
+	if !isHedgeableRelayMode(info.RelayMode) {
+		return 0, false
+	}
+	if _, ok := common.GetContextKey(c, constant.ContextKeyTokenSpecificChannelId); ok {
+		return 0, false
+	}
+	return operation_setting.GetHedgeSetting().DelayFor(info.UsingGroup, info.OriginModelName)
+}
+
+func isHedgeableRelayMode(relayMode int) bool {
+	switch relayMode {
+	case relayconstant.RelayModeChatCompletions,
+		relayconstant.RelayModeCompletions,
+		relayconstant.RelayModeEmbeddings,
+		relayconstant.RelayModeRerank,
+		relayconstant.RelayModeResponses,
+		relayconstant.RelayModeGemini,
+		relayconstant.RelayModeUnknown: // Claude /v1/messages
+		return true
+	}
+	return false
+}
+
+// GetHedgeChannel 选择对冲渠道：与当前渠道的类型、附加配置、模型映射和参数覆盖一致，
+// 这样可以直接复用已经转换好的请求体；已经用过的渠道不再选择。
+// 返回的渠道已占用熔断器的半开探测名额，调用方必须通过 circuitbreaker.Record 记录结果
+func GetHedgeChannel(c *gin.Context, info *relaycommon.RelayInfo) *model.Channel {
+	current, err := model.CacheGetChannel(info.ChannelId)
+	if err != nil {
+		return nil
+	}
+	group := info.UsingGroup
+	if group == "auto" {
+		group = common.GetContextKeyString(c, constant.ContextKeyAutoGroup)
+	}
+	usedChannels := c.GetStringSlice("use_channel")
+	var deniedIds []int
+	for attempt := 0; attempt < maxBreakerAcquireAttempts; attempt++ {
+		channel := model.GetHedgeChannel(group, info.OriginModelName, func(channel *model.Channel) bool {
+			return channel.Id != current.Id &&
+				channel.Type == current.Type &&
+				channel.Other == current.Other &&
+				channel.GetModelMapping() == current.GetModelMapping() &&
+				lo.FromPtr(channel.ParamOverride) == lo.FromPtr(current.ParamOverride) &&
+				!slices.Contains(usedChannels, fmt.Sprintf("%d", channel.Id)) &&
+				!slices.Contains(deniedIds, channel.Id)
+		})
+		if channel == nil {
+			return nil
+		}
+		if circuitbreaker.Acquire(channel.Id, circuitbreaker.ChannelScope) {
+			return channel
+		}
+		deniedIds = append(deniedIds, channel.Id)
+	}
+	return nil
+}
+
+// RecordHedgeCancelled 记录被取消的对冲请求渠道，写入日志的 admin_info
+func RecordHedgeCancelled(c *gin.Context, channelId int) {
+	cancelled, _ := common.GetContextKeyType[[]int](c, constant.ContextKeyHedgeCancelledChannels)
+	common.SetContextKey(c, constant.ContextKeyHedgeCancelledChannels, append(cancelled, channelId))
+}
+
+func AppendHedgeAdminInfo(c *gin.Context, adminInfo map[string]interface{}) {
+	if c == nil || adminInfo == nil {
+		return
+	}
+	if cancelled, ok := common.GetContextKeyType[[]int](c, constant.ContextKeyHedgeCancelledChannels); ok && len(cancelled) > 0 {
+		adminInfo["hedge_cancelled_channels"] = cancelled
+	}
+}
@@ -2,15 +2,19 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/setting/system_setting"
+	"github.com/QuantumNous/new-api/types"
 
 	"golang.org/x/net/proxy"
 )
@@ -19,6 +23,8 @@ var (
 	httpClient      *http.Client
 	proxyClientLock sync.Mutex
 	proxyClients    = make(map[string]*http.Client)
+	// channelClients 按渠道超时配置缓存的客户端，key 为代理地址与各项超时的组合
+	channelClients = make(map[string]*http.Client)
 )
 
 func checkRedirect(req *http.Request, via []*http.Request) error {
@@ -80,6 +86,87 @@ func ResetProxyClientCache() {
 		}
 	}
 	proxyClients = make(map[string]*http.Client)
+	for _, client := range channelClients {
+		if transport, ok := client.Transport.(*http.Transport); ok && transport != nil {
+			transport.CloseIdleConnections()
+		}
+	}
+	channelClients = make(map[string]*http.Client)
+}
+
+// GetChannelHttpClient 根据渠道设置返回客户端：未配置超时时与 NewProxyHttpClient 相同，
+// 否则在代理客户端的基础上覆盖连接、响应头和整体超时
+func GetChannelHttpClient(setting dto.ChannelSettings) (*http.Client, error) {
+	baseClient, err := NewProxyHttpClient(setting.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	if setting.ConnectTimeout <= 0 && setting.ResponseHeaderTimeout <= 0 && setting.TotalTimeout <= 0 {
+		return baseClient, nil
+	}
+	baseTransport, ok := baseClient.Transport.(*http.Transport)
+	if !ok || baseTransport == nil {
+		return baseClient, nil
+	}
+
+	key := fmt.Sprintf("%s|%d|%d|%d", setting.Proxy, setting.ConnectTimeout, setting.ResponseHeaderTimeout, setting.TotalTimeout)
+	proxyClientLock.Lock()
+	defer proxyClientLock.Unlock()
+	if client, ok := channelClients[key]; ok {
+		return client, nil
+	}
+
+	transport := baseTransport.Clone()
+	if setting.ConnectTimeout > 0 {
+		connectTimeout := time.Duration(setting.ConnectTimeout) * time.Second
+		transport.TLSHandshakeTimeout = connectTimeout
+		if dialContext := transport.DialContext; dialContext != nil {
+			transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+				defer cancel()
+				return dialContext(ctx, network, addr)
+			}
+		} else {
+			transport.DialContext = (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext
+		}
+	}
+	if setting.ResponseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = time.Duration(setting.ResponseHeaderTimeout) * time.Second
+	}
+	client := &http.Client{
+		Transport:     transport,
+		CheckRedirect: checkRedirect,
+		Timeout:       baseClient.Timeout,
+	}
+	if setting.TotalTimeout > 0 {
+		client.Timeout = time.Duration(setting.TotalTimeout) * time.Second
+	}
+	channelClients[key] = client
+	return client, nil
+}
+
+// TimeoutErrorCode 把 HTTP 客户端的超时错误映射为对应的错误码，非超时错误返回 false
+func TimeoutErrorCode(err error) (types.ErrorCode, bool) {
+	if err == nil {
+		return "", false
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "timeout awaiting response headers"):
+		return types.ErrorCodeResponseHeaderTimeout, true
+	case strings.Contains(msg, "Client.Timeout exceeded"):
+		return types.ErrorCodeTotalTimeout, true
+	case strings.Contains(msg, "TLS handshake timeout"):
+		return types.ErrorCodeConnectTimeout, true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" && opErr.Timeout() {
+		return types.ErrorCodeConnectTimeout, true
+	}
+	if errors.Is(err, context.DeadlineExceeded) && strings.Contains(msg, "dial") {
+		return types.ErrorCodeConnectTimeout, true
+	}
+	return "", false
 }
 
 // NewProxyHttpClient 创建支持代理的 HTTP 客户端
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/stretchr/testify/require"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestTimeoutErrorCode(t *testing.T) {
+	dialErr := &url.Error{Op: "Post", URL: "https://api.example.com", Err: &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}}
+	code, ok := TimeoutErrorCode(dialErr)
+	require.True(t, ok)
+	require.Equal(t, types.ErrorCodeConnectTimeout, code)
+
+	code, ok = TimeoutErrorCode(errors.New("net/http: timeout awaiting response headers"))
+	require.True(t, ok)
+	require.Equal(t, types.ErrorCodeResponseHeaderTimeout, code)
+
+	code, ok = TimeoutErrorCode(errors.New(`Post "https://api.example.com": context deadline exceeded (Client.Timeout exceeded while awaiting headers)`))
+	require.True(t, ok)
+	require.Equal(t, types.ErrorCodeTotalTimeout, code)
+
+	_, ok = TimeoutErrorCode(errors.New("connection refused"))
+	require.False(t, ok)
+}
+
+func TestGetChannelHttpClient(t *testing.T) {
+	InitHttpClient()
+
+	client, err := GetChannelHttpClient(dto.ChannelSettings{ResponseHeaderTimeout: 0})
+	require.NoError(t, err)
+	require.Same(t, GetHttpClient(), client)
+
+	client, err = GetChannelHttpClient(dto.ChannelSettings{ResponseHeaderTimeout: 1})
+	require.NoError(t, err)
+	transport := client.Transport.(*http.Transport)
+	require.Equal(t, time.Second, transport.ResponseHeaderTimeout)
+
+	cached, err := GetChannelHttpClient(dto.ChannelSettings{ResponseHeaderTimeout: 1})
+	require.NoError(t, err)
+	require.Same(t, client, cached)
+}
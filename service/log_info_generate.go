@@ -49,6 +49,15 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 		other["is_model_mapped"] = true
 		other["upstream_model_name"] = relayInfo.UpstreamModelName
 	}
+	if fallbackFrom := common.GetContextKeyString(ctx, constant.ContextKeyModelFallbackFrom); fallbackFrom != "" {
+		other["fallback_from"] = fallbackFrom
+	}
+	if virtualModel := common.GetContextKeyString(ctx, constant.ContextKeyVirtualModel); virtualModel != "" {
+		other["virtual_model"] = virtualModel
+	}
+	if relayInfo.StreamInterruptCode != "" {
+		other["stream_interrupt"] = relayInfo.StreamInterruptCode
+	}
 
 	isSystemPromptOverwritten := common.GetContextKeyBool(ctx, constant.ContextKeySystemPromptOverride)
 	if isSystemPromptOverwritten {
@@ -69,6 +78,7 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 	}
 
 	AppendChannelAffinityAdminInfo(ctx, adminInfo)
+	AppendHedgeAdminInfo(ctx, adminInfo)
 
 	other["admin_info"] = adminInfo
 	appendRequestPath(ctx, relayInfo, other)
@@ -219,6 +229,12 @@ func GenerateMjOtherInfo(relayInfo *relaycommon.RelayInfo, priceData types.Price
 	if priceData.GroupRatioInfo.HasSpecialRatio {
 		other["user_group_ratio"] = priceData.GroupRatioInfo.GroupSpecialRatio
 	}
+	if relayInfo.ChannelMeta != nil && relayInfo.ChannelIsMultiKey {
+		other["admin_info"] = map[string]interface{}{
+			"is_multi_key":    true,
+			"multi_key_index": relayInfo.ChannelMultiKeyIndex,
+		}
+	}
 	appendRequestPath(nil, relayInfo, other)
 	return other
 }
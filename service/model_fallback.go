@@ -0,0 +1,92 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const servedModelHeader = "x-served-model"
+
+// ParseModelFallbackChains 解析令牌上配置的降级链，空字符串表示未配置
+func ParseModelFallbackChains(raw string) (map[string][]string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	chains := make(map[string][]string)
+	if err := common.UnmarshalJsonStr(raw, &chains); err != nil {
+		return nil, fmt.Errorf("模型降级链格式错误，应为 {\"模型\": [\"降级模型\"]}: %w", err)
+	}
+	return chains, nil
+}
+
+// GetModelFallbackChain 返回 modelName 的降级模型列表：令牌配置 > 分组配置 > 全局配置。
+// 已去重、去掉原模型，并过滤掉令牌模型限制不允许的模型
+func GetModelFallbackChain(c *gin.Context, modelName string, group string) []string {
+	setting := operation_setting.GetModelFallbackSetting()
+	if !setting.Enabled || modelName == "" {
+		return nil
+	}
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return nil
+	}
+
+	var chain []string
+	found := false
+	if tokenChains, err := ParseModelFallbackChains(common.GetContextKeyString(c, constant.ContextKeyTokenModelFallback)); err == nil {
+		chain, found = tokenChains[modelName]
+	}
+	if !found {
+		chain = setting.ChainFor(group, modelName)
+	}
+
+	seen := map[string]bool{modelName: true}
+	fallbacks := make([]string, 0, len(chain))
+	for _, fallback := range chain {
+		fallback = strings.TrimSpace(fallback)
+		if fallback == "" || seen[fallback] {
+			continue
+		}
+		seen[fallback] = true
+		if !tokenAllowsModel(c, fallback) {
+			continue
+		}
+		fallbacks = append(fallbacks, fallback)
+		if setting.MaxHops > 0 && len(fallbacks) >= setting.MaxHops {
+			break
+		}
+	}
+	return fallbacks
+}
+
+func tokenAllowsModel(c *gin.Context, modelName string) bool {
+	if !common.GetContextKeyBool(c, constant.ContextKeyTokenModelLimitEnabled) {
+		return true
+	}
+	tokenModelLimit, ok := common.GetContextKeyType[map[string]bool](c, constant.ContextKeyTokenModelLimit)
+	if !ok {
+		return false
+	}
+	_, allowed := tokenModelLimit[ratio_setting.FormatMatchingModelName(modelName)]
+	return allowed
+}
+
+// MarkModelFallback 记录实际服务请求的模型，requestedModel 为客户端请求的模型。
+// 响应头需在写出响应体前设置，因此每次切换模型后、发起请求前调用
+func MarkModelFallback(c *gin.Context, requestedModel string, servedModel string) {
+	if requestedModel != "" && requestedModel != servedModel {
+		if _, ok := common.GetContextKey(c, constant.ContextKeyModelFallbackFrom); !ok {
+			common.SetContextKey(c, constant.ContextKeyModelFallbackFrom, requestedModel)
+		}
+	}
+	if operation_setting.GetModelFallbackSetting().Enabled {
+		c.Header(servedModelHeader, servedModel)
+	}
+}
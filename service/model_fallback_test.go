@@ -0,0 +1,54 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseModelFallbackChains(t *testing.T) {
+	chains, err := ParseModelFallbackChains("")
+	require.NoError(t, err)
+	require.Nil(t, chains)
+
+	chains, err = ParseModelFallbackChains(`{"gpt-4o": ["gpt-4.1", "gemini-2.5-pro"]}`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"gpt-4.1", "gemini-2.5-pro"}, chains["gpt-4o"])
+
+	_, err = ParseModelFallbackChains(`["gpt-4.1"]`)
+	require.Error(t, err)
+}
+
+func TestGetModelFallbackChain(t *testing.T) {
+	setting := operation_setting.GetModelFallbackSetting()
+	saved := *setting
+	t.Cleanup(func() { *setting = saved })
+
+	setting.Enabled = true
+	setting.MaxHops = 2
+	setting.Chains = map[string][]string{"gpt-4o": {"gpt-4o", "gpt-4.1", "gpt-4.1", "o3", "gemini-2.5-pro"}}
+	setting.GroupChains = map[string]map[string][]string{"vip": {"gpt-4o": {"claude-sonnet-4-20250514"}}}
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	// 去掉原模型与重复项，并受 MaxHops 限制
+	require.Equal(t, []string{"gpt-4.1", "o3"}, GetModelFallbackChain(c, "gpt-4o", "default"))
+	require.Equal(t, []string{"claude-sonnet-4-20250514"}, GetModelFallbackChain(c, "gpt-4o", "vip"))
+	require.Empty(t, GetModelFallbackChain(c, "gpt-4.1", "default"))
+
+	// 令牌配置优先，且过滤令牌不允许的模型
+	common.SetContextKey(c, constant.ContextKeyTokenModelFallback, `{"gpt-4o": ["o3", "gemini-2.5-pro"]}`)
+	common.SetContextKey(c, constant.ContextKeyTokenModelLimitEnabled, true)
+	common.SetContextKey(c, constant.ContextKeyTokenModelLimit, map[string]bool{"gemini-2.5-pro": true})
+	require.Equal(t, []string{"gemini-2.5-pro"}, GetModelFallbackChain(c, "gpt-4o", "default"))
+
+	setting.Enabled = false
+	require.Nil(t, GetModelFallbackChain(c, "gpt-4o", "default"))
+}
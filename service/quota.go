@@ -95,7 +95,7 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 		return err
 	}
 
-	token, err := model.GetTokenByKey(strings.TrimPrefix(relayInfo.TokenKey, "sk-"), false)
+	token, err := getRelayToken(relayInfo)
 	if err != nil {
 		return err
 	}
@@ -212,6 +212,9 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
 		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
 	}
+	ReconcileRateLimit(ctx, usage.InputTokens, usage.OutputTokens)
+	RecordChannelKeyTokens(relayInfo, totalTokens)
+	RecordChannelKeyQuota(relayInfo, quota)
 
 	logModel := modelName
 	if extraContent != "" {
@@ -313,6 +316,8 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	if err := SettleBilling(ctx, relayInfo, quota); err != nil {
 		logger.LogError(ctx, "error settling billing: "+err.Error())
 	}
+	ReconcileRateLimit(ctx, promptTokens, completionTokens)
+	RecordChannelKeyTokens(relayInfo, promptTokens+completionTokens)
 
 	other := GenerateClaudeOtherInfo(ctx, relayInfo, modelRatio, groupRatio, completionRatio,
 		cacheTokens, cacheRatio,
@@ -419,6 +424,8 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, u
 	if err := SettleBilling(ctx, relayInfo, quota); err != nil {
 		logger.LogError(ctx, "error settling billing: "+err.Error())
 	}
+	ReconcileRateLimit(ctx, usage.PromptTokens, usage.CompletionTokens)
+	RecordChannelKeyTokens(relayInfo, usage.PromptTokens+usage.CompletionTokens)
 
 	logModel := relayInfo.OriginModelName
 	if extraContent != "" {
@@ -442,6 +449,14 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, u
 	})
 }
 
+// getRelayToken 获取请求使用的令牌，子令牌请求中只有父令牌的哈希
+func getRelayToken(relayInfo *relaycommon.RelayInfo) (*model.Token, error) {
+	if model.IsHashedTokenKey(relayInfo.TokenKey) {
+		return model.GetTokenByLookupHash(relayInfo.TokenId, relayInfo.TokenKey)
+	}
+	return model.GetTokenByKey(strings.TrimPrefix(relayInfo.TokenKey, "sk-"), false)
+}
+
 func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
@@ -452,13 +467,20 @@ func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
 	//if relayInfo.TokenUnlimited {
 	//	return nil
 	//}
-	token, err := model.GetTokenByKey(relayInfo.TokenKey, false)
+	token, err := getRelayToken(relayInfo)
 	if err != nil {
 		return err
 	}
 	if !relayInfo.TokenUnlimited && token.RemainQuota < quota {
 		return fmt.Errorf("token quota is not enough, token remain quota: %s, need quota: %s", logger.FormatQuota(token.RemainQuota), logger.FormatQuota(quota))
 	}
+	if token.HasBudget() {
+		budgetUsed := token.GetBudgetUsed(common.GetTimestamp())
+		if budgetUsed+quota > token.BudgetLimit {
+			return fmt.Errorf("token %s budget is not enough, budget used: %s, budget limit: %s, need quota: %s", token.BudgetPeriod,
+				logger.FormatQuota(budgetUsed), logger.FormatQuota(token.BudgetLimit), logger.FormatQuota(quota))
+		}
+	}
 	err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
 	if err != nil {
 		return err
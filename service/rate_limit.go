@@ -0,0 +1,277 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	rateLimitScopeToken = "token"
+	rateLimitScopeUser  = "user"
+	rateLimitScopeGroup = "group"
+)
+
+// rateLimitScope 一个限流维度：令牌、用户，或分组/模型下的单个用户
+type rateLimitScope struct {
+	name  string
+	id    string
+	limit operation_setting.TokenRateLimit
+}
+
+func (s rateLimitScope) inputKey() string {
+	return fmt.Sprintf("rateLimit:tpm:input:%s:%s", s.name, s.id)
+}
+
+func (s rateLimitScope) outputKey() string {
+	return fmt.Sprintf("rateLimit:tpm:output:%s:%s", s.name, s.id)
+}
+
+func (s rateLimitScope) concurrencyKey() string {
+	return fmt.Sprintf("rateLimit:concurrency:%s:%s", s.name, s.id)
+}
+
+// RateLimitSession 记录一次请求持有的 TPM 预占与并发名额，请求结束时修正与释放
+type RateLimitSession struct {
+	scopes      []rateLimitScope
+	member      string
+	inputTokens int64
+	inputHeld   []rateLimitScope
+	concHeld    []rateLimitScope
+	reconciled  bool
+
+	// 剩余比例最低的 TPM 检查结果，用于 x-ratelimit-*-tokens 响应头
+	tokenLimit  int64
+	tokenResult limiter.TokenWindowResult
+	hasTokenRes bool
+}
+
+func (s *RateLimitSession) observe(limit int, result limiter.TokenWindowResult) {
+	if !s.hasTokenRes || float64(result.Remaining)/float64(limit) < float64(s.tokenResult.Remaining)/float64(s.tokenLimit) {
+		s.tokenLimit = int64(limit)
+		s.tokenResult = result
+		s.hasTokenRes = true
+	}
+}
+
+func collectRateLimitScopes(c *gin.Context, relayInfo *relaycommon.RelayInfo) []rateLimitScope {
+	scopes := make([]rateLimitScope, 0, 3)
+	tokenLimit := operation_setting.TokenRateLimit{
+		InputTPM:    common.GetContextKeyInt(c, constant.ContextKeyTokenTpmLimit),
+		OutputTPM:   common.GetContextKeyInt(c, constant.ContextKeyTokenOutputTpmLimit),
+		Concurrency: common.GetContextKeyInt(c, constant.ContextKeyTokenConcurrencyLimit),
+	}
+	if relayInfo.TokenId > 0 && !tokenLimit.IsZero() {
+		scopes = append(scopes, rateLimitScope{name: rateLimitScopeToken, id: strconv.Itoa(relayInfo.TokenId), limit: tokenLimit})
+	}
+	userLimit := operation_setting.TokenRateLimit{
+		InputTPM:    common.GetContextKeyInt(c, constant.ContextKeyUserTpmLimit),
+		OutputTPM:   common.GetContextKeyInt(c, constant.ContextKeyUserOutputTpmLimit),
+		Concurrency: common.GetContextKeyInt(c, constant.ContextKeyUserConcurrencyLimit),
+	}
+	if relayInfo.UserId > 0 && !userLimit.IsZero() {
+		scopes = append(scopes, rateLimitScope{name: rateLimitScopeUser, id: strconv.Itoa(relayInfo.UserId), limit: userLimit})
+	}
+	setting := operation_setting.GetTokenRateLimitSetting()
+	if setting.Enabled {
+		// "*" 配置项下的所有模型共享同一份额度
+		limit, modelKey, ok := setting.GetGroupModelTokenRateLimit(relayInfo.UsingGroup, relayInfo.OriginModelName)
+		if ok && !limit.IsZero() {
+			scopes = append(scopes, rateLimitScope{
+				name:  rateLimitScopeGroup,
+				id:    fmt.Sprintf("%s:%s:%d", relayInfo.UsingGroup, modelKey, relayInfo.UserId),
+				limit: limit,
+			})
+		}
+	}
+	return scopes
+}
+
+// AcquireRateLimit 检查令牌、用户以及分组/模型级别的 TPM 与并发限制，并按预估的输入 token 数预占额度。
+// 超出限制时返回 429 并设置 x-ratelimit-* 与 retry-after 响应头；Redis 不可用时放行
+func AcquireRateLimit(c *gin.Context, relayInfo *relaycommon.RelayInfo, promptTokens int) *types.NewAPIError {
+	scopes := collectRateLimitScopes(c, relayInfo)
+	if len(scopes) == 0 {
+		return nil
+	}
+	member := relayInfo.RequestId
+	if member == "" {
+		member = common.GetRandomString(16)
+	}
+	session := &RateLimitSession{
+		scopes:      scopes,
+		member:      member,
+		inputTokens: int64(promptTokens),
+	}
+	ctx := c.Request.Context()
+
+	for _, scope := range scopes {
+		if scope.limit.Concurrency <= 0 {
+			continue
+		}
+		allowed, count, err := limiter.ConcurrencyAcquire(ctx, scope.concurrencyKey(), member, int64(scope.limit.Concurrency))
+		if err != nil {
+			logger.LogError(c, "concurrency limit check failed: "+err.Error())
+			continue
+		}
+		if !allowed {
+			session.release(true)
+			c.Header("x-ratelimit-limit-concurrency", strconv.Itoa(scope.limit.Concurrency))
+			c.Header("x-ratelimit-remaining-concurrency", strconv.FormatInt(max(int64(scope.limit.Concurrency)-count, 0), 10))
+			c.Header("retry-after", "1")
+			return rateLimitError(fmt.Sprintf("%s 并发请求数已达上限 %d，请稍后重试", scopeDisplayName(scope), scope.limit.Concurrency))
+		}
+		session.concHeld = append(session.concHeld, scope)
+	}
+
+	for _, scope := range scopes {
+		if scope.limit.InputTPM <= 0 {
+			continue
+		}
+		result, err := limiter.TokenWindowAcquire(ctx, scope.inputKey(), int64(promptTokens), int64(scope.limit.InputTPM))
+		if err != nil {
+			logger.LogError(c, "token rate limit check failed: "+err.Error())
+			continue
+		}
+		if !result.Allowed {
+			session.release(true)
+			setTokenRateLimitHeaders(c, int64(scope.limit.InputTPM), result)
+			return rateLimitError(fmt.Sprintf("%s 每分钟输入 token 数已达上限 %d，请在 %s 后重试", scopeDisplayName(scope), scope.limit.InputTPM, formatRateLimitReset(result.ResetAfter)))
+		}
+		session.inputHeld = append(session.inputHeld, scope)
+		session.observe(scope.limit.InputTPM, result)
+	}
+
+	for _, scope := range scopes {
+		if scope.limit.OutputTPM <= 0 {
+			continue
+		}
+		// 输出 token 无法预估，只检查额度是否已耗尽，请求结束后按实际用量扣除
+		result, err := limiter.TokenWindowAcquire(ctx, scope.outputKey(), 0, int64(scope.limit.OutputTPM))
+		if err != nil {
+			logger.LogError(c, "token rate limit check failed: "+err.Error())
+			continue
+		}
+		if !result.Allowed {
+			session.release(true)
+			setTokenRateLimitHeaders(c, int64(scope.limit.OutputTPM), result)
+			return rateLimitError(fmt.Sprintf("%s 每分钟输出 token 数已达上限 %d，请在 %s 后重试", scopeDisplayName(scope), scope.limit.OutputTPM, formatRateLimitReset(result.ResetAfter)))
+		}
+		session.observe(scope.limit.OutputTPM, result)
+	}
+
+	common.SetContextKey(c, constant.ContextKeyRateLimitSession, session)
+	return nil
+}
+
+// ReconcileRateLimit 按实际用量修正 TPM 额度：返还或补扣输入 token 的预估差值，并扣除输出 token
+func ReconcileRateLimit(c *gin.Context, promptTokens int, completionTokens int) {
+	session := getRateLimitSession(c)
+	if session == nil || session.reconciled {
+		return
+	}
+	session.reconciled = true
+	ctx := context.Background()
+	delta := int64(promptTokens) - session.inputTokens
+	if delta != 0 {
+		for _, scope := range session.inputHeld {
+			if _, err := limiter.TokenWindowAdjust(ctx, scope.inputKey(), delta, int64(scope.limit.InputTPM)); err != nil {
+				logger.LogError(c, "token rate limit adjust failed: "+err.Error())
+			}
+		}
+	}
+	if completionTokens > 0 {
+		for _, scope := range session.scopes {
+			if scope.limit.OutputTPM <= 0 {
+				continue
+			}
+			if _, err := limiter.TokenWindowAdjust(ctx, scope.outputKey(), int64(completionTokens), int64(scope.limit.OutputTPM)); err != nil {
+				logger.LogError(c, "token rate limit adjust failed: "+err.Error())
+			}
+		}
+	}
+}
+
+// ReleaseRateLimit 在请求结束时释放并发名额；请求失败且未结算时返还预占的输入 token
+func ReleaseRateLimit(c *gin.Context, failed bool) {
+	session := getRateLimitSession(c)
+	if session == nil {
+		return
+	}
+	session.release(failed && !session.reconciled)
+}
+
+func (s *RateLimitSession) release(refundInput bool) {
+	ctx := context.Background()
+	if refundInput && s.inputTokens > 0 {
+		for _, scope := range s.inputHeld {
+			if _, err := limiter.TokenWindowAdjust(ctx, scope.inputKey(), -s.inputTokens, int64(scope.limit.InputTPM)); err != nil {
+				common.SysError("token rate limit refund failed: " + err.Error())
+			}
+		}
+	}
+	s.inputHeld = nil
+	for _, scope := range s.concHeld {
+		if err := limiter.ConcurrencyRelease(ctx, scope.concurrencyKey(), s.member); err != nil {
+			common.SysError("concurrency limit release failed: " + err.Error())
+		}
+	}
+	s.concHeld = nil
+}
+
+func getRateLimitSession(c *gin.Context) *RateLimitSession {
+	value, ok := common.GetContextKey(c, constant.ContextKeyRateLimitSession)
+	if !ok {
+		return nil
+	}
+	session, _ := value.(*RateLimitSession)
+	return session
+}
+
+func rateLimitError(message string) *types.NewAPIError {
+	return types.NewErrorWithStatusCode(fmt.Errorf("%s", message), types.ErrorCodeRateLimitExceeded, http.StatusTooManyRequests,
+		types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+}
+
+func scopeDisplayName(scope rateLimitScope) string {
+	switch scope.name {
+	case rateLimitScopeToken:
+		return "令牌"
+	case rateLimitScopeUser:
+		return "用户"
+	default:
+		return "分组"
+	}
+}
+
+func setTokenRateLimitHeaders(c *gin.Context, limit int64, result limiter.TokenWindowResult) {
+	c.Header("x-ratelimit-limit-tokens", strconv.FormatInt(limit, 10))
+	c.Header("x-ratelimit-remaining-tokens", strconv.FormatInt(max(result.Remaining, 0), 10))
+	c.Header("x-ratelimit-reset-tokens", formatRateLimitReset(result.ResetAfter))
+	if !result.Allowed {
+		c.Header("retry-after", strconv.Itoa(max(int(math.Ceil(result.ResetAfter.Seconds())), 1)))
+	}
+}
+
+// formatRateLimitReset 与 OpenAI 的 x-ratelimit-reset-* 格式保持一致，如 "20ms"、"1s"、"6m0s"
+func formatRateLimitReset(d time.Duration) string {
+	if d <= 0 {
+		return "0s"
+	}
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
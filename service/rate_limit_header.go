@@ -0,0 +1,50 @@
+package service
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/gin-gonic/gin"
+)
+
+// RequestRateLimitState 模型请求数限流（ModelRequestRateLimit）窗口的状态，Remaining 已扣除当前请求
+type RequestRateLimitState struct {
+	Limit      int
+	Remaining  int
+	ResetAfter time.Duration
+}
+
+func SetRequestRateLimitState(c *gin.Context, state RequestRateLimitState) {
+	common.SetContextKey(c, constant.ContextKeyRequestRateLimitState, state)
+}
+
+// SetRateLimitHeaders 在响应写出之前设置 x-ratelimit-* 与额度响应头，流式响应同样生效。
+// 需要在 AcquireRateLimit 之后调用，以便带上 TPM 窗口的状态
+func SetRateLimitHeaders(c *gin.Context, relayInfo *relaycommon.RelayInfo) {
+	rateLimitHeaders, quotaHeaders := operation_setting.GetRateLimitHeaderSetting().HeadersForGroup(relayInfo.UsingGroup)
+	if rateLimitHeaders {
+		if value, ok := common.GetContextKey(c, constant.ContextKeyRequestRateLimitState); ok {
+			if state, ok := value.(RequestRateLimitState); ok && state.Limit > 0 {
+				c.Header("x-ratelimit-limit-requests", strconv.Itoa(state.Limit))
+				c.Header("x-ratelimit-remaining-requests", strconv.Itoa(max(state.Remaining, 0)))
+				c.Header("x-ratelimit-reset-requests", formatRateLimitReset(state.ResetAfter))
+			}
+		}
+		if session := getRateLimitSession(c); session != nil && session.hasTokenRes {
+			setTokenRateLimitHeaders(c, session.tokenLimit, session.tokenResult)
+		}
+	}
+	if quotaHeaders {
+		c.Header("X-New-Api-Group", relayInfo.UsingGroup)
+		c.Header("X-New-Api-User-Quota", strconv.Itoa(relayInfo.UserQuota))
+		if relayInfo.TokenUnlimited {
+			c.Header("X-New-Api-Remaining-Quota", "unlimited")
+		} else {
+			c.Header("X-New-Api-Remaining-Quota", strconv.Itoa(c.GetInt("token_quota")))
+		}
+	}
+}
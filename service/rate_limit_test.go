@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// 实时语音（WebSocket）结算同样要把输出 token 计入 TPM 窗口
+func TestPostWssConsumeQuotaReconcilesRateLimit(t *testing.T) {
+	truncate(t)
+	seedUser(t, 7301, 1000000)
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/v1/realtime", nil)
+	common.SetContextKey(c, constant.ContextKeyTokenTpmLimit, 1000)
+	common.SetContextKey(c, constant.ContextKeyTokenOutputTpmLimit, 1000)
+
+	info := &relaycommon.RelayInfo{
+		UserId:          7301,
+		TokenId:         7302,
+		OriginModelName: "gpt-4o-realtime-preview",
+		StartTime:       time.Now(),
+		RequestId:       "rate-limit-wss",
+		ChannelMeta:     &relaycommon.ChannelMeta{ChannelId: 7303},
+		PriceData: types.PriceData{
+			ModelRatio:     1,
+			GroupRatioInfo: types.GroupRatioInfo{GroupRatio: 1},
+		},
+	}
+	require.Nil(t, AcquireRateLimit(c, info, 100))
+
+	usage := &dto.RealtimeUsage{TotalTokens: 400, InputTokens: 150, OutputTokens: 250}
+	usage.InputTokenDetails.TextTokens = 150
+	usage.OutputTokenDetails.TextTokens = 250
+	PostWssConsumeQuota(c, info, info.OriginModelName, usage, "")
+	ReleaseRateLimit(c, false)
+
+	scope := rateLimitScope{name: rateLimitScopeToken, id: "7302"}
+	input, err := limiter.TokenWindowAcquire(context.Background(), scope.inputKey(), 0, 1000)
+	require.NoError(t, err)
+	require.Equal(t, int64(850), input.Remaining)
+	output, err := limiter.TokenWindowAcquire(context.Background(), scope.outputKey(), 0, 1000)
+	require.NoError(t, err)
+	require.Equal(t, int64(750), output.Remaining)
+}
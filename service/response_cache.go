@@ -0,0 +1,432 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/pkg/cachex"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/samber/hot"
+)
+
+const (
+	responseCacheNamespace = "new-api:response_cache:v1"
+	// ResponseCacheHeader 响应头，取值 HIT / MISS；请求中设置为 force 时强制缓存 temperature>0 的请求
+	ResponseCacheHeader = "X-New-Api-Cache"
+	// responseCacheOtherRatioKey 命中缓存时写入 PriceData.OtherRatios 的倍率名
+	responseCacheOtherRatioKey = "response_cache_hit"
+)
+
+// ResponseCacheEntry 缓存的下游响应，流式响应保存原始 SSE 字节用于回放
+type ResponseCacheEntry struct {
+	ContentType string    `json:"content_type"`
+	IsStream    bool      `json:"is_stream"`
+	Body        []byte    `json:"body"`
+	Usage       dto.Usage `json:"usage"`
+	CreatedAt   int64     `json:"created_at"`
+}
+
+var (
+	responseCacheOnce sync.Once
+	responseCache     *cachex.HybridCache[ResponseCacheEntry]
+)
+
+func getResponseCache() *cachex.HybridCache[ResponseCacheEntry] {
+	responseCacheOnce.Do(func() {
+		setting := operation_setting.GetResponseCacheSetting()
+		capacity := setting.MaxEntries
+		if capacity <= 0 {
+			capacity = 10000
+		}
+		responseCache = cachex.NewHybridCache[ResponseCacheEntry](cachex.HybridCacheConfig[ResponseCacheEntry]{
+			Namespace: cachex.Namespace(responseCacheNamespace),
+			Redis:     common.RDB,
+			RedisEnabled: func() bool {
+				return common.RedisEnabled && common.RDB != nil
+			},
+			RedisCodec: cachex.JSONCodec[ResponseCacheEntry]{},
+			Memory: func() *hot.HotCache[string, ResponseCacheEntry] {
+				return hot.NewHotCache[string, ResponseCacheEntry](hot.LRU, capacity).
+					WithJanitor().
+					Build()
+			},
+		})
+	})
+	return responseCache
+}
+
+// ResponseCacheEmbedder 计算语义缓存使用的向量，费用计入 tokenId 对应的令牌
+type ResponseCacheEmbedder func(tokenId int, model string, input string) ([]float64, error)
+
+var responseCacheEmbedder ResponseCacheEmbedder
+
+// SetResponseCacheEmbedder 注册语义缓存的向量计算函数，未注册时语义缓存不生效
+func SetResponseCacheEmbedder(embedder ResponseCacheEmbedder) {
+	responseCacheEmbedder = embedder
+}
+
+// ResponseCacheSession 单个请求的缓存状态，在重试之间复用；nil 表示该请求不使用缓存
+type ResponseCacheSession struct {
+	key    string
+	model  string // 计算缓存键时的模型，模型降级后需要重新计算
+	lookup bool
+	store  bool
+
+	semanticScope string
+	semanticText  string
+	vector        []float64
+	embedTried    bool
+
+	capture *responseCaptureWriter
+}
+
+// PrepareResponseCache 判断请求是否使用响应缓存并计算缓存键。
+// 键由缓存归属（用户或令牌）、规范化后的请求体、模型与分组组成；deterministic 为 false（temperature>0）时除非强制否则不缓存；
+// semanticText 非空时在精确匹配未命中后尝试语义匹配；模型降级后按新模型重新计算，避免降级模型的响应存入原模型的键下
+func PrepareResponseCache(c *gin.Context, info *relaycommon.RelayInfo, deterministic bool, semanticText string) *ResponseCacheSession {
+	if value, ok := common.GetContextKey(c, constant.ContextKeyResponseCacheSession); ok {
+		session, _ := value.(*ResponseCacheSession)
+		if session == nil || session.model == info.OriginModelName {
+			return session
+		}
+	}
+	session := newResponseCacheSession(c, info, deterministic, semanticText)
+	common.SetContextKey(c, constant.ContextKeyResponseCacheSession, session)
+	return session
+}
+
+func newResponseCacheSession(c *gin.Context, info *relaycommon.RelayInfo, deterministic bool, semanticText string) *ResponseCacheSession {
+	setting := operation_setting.GetResponseCacheSetting()
+	if !setting.Enabled || !common.GetContextKeyBool(c, constant.ContextKeyTokenResponseCache) {
+		return nil
+	}
+	forced := strings.EqualFold(c.GetHeader(ResponseCacheHeader), "force")
+	if !deterministic && !setting.CacheNonDeterministic && !forced {
+		return nil
+	}
+	session := &ResponseCacheSession{model: info.OriginModelName, lookup: true, store: true}
+	for _, directive := range strings.Split(c.GetHeader("Cache-Control"), ",") {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "no-cache":
+			session.lookup = false
+		case "no-store":
+			session.store = false
+		}
+	}
+	if !session.lookup && !session.store {
+		return nil
+	}
+	storage, err := common.GetBodyStorage(c)
+	if err != nil {
+		return nil
+	}
+	body, err := storage.Bytes()
+	if err != nil {
+		return nil
+	}
+	normalized, params := normalizeResponseCacheBody(body)
+	prefix := fmt.Sprintf("%s|%d|%s|%s|", responseCacheOwner(info, setting.Scope), info.RelayMode, info.OriginModelName, info.UsingGroup)
+	session.key = responseCacheHash(prefix, normalized)
+	if setting.SemanticEnabled && semanticText != "" && params != nil && responseCacheEmbedder != nil {
+		session.semanticScope = responseCacheHash(prefix, params)
+		session.semanticText = semanticText
+	}
+	return session
+}
+
+// responseCacheOwner 缓存归属，精确匹配的键与语义索引都按归属隔离，避免不同用户之间互相读取响应
+func responseCacheOwner(info *relaycommon.RelayInfo, scope string) string {
+	if scope == operation_setting.ResponseCacheScopeToken {
+		return fmt.Sprintf("token:%d", info.TokenId)
+	}
+	return fmt.Sprintf("user:%d", info.UserId)
+}
+
+// normalizeResponseCacheBody 返回按字段名排序的请求体，以及去掉 prompt 内容后只包含参数的部分（用于语义匹配分桶）
+func normalizeResponseCacheBody(body []byte) ([]byte, []byte) {
+	var payload map[string]any
+	if err := common.Unmarshal(body, &payload); err != nil {
+		return body, nil
+	}
+	// 不影响生成结果的字段；stream_options 决定流式响应是否包含 usage 块，需要保留
+	delete(payload, "user")
+	normalized, err := common.Marshal(payload)
+	if err != nil {
+		return body, nil
+	}
+	for _, field := range []string{"messages", "prompt", "input"} {
+		delete(payload, field)
+	}
+	params, err := common.Marshal(payload)
+	if err != nil {
+		return normalized, nil
+	}
+	return normalized, params
+}
+
+func responseCacheHash(prefix string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(prefix))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Lookup 查找缓存，先精确匹配再语义匹配
+func (s *ResponseCacheSession) Lookup(c *gin.Context, info *relaycommon.RelayInfo) *ResponseCacheEntry {
+	if s == nil || !s.lookup {
+		return nil
+	}
+	cache := getResponseCache()
+	entry, found, err := cache.Get(s.key)
+	if err != nil {
+		logger.LogWarn(c, "response cache get failed: "+err.Error())
+	}
+	if found {
+		return &entry
+	}
+	if s.semanticScope != "" {
+		if vector := s.embed(c, info); vector != nil {
+			setting := operation_setting.GetResponseCacheSetting()
+			if key, ok := semanticCacheSearch(s.semanticScope, vector, setting.SemanticThreshold); ok {
+				entry, found, err = cache.Get(key)
+				if err == nil && found {
+					return &entry
+				}
+			}
+		}
+	}
+	if s.store {
+		c.Header(ResponseCacheHeader, "MISS")
+	}
+	return nil
+}
+
+func (s *ResponseCacheSession) embed(c *gin.Context, info *relaycommon.RelayInfo) []float64 {
+	if s.embedTried {
+		return s.vector
+	}
+	s.embedTried = true
+	model := operation_setting.GetResponseCacheSetting().SemanticEmbeddingModel
+	if model == "" || responseCacheEmbedder == nil {
+		return nil
+	}
+	vector, err := responseCacheEmbedder(info.TokenId, model, s.semanticText)
+	if err != nil {
+		logger.LogWarn(c, "response cache embedding failed: "+err.Error())
+		return nil
+	}
+	s.vector = vector
+	return vector
+}
+
+// Capture 记录写给客户端的响应，返回的函数用于恢复原始 Writer
+func (s *ResponseCacheSession) Capture(c *gin.Context) func() {
+	if s == nil || !s.store {
+		return func() {}
+	}
+	limit := operation_setting.GetResponseCacheSetting().MaxBodyBytes
+	if limit <= 0 {
+		limit = 1 << 20
+	}
+	writer := &responseCaptureWriter{ResponseWriter: c.Writer, limit: limit}
+	s.capture = writer
+	c.Writer = writer
+	return func() {
+		c.Writer = writer.ResponseWriter
+	}
+}
+
+// Store 在请求成功后保存响应；响应过大、状态码非 200 或没有用量信息时不保存
+func (s *ResponseCacheSession) Store(info *relaycommon.RelayInfo, usage *dto.Usage) {
+	if s == nil || !s.store || s.capture == nil || usage == nil {
+		return
+	}
+	capture := s.capture
+	s.capture = nil
+	if info.OriginModelName != s.model {
+		return
+	}
+	if capture.overflow || capture.Status() != http.StatusOK || capture.buf.Len() == 0 || usage.PromptTokens+usage.CompletionTokens == 0 {
+		return
+	}
+	entry := ResponseCacheEntry{
+		ContentType: capture.Header().Get("Content-Type"),
+		IsStream:    info.IsStream,
+		Body:        bytes.Clone(capture.buf.Bytes()),
+		Usage:       *usage,
+		CreatedAt:   common.GetTimestamp(),
+	}
+	setting := operation_setting.GetResponseCacheSetting()
+	ttl := time.Duration(setting.TTLSeconds) * time.Second
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	key, scope, vector := s.key, s.semanticScope, s.vector
+	gopool.Go(func() {
+		if err := getResponseCache().SetWithTTL(key, entry, ttl); err != nil {
+			common.SysError("response cache set failed: " + err.Error())
+			return
+		}
+		if scope != "" && vector != nil {
+			semanticCacheAdd(scope, key, vector, ttl, setting.SemanticMaxEntries)
+		}
+	})
+}
+
+// ReplayResponseCache 将缓存的响应写回客户端，流式响应按事件逐条写出
+func ReplayResponseCache(c *gin.Context, entry *ResponseCacheEntry) {
+	common.SetContextKey(c, constant.ContextKeyResponseCacheHit, true)
+	c.Header(ResponseCacheHeader, "HIT")
+	contentType := entry.ContentType
+	if !entry.IsStream {
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		c.Data(http.StatusOK, contentType, entry.Body)
+		return
+	}
+	if contentType == "" {
+		contentType = "text/event-stream"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	for _, event := range bytes.SplitAfter(entry.Body, []byte("\n\n")) {
+		if len(event) == 0 {
+			continue
+		}
+		if _, err := c.Writer.Write(event); err != nil {
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// ApplyResponseCacheHitBilling 命中缓存时按 HitBillingRatio 计费
+func ApplyResponseCacheHitBilling(info *relaycommon.RelayInfo) {
+	ratio := operation_setting.GetResponseCacheSetting().HitBillingRatio
+	if ratio < 0 {
+		ratio = 0
+	}
+	if info.PriceData.OtherRatios == nil {
+		info.PriceData.OtherRatios = make(map[string]float64)
+	}
+	// 不使用 AddOtherRatio，倍率为 0（免费）时同样需要生效
+	info.PriceData.OtherRatios[responseCacheOtherRatioKey] = ratio
+}
+
+// IsResponseCacheHit 当前请求是否由响应缓存返回，渠道健康统计等需要忽略
+func IsResponseCacheHit(c *gin.Context) bool {
+	return common.GetContextKeyBool(c, constant.ContextKeyResponseCacheHit)
+}
+
+type responseCaptureWriter struct {
+	gin.ResponseWriter
+	buf      bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (w *responseCaptureWriter) record(n int, data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.buf.Len()+n > w.limit {
+		w.overflow = true
+		w.buf.Reset()
+		return
+	}
+	w.buf.Write(data[:n])
+}
+
+func (w *responseCaptureWriter) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.record(n, data)
+	return n, err
+}
+
+func (w *responseCaptureWriter) WriteString(s string) (int, error) {
+	n, err := w.ResponseWriter.WriteString(s)
+	w.record(n, []byte(s))
+	return n, err
+}
+
+type semanticCacheItem struct {
+	key      string
+	vector   []float64
+	expireAt time.Time
+}
+
+var (
+	semanticCacheMu    sync.RWMutex
+	semanticCacheIndex = make(map[string][]semanticCacheItem)
+)
+
+func semanticCacheSearch(scope string, vector []float64, threshold float64) (string, bool) {
+	semanticCacheMu.RLock()
+	defer semanticCacheMu.RUnlock()
+	now := time.Now()
+	bestKey, bestScore := "", threshold
+	for _, item := range semanticCacheIndex[scope] {
+		if now.After(item.expireAt) {
+			continue
+		}
+		if score := cosineSimilarity(vector, item.vector); score >= bestScore {
+			bestKey, bestScore = item.key, score
+		}
+	}
+	return bestKey, bestKey != ""
+}
+
+func semanticCacheAdd(scope string, key string, vector []float64, ttl time.Duration, maxEntries int) {
+	if maxEntries <= 0 {
+		maxEntries = 1000
+	}
+	semanticCacheMu.Lock()
+	defer semanticCacheMu.Unlock()
+	now := time.Now()
+	items := semanticCacheIndex[scope]
+	kept := items[:0]
+	for _, item := range items {
+		if now.Before(item.expireAt) && item.key != key {
+			kept = append(kept, item)
+		}
+	}
+	kept = append(kept, semanticCacheItem{key: key, vector: vector, expireAt: now.Add(ttl)})
+	if len(kept) > maxEntries {
+		kept = append([]semanticCacheItem(nil), kept[len(kept)-maxEntries:]...)
+	}
+	semanticCacheIndex[scope] = kept
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
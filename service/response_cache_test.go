@@ -0,0 +1,137 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeResponseCacheBody(t *testing.T) {
+	a, paramsA := normalizeResponseCacheBody([]byte(`{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}],"user":"alice"}`))
+	b, paramsB := normalizeResponseCacheBody([]byte(`{ "messages":[{"content":"hi","role":"user"}], "user":"bob", "temperature":0, "model":"gpt-4o" }`))
+	require.Equal(t, string(a), string(b))
+	require.Equal(t, string(paramsA), string(paramsB))
+
+	c, paramsC := normalizeResponseCacheBody([]byte(`{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hello"}]}`))
+	require.NotEqual(t, string(a), string(c))
+	// 只有 prompt 不同时参数部分相同，可以进行语义匹配
+	require.Equal(t, string(paramsA), string(paramsC))
+
+	_, paramsD := normalizeResponseCacheBody([]byte(`{"model":"gpt-4o","temperature":0,"max_tokens":10,"messages":[]}`))
+	require.NotEqual(t, string(paramsA), string(paramsD))
+
+	// stream_options 影响流式响应是否包含 usage 块，不能共用缓存
+	f, _ := normalizeResponseCacheBody([]byte(`{"model":"gpt-4o","stream":true,"messages":[]}`))
+	g, _ := normalizeResponseCacheBody([]byte(`{"model":"gpt-4o","stream":true,"stream_options":{"include_usage":true},"messages":[]}`))
+	require.NotEqual(t, string(f), string(g))
+
+	raw := []byte(`not json`)
+	e, paramsE := normalizeResponseCacheBody(raw)
+	require.Equal(t, raw, e)
+	require.Nil(t, paramsE)
+}
+
+func TestResponseCacheKeyScopedByOwner(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setting := operation_setting.GetResponseCacheSetting()
+	saved := *setting
+	t.Cleanup(func() { *setting = saved })
+	setting.Enabled = true
+	setting.SemanticEnabled = true
+	SetResponseCacheEmbedder(func(int, string, string) ([]float64, error) { return nil, nil })
+	t.Cleanup(func() { SetResponseCacheEmbedder(nil) })
+
+	session := func(userId, tokenId int) *ResponseCacheSession {
+		body := `{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}]}`
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		common.SetContextKey(c, constant.ContextKeyTokenResponseCache, true)
+		info := &relaycommon.RelayInfo{UserId: userId, TokenId: tokenId, OriginModelName: "gpt-4o", UsingGroup: "default"}
+		s := newResponseCacheSession(c, info, true, "hi")
+		require.NotNil(t, s)
+		return s
+	}
+
+	setting.Scope = operation_setting.ResponseCacheScopeUser
+	a, b, other := session(1, 10), session(1, 11), session(2, 20)
+	require.Equal(t, a.key, b.key)
+	require.Equal(t, a.semanticScope, b.semanticScope)
+	require.NotEqual(t, a.key, other.key)
+	require.NotEqual(t, a.semanticScope, other.semanticScope)
+
+	setting.Scope = operation_setting.ResponseCacheScopeToken
+	a, b = session(1, 10), session(1, 11)
+	require.NotEqual(t, a.key, b.key)
+	require.NotEqual(t, a.semanticScope, b.semanticScope)
+}
+
+func TestSemanticCacheIndex(t *testing.T) {
+	semanticCacheMu.Lock()
+	semanticCacheIndex = make(map[string][]semanticCacheItem)
+	semanticCacheMu.Unlock()
+
+	semanticCacheAdd("scope", "k1", []float64{1, 0, 0}, time.Minute, 2)
+	semanticCacheAdd("scope", "k2", []float64{0, 1, 0}, time.Minute, 2)
+
+	key, ok := semanticCacheSearch("scope", []float64{0.99, 0.05, 0}, 0.95)
+	require.True(t, ok)
+	require.Equal(t, "k1", key)
+
+	_, ok = semanticCacheSearch("scope", []float64{0.7, 0.7, 0}, 0.95)
+	require.False(t, ok)
+	_, ok = semanticCacheSearch("other", []float64{1, 0, 0}, 0.95)
+	require.False(t, ok)
+
+	// 超过上限时淘汰最早的向量
+	semanticCacheAdd("scope", "k3", []float64{0, 0, 1}, time.Minute, 2)
+	_, ok = semanticCacheSearch("scope", []float64{1, 0, 0}, 0.95)
+	require.False(t, ok)
+
+	// 过期的向量不参与匹配
+	semanticCacheAdd("scope", "k4", []float64{1, 1, 0}, -time.Second, 2)
+	_, ok = semanticCacheSearch("scope", []float64{1, 1, 0}, 0.95)
+	require.False(t, ok)
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	require.InDelta(t, 1.0, cosineSimilarity([]float64{1, 2}, []float64{2, 4}), 1e-9)
+	require.InDelta(t, 0.0, cosineSimilarity([]float64{1, 0}, []float64{0, 1}), 1e-9)
+	require.Equal(t, 0.0, cosineSimilarity([]float64{1}, []float64{1, 2}))
+	require.Equal(t, 0.0, cosineSimilarity([]float64{0, 0}, []float64{1, 2}))
+}
+
+func TestResponseCacheSessionRebuiltAfterModelFallback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setting := operation_setting.GetResponseCacheSetting()
+	saved := *setting
+	t.Cleanup(func() { *setting = saved })
+	setting.Enabled = true
+	setting.SemanticEnabled = false
+
+	body := `{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}]}`
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	common.SetContextKey(c, constant.ContextKeyTokenResponseCache, true)
+	info := &relaycommon.RelayInfo{UserId: 1, TokenId: 10, OriginModelName: "gpt-4o", UsingGroup: "default"}
+
+	requested := PrepareResponseCache(c, info, true, "")
+	require.NotNil(t, requested)
+	require.Same(t, requested, PrepareResponseCache(c, info, true, ""))
+
+	// 降级到其他模型后，响应不能存入原模型的缓存键
+	info.OriginModelName = "gpt-4o-mini"
+	fallback := PrepareResponseCache(c, info, true, "")
+	require.NotNil(t, fallback)
+	require.NotEqual(t, requested.key, fallback.key)
+	require.Equal(t, "gpt-4o-mini", fallback.model)
+	require.Same(t, fallback, PrepareResponseCache(c, info, true, ""))
+}
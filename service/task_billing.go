@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/QuantumNous/new-api/common"
@@ -64,6 +65,22 @@ func LogTaskConsumption(c *gin.Context, info *relaycommon.RelayInfo) {
 // 异步任务计费辅助函数
 // ---------------------------------------------------------------------------
 
+// recordTaskChannelKeyQuota 任务差额补扣累计到提交任务时使用的密钥，任务只保存了密钥本身，按密钥查找下标
+func recordTaskChannelKeyQuota(task *model.Task, quota int) {
+	if task.PrivateData.Key == "" {
+		return
+	}
+	channel, err := model.CacheGetChannel(task.ChannelId)
+	if err != nil || !channel.ChannelInfo.IsMultiKey {
+		return
+	}
+	keyIndex := slices.Index(channel.GetKeys(), task.PrivateData.Key)
+	if keyIndex < 0 {
+		return
+	}
+	recordChannelKeyQuota(task.ChannelId, keyIndex, quota)
+}
+
 // resolveTokenKey 通过 TokenId 运行时获取令牌 Key（用于 Redis 缓存操作）。
 // 如果令牌已被删除或查询失败，返回空字符串。
 func resolveTokenKey(ctx context.Context, tokenId int, taskID string) string {
@@ -216,6 +233,7 @@ func RecalculateTaskQuota(ctx context.Context, task *model.Task, actualQuota int
 		logQuota = quotaDelta
 		model.UpdateUserUsedQuotaAndRequestCount(task.UserId, quotaDelta)
 		model.UpdateChannelUsedQuota(task.ChannelId, quotaDelta)
+		recordTaskChannelKeyQuota(task, quotaDelta)
 	} else {
 		logType = model.LogTypeRefund
 		logQuota = -quotaDelta
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/QuantumNous/new-api/constant"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+)
+
+// NormalizeTokenScopes 校验并规范化令牌作用域（逗号分隔），空字符串表示不限制
+func NormalizeTokenScopes(raw string) (string, error) {
+	scopes := make([]string, 0)
+	for _, scope := range strings.Split(raw, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope == "" || slices.Contains(scopes, scope) {
+			continue
+		}
+		if !slices.Contains(constant.TokenScopes, scope) {
+			return "", fmt.Errorf("未知的令牌作用域：%s", scope)
+		}
+		scopes = append(scopes, scope)
+	}
+	return strings.Join(scopes, ","), nil
+}
+
+// tokenScopeExemptPaths 查询令牌自身用量、签发子令牌等自助接口，不受作用域限制
+var tokenScopeExemptPaths = []string{
+	"/dashboard/billing/",
+	"/v1/dashboard/billing/",
+	"/api/usage/token",
+	"/api/child_token",
+}
+
+// IsTokenScopeExemptPath 判断请求路径是否为不受作用域限制的自助接口
+func IsTokenScopeExemptPath(path string) bool {
+	for _, prefix := range tokenScopeExemptPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestTokenScope 返回请求所需的令牌作用域，按任务平台、请求路径与 relay mode 判断；
+// 返回空字符串表示无法归类，配置了作用域的令牌不能访问
+func RequestTokenScope(method string, path string) string {
+	switch {
+	case strings.HasPrefix(path, "/v1/files") || strings.HasPrefix(path, "/v1/batches"):
+		return constant.TokenScopeBatch
+	case strings.HasPrefix(path, "/suno/"):
+		return constant.TokenScopeSuno
+	case strings.HasPrefix(path, "/mj/") || strings.Contains(path, "/mj/"):
+		return constant.TokenScopeMidjourney
+	case strings.HasPrefix(path, "/v1/video") || strings.HasPrefix(path, "/kling/") || strings.HasPrefix(path, "/jimeng"):
+		return constant.TokenScopeVideo
+	case method == http.MethodGet && (strings.HasPrefix(path, "/v1/models") || strings.HasPrefix(path, "/v1beta/models") ||
+		strings.HasPrefix(path, "/v1beta/openai/models")):
+		return constant.TokenScopeModels
+	case strings.Contains(path, ":embedContent") || strings.Contains(path, ":batchEmbedContents"):
+		return constant.TokenScopeEmbeddings
+	case strings.HasPrefix(path, "/v1/messages"):
+		return constant.TokenScopeChat
+	}
+
+	switch relayconstant.Path2RelayMode(path) {
+	case relayconstant.RelayModeChatCompletions, relayconstant.RelayModeCompletions,
+		relayconstant.RelayModeResponses, relayconstant.RelayModeResponsesCompact,
+		relayconstant.RelayModeGemini, relayconstant.RelayModeGeminiCountTokens,
+		relayconstant.RelayModeGeminiCachedContents, relayconstant.RelayModeClaudeCountTokens:
+		return constant.TokenScopeChat
+	case relayconstant.RelayModeEmbeddings:
+		return constant.TokenScopeEmbeddings
+	case relayconstant.RelayModeImagesGenerations, relayconstant.RelayModeImagesEdits, relayconstant.RelayModeEdits:
+		return constant.TokenScopeImages
+	case relayconstant.RelayModeAudioSpeech, relayconstant.RelayModeAudioTranscription, relayconstant.RelayModeAudioTranslation:
+		return constant.TokenScopeAudio
+	case relayconstant.RelayModeRealtime:
+		return constant.TokenScopeRealtime
+	case relayconstant.RelayModeRerank:
+		return constant.TokenScopeRerank
+	case relayconstant.RelayModeModerations:
+		return constant.TokenScopeModerations
+	}
+	return ""
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestTokenScope(t *testing.T) {
+	cases := []struct {
+		method string
+		path   string
+		scope  string
+	}{
+		{http.MethodPost, "/v1/chat/completions", constant.TokenScopeChat},
+		{http.MethodPost, "/v1/responses", constant.TokenScopeChat},
+		{http.MethodPost, "/v1/messages", constant.TokenScopeChat},
+		{http.MethodPost, "/v1beta/models/gemini-2.5-pro:generateContent", constant.TokenScopeChat},
+		{http.MethodPost, "/v1/embeddings", constant.TokenScopeEmbeddings},
+		{http.MethodPost, "/v1/engines/text-embedding-004/embeddings", constant.TokenScopeEmbeddings},
+		{http.MethodPost, "/v1beta/models/text-embedding-004:embedContent", constant.TokenScopeEmbeddings},
+		{http.MethodPost, "/v1/images/generations", constant.TokenScopeImages},
+		{http.MethodPost, "/v1/audio/speech", constant.TokenScopeAudio},
+		{http.MethodGet, "/v1/realtime", constant.TokenScopeRealtime},
+		{http.MethodPost, "/v1/rerank", constant.TokenScopeRerank},
+		{http.MethodPost, "/v1/moderations", constant.TokenScopeModerations},
+		{http.MethodGet, "/v1/models", constant.TokenScopeModels},
+		{http.MethodGet, "/v1beta/models", constant.TokenScopeModels},
+		{http.MethodPost, "/v1/batches", constant.TokenScopeBatch},
+		{http.MethodPost, "/mj/submit/imagine", constant.TokenScopeMidjourney},
+		{http.MethodPost, "/fast/mj/submit/imagine", constant.TokenScopeMidjourney},
+		{http.MethodPost, "/suno/submit/music", constant.TokenScopeSuno},
+		{http.MethodPost, "/v1/videos", constant.TokenScopeVideo},
+		{http.MethodPost, "/kling/v1/videos/text2video", constant.TokenScopeVideo},
+		{http.MethodGet, "/api/usage/token", ""},
+	}
+	for _, tc := range cases {
+		require.Equal(t, tc.scope, RequestTokenScope(tc.method, tc.path), tc.path)
+	}
+}
+
+func TestIsTokenScopeExemptPath(t *testing.T) {
+	require.True(t, IsTokenScopeExemptPath("/api/usage/token/"))
+	require.True(t, IsTokenScopeExemptPath("/v1/dashboard/billing/subscription"))
+	require.True(t, IsTokenScopeExemptPath("/api/child_token/"))
+	require.False(t, IsTokenScopeExemptPath("/v1/chat/completions"))
+	require.False(t, IsTokenScopeExemptPath("/v1/fine-tunes"))
+}
+
+func TestHasTokenScope(t *testing.T) {
+	require.True(t, model.HasTokenScope("", constant.TokenScopeChat))
+	require.True(t, model.HasTokenScope("", ""))
+	require.True(t, model.HasTokenScope("chat,embeddings", constant.TokenScopeEmbeddings))
+	require.False(t, model.HasTokenScope("chat", constant.TokenScopeBatch))
+	// 配置了作用域时拒绝无法归类的接口
+	require.False(t, model.HasTokenScope("chat", RequestTokenScope(http.MethodPost, "/v1/fine-tunes")))
+}
+
+func TestNormalizeTokenScopes(t *testing.T) {
+	scopes, err := NormalizeTokenScopes(" chat, embeddings,chat,, ")
+	require.NoError(t, err)
+	require.Equal(t, "chat,embeddings", scopes)
+
+	scopes, err = NormalizeTokenScopes("")
+	require.NoError(t, err)
+	require.Empty(t, scopes)
+
+	_, err = NormalizeTokenScopes("chat,admin")
+	require.Error(t, err)
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IsVirtualModel 判断是否为已启用的虚拟模型，虚拟模型在 Distribute 阶段不选择渠道
+func IsVirtualModel(modelName string) bool {
+	_, ok := operation_setting.GetVirtualModelSetting().GetVirtualModel(modelName)
+	return ok
+}
+
+// GetVirtualModelNames 返回所有已启用的虚拟模型名，用于 /v1/models
+func GetVirtualModelNames() []string {
+	setting := operation_setting.GetVirtualModelSetting()
+	if !setting.Enabled {
+		return nil
+	}
+	names := make([]string, 0, len(setting.Models))
+	for name := range setting.Models {
+		names = append(names, name)
+	}
+	return names
+}
+
+// ResolveVirtualModel 根据请求内容把虚拟模型解析为真实模型，需在 EstimateRequestToken 之后、选择渠道之前调用。
+// 规则中的条件先匹配路由特征（estimated_prompt_tokens、has_image、has_tools、is_stream、group），再匹配请求体
+func ResolveVirtualModel(c *gin.Context, info *relaycommon.RelayInfo, meta *types.TokenCountMeta) (string, error) {
+	virtualName := info.OriginModelName
+	virtualModel, ok := operation_setting.GetVirtualModelSetting().GetVirtualModel(virtualName)
+	if !ok {
+		return virtualName, nil
+	}
+
+	factsJSON, err := common.Marshal(buildVirtualModelFacts(info, meta))
+	if err != nil {
+		return "", err
+	}
+	bodyJSON := ""
+	if strings.Contains(c.Request.Header.Get("Content-Type"), "application/json") {
+		if storage, err := common.GetBodyStorage(c); err == nil {
+			if body, err := storage.Bytes(); err == nil {
+				bodyJSON = string(body)
+			}
+		}
+	}
+
+	target, err := resolveVirtualModelTarget(virtualName, virtualModel, string(factsJSON), bodyJSON)
+	if err != nil {
+		return "", err
+	}
+	common.SetContextKey(c, constant.ContextKeyVirtualModel, virtualName)
+	return target, nil
+}
+
+func buildVirtualModelFacts(info *relaycommon.RelayInfo, meta *types.TokenCountMeta) map[string]any {
+	promptTokens := info.GetEstimatePromptTokens()
+	hasImage := false
+	hasTools := false
+	if meta != nil {
+		if promptTokens == 0 {
+			// 未开启 token 统计时仍需要估算长度用于路由
+			promptTokens = EstimateTokenByModel(info.OriginModelName, meta.CombineText)
+		}
+		for _, file := range meta.Files {
+			if file != nil && file.FileType == types.FileTypeImage {
+				hasImage = true
+				break
+			}
+		}
+		hasTools = meta.ToolsCount > 0
+	}
+	return map[string]any{
+		"estimated_prompt_tokens": promptTokens,
+		"has_image":               hasImage,
+		"has_tools":               hasTools,
+		"is_stream":               info.IsStream,
+		"group":                   info.UsingGroup,
+	}
+}
+
+func resolveVirtualModelTarget(virtualName string, virtualModel operation_setting.VirtualModel, factsJSON, bodyJSON string) (string, error) {
+	for i, rule := range virtualModel.Rules {
+		if rule.Target == "" {
+			continue
+		}
+		conditions := make([]relaycommon.ConditionOperation, 0, len(rule.Conditions))
+		for _, condition := range rule.Conditions {
+			conditions = append(conditions, relaycommon.ConditionOperation{
+				Path:           condition.Path,
+				Mode:           condition.Mode,
+				Value:          condition.Value,
+				Invert:         condition.Invert,
+				PassMissingKey: condition.PassMissingKey,
+			})
+		}
+		matched, err := relaycommon.CheckConditions(factsJSON, bodyJSON, conditions, rule.Logic)
+		if err != nil {
+			return "", fmt.Errorf("虚拟模型 %s 第 %d 条规则匹配失败: %w", virtualName, i+1, err)
+		}
+		if matched {
+			return rule.Target, nil
+		}
+	}
+	if virtualModel.Default == "" {
+		return "", fmt.Errorf("虚拟模型 %s 没有匹配的规则，且未配置默认模型", virtualName)
+	}
+	return virtualModel.Default, nil
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveVirtualModelTarget(t *testing.T) {
+	virtualModel := operation_setting.VirtualModel{
+		Rules: []operation_setting.VirtualModelRule{
+			{
+				Conditions: []operation_setting.VirtualModelCondition{{Path: "estimated_prompt_tokens", Mode: "gt", Value: 100000}},
+				Target:     "long-context-model",
+			},
+			{
+				Conditions: []operation_setting.VirtualModelCondition{{Path: "has_image", Mode: "full", Value: true}},
+				Target:     "vision-model",
+			},
+			{
+				Conditions: []operation_setting.VirtualModelCondition{
+					{Path: "has_tools", Mode: "full", Value: true},
+					{Path: "temperature", Mode: "lte", Value: 0.5},
+				},
+				Logic:  "AND",
+				Target: "tool-model",
+			},
+		},
+		Default: "default-model",
+	}
+
+	tests := []struct {
+		name  string
+		facts string
+		body  string
+		want  string
+	}{
+		{"long context wins over image", `{"estimated_prompt_tokens":150000,"has_image":true}`, `{}`, "long-context-model"},
+		{"image", `{"estimated_prompt_tokens":10,"has_image":true}`, `{}`, "vision-model"},
+		{"tools with body condition", `{"has_tools":true}`, `{"temperature":0.2}`, "tool-model"},
+		{"tools but body condition not met", `{"has_tools":true}`, `{"temperature":0.9}`, "default-model"},
+		{"default", `{"estimated_prompt_tokens":10}`, `{}`, "default-model"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := resolveVirtualModelTarget("company-chat", virtualModel, tt.facts, tt.body)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, target)
+		})
+	}
+
+	_, err := resolveVirtualModelTarget("company-chat", operation_setting.VirtualModel{}, `{}`, `{}`)
+	require.Error(t, err)
+}
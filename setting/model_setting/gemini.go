@@ -13,6 +13,8 @@ type GeminiSettings struct {
 	ThinkingAdapterBudgetTokensPercentage float64           `json:"thinking_adapter_budget_tokens_percentage"`
 	FunctionCallThoughtSignatureEnabled   bool              `json:"function_call_thought_signature_enabled"`
 	RemoveFunctionResponseIdEnabled       bool              `json:"remove_function_response_id_enabled"`
+	// CachedContentStorageRatio 上下文缓存（cachedContents）每小时存储费用相对模型输入价格的倍率
+	CachedContentStorageRatio float64 `json:"cached_content_storage_ratio"`
 }
 
 // 默认配置
@@ -35,6 +37,7 @@ var defaultGeminiSettings = GeminiSettings{
 	ThinkingAdapterBudgetTokensPercentage: 0.6,
 	FunctionCallThoughtSignatureEnabled:   true,
 	RemoveFunctionResponseIdEnabled:       true,
+	CachedContentStorageRatio:             3.6,
 }
 
 // 全局实例
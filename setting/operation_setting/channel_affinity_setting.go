@@ -2,6 +2,9 @@ package operation_setting
 
 import "github.com/QuantumNous/new-api/setting/config"
 
+// GeminiCachedContentAffinityRule 上下文缓存只存在于创建它的渠道，引用 cachedContent 的请求按该规则固定到原渠道
+const GeminiCachedContentAffinityRule = "gemini cached content"
+
 type ChannelAffinityKeySource struct {
 	Type string `json:"type"` // context_int, context_string, gjson
 	Key  string `json:"key,omitempty"`
@@ -108,6 +111,20 @@ var channelAffinitySetting = ChannelAffinitySetting{
 			IncludeRuleName:       true,
 			UserAgentInclude:      nil,
 		},
+		{
+			Name:       GeminiCachedContentAffinityRule,
+			ModelRegex: []string{"^gemini-.*$"},
+			PathRegex:  []string{"/models/"},
+			KeySources: []ChannelAffinityKeySource{
+				{Type: "gjson", Path: "cachedContent"},
+			},
+			ValueRegex:         "^cachedContents/",
+			TTLSeconds:         7 * 24 * 3600,
+			SkipRetryOnFailure: true,
+			IncludeUsingGroup:  false,
+			IncludeRuleName:    true,
+			UserAgentInclude:   nil,
+		},
 	},
 }
 
@@ -0,0 +1,38 @@
+package operation_setting
+
+import (
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// ChannelCooldownSetting 上游返回 429 并给出 Retry-After / x-ratelimit-reset-* 时，
+// 让渠道（多密钥渠道为当前密钥）暂时冷却，而不是立即重试或自动禁用
+type ChannelCooldownSetting struct {
+	Enabled bool `json:"enabled"`
+	// MaxSeconds 单次冷却的上限，避免上游给出过长的等待时间导致渠道长期不可用
+	MaxSeconds int `json:"max_seconds"`
+}
+
+// 默认配置
+var channelCooldownSetting = ChannelCooldownSetting{
+	Enabled:    true,
+	MaxSeconds: 600,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_cooldown_setting", &channelCooldownSetting)
+}
+
+func GetChannelCooldownSetting() *ChannelCooldownSetting {
+	return &channelCooldownSetting
+}
+
+// Clamp 把上游给出的冷却时间限制在 MaxSeconds 以内
+func (s *ChannelCooldownSetting) Clamp(d time.Duration) time.Duration {
+	if s.MaxSeconds > 0 {
+		return min(d, time.Duration(s.MaxSeconds)*time.Second)
+	}
+	return d
+}
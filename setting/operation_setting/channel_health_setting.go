@@ -0,0 +1,50 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ChannelHealthSetting 自适应选路：按真实流量统计渠道/模型的成功率与延迟，在同优先级内调整权重，
+// 故障渠道被软摘除并逐步恢复流量。软摘除只影响选路权重，与 ShouldDisableChannel 的硬禁用相互独立，
+// 后者仍按状态码与关键词规则判断
+type ChannelHealthSetting struct {
+	AdaptiveSelectionEnabled bool `json:"adaptive_selection_enabled"`
+	// EwmaAlpha 指数加权平均的新样本权重 (0, 1]
+	EwmaAlpha float64 `json:"ewma_alpha"`
+	// MinRequests 样本数达到该值后才按成功率 / 延迟调整权重
+	MinRequests int `json:"min_requests"`
+	// LatencyAware 是否按 TTFT 与在途请求数偏向更快的渠道
+	LatencyAware bool `json:"latency_aware"`
+	// EjectConsecutiveFailures 连续失败次数达到该值即软摘除，0 表示不启用
+	EjectConsecutiveFailures int `json:"eject_consecutive_failures"`
+	// EjectErrorRate 滚动错误率达到该值即软摘除，0 表示不启用
+	EjectErrorRate float64 `json:"eject_error_rate"`
+	// EjectBaseSeconds 首次摘除时长，再次摘除时按次数翻倍，最长 EjectMaxSeconds
+	EjectBaseSeconds int `json:"eject_base_seconds"`
+	EjectMaxSeconds  int `json:"eject_max_seconds"`
+	// MaxEjectPercent 同一优先级内最多摘除的渠道比例，超出时全部放行
+	MaxEjectPercent int `json:"max_eject_percent"`
+	// RecoverySeconds 摘除结束后流量从 10% 线性恢复到 100% 所需时间
+	RecoverySeconds int `json:"recovery_seconds"`
+}
+
+// 默认配置
+var channelHealthSetting = ChannelHealthSetting{
+	AdaptiveSelectionEnabled: false,
+	EwmaAlpha:                0.2,
+	MinRequests:              10,
+	LatencyAware:             true,
+	EjectConsecutiveFailures: 5,
+	EjectErrorRate:           0.5,
+	EjectBaseSeconds:         30,
+	EjectMaxSeconds:          300,
+	MaxEjectPercent:          50,
+	RecoverySeconds:          60,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_health_setting", &channelHealthSetting)
+}
+
+func GetChannelHealthSetting() *ChannelHealthSetting {
+	return &channelHealthSetting
+}
@@ -0,0 +1,35 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// CircuitBreakerSetting 渠道 / 多密钥熔断：连续 5xx 或超时达到阈值后熔断，
+// 冷却结束进入半开状态，放行少量真实请求探测，全部成功后恢复
+type CircuitBreakerSetting struct {
+	Enabled bool `json:"enabled"`
+	// KeyLevelEnabled 多密钥渠道是否按密钥单独熔断
+	KeyLevelEnabled bool `json:"key_level_enabled"`
+	// FailureThreshold 连续失败多少次后熔断
+	FailureThreshold int `json:"failure_threshold"`
+	// OpenSeconds 熔断持续时间，结束后进入半开状态
+	OpenSeconds int `json:"open_seconds"`
+	// HalfOpenMaxRequests 半开状态放行的探测请求数，全部成功后关闭熔断
+	HalfOpenMaxRequests int `json:"half_open_max_requests"`
+}
+
+// 默认配置
+var circuitBreakerSetting = CircuitBreakerSetting{
+	Enabled:             false,
+	KeyLevelEnabled:     true,
+	FailureThreshold:    5,
+	OpenSeconds:         60,
+	HalfOpenMaxRequests: 3,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("circuit_breaker_setting", &circuitBreakerSetting)
+}
+
+func GetCircuitBreakerSetting() *CircuitBreakerSetting {
+	return &circuitBreakerSetting
+}
@@ -0,0 +1,57 @@
+package operation_setting
+
+import (
+	"slices"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// HedgeRule 对冲请求规则，Group/Models 为空表示匹配全部
+type HedgeRule struct {
+	Group  string   `json:"group"`
+	Models []string `json:"models"`
+	// DelayMs 主请求超过该时长仍未返回响应头时，向另一个渠道发起对冲请求
+	DelayMs int `json:"delay_ms"`
+}
+
+// HedgeSetting 对冲请求：用一次额外的上游请求换取更低的尾延迟，只对幂等的文本类请求生效
+type HedgeSetting struct {
+	Enabled bool        `json:"enabled"`
+	Rules   []HedgeRule `json:"rules"`
+}
+
+// 默认配置
+var hedgeSetting = HedgeSetting{
+	Enabled: false,
+	Rules:   []HedgeRule{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("hedge_setting", &hedgeSetting)
+}
+
+func GetHedgeSetting() *HedgeSetting {
+	return &hedgeSetting
+}
+
+// DelayFor 返回第一条匹配规则的对冲延迟，未启用或未匹配时 ok 为 false
+func (s *HedgeSetting) DelayFor(group, modelName string) (time.Duration, bool) {
+	if !s.Enabled {
+		return 0, false
+	}
+	for _, rule := range s.Rules {
+		if rule.DelayMs <= 0 {
+			continue
+		}
+		if rule.Group != "" && rule.Group != group {
+			continue
+		}
+		if len(rule.Models) > 0 && !slices.Contains(rule.Models, modelName) {
+			continue
+		}
+		return time.Duration(rule.DelayMs) * time.Millisecond, true
+	}
+	return 0, false
+}
@@ -0,0 +1,34 @@
+package operation_setting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHedgeSettingDelayFor(t *testing.T) {
+	s := HedgeSetting{
+		Enabled: true,
+		Rules: []HedgeRule{
+			{Group: "vip", Models: []string{"gpt-4o"}, DelayMs: 500},
+			{Group: "vip", DelayMs: 0},
+			{Models: []string{"gpt-4o-mini"}, DelayMs: 800},
+		},
+	}
+
+	delay, ok := s.DelayFor("vip", "gpt-4o")
+	require.True(t, ok)
+	require.Equal(t, 500*time.Millisecond, delay)
+
+	delay, ok = s.DelayFor("default", "gpt-4o-mini")
+	require.True(t, ok)
+	require.Equal(t, 800*time.Millisecond, delay)
+
+	_, ok = s.DelayFor("vip", "claude-sonnet-4")
+	require.False(t, ok)
+
+	s.Enabled = false
+	_, ok = s.DelayFor("vip", "gpt-4o")
+	require.False(t, ok)
+}
@@ -0,0 +1,41 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ModelFallbackSetting 模型级降级链：某模型无可用渠道或重试全部失败时，依次改用链上的下一个模型
+type ModelFallbackSetting struct {
+	Enabled bool `json:"enabled"`
+	// MaxHops 最多降级几次，0 表示不限制（仍受链长度限制）
+	MaxHops int `json:"max_hops"`
+	// Chains 全局降级链，如 {"claude-sonnet-4-20250514": ["gpt-4.1", "gemini-2.5-pro"]}
+	Chains map[string][]string `json:"chains"`
+	// GroupChains 按分组覆盖的降级链，优先于全局配置
+	GroupChains map[string]map[string][]string `json:"group_chains"`
+}
+
+// 默认配置
+var modelFallbackSetting = ModelFallbackSetting{
+	Enabled:     false,
+	MaxHops:     2,
+	Chains:      map[string][]string{},
+	GroupChains: map[string]map[string][]string{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("model_fallback_setting", &modelFallbackSetting)
+}
+
+func GetModelFallbackSetting() *ModelFallbackSetting {
+	return &modelFallbackSetting
+}
+
+// ChainFor 返回分组配置优先、全局配置兜底的降级链
+func (s *ModelFallbackSetting) ChainFor(group, modelName string) []string {
+	if chains, ok := s.GroupChains[group]; ok {
+		if chain, ok := chains[modelName]; ok {
+			return chain
+		}
+	}
+	return s.Chains[modelName]
+}
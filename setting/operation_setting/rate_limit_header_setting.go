@@ -0,0 +1,38 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// RateLimitHeaderSetting 中继响应上的 x-ratelimit-* 与额度响应头
+type RateLimitHeaderSetting struct {
+	// Enabled 是否输出 OpenAI 风格的 x-ratelimit-* 响应头
+	Enabled bool `json:"enabled"`
+	// QuotaHeadersEnabled 是否输出 X-New-Api-Remaining-Quota 等剩余额度与分组响应头；
+	// 这些响应头会暴露账户余额与分组，默认关闭，需要管理员显式开启
+	QuotaHeadersEnabled bool `json:"quota_headers_enabled"`
+	// GroupEnabled 按分组单独开关，未配置的分组跟随 Enabled / QuotaHeadersEnabled
+	GroupEnabled map[string]bool `json:"group_enabled"`
+}
+
+// 默认配置
+var rateLimitHeaderSetting = RateLimitHeaderSetting{
+	Enabled:             true,
+	QuotaHeadersEnabled: false,
+	GroupEnabled:        map[string]bool{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("rate_limit_header_setting", &rateLimitHeaderSetting)
+}
+
+func GetRateLimitHeaderSetting() *RateLimitHeaderSetting {
+	return &rateLimitHeaderSetting
+}
+
+// HeadersForGroup 返回分组是否输出限流响应头与额度响应头；分组单独配置时两类响应头一起开关
+func (s *RateLimitHeaderSetting) HeadersForGroup(group string) (rateLimit bool, quota bool) {
+	if enabled, ok := s.GroupEnabled[group]; ok {
+		return enabled, enabled
+	}
+	return s.Enabled, s.QuotaHeadersEnabled
+}
@@ -0,0 +1,58 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ResponseCacheSetting 对话补全与 embeddings 的响应缓存，需要同时在令牌上开启才会生效
+type ResponseCacheSetting struct {
+	Enabled bool `json:"enabled"`
+	// TTLSeconds 缓存有效期
+	TTLSeconds int `json:"ttl_seconds"`
+	// MaxEntries 未启用 Redis 时内存缓存的最大条目数
+	MaxEntries int `json:"max_entries"`
+	// MaxBodyBytes 超过该大小的响应不缓存
+	MaxBodyBytes int `json:"max_body_bytes"`
+	// HitBillingRatio 命中缓存时按正常价格的多少倍计费，0 表示免费
+	HitBillingRatio float64 `json:"hit_billing_ratio"`
+	// CacheNonDeterministic temperature>0（或未指定）的对话请求默认不缓存，开启后强制缓存
+	CacheNonDeterministic bool `json:"cache_non_deterministic"`
+	// Scope 缓存的共享范围：user 同一用户的令牌之间共享，token 只在同一令牌内共享
+	Scope string `json:"scope"`
+
+	// SemanticEnabled 精确匹配未命中时，按 prompt 的向量相似度查找参数相同的已缓存请求
+	SemanticEnabled bool `json:"semantic_enabled"`
+	// SemanticEmbeddingModel 计算向量使用的 embedding 模型，费用计入当前令牌
+	SemanticEmbeddingModel string `json:"semantic_embedding_model"`
+	// SemanticThreshold 余弦相似度阈值
+	SemanticThreshold float64 `json:"semantic_threshold"`
+	// SemanticMaxEntries 每个模型 / 分组 / 参数组合在本实例内存中保留的向量数
+	SemanticMaxEntries int `json:"semantic_max_entries"`
+}
+
+const (
+	ResponseCacheScopeUser  = "user"
+	ResponseCacheScopeToken = "token"
+)
+
+// 默认配置
+var responseCacheSetting = ResponseCacheSetting{
+	Enabled:                false,
+	TTLSeconds:             3600,
+	MaxEntries:             10000,
+	MaxBodyBytes:           1 << 20,
+	HitBillingRatio:        0.1,
+	CacheNonDeterministic:  false,
+	Scope:                  ResponseCacheScopeUser,
+	SemanticEnabled:        false,
+	SemanticEmbeddingModel: "text-embedding-3-small",
+	SemanticThreshold:      0.95,
+	SemanticMaxEntries:     1000,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("response_cache_setting", &responseCacheSetting)
+}
+
+func GetResponseCacheSetting() *ResponseCacheSetting {
+	return &responseCacheSetting
+}
@@ -0,0 +1,55 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// TokenRateLimit 每分钟 token 数（TPM）与并发限制，0 表示不限制
+type TokenRateLimit struct {
+	// InputTPM 每分钟输入 token 上限，按预估值预占，请求结束后按实际用量修正
+	InputTPM int `json:"input_tpm"`
+	// OutputTPM 每分钟输出 token 上限，请求结束后按实际用量扣除
+	OutputTPM int `json:"output_tpm"`
+	// Concurrency 同时在途的请求数上限
+	Concurrency int `json:"concurrency"`
+}
+
+func (l TokenRateLimit) IsZero() bool {
+	return l.InputTPM <= 0 && l.OutputTPM <= 0 && l.Concurrency <= 0
+}
+
+// TokenRateLimitSetting 分组 / 模型级别的 TPM 与并发限制，对分组内的每个用户分别生效。
+// 令牌和用户级别的限制保存在各自的 tpm_limit / output_tpm_limit / concurrency_limit 字段中
+type TokenRateLimitSetting struct {
+	Enabled bool `json:"enabled"`
+	// GroupModelLimits 分组 -> 模型 -> 限制，模型为 "*" 时匹配该分组下未单独配置的所有模型
+	GroupModelLimits map[string]map[string]TokenRateLimit `json:"group_model_limits"`
+}
+
+// 默认配置
+var tokenRateLimitSetting = TokenRateLimitSetting{
+	Enabled:          false,
+	GroupModelLimits: map[string]map[string]TokenRateLimit{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("token_rate_limit_setting", &tokenRateLimitSetting)
+}
+
+func GetTokenRateLimitSetting() *TokenRateLimitSetting {
+	return &tokenRateLimitSetting
+}
+
+// GetGroupModelTokenRateLimit 返回分组下指定模型的限制以及命中的模型配置项（模型名或 "*"）
+func (s *TokenRateLimitSetting) GetGroupModelTokenRateLimit(group string, modelName string) (TokenRateLimit, string, bool) {
+	models, ok := s.GroupModelLimits[group]
+	if !ok {
+		return TokenRateLimit{}, "", false
+	}
+	if limit, ok := models[modelName]; ok {
+		return limit, modelName, true
+	}
+	if limit, ok := models["*"]; ok {
+		return limit, "*", true
+	}
+	return TokenRateLimit{}, "", false
+}
@@ -0,0 +1,58 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// VirtualModelCondition 与参数覆盖的条件格式一致，见 relay/common.ConditionOperation
+type VirtualModelCondition struct {
+	Path           string `json:"path"`
+	Mode           string `json:"mode"` // full, prefix, suffix, contains, gt, gte, lt, lte
+	Value          any    `json:"value"`
+	Invert         bool   `json:"invert"`
+	PassMissingKey bool   `json:"pass_missing_key"`
+}
+
+// VirtualModelRule 条件满足时路由到 Target，Logic 为 AND/OR，默认 OR
+type VirtualModelRule struct {
+	Conditions []VirtualModelCondition `json:"conditions"`
+	Logic      string                  `json:"logic"`
+	Target     string                  `json:"target"`
+}
+
+// VirtualModel 对外暴露的稳定模型名，按请求内容路由到真实模型。
+// 规则按顺序匹配，全部不满足时使用 Default
+type VirtualModel struct {
+	Description string             `json:"description"`
+	Rules       []VirtualModelRule `json:"rules"`
+	Default     string             `json:"default"`
+}
+
+// VirtualModelSetting 虚拟模型配置，如
+// {"company-chat": {"rules": [{"conditions": [{"path": "estimated_prompt_tokens", "mode": "gt", "value": 100000}], "target": "gemini-2.5-pro"}], "default": "gpt-4.1-mini"}}
+type VirtualModelSetting struct {
+	Enabled bool                    `json:"enabled"`
+	Models  map[string]VirtualModel `json:"models"`
+}
+
+// 默认配置
+var virtualModelSetting = VirtualModelSetting{
+	Enabled: false,
+	Models:  map[string]VirtualModel{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("virtual_model_setting", &virtualModelSetting)
+}
+
+func GetVirtualModelSetting() *VirtualModelSetting {
+	return &virtualModelSetting
+}
+
+// GetVirtualModel 返回虚拟模型配置，未启用或不存在时 ok 为 false
+func (s *VirtualModelSetting) GetVirtualModel(modelName string) (VirtualModel, bool) {
+	if !s.Enabled || modelName == "" {
+		return VirtualModel{}, false
+	}
+	virtualModel, ok := s.Models[modelName]
+	return virtualModel, ok
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
 )
@@ -69,14 +70,19 @@ const (
 	ErrorCodeBadRequestBody ErrorCode = "bad_request_body"
 
 	// response error
-	ErrorCodeReadResponseBodyFailed ErrorCode = "read_response_body_failed"
-	ErrorCodeBadResponseStatusCode  ErrorCode = "bad_response_status_code"
-	ErrorCodeBadResponse            ErrorCode = "bad_response"
-	ErrorCodeBadResponseBody        ErrorCode = "bad_response_body"
-	ErrorCodeEmptyResponse          ErrorCode = "empty_response"
-	ErrorCodeAwsInvokeError         ErrorCode = "aws_invoke_error"
-	ErrorCodeModelNotFound          ErrorCode = "model_not_found"
-	ErrorCodePromptBlocked          ErrorCode = "prompt_blocked"
+	ErrorCodeReadResponseBodyFailed  ErrorCode = "read_response_body_failed"
+	ErrorCodeBadResponseStatusCode   ErrorCode = "bad_response_status_code"
+	ErrorCodeBadResponse             ErrorCode = "bad_response"
+	ErrorCodeBadResponseBody         ErrorCode = "bad_response_body"
+	ErrorCodeEmptyResponse           ErrorCode = "empty_response"
+	ErrorCodeStreamFirstTokenTimeout ErrorCode = "stream_first_token_timeout"
+	ErrorCodeConnectTimeout          ErrorCode = "connect_timeout"
+	ErrorCodeResponseHeaderTimeout   ErrorCode = "response_header_timeout"
+	ErrorCodeStreamIdleTimeout       ErrorCode = "stream_idle_timeout"
+	ErrorCodeTotalTimeout            ErrorCode = "total_timeout"
+	ErrorCodeAwsInvokeError          ErrorCode = "aws_invoke_error"
+	ErrorCodeModelNotFound           ErrorCode = "model_not_found"
+	ErrorCodePromptBlocked           ErrorCode = "prompt_blocked"
 
 	// sql error
 	ErrorCodeQueryDataError  ErrorCode = "query_data_error"
@@ -85,6 +91,9 @@ const (
 	// quota error
 	ErrorCodeInsufficientUserQuota      ErrorCode = "insufficient_user_quota"
 	ErrorCodePreConsumeTokenQuotaFailed ErrorCode = "pre_consume_token_quota_failed"
+
+	// rate limit error
+	ErrorCodeRateLimitExceeded ErrorCode = "rate_limit_exceeded"
 )
 
 type NewAPIError struct {
@@ -96,6 +105,8 @@ type NewAPIError struct {
 	errorCode      ErrorCode
 	StatusCode     int
 	Metadata       json.RawMessage
+	// RetryAfter 上游限流时通过 Retry-After / x-ratelimit-reset-* 要求等待的时间，0 表示未给出
+	RetryAfter time.Duration
 }
 
 // Unwrap enables errors.Is / errors.As to work with NewAPIError by exposing the underlying error.
@@ -370,6 +381,18 @@ func IsChannelError(err *NewAPIError) bool {
 	return strings.HasPrefix(string(err.errorCode), "channel:")
 }
 
+// IsTimeoutError 渠道超时配置产生的错误
+func IsTimeoutError(err *NewAPIError) bool {
+	if err == nil {
+		return false
+	}
+	switch err.errorCode {
+	case ErrorCodeConnectTimeout, ErrorCodeResponseHeaderTimeout, ErrorCodeStreamIdleTimeout, ErrorCodeTotalTimeout, ErrorCodeStreamFirstTokenTimeout:
+		return true
+	}
+	return false
+}
+
 func IsSkipRetryError(err *NewAPIError) bool {
 	if err == nil {
 		return false
@@ -41,6 +41,7 @@ import {
   Form,
   Col,
   Row,
+  Modal,
 } from '@douyinfe/semi-ui';
 import {
   IconCreditCard,
@@ -64,6 +65,28 @@ const EditTokenModal = (props) => {
   const [groups, setGroups] = useState([]);
   const isEdit = props.editingToken.id !== undefined;
 
+  const scopeOptions = [
+    { label: t('对话'), value: 'chat' },
+    { label: t('向量嵌入'), value: 'embeddings' },
+    { label: t('图像生成'), value: 'images' },
+    { label: t('音频'), value: 'audio' },
+    { label: t('实时语音'), value: 'realtime' },
+    { label: t('重排序'), value: 'rerank' },
+    { label: t('内容审核'), value: 'moderations' },
+    { label: t('模型列表'), value: 'models' },
+    { label: t('文件与批处理'), value: 'batch' },
+    { label: 'Midjourney', value: 'midjourney' },
+    { label: 'Suno', value: 'suno' },
+    { label: t('视频生成'), value: 'video' },
+  ];
+
+  const budgetPeriodOptions = [
+    { label: t('不启用'), value: '' },
+    { label: t('每日'), value: 'daily' },
+    { label: t('每周'), value: 'weekly' },
+    { label: t('每月'), value: 'monthly' },
+  ];
+
   const getInitValues = () => ({
     name: '',
     remain_quota: 0,
@@ -71,6 +94,9 @@ const EditTokenModal = (props) => {
     unlimited_quota: true,
     model_limits_enabled: false,
     model_limits: [],
+    scopes: [],
+    budget_period: '',
+    budget_limit: 0,
     allow_ips: '',
     group: '',
     cross_group_retry: false,
@@ -162,6 +188,7 @@ const EditTokenModal = (props) => {
       } else {
         data.model_limits = [];
       }
+      data.scopes = data.scopes ? data.scopes.split(',') : [];
       if (formApiRef.current) {
         formApiRef.current.setValues({ ...getInitValues(), ...data });
       }
@@ -210,6 +237,7 @@ const EditTokenModal = (props) => {
     if (isEdit) {
       let { tokenCount: _tc, ...localInputs } = values;
       localInputs.remain_quota = parseInt(localInputs.remain_quota);
+      localInputs.budget_limit = parseInt(localInputs.budget_limit) || 0;
       if (localInputs.expired_time !== -1) {
         let time = Date.parse(localInputs.expired_time);
         if (isNaN(time)) {
@@ -221,6 +249,7 @@ const EditTokenModal = (props) => {
       }
       localInputs.model_limits = localInputs.model_limits.join(',');
       localInputs.model_limits_enabled = localInputs.model_limits.length > 0;
+      localInputs.scopes = localInputs.scopes.join(',');
       let res = await API.put(`/api/token/`, {
         ...localInputs,
         id: parseInt(props.editingToken.id),
@@ -236,6 +265,7 @@ const EditTokenModal = (props) => {
     } else {
       const count = parseInt(values.tokenCount, 10) || 1;
       let successCount = 0;
+      const createdKeys = [];
       for (let i = 0; i < count; i++) {
         let { tokenCount: _tc, ...localInputs } = values;
         const baseName =
@@ -246,6 +276,8 @@ const EditTokenModal = (props) => {
           localInputs.name = baseName;
         }
         localInputs.remain_quota = parseInt(localInputs.remain_quota);
+        localInputs.budget_limit = parseInt(localInputs.budget_limit) || 0;
+      localInputs.budget_limit = parseInt(localInputs.budget_limit) || 0;
 
         if (localInputs.expired_time !== -1) {
           let time = Date.parse(localInputs.expired_time);
@@ -258,17 +290,40 @@ const EditTokenModal = (props) => {
         }
         localInputs.model_limits = localInputs.model_limits.join(',');
         localInputs.model_limits_enabled = localInputs.model_limits.length > 0;
+        localInputs.scopes = localInputs.scopes.join(',');
         let res = await API.post(`/api/token/`, localInputs);
-        const { success, message } = res.data;
+        const { success, message, data } = res.data;
         if (success) {
           successCount++;
+          if (data?.key) {
+            createdKeys.push(`${localInputs.name}: sk-${data.key}`);
+          }
         } else {
           showError(t(message));
           break;
         }
       }
       if (successCount > 0) {
-        showSuccess(t('令牌创建成功，请在列表页面点击复制获取令牌！'));
+        showSuccess(t('令牌创建成功'));
+        if (createdKeys.length > 0) {
+          Modal.info({
+            title: t('请立即保存令牌'),
+            content: (
+              <div>
+                <Typography.Text type='warning'>
+                  {t('完整令牌只显示这一次，关闭后将无法再次查看')}
+                </Typography.Text>
+                <Typography.Paragraph
+                  copyable={{ content: createdKeys.join('\n') }}
+                  className='mt-2 whitespace-pre-wrap break-all font-mono'
+                >
+                  {createdKeys.join('\n')}
+                </Typography.Paragraph>
+              </div>
+            ),
+            size: 'large',
+          });
+        }
         props.refresh();
         props.handleClose();
       }
@@ -521,6 +576,44 @@ const EditTokenModal = (props) => {
                       )}
                     />
                   </Col>
+                  <Col span={12}>
+                    <Form.Select
+                      field='budget_period'
+                      label={t('周期预算')}
+                      optionList={budgetPeriodOptions}
+                      style={{ width: '100%' }}
+                      extraText={
+                        isEdit && values.budget_period
+                          ? t('本周期已用') +
+                            ' ' +
+                            renderQuotaWithPrompt(
+                              props.editingToken.budget_used || 0,
+                            )
+                          : t('按自然日、周一或每月 1 日重置')
+                      }
+                    />
+                  </Col>
+                  <Col span={12}>
+                    <Form.AutoComplete
+                      field='budget_limit'
+                      label={t('每周期限额')}
+                      placeholder={t('请输入额度')}
+                      type='number'
+                      disabled={!values.budget_period}
+                      extraText={renderQuotaWithPrompt(values.budget_limit)}
+                      rules={
+                        values.budget_period
+                          ? [{ required: true, message: t('请输入额度') }]
+                          : []
+                      }
+                      data={[
+                        { value: 5000000, label: '10$' },
+                        { value: 10000000, label: '20$' },
+                        { value: 50000000, label: '100$' },
+                        { value: 250000000, label: '500$' },
+                      ]}
+                    />
+                  </Col>
                 </Row>
               </Card>
 
@@ -559,6 +652,20 @@ const EditTokenModal = (props) => {
                       style={{ width: '100%' }}
                     />
                   </Col>
+                  <Col span={24}>
+                    <Form.Select
+                      field='scopes'
+                      label={t('接口作用域')}
+                      placeholder={t('请选择该令牌可以调用的接口类型，留空不限制')}
+                      extraText={t(
+                        '设置作用域后，不属于任何作用域的接口也会被拒绝',
+                      )}
+                      multiple
+                      optionList={scopeOptions}
+                      showClear
+                      style={{ width: '100%' }}
+                    />
+                  </Col>
                   <Col span={24}>
                     <Form.TextArea
                       field='allow_ips'